@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+)
+
+// config holds the settings of blackbirdctl, read from its configuration
+// file. Environment variables override the file, and flags override both.
+type config struct {
+	Server     string `yaml:"server"`
+	Token      string `yaml:"token"`
+	APIVersion string `yaml:"apiVersion"`
+	Output     string `yaml:"output"`
+}
+
+// defaultConfigPath returns the path of the configuration file read when
+// none is given: blackbirdctl/config.yaml in the user's config directory.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "blackbirdctl", "config.yaml")
+}
+
+// loadConfig reads the configuration file at path. A missing file is only
+// an error when required is set.
+func loadConfig(path string, required bool) (config, error) {
+	var c config
+	if len(path) == 0 {
+		return c, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	if err = yaml.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// firstNonBlank returns the first of values which is not blank.
+func firstNonBlank(values ...string) string {
+	for _, v := range values {
+		if len(v) > 0 {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"alovenio.com/blackbird/client"
+	"alovenio.com/blackbird/sfu"
+	"context"
+	"errors"
+	"flag"
+)
+
+func tailEvents(ctx context.Context, c *client.Client, p printer, args []string) error {
+	fs := flag.NewFlagSet("events tail", flag.ContinueOnError)
+	session := fs.String("session", "", "id of the session whose events are streamed (all sessions when blank)")
+	if _, err := parseArgs(fs, args, 0, "[-session SESSION]"); err != nil {
+		return err
+	}
+	err := c.StreamEvents(ctx, *session, "", func(_ string, e sfu.Event) error {
+		return p.event(e)
+	})
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		// interrupting is the way to stop tailing events
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,3 @@
+module alovenio.com/blackbird/blackbirdctl
+
+go 1.20
@@ -0,0 +1,564 @@
+// blackbirdctl application manages the sessions and participants of a
+// Blackbird SFU server through its REST API
+package main
+
+import (
+	"alovenio.com/blackbird/client"
+	"alovenio.com/blackbird/sfu"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const usage = `Usage: blackbirdctl [flags] <command> [args]
+
+Commands:
+  sessions create -name NAME [-maxParticipants N] [-maxPublishers N] [-maxVideoTracks N]
+                  [-metadata KEY=VALUE] [-idempotencyKey KEY]
+  sessions get SESSION
+  sessions list [-namePrefix PREFIX] [-createdBefore TIME] [-createdAfter TIME] [-hasParticipants BOOL]
+                [-metadata KEY=VALUE] [-sort [-]creationDateTime|name] [-limit N] [-cursor CURSOR] [-all]
+  sessions update SESSION [-name NAME] [-maxParticipants N] [-maxPublishers N] [-maxVideoTracks N]
+                  [-metadata KEY=VALUE] [-unset KEY] [-revision N]
+  sessions delete SESSION [-revision N]
+  participants add SESSION -name NAME [-metadata KEY=VALUE] [-idempotencyKey KEY]
+  participants get SESSION PARTICIPANT
+  participants list SESSION
+  participants update SESSION PARTICIPANT [-name NAME] [-metadata KEY=VALUE] [-unset KEY] [-revision N]
+  participants kick SESSION PARTICIPANT [-revision N]
+  events tail [-session SESSION]
+
+Settings are read from flags, then from the BLACKBIRD_SERVER,
+BLACKBIRD_TOKEN, BLACKBIRD_API_VERSION and BLACKBIRD_OUTPUT environment
+variables, then from the configuration file (-config or
+BLACKBIRD_CTL_CONFIG), a YAML document with server, token, apiVersion and
+output keys. Exit status is 1 on API errors or when interrupted, except for
+events tail which exits with 0 once interrupted, and 2 on invalid usage.
+
+Flags:
+`
+
+// Exit codes.
+const (
+	exitOk    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+var configPath = flag.String("config", "", "path of the YAML configuration file (blackbirdctl/config.yaml in the user config directory when blank)")
+var server = flag.String("server", "", "base URL of the Blackbird server (http://localhost:8000 when blank)")
+var token = flag.String("token", "", "bearer token sent with every request")
+var apiVersion = flag.String("apiVersion", "", "version of the REST API ("+client.DefaultVersion+" when blank)")
+var output = flag.String("output", "", "output format: table or json (table when blank)")
+var insecure = flag.Bool("insecure", false, "skip the verification of the server's TLS certificate")
+var timeout = flag.Duration("timeout", 30*time.Second, "timeout of every request, except event streams")
+
+// errUsage is returned for commands called with invalid arguments.
+var errUsage = errors.New("invalid usage")
+
+// command runs a subcommand with its args.
+type command func(ctx context.Context, c *client.Client, p printer, args []string) error
+
+var commands = map[string]map[string]command{
+	"sessions": {
+		"create": createSession,
+		"get":    getSession,
+		"list":   listSessions,
+		"update": updateSession,
+		"delete": deleteSession,
+	},
+	"participants": {
+		"add":    addParticipant,
+		"get":    getParticipant,
+		"list":   listParticipants,
+		"update": updateParticipant,
+		"kick":   kickParticipant,
+	},
+	"events": {
+		"tail": tailEvents,
+	},
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	code := run(ctx, flag.Args(), os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run runs the command given by args, writing its output to stdout and
+// errors to stderr, and returns the process exit code. Commands are
+// interrupted when ctx is done.
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) < 2 || commands[args[0]][args[1]] == nil {
+		flag.Usage()
+		return exitUsage
+	}
+	path := firstNonBlank(*configPath, os.Getenv("BLACKBIRD_CTL_CONFIG"))
+	c, err := loadConfig(firstNonBlank(path, defaultConfigPath()), len(path) > 0)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	format := firstNonBlank(*output, os.Getenv("BLACKBIRD_OUTPUT"), c.Output, tableOutput)
+	if format != tableOutput && format != jsonOutput {
+		fmt.Fprintf(stderr, "unknown output format %q\n", format)
+		return exitUsage
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if *insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	api := client.New(firstNonBlank(*server, os.Getenv("BLACKBIRD_SERVER"), c.Server, "http://localhost:8000"),
+		client.WithVersion(firstNonBlank(*apiVersion, os.Getenv("BLACKBIRD_API_VERSION"), c.APIVersion, client.DefaultVersion)),
+		client.WithToken(firstNonBlank(*token, os.Getenv("BLACKBIRD_TOKEN"), c.Token)),
+		client.WithHTTPClient(&http.Client{Transport: transport}),
+		client.WithTimeout(*timeout))
+	err = commands[args[0]][args[1]](ctx, api, printer{w: stdout, notes: stderr, format: format}, args[2:])
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+		return exitOk
+	case errors.Is(err, errUsage):
+		return exitUsage
+	}
+	fmt.Fprintln(stderr, err)
+	return exitError
+}
+
+// parseArgs parses the flags of a subcommand, which may follow its
+// positional arguments, checking exactly n positional arguments are given.
+func parseArgs(fs *flag.FlagSet, args []string, n int, names string) ([]string, error) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: blackbirdctl %s %s\n", fs.Name(), names)
+		fs.PrintDefaults()
+	}
+	var positional []string
+	for {
+		if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		} else if err != nil {
+			return nil, errUsage
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) != n {
+		fs.Usage()
+		return nil, errUsage
+	}
+	return positional, nil
+}
+
+// withTimeout returns a copy of ctx done once the request timeout elapses.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if *timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, *timeout)
+}
+
+// checkResult returns an error for results reporting errors, or missing
+// the object they are about.
+func checkResult(errs []string, found bool, what string) error {
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	if !found {
+		return fmt.Errorf("%s not found", what)
+	}
+	return nil
+}
+
+// idempotencyKeyFlag defines the -idempotencyKey flag of commands creating
+// an object of fs, which create a single object when repeated with the
+// same key.
+func idempotencyKeyFlag(fs *flag.FlagSet) *string {
+	return fs.String("idempotencyKey", "", "key making the command safe to repeat: repeating it returns the object first created")
+}
+
+func createSession(ctx context.Context, c *client.Client, p printer, args []string) error {
+	fs := flag.NewFlagSet("sessions create", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the session")
+	limits := sfu.SessionLimits{}
+	fs.IntVar(&limits.MaxParticipants, "maxParticipants", 0, "maximum number of participants (server limit when 0)")
+	fs.IntVar(&limits.MaxPublishers, "maxPublishers", 0, "maximum number of participants publishing tracks (server limit when 0)")
+	fs.IntVar(&limits.MaxVideoTracks, "maxVideoTracks", 0, "maximum number of video tracks (server limit when 0)")
+	metadata := metadataFlag{}
+	fs.Var(metadata, "metadata", "key=value metadata entry of the session (repeatable)")
+	idempotencyKey := idempotencyKeyFlag(fs)
+	if _, err := parseArgs(fs, args, 0, "-name NAME"); err != nil {
+		return err
+	}
+	params := sfu.CreateSessionParams{Name: *name, IdempotencyKey: *idempotencyKey}
+	if len(metadata) > 0 {
+		params.Metadata = metadata
+	}
+	if limits != (sfu.SessionLimits{}) {
+		params.Limits = &limits
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.CreateSessionContext(ctx, params)
+	if err != nil {
+		return err
+	}
+	if err = checkResult(result.Errors, result.Session != nil, "session"); err != nil {
+		return err
+	}
+	return p.sessions(result, result.Session)
+}
+
+func getSession(ctx context.Context, c *client.Client, p printer, args []string) error {
+	positional, err := parseArgs(flag.NewFlagSet("sessions get", flag.ContinueOnError), args, 1, "SESSION")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.GetSessionContext(ctx, sfu.GetSessionParams{Id: positional[0]})
+	if err != nil {
+		return err
+	}
+	if err = checkResult(result.Errors, result.Session != nil, "session"); err != nil {
+		return err
+	}
+	return p.sessions(result, result.Session)
+}
+
+func listSessions(ctx context.Context, c *client.Client, p printer, args []string) error {
+	fs := flag.NewFlagSet("sessions list", flag.ContinueOnError)
+	params := sfu.ListSessionsParams{}
+	fs.StringVar(&params.NamePrefix, "namePrefix", "", "prefix of the names of listed sessions")
+	fs.StringVar(&params.CreatedBefore, "createdBefore", "", "only list sessions created before this RFC 3339 date time")
+	fs.StringVar(&params.CreatedAfter, "createdAfter", "", "only list sessions created after this RFC 3339 date time")
+	hasParticipants := fs.String("hasParticipants", "", "only list sessions with (true) or without (false) participants")
+	metadata := metadataFlag{}
+	fs.Var(metadata, "metadata", "only list sessions with this key=value metadata entry (repeatable)")
+	sort := fs.String("sort", "", "order of listed sessions: creationDateTime or name, prefixed with - for descending order")
+	fs.IntVar(&params.Limit, "limit", 0, "maximum number of listed sessions per page (server default when 0)")
+	fs.StringVar(&params.Cursor, "cursor", "", "cursor of the page to list, as printed with the previous page")
+	all := fs.Bool("all", false, "list all pages")
+	if _, err := parseArgs(fs, args, 0, "[-namePrefix PREFIX] [-metadata KEY=VALUE] [-sort SORT] [-all]"); err != nil {
+		return err
+	}
+	if len(*hasParticipants) > 0 {
+		b, err := strconv.ParseBool(*hasParticipants)
+		if err != nil {
+			fmt.Fprintln(fs.Output(), "hasParticipants must be true or false")
+			return errUsage
+		}
+		params.HasParticipants = &b
+	}
+	if len(metadata) > 0 {
+		params.Metadata = metadata
+	}
+	params.Descending = strings.HasPrefix(*sort, "-")
+	params.Sort = sfu.SessionSort(strings.TrimPrefix(*sort, "-"))
+	result, err := listSessionsPage(ctx, c, params)
+	for err == nil && *all && len(result.NextCursor) > 0 {
+		params.Cursor = result.NextCursor
+		var next sfu.ListSessionsResult
+		next, err = listSessionsPage(ctx, c, params)
+		next.Sessions = append(result.Sessions, next.Sessions...)
+		result = next
+	}
+	if err != nil {
+		return err
+	}
+	if err = p.sessions(result, result.Sessions...); err != nil {
+		return err
+	}
+	if len(result.NextCursor) > 0 && p.format == tableOutput {
+		fmt.Fprintf(p.notes, "more sessions: -cursor %s\n", result.NextCursor)
+	}
+	return nil
+}
+
+// listSessionsPage lists a page of sessions.
+func listSessionsPage(ctx context.Context, c *client.Client, params sfu.ListSessionsParams) (sfu.ListSessionsResult, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.ListSessionsContext(ctx, params)
+	if err != nil {
+		return result, err
+	}
+	return result, checkResult(result.Errors, true, "sessions")
+}
+
+// metadataFlag is a repeatable flag of key=value metadata entries.
+type metadataFlag map[string]string
+
+func (f metadataFlag) String() string {
+	entries := make([]string, 0, len(f))
+	for k, v := range f {
+		entries = append(entries, k+"="+v)
+	}
+	return strings.Join(entries, ",")
+}
+
+func (f metadataFlag) Set(entry string) error {
+	k, v, found := strings.Cut(entry, "=")
+	if !found {
+		return errors.New("metadata entries must be key=value pairs")
+	}
+	f[k] = v
+	return nil
+}
+
+// unsetFlag is a repeatable flag of metadata keys to remove.
+type unsetFlag []string
+
+func (f *unsetFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *unsetFlag) Set(key string) error {
+	*f = append(*f, key)
+	return nil
+}
+
+// metadataPatch returns the JSON Merge Patch of a metadata map setting the
+// entries of set and removing the keys of unset, or nil if it changes
+// nothing.
+func metadataPatch(set metadataFlag, unset unsetFlag) map[string]any {
+	if len(set) == 0 && len(unset) == 0 {
+		return nil
+	}
+	patch := make(map[string]any)
+	for _, k := range unset {
+		patch[k] = nil
+	}
+	for k, v := range set {
+		patch[k] = v
+	}
+	return patch
+}
+
+// revisionFlag defines the -revision flag of commands changing an object
+// of fs, which are only applied to the given revision of the object.
+func revisionFlag(fs *flag.FlagSet) *int64 {
+	return fs.Int64("revision", 0, "only apply the change if the current revision is this one (any revision when 0)")
+}
+
+// revisionConditions returns the conditions of a change given -revision.
+func revisionConditions(revision int64) sfu.Conditions {
+	if revision == 0 {
+		return sfu.Conditions{}
+	}
+	return sfu.Conditions{IfMatch: []string{sfu.ETag(revision)}}
+}
+
+func updateSession(ctx context.Context, c *client.Client, p printer, args []string) error {
+	fs := flag.NewFlagSet("sessions update", flag.ContinueOnError)
+	name := fs.String("name", "", "new name of the session")
+	fs.Int("maxParticipants", 0, "new maximum number of participants (server limit when 0)")
+	fs.Int("maxPublishers", 0, "new maximum number of participants publishing tracks (server limit when 0)")
+	fs.Int("maxVideoTracks", 0, "new maximum number of video tracks (server limit when 0)")
+	metadata := metadataFlag{}
+	fs.Var(metadata, "metadata", "key=value metadata entry to set (repeatable)")
+	unset := unsetFlag{}
+	fs.Var(&unset, "unset", "key of a metadata entry to remove (repeatable)")
+	revision := revisionFlag(fs)
+	positional, err := parseArgs(fs, args, 1, "SESSION [-name NAME] [-metadata KEY=VALUE] [-unset KEY] [-revision N]")
+	if err != nil {
+		return err
+	}
+	patch := make(map[string]any)
+	limits := make(map[string]any)
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			patch["name"] = *name
+		case "maxParticipants", "maxPublishers", "maxVideoTracks":
+			limits[f.Name] = f.Value.(flag.Getter).Get()
+		}
+	})
+	if len(limits) > 0 {
+		patch["limits"] = limits
+	}
+	if m := metadataPatch(metadata, unset); m != nil {
+		patch["metadata"] = m
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.UpdateSessionContext(ctx, sfu.UpdateSessionParams{
+		Id:         positional[0],
+		Patch:      data,
+		Conditions: revisionConditions(*revision),
+	})
+	if err != nil {
+		return err
+	}
+	if err = checkResult(result.Errors, result.Session != nil, "session"); err != nil {
+		return err
+	}
+	return p.sessions(result, result.Session)
+}
+
+func deleteSession(ctx context.Context, c *client.Client, p printer, args []string) error {
+	fs := flag.NewFlagSet("sessions delete", flag.ContinueOnError)
+	revision := revisionFlag(fs)
+	positional, err := parseArgs(fs, args, 1, "SESSION [-revision N]")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.DeleteSessionContext(ctx, sfu.DeleteSessionParams{Id: positional[0], Conditions: revisionConditions(*revision)})
+	if err != nil {
+		return err
+	}
+	if err = checkResult(result.Errors, result.Session != nil, "session"); err != nil {
+		return err
+	}
+	return p.sessions(result, result.Session)
+}
+
+func addParticipant(ctx context.Context, c *client.Client, p printer, args []string) error {
+	fs := flag.NewFlagSet("participants add", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the participant")
+	metadata := metadataFlag{}
+	fs.Var(metadata, "metadata", "key=value metadata entry of the participant (repeatable)")
+	idempotencyKey := idempotencyKeyFlag(fs)
+	positional, err := parseArgs(fs, args, 1, "SESSION -name NAME")
+	if err != nil {
+		return err
+	}
+	params := sfu.AddParticipantParams{SessionId: positional[0], Name: *name, IdempotencyKey: *idempotencyKey}
+	if len(metadata) > 0 {
+		params.Metadata = metadata
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.AddParticipantContext(ctx, params)
+	if err != nil {
+		return err
+	}
+	if err = checkResult(result.Errors, result.Participant != nil, "session"); err != nil {
+		return err
+	}
+	return p.participants(result, result.Participant)
+}
+
+func getParticipant(ctx context.Context, c *client.Client, p printer, args []string) error {
+	positional, err := parseArgs(flag.NewFlagSet("participants get", flag.ContinueOnError), args, 2, "SESSION PARTICIPANT")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.GetParticipantContext(ctx, sfu.GetParticipantParams{SessionId: positional[0], ParticipantId: positional[1]})
+	if err != nil {
+		return err
+	}
+	if err = checkResult(result.Errors, result.Participant != nil, "participant"); err != nil {
+		return err
+	}
+	return p.participants(result, result.Participant)
+}
+
+func listParticipants(ctx context.Context, c *client.Client, p printer, args []string) error {
+	positional, err := parseArgs(flag.NewFlagSet("participants list", flag.ContinueOnError), args, 1, "SESSION")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.GetParticipantsContext(ctx, sfu.GetParticipantsParams{SessionId: positional[0]})
+	if err != nil {
+		return err
+	}
+	if err = checkResult(result.Errors, true, "session"); err != nil {
+		return err
+	}
+	return p.participants(result, result.Participants...)
+}
+
+func updateParticipant(ctx context.Context, c *client.Client, p printer, args []string) error {
+	fs := flag.NewFlagSet("participants update", flag.ContinueOnError)
+	name := fs.String("name", "", "new name of the participant")
+	metadata := metadataFlag{}
+	fs.Var(metadata, "metadata", "key=value metadata entry to set (repeatable)")
+	unset := unsetFlag{}
+	fs.Var(&unset, "unset", "key of a metadata entry to remove (repeatable)")
+	revision := revisionFlag(fs)
+	positional, err := parseArgs(fs, args, 2, "SESSION PARTICIPANT [-name NAME] [-metadata KEY=VALUE] [-unset KEY] [-revision N]")
+	if err != nil {
+		return err
+	}
+	patch := make(map[string]any)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "name" {
+			patch["name"] = *name
+		}
+	})
+	if m := metadataPatch(metadata, unset); m != nil {
+		patch["metadata"] = m
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.UpdateParticipantContext(ctx, sfu.UpdateParticipantParams{
+		SessionId:     positional[0],
+		ParticipantId: positional[1],
+		Patch:         data,
+		Conditions:    revisionConditions(*revision),
+	})
+	if err != nil {
+		return err
+	}
+	if err = checkResult(result.Errors, result.Participant != nil, "participant"); err != nil {
+		return err
+	}
+	return p.participants(result, result.Participant)
+}
+
+func kickParticipant(ctx context.Context, c *client.Client, p printer, args []string) error {
+	fs := flag.NewFlagSet("participants kick", flag.ContinueOnError)
+	revision := revisionFlag(fs)
+	positional, err := parseArgs(fs, args, 2, "SESSION PARTICIPANT [-revision N]")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	result, err := c.DeleteParticipantContext(ctx, sfu.DeleteParticipantParams{
+		SessionId:     positional[0],
+		ParticipantId: positional[1],
+		Conditions:    revisionConditions(*revision),
+	})
+	if err != nil {
+		return err
+	}
+	if err = checkResult(result.Errors, result.Participant != nil, "participant"); err != nil {
+		return err
+	}
+	return p.participants(result, result.Participant)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"alovenio.com/blackbird/sfu"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the REST API of a new session handler, used by the
+// commands run afterwards, whose settings are reset to their defaults.
+func newTestServer(t *testing.T) *sfu.WebRtcSessionHandler {
+	t.Helper()
+	h := sfu.NewWebRtcSessionHandler()
+	ts := httptest.NewServer((&sfu.Server{}).Handler(h))
+	t.Cleanup(ts.Close)
+	t.Setenv("BLACKBIRD_SERVER", ts.URL)
+	for _, name := range []string{"BLACKBIRD_TOKEN", "BLACKBIRD_API_VERSION", "BLACKBIRD_OUTPUT", "BLACKBIRD_CTL_CONFIG"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	flag.CommandLine.SetOutput(io.Discard)
+	return h
+}
+
+// runCommand runs the command given by args, returning its exit code and
+// what it wrote to its standard output and error.
+func runCommand(ctx context.Context, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(ctx, args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args       []string
+		n          int
+		positional []string
+		name       string
+		err        error
+	}{
+		{[]string{"s1", "-name", "alice"}, 1, []string{"s1"}, "alice", nil},
+		{[]string{"-name", "alice", "s1", "p1"}, 2, []string{"s1", "p1"}, "alice", nil},
+		{[]string{"s1", "-name=alice", "p1"}, 2, []string{"s1", "p1"}, "alice", nil},
+		{[]string{"-name", "alice"}, 1, nil, "", errUsage},
+		{[]string{"s1", "s2"}, 1, nil, "", errUsage},
+		{[]string{"s1", "-unknown"}, 1, nil, "", errUsage},
+		{[]string{"-h"}, 0, nil, "", flag.ErrHelp},
+	}
+	for _, test := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		name := fs.String("name", "", "")
+		positional, err := parseArgs(fs, test.args, test.n, "")
+		if !errors.Is(err, test.err) {
+			t.Errorf("parseArgs(%q) error = %v, want %v", test.args, err, test.err)
+			continue
+		}
+		if err == nil && (strings.Join(positional, " ") != strings.Join(test.positional, " ") || *name != test.name) {
+			t.Errorf("parseArgs(%q) = %q with -name %q, want %q with -name %q", test.args, positional, *name, test.positional, test.name)
+		}
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	h := newTestServer(t)
+	session, _ := h.CreateSession(sfu.CreateSessionParams{Name: "existing"})
+	missingConfig := filepath.Join(t.TempDir(), "missing.yaml")
+	tests := []struct {
+		args []string
+		env  map[string]string
+		want int
+	}{
+		{nil, nil, exitUsage},
+		{[]string{"sessions"}, nil, exitUsage},
+		{[]string{"sessions", "rename"}, nil, exitUsage},
+		{[]string{"sessions", "get"}, nil, exitUsage},
+		{[]string{"sessions", "get", "-h"}, nil, exitOk},
+		{[]string{"sessions", "list", "-hasParticipants", "maybe"}, nil, exitUsage},
+		{[]string{"sessions", "list"}, map[string]string{"BLACKBIRD_OUTPUT": "yaml"}, exitUsage},
+		{[]string{"sessions", "list"}, map[string]string{"BLACKBIRD_CTL_CONFIG": missingConfig}, exitUsage},
+		{[]string{"sessions", "create", "-name", "created"}, nil, exitOk},
+		{[]string{"sessions", "create", "-name", " "}, nil, exitError},
+		{[]string{"sessions", "create", "-name", "created", "-maxPublishers", "2", "-maxVideoTracks", "4"}, nil, exitOk},
+		{[]string{"sessions", "create", "-name", "created", "-maxVideoTracks", "-1"}, nil, exitError},
+		{[]string{"sessions", "get", session.Session.Id}, nil, exitOk},
+		{[]string{"sessions", "get", "0000000000"}, nil, exitError},
+		{[]string{"sessions", "delete", session.Session.Id, "-revision", "99"}, nil, exitError},
+		{[]string{"participants", "add", session.Session.Id, "-name", "alice"}, nil, exitOk},
+		{[]string{"participants", "list", "0000000000"}, nil, exitError},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			if code, _, stderr := runCommand(context.Background(), test.args...); code != test.want {
+				t.Errorf("exit code = %d, want %d, stderr %s", code, test.want, stderr)
+			}
+		})
+	}
+}
+
+func TestInterruptedExitCodes(t *testing.T) {
+	newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if code, _, _ := runCommand(ctx, "sessions", "list"); code != exitError {
+		t.Errorf("interrupted sessions list exit code = %d, want %d", code, exitError)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if code, _, stderr := runCommand(ctx, "events", "tail"); code != exitOk {
+		t.Errorf("interrupted events tail exit code = %d, want %d, stderr %s", code, exitOk, stderr)
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	h := newTestServer(t)
+	session, _ := h.CreateSession(sfu.CreateSessionParams{Name: "standup", Metadata: map[string]string{"team": "core", "floor": "2"}})
+
+	code, stdout, _ := runCommand(context.Background(), "sessions", "get", session.Session.Id)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if code != exitOk || len(lines) != 2 {
+		t.Fatalf("sessions get = %d, %q, want a header and a row", code, stdout)
+	}
+	if fields := strings.Fields(lines[0]); fields[0] != "ID" || fields[1] != "NAME" {
+		t.Errorf("table header = %q", lines[0])
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != session.Session.Id || fields[1] != "standup" ||
+		!strings.Contains(lines[1], "floor=2,team=core") {
+		t.Errorf("table row = %q", lines[1])
+	}
+
+	t.Setenv("BLACKBIRD_OUTPUT", "json")
+	code, stdout, _ = runCommand(context.Background(), "sessions", "get", session.Session.Id)
+	var result sfu.GetSessionResult
+	if err := json.Unmarshal([]byte(stdout), &result); code != exitOk || err != nil || result.Session == nil || result.Session.Name != "standup" {
+		t.Errorf("sessions get in json = %d, %q, %v", code, stdout, err)
+	}
+
+	// the configuration file sets the output when the environment does not
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, []byte("output: json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BLACKBIRD_OUTPUT", "")
+	t.Setenv("BLACKBIRD_CTL_CONFIG", config)
+	var participants sfu.GetParticipantsResult
+	_, stdout, _ = runCommand(context.Background(), "participants", "list", session.Session.Id)
+	if err := json.Unmarshal([]byte(stdout), &participants); err != nil {
+		t.Errorf("participants list with the configured output = %q, want json: %v", stdout, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"alovenio.com/blackbird/sfu"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output formats.
+const (
+	tableOutput = "table"
+	jsonOutput  = "json"
+)
+
+// printer writes API objects in table or JSON format.
+type printer struct {
+	w io.Writer
+	// Writer of notes about the output, e.g. that more pages are available.
+	notes  io.Writer
+	format string
+}
+
+// print writes v, as is in JSON format, or as a table built by rows.
+func (p printer) print(v any, header []string, rows [][]string) error {
+	if p.format == jsonOutput {
+		encoder := json.NewEncoder(p.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	writeRow(tw, header)
+	for _, row := range rows {
+		writeRow(tw, row)
+	}
+	return tw.Flush()
+}
+
+// sessions writes sessions, as a table with one row per session.
+func (p printer) sessions(v any, sessions ...*sfu.Session) error {
+	var rows [][]string
+	for _, s := range sessions {
+		rows = append(rows, []string{s.Id, s.Name, s.CreationDateTime,
+			limit(s.Limits.MaxParticipants), metadata(s.Metadata), strconv.FormatInt(s.Revision, 10)})
+	}
+	return p.print(v, []string{"ID", "NAME", "CREATED", "MAX PARTICIPANTS", "METADATA", "REVISION"}, rows)
+}
+
+// participants writes participants, as a table with one row per participant.
+func (p printer) participants(v any, participants ...*sfu.Participant) error {
+	var rows [][]string
+	for _, pt := range participants {
+		rows = append(rows, []string{pt.Id, pt.Name, pt.SessionId, pt.CreationDateTime, string(pt.ConnectionState),
+			metadata(pt.Metadata), strconv.FormatInt(pt.Revision, 10)})
+	}
+	return p.print(v, []string{"ID", "NAME", "SESSION", "CREATED", "CONNECTION", "METADATA", "REVISION"}, rows)
+}
+
+// event writes a single event, as a table row without header.
+func (p printer) event(e sfu.Event) error {
+	if p.format == jsonOutput {
+		return json.NewEncoder(p.w).Encode(e)
+	}
+	_, err := fmt.Fprintf(p.w, "%s\t%s\t%s\t%s\t%s\n", e.DateTime, e.Type, e.SessionId, e.ParticipantId, e.Reason)
+	return err
+}
+
+// limit formats a capacity limit, where zero means unlimited.
+func limit(v int) string {
+	if v == 0 {
+		return "-"
+	}
+	return strconv.Itoa(v)
+}
+
+// metadata formats a metadata map as comma separated key=value entries,
+// sorted by key.
+func metadata(m map[string]string) string {
+	if len(m) == 0 {
+		return "-"
+	}
+	entries := make([]string, 0, len(m))
+	for k, v := range m {
+		entries = append(entries, k+"="+v)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+func writeRow(w io.Writer, cells []string) {
+	for i, c := range cells {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, c)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,602 @@
+// Package client provides a typed client of the REST API of Blackbird's
+// SFU server. Client implements sfu.SessionHandler, so a remote server can
+// be used anywhere a local session handler is.
+package client
+
+import (
+	"alovenio.com/blackbird/sfu"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// problemContentType is the content type of problem details.
+const problemContentType = "application/problem+json"
+
+// DefaultVersion is the version of the REST API used when no other one is
+// configured.
+const DefaultVersion = "v2"
+
+// Error is returned for responses of the REST API reporting an unexpected
+// condition, i.e. any status other than 2xx, 400, 404, 409, 412, 422 and
+// 429.
+type Error struct {
+	// HTTP status of the response.
+	Status int
+	// Errors reported in the response's body, if any.
+	Errors []sfu.FieldError
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("blackbird: %d %s", e.Status, http.StatusText(e.Status))
+	if len(e.Errors) > 0 {
+		details := make([]string, len(e.Errors))
+		for i, err := range e.Errors {
+			details[i] = err.Detail
+		}
+		msg += ": " + strings.Join(details, ", ")
+	}
+	return msg
+}
+
+// RetryPolicy holds the policy of retries of failed requests. Only
+// requests which can safely be repeated, i.e. GET, PUT, DELETE and those
+// with an idempotency key, are retried, on network errors and on 429, 502,
+// 503 and 504 responses.
+type RetryPolicy struct {
+	// Maximum number of retries of a failed request. Zero disables retries.
+	MaxRetries int
+	// Delay before the first retry. It doubles on every subsequent retry,
+	// up to MaxBackoff. A Retry-After header overrides it.
+	InitialBackoff time.Duration
+	// Maximum delay between two retries.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy returns a RetryPolicy with sensible defaults.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     3,
+		InitialBackoff: 200 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+	}
+}
+
+// Client sends requests to the REST API of a Blackbird server.
+type Client struct {
+	server  string
+	version string
+	token   string
+	http    *http.Client
+	retry   RetryPolicy
+	timeout time.Duration
+}
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithVersion sets the version of the REST API. DefaultVersion is used
+// by default.
+func WithVersion(version string) Option {
+	return func(c *Client) {
+		c.version = version
+	}
+}
+
+// WithToken sets the bearer token sent with every request.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sets the http client sending every request.
+// http.DefaultClient is used by default.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// WithRetryPolicy sets the retry policy of failed requests.
+// DefaultRetryPolicy is used by default.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithTimeout sets the timeout of the calls made through the
+// sfu.SessionHandler methods, which have no context. Zero disables it.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// New creates and returns a properly initialized Client sending requests
+// to the server at base URL server, e.g. https://blackbird.example.org.
+func New(server string, opts ...Option) *Client {
+	c := &Client{
+		server:  strings.TrimSuffix(server, "/"),
+		version: DefaultVersion,
+		http:    http.DefaultClient,
+		retry:   DefaultRetryPolicy(),
+		timeout: 30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// url returns the URL of a route of the REST API, where path is relative
+// to the API version.
+func (c *Client) url(path string, query url.Values) string {
+	u := c.server + "/" + c.version + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// newRequest creates a request to a route of the REST API with header,
+// sending body, if not nil, as JSON, or as a JSON Merge Patch for PATCH
+// requests.
+func (c *Client) newRequest(ctx context.Context, method string, path string, query url.Values, header http.Header, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, query), reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil && method == http.MethodPatch {
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	} else if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+	// errors are decoded as problem details whatever the version
+	req.Header.Set("Accept", "application/json, application/problem+json")
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// Do sends a request to a route of the REST API, where path is relative to
+// the API version, encoding body as JSON if not nil, and decodes the
+// response's body into result. Responses reporting expected conditions,
+// i.e. 400, 404, 409, 412, 422 and 429, are decoded as well, as the
+// results of the sfu.SessionHandler methods carry their errors: the errors
+// and limit of problem details are set in the Errors, FieldErrors and
+// Limit of results, and the current object of those whose conditions do
+// not hold is retrieved, as the local handler reports it. It returns the
+// status of the response, and an *Error for any unexpected one. Typed
+// methods should be preferred, Do is meant for routes without one.
+func (c *Client) Do(ctx context.Context, method string, path string, query url.Values, body any, result any) (int, error) {
+	return c.do(ctx, method, path, query, nil, body, result)
+}
+
+// do sends a request with header as Do does.
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, header http.Header, body any, result any) (int, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+	resp, err := c.send(ctx, method, path, query, header, data)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299,
+		resp.StatusCode == http.StatusBadRequest,
+		resp.StatusCode == http.StatusNotFound,
+		resp.StatusCode == http.StatusConflict,
+		resp.StatusCode == http.StatusPreconditionFailed,
+		resp.StatusCode == http.StatusUnprocessableEntity,
+		resp.StatusCode == http.StatusTooManyRequests:
+		if result == nil || len(bytes.TrimSpace(respBody)) == 0 {
+			return resp.StatusCode, nil
+		}
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == problemContentType {
+			var problem sfu.Problem
+			if err = json.Unmarshal(respBody, &problem); err != nil {
+				return resp.StatusCode, fmt.Errorf("blackbird: invalid problem details: %w", err)
+			}
+			if setProblem(result, problem) {
+				if resp.StatusCode == http.StatusPreconditionFailed && method != http.MethodGet {
+					return resp.StatusCode, c.current(ctx, path, result)
+				}
+				return resp.StatusCode, nil
+			}
+		}
+		if err = json.Unmarshal(respBody, result); err != nil {
+			return resp.StatusCode, fmt.Errorf("blackbird: invalid response body: %w", err)
+		}
+		return resp.StatusCode, nil
+	}
+	apiErr := &Error{Status: resp.StatusCode}
+	var errorsBody struct {
+		Errors []sfu.FieldError `json:"errors"`
+	}
+	if json.Unmarshal(respBody, &errorsBody) == nil {
+		apiErr.Errors = errorsBody.Errors
+	}
+	return resp.StatusCode, apiErr
+}
+
+// setProblem sets the Errors, FieldErrors and Limit fields of result, a
+// pointer to the result of a sfu.SessionHandler method, to the errors and
+// limit of problem. It returns false if result has no such fields.
+func setProblem(result any, problem sfu.Problem) bool {
+	v := reflect.ValueOf(result)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	v = v.Elem()
+	var details []string
+	for _, e := range problem.Errors {
+		details = append(details, e.Detail)
+	}
+	set := func(name string, value any) bool {
+		f := v.FieldByName(name)
+		if !f.IsValid() || !f.CanSet() || f.Type() != reflect.TypeOf(value) {
+			return false
+		}
+		f.Set(reflect.ValueOf(value))
+		return true
+	}
+	if !set("Errors", details) || !set("FieldErrors", problem.Errors) {
+		return false
+	}
+	set("Limit", problem.Limit)
+	return true
+}
+
+// current sets the object of result, the result of an operation on the
+// object at path whose conditions do not hold, to its current
+// representation, as sfu.SessionHandler methods report it along with the
+// failed condition. Problem details only carry the errors, so it is
+// retrieved with a request of its own. The object is left nil if it no
+// longer exists.
+func (c *Client) current(ctx context.Context, path string, result any) error {
+	resp, err := c.send(ctx, http.MethodGet, path, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &Error{Status: resp.StatusCode}
+	}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("blackbird: invalid response body: %w", err)
+	}
+	return nil
+}
+
+// send sends a request, retrying it according to the retry policy.
+func (c *Client) send(ctx context.Context, method string, path string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	retryable := method == http.MethodGet || method == http.MethodPut || method == http.MethodDelete ||
+		header.Get("Idempotency-Key") != ""
+	backoff := c.retry.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := c.newRequest(ctx, method, path, query, header, body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.http.Do(req)
+		if !retryable || attempt >= c.retry.MaxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		delay := backoff
+		if resp != nil {
+			if after, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				delay = time.Duration(after) * time.Second
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+		if c.retry.MaxBackoff > 0 && backoff > c.retry.MaxBackoff {
+			backoff = c.retry.MaxBackoff
+		}
+	}
+}
+
+// shouldRetry returns whether a request which got resp, or failed with
+// err, should be retried.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// context returns the context of the calls made through the
+// sfu.SessionHandler methods.
+func (c *Client) context() (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// sessionPath returns the path of a session's route.
+func sessionPath(sessionId string) string {
+	return "/sessions/" + url.PathEscape(sessionId)
+}
+
+// participantPath returns the path of a participant's route.
+func participantPath(sessionId string, participantId string) string {
+	return sessionPath(sessionId) + "/participants/" + url.PathEscape(participantId)
+}
+
+// conditionHeader returns the If-Match and If-None-Match headers of
+// conditions.
+func conditionHeader(conditions sfu.Conditions) http.Header {
+	header := make(http.Header)
+	if conditions.IfMatch != nil {
+		header.Set("If-Match", strings.Join(conditions.IfMatch, ", "))
+	}
+	if conditions.IfNoneMatch != nil {
+		header.Set("If-None-Match", strings.Join(conditions.IfNoneMatch, ", "))
+	}
+	return header
+}
+
+// idempotencyHeader returns the Idempotency-Key header of key, if not
+// blank.
+func idempotencyHeader(key string) http.Header {
+	header := make(http.Header)
+	if len(key) > 0 {
+		header.Set("Idempotency-Key", key)
+	}
+	return header
+}
+
+// CreateSessionContext creates a new live view session. Requests with
+// p.IdempotencyKey are retried, and only create a single session.
+func (c *Client) CreateSessionContext(ctx context.Context, p sfu.CreateSessionParams) (result sfu.CreateSessionResult, err error) {
+	_, err = c.do(ctx, http.MethodPost, "/sessions", nil, idempotencyHeader(p.IdempotencyKey), p, &result)
+	return
+}
+
+// GetSessionContext retrieves an existing live view session. The result's
+// Session is nil if no such session exists.
+func (c *Client) GetSessionContext(ctx context.Context, p sfu.GetSessionParams) (result sfu.GetSessionResult, err error) {
+	_, err = c.Do(ctx, http.MethodGet, sessionPath(p.Id), nil, nil, &result)
+	return
+}
+
+// DeleteSessionContext deletes an existing live view session, if
+// p.Conditions hold. The result's Session is nil if no such session
+// exists.
+func (c *Client) DeleteSessionContext(ctx context.Context, p sfu.DeleteSessionParams) (result sfu.DeleteSessionResult, err error) {
+	_, err = c.do(ctx, http.MethodDelete, sessionPath(p.Id), nil, conditionHeader(p.Conditions), nil, &result)
+	return
+}
+
+// UpdateSessionContext applies the JSON Merge Patch p.Patch to an
+// existing live view session, if p.Conditions hold. The result's Session
+// is nil if no such session exists.
+func (c *Client) UpdateSessionContext(ctx context.Context, p sfu.UpdateSessionParams) (result sfu.UpdateSessionResult, err error) {
+	_, err = c.do(ctx, http.MethodPatch, sessionPath(p.Id), nil, conditionHeader(p.Conditions), p.Patch, &result)
+	return
+}
+
+// ListSessionsContext lists existing live view sessions, a page at a
+// time. The result's NextCursor is the cursor of the next page, blank on
+// the last page.
+func (c *Client) ListSessionsContext(ctx context.Context, p sfu.ListSessionsParams) (result sfu.ListSessionsResult, err error) {
+	_, err = c.Do(ctx, http.MethodGet, "/sessions", listSessionsQuery(p), nil, &result)
+	return
+}
+
+// listSessionsQuery returns the query of a request listing sessions.
+func listSessionsQuery(p sfu.ListSessionsParams) url.Values {
+	query := url.Values{}
+	set := func(key string, value string) {
+		if len(value) > 0 {
+			query.Set(key, value)
+		}
+	}
+	set("namePrefix", p.NamePrefix)
+	set("createdBefore", p.CreatedBefore)
+	set("createdAfter", p.CreatedAfter)
+	if p.HasParticipants != nil {
+		set("hasParticipants", strconv.FormatBool(*p.HasParticipants))
+	}
+	for k, v := range p.Metadata {
+		query.Add("metadata", k+":"+v)
+	}
+	if len(p.Sort) > 0 || p.Descending {
+		sort := string(p.Sort)
+		if len(sort) == 0 {
+			sort = string(sfu.SortByCreation)
+		}
+		if p.Descending {
+			sort = "-" + sort
+		}
+		set("sort", sort)
+	}
+	if p.Limit != 0 {
+		set("limit", strconv.Itoa(p.Limit))
+	}
+	set("cursor", p.Cursor)
+	return query
+}
+
+// AddParticipantContext adds a new participant to an existing live view
+// session. Requests with p.IdempotencyKey are retried, and only add a
+// single participant.
+func (c *Client) AddParticipantContext(ctx context.Context, p sfu.AddParticipantParams) (result sfu.AddParticipantResult, err error) {
+	_, err = c.do(ctx, http.MethodPost, sessionPath(p.SessionId)+"/participants", nil, idempotencyHeader(p.IdempotencyKey), p, &result)
+	return
+}
+
+// GetParticipantContext retrieves an existing participant of a live view
+// session. The result's Participant is nil if no such participant exists.
+func (c *Client) GetParticipantContext(ctx context.Context, p sfu.GetParticipantParams) (result sfu.GetParticipantResult, err error) {
+	_, err = c.Do(ctx, http.MethodGet, participantPath(p.SessionId, p.ParticipantId), nil, nil, &result)
+	return
+}
+
+// UpdateParticipantContext updates an existing participant of a live view
+// session, applying the JSON Merge Patch p.Patch if not nil, if
+// p.Conditions hold. The result's Participant is nil if no such
+// participant exists.
+func (c *Client) UpdateParticipantContext(ctx context.Context, p sfu.UpdateParticipantParams) (result sfu.UpdateParticipantResult, err error) {
+	header := conditionHeader(p.Conditions)
+	if p.Patch != nil {
+		_, err = c.do(ctx, http.MethodPatch, participantPath(p.SessionId, p.ParticipantId), nil, header, p.Patch, &result)
+		return
+	}
+	_, err = c.do(ctx, http.MethodPut, participantPath(p.SessionId, p.ParticipantId), nil, header, p, &result)
+	return
+}
+
+// DeleteParticipantContext removes an existing participant from a live
+// view session, if p.Conditions hold. The result's Participant is nil if
+// no such participant exists.
+func (c *Client) DeleteParticipantContext(ctx context.Context, p sfu.DeleteParticipantParams) (result sfu.DeleteParticipantResult, err error) {
+	_, err = c.do(ctx, http.MethodDelete, participantPath(p.SessionId, p.ParticipantId), nil, conditionHeader(p.Conditions), nil, &result)
+	return
+}
+
+// GetParticipantsContext retrieves all participants of an existing live
+// view session.
+func (c *Client) GetParticipantsContext(ctx context.Context, p sfu.GetParticipantsParams) (result sfu.GetParticipantsResult, err error) {
+	_, err = c.Do(ctx, http.MethodGet, sessionPath(p.SessionId)+"/participants", nil, nil, &result)
+	return
+}
+
+// HeartbeatContext records that an existing participant of a live view
+// session is still alive. The result's Participant is nil if no such
+// participant exists.
+func (c *Client) HeartbeatContext(ctx context.Context, p sfu.HeartbeatParams) (result sfu.HeartbeatResult, err error) {
+	_, err = c.Do(ctx, http.MethodPut, participantPath(p.SessionId, p.ParticipantId)+"/heartbeat", nil, p, &result)
+	return
+}
+
+// NegotiateContext sends the SDP offer of a participant of a live view
+// session, returning the answer of the server. The result's Participant is
+// nil if no such participant exists.
+func (c *Client) NegotiateContext(ctx context.Context, p sfu.NegotiateParams) (result sfu.NegotiateResult, err error) {
+	_, err = c.Do(ctx, http.MethodPost, participantPath(p.SessionId, p.ParticipantId)+"/negotiate", nil, p, &result)
+	return
+}
+
+/**
+========================================
+     SessionHandler interface
+========================================
+*/
+
+func (c *Client) CreateSession(p sfu.CreateSessionParams) (sfu.CreateSessionResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.CreateSessionContext(ctx, p)
+}
+
+func (c *Client) GetSession(p sfu.GetSessionParams) (sfu.GetSessionResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.GetSessionContext(ctx, p)
+}
+
+func (c *Client) DeleteSession(p sfu.DeleteSessionParams) (sfu.DeleteSessionResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.DeleteSessionContext(ctx, p)
+}
+
+func (c *Client) UpdateSession(p sfu.UpdateSessionParams) (sfu.UpdateSessionResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.UpdateSessionContext(ctx, p)
+}
+
+func (c *Client) ListSessions(p sfu.ListSessionsParams) (sfu.ListSessionsResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.ListSessionsContext(ctx, p)
+}
+
+func (c *Client) AddParticipant(p sfu.AddParticipantParams) (sfu.AddParticipantResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.AddParticipantContext(ctx, p)
+}
+
+func (c *Client) GetParticipant(p sfu.GetParticipantParams) (sfu.GetParticipantResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.GetParticipantContext(ctx, p)
+}
+
+func (c *Client) UpdateParticipant(p sfu.UpdateParticipantParams) (sfu.UpdateParticipantResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.UpdateParticipantContext(ctx, p)
+}
+
+func (c *Client) DeleteParticipant(p sfu.DeleteParticipantParams) (sfu.DeleteParticipantResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.DeleteParticipantContext(ctx, p)
+}
+
+func (c *Client) GetParticipants(p sfu.GetParticipantsParams) (sfu.GetParticipantsResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.GetParticipantsContext(ctx, p)
+}
+
+func (c *Client) Heartbeat(p sfu.HeartbeatParams) (sfu.HeartbeatResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.HeartbeatContext(ctx, p)
+}
+
+func (c *Client) Negotiate(p sfu.NegotiateParams) (sfu.NegotiateResult, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.NegotiateContext(ctx, p)
+}
+
+var _ sfu.SessionHandler = (*Client)(nil)
@@ -0,0 +1,323 @@
+package client
+
+import (
+	"alovenio.com/blackbird/sfu"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of the REST API, at version, of a new
+// session handler with limits.
+func newTestClient(t *testing.T, version string, limits sfu.Limits) *Client {
+	t.Helper()
+	ts := httptest.NewServer((&sfu.Server{}).Handler(sfu.NewWebRtcSessionHandler(sfu.WithLimits(limits))))
+	t.Cleanup(ts.Close)
+	return New(ts.URL, WithVersion(version))
+}
+
+// newStatusServer returns a server answering every request with status,
+// header and body, and a count of the requests it got.
+func newStatusServer(t *testing.T, status int, header http.Header, body string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &requests
+}
+
+func TestSessionHandler(t *testing.T) {
+	for _, version := range []string{"v1", "v2"} {
+		t.Run(version, func(t *testing.T) {
+			var c sfu.SessionHandler = newTestClient(t, version, sfu.Limits{})
+
+			created, err := c.CreateSession(sfu.CreateSessionParams{Name: "standup", Metadata: map[string]string{"team": "core"}})
+			if err != nil || created.Session == nil || created.Session.Name != "standup" {
+				t.Fatalf("CreateSession() = %+v, %v", created, err)
+			}
+			id := created.Session.Id
+			got, err := c.GetSession(sfu.GetSessionParams{Id: id})
+			if err != nil || got.Session == nil || got.Session.Metadata["team"] != "core" {
+				t.Errorf("GetSession() = %+v, %v", got, err)
+			}
+			updated, err := c.UpdateSession(sfu.UpdateSessionParams{Id: id, Patch: json.RawMessage(`{"name":"retro"}`),
+				Conditions: sfu.Conditions{IfMatch: []string{sfu.ETag(created.Session.Revision)}}})
+			if err != nil || updated.Session == nil || updated.Session.Name != "retro" {
+				t.Errorf("UpdateSession() = %+v, %v", updated, err)
+			}
+			listed, err := c.ListSessions(sfu.ListSessionsParams{NamePrefix: "ret"})
+			if err != nil || len(listed.Sessions) != 1 || listed.Sessions[0].Id != id {
+				t.Errorf("ListSessions() = %+v, %v", listed, err)
+			}
+
+			added, err := c.AddParticipant(sfu.AddParticipantParams{SessionId: id, Name: "alice"})
+			if err != nil || added.Participant == nil || added.Participant.Name != "alice" {
+				t.Fatalf("AddParticipant() = %+v, %v", added, err)
+			}
+			pid := added.Participant.Id
+			participant, err := c.GetParticipant(sfu.GetParticipantParams{SessionId: id, ParticipantId: pid})
+			if err != nil || participant.Participant == nil || participant.Participant.Id != pid {
+				t.Errorf("GetParticipant() = %+v, %v", participant, err)
+			}
+			renamed, err := c.UpdateParticipant(sfu.UpdateParticipantParams{SessionId: id, ParticipantId: pid, Name: "alicia"})
+			if err != nil || renamed.Participant == nil || renamed.Participant.Name != "alicia" {
+				t.Errorf("UpdateParticipant() = %+v, %v", renamed, err)
+			}
+			patched, err := c.UpdateParticipant(sfu.UpdateParticipantParams{SessionId: id, ParticipantId: pid,
+				Patch: json.RawMessage(`{"metadata":{"role":"host"}}`)})
+			if err != nil || patched.Participant == nil || patched.Participant.Name != "alicia" || patched.Participant.Metadata["role"] != "host" {
+				t.Errorf("UpdateParticipant() with a patch = %+v, %v", patched, err)
+			}
+			heartbeat, err := c.Heartbeat(sfu.HeartbeatParams{SessionId: id, ParticipantId: pid, ConnectionState: sfu.ConnectionConnected})
+			if err != nil || heartbeat.Participant == nil || heartbeat.Participant.ConnectionState != sfu.ConnectionConnected {
+				t.Errorf("Heartbeat() = %+v, %v", heartbeat, err)
+			}
+			participants, err := c.GetParticipants(sfu.GetParticipantsParams{SessionId: id})
+			if err != nil || len(participants.Participants) != 1 {
+				t.Errorf("GetParticipants() = %+v, %v", participants, err)
+			}
+			removed, err := c.DeleteParticipant(sfu.DeleteParticipantParams{SessionId: id, ParticipantId: pid})
+			if err != nil || removed.Participant == nil || removed.Participant.Id != pid {
+				t.Errorf("DeleteParticipant() = %+v, %v", removed, err)
+			}
+
+			stale, err := c.DeleteSession(sfu.DeleteSessionParams{Id: id, Conditions: sfu.Conditions{IfMatch: []string{sfu.ETag(created.Session.Revision)}}})
+			if err != nil || stale.Session == nil || stale.Session.Id != id || len(stale.Errors) == 0 {
+				t.Errorf("DeleteSession() of a stale revision = %+v, %v, want the current session and errors", stale, err)
+			}
+			deleted, err := c.DeleteSession(sfu.DeleteSessionParams{Id: id})
+			if err != nil || deleted.Session == nil || deleted.Session.Id != id {
+				t.Errorf("DeleteSession() = %+v, %v", deleted, err)
+			}
+			if got, err = c.GetSession(sfu.GetSessionParams{Id: id}); err != nil || got.Session != nil {
+				t.Errorf("GetSession() of a deleted session = %+v, %v, want no session", got, err)
+			}
+		})
+	}
+}
+
+func TestPreconditionFailedParity(t *testing.T) {
+	h := sfu.NewWebRtcSessionHandler()
+	ts := httptest.NewServer((&sfu.Server{}).Handler(h))
+	t.Cleanup(ts.Close)
+	c := New(ts.URL)
+
+	created, err := h.CreateSession(sfu.CreateSessionParams{Name: "standup", Metadata: map[string]string{"team": "core"}})
+	if err != nil || created.Session == nil {
+		t.Fatalf("CreateSession() = %+v, %v", created, err)
+	}
+	id := created.Session.Id
+	added, err := h.AddParticipant(sfu.AddParticipantParams{SessionId: id, Name: "alice"})
+	if err != nil || added.Participant == nil {
+		t.Fatalf("AddParticipant() = %+v, %v", added, err)
+	}
+	pid := added.Participant.Id
+	stale := sfu.Conditions{IfMatch: []string{sfu.ETag(0)}}
+
+	tests := []struct {
+		name string
+		call func(handler sfu.SessionHandler) (any, error)
+	}{
+		{"UpdateSession", func(handler sfu.SessionHandler) (any, error) {
+			return handler.UpdateSession(sfu.UpdateSessionParams{Id: id, Patch: json.RawMessage(`{"name":"retro"}`), Conditions: stale})
+		}},
+		{"DeleteSession", func(handler sfu.SessionHandler) (any, error) {
+			return handler.DeleteSession(sfu.DeleteSessionParams{Id: id, Conditions: stale})
+		}},
+		{"UpdateParticipant", func(handler sfu.SessionHandler) (any, error) {
+			return handler.UpdateParticipant(sfu.UpdateParticipantParams{SessionId: id, ParticipantId: pid, Name: "alicia", Conditions: stale})
+		}},
+		{"UpdateParticipant with a patch", func(handler sfu.SessionHandler) (any, error) {
+			return handler.UpdateParticipant(sfu.UpdateParticipantParams{SessionId: id, ParticipantId: pid,
+				Patch: json.RawMessage(`{"name":"alicia"}`), Conditions: stale})
+		}},
+		{"DeleteParticipant", func(handler sfu.SessionHandler) (any, error) {
+			return handler.DeleteParticipant(sfu.DeleteParticipantParams{SessionId: id, ParticipantId: pid, Conditions: stale})
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			local, err := test.call(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			remote, err := test.call(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(remote, local) {
+				t.Errorf("%s() of a stale revision = %+v, want %+v", test.name, remote, local)
+			}
+		})
+	}
+}
+
+func TestErrorsOfVersions(t *testing.T) {
+	limits := sfu.Limits{MaxSessions: 1}
+	for _, version := range []string{"v1", "v2"} {
+		t.Run(version, func(t *testing.T) {
+			c := newTestClient(t, version, limits)
+			invalid, err := c.CreateSession(sfu.CreateSessionParams{Name: " "})
+			if err != nil || invalid.Session != nil || len(invalid.Errors) == 0 {
+				t.Errorf("CreateSession() with a blank name = %+v, %v, want errors", invalid, err)
+			}
+			// only problem details carry the code of each error
+			if version == "v2" && (len(invalid.FieldErrors) == 0 || invalid.FieldErrors[0].Code != sfu.ErrorBlank) {
+				t.Errorf("CreateSession() with a blank name field errors = %+v, want %s", invalid.FieldErrors, sfu.ErrorBlank)
+			}
+
+			if _, err = c.CreateSession(sfu.CreateSessionParams{Name: "first"}); err != nil {
+				t.Fatal(err)
+			}
+			limited, err := c.CreateSession(sfu.CreateSessionParams{Name: "second"})
+			if err != nil || limited.Session != nil || limited.Limit != sfu.ServerSessionsLimit {
+				t.Errorf("CreateSession() beyond the limit = %+v, %v, want limit %s", limited, err, sfu.ServerSessionsLimit)
+			}
+		})
+	}
+}
+
+func TestStatusMapping(t *testing.T) {
+	problem := http.Header{"Content-Type": {problemContentType}}
+	plain := http.Header{"Content-Type": {"application/json"}}
+	tests := []struct {
+		name   string
+		status int
+		header http.Header
+		body   string
+		want   sfu.GetSessionResult
+		err    *Error
+	}{
+		{"ok", http.StatusOK, plain, `{"session":{"id":"abc","name":"standup"}}`,
+			sfu.GetSessionResult{Session: &sfu.Session{Id: "abc", Name: "standup"}}, nil},
+		{"not found without a body", http.StatusNotFound, nil, "", sfu.GetSessionResult{}, nil},
+		{"legacy errors", http.StatusBadRequest, plain, `{"errors":["id is invalid"]}`,
+			sfu.GetSessionResult{Errors: []string{"id is invalid"}}, nil},
+		{"problem details", http.StatusUnprocessableEntity, problem,
+			`{"type":"about:blank","title":"Unprocessable Entity","status":422,"errors":[{"field":"id","code":"invalidId","detail":"id is invalid"}]}`,
+			sfu.GetSessionResult{Errors: []string{"id is invalid"},
+				FieldErrors: []sfu.FieldError{{Field: "id", Code: sfu.ErrorInvalidId, Detail: "id is invalid"}}}, nil},
+		{"unauthorized", http.StatusUnauthorized, problem,
+			`{"type":"about:blank","title":"Unauthorized","status":401,"errors":[{"code":"unauthorized","detail":"invalid token"}]}`,
+			sfu.GetSessionResult{}, &Error{Status: http.StatusUnauthorized, Errors: []sfu.FieldError{{Code: "unauthorized", Detail: "invalid token"}}}},
+		{"internal error", http.StatusInternalServerError, nil, "boom", sfu.GetSessionResult{}, &Error{Status: http.StatusInternalServerError}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts, _ := newStatusServer(t, test.status, test.header, test.body)
+			c := New(ts.URL, WithRetryPolicy(RetryPolicy{}))
+			var result sfu.GetSessionResult
+			status, err := c.Do(context.Background(), http.MethodGet, "/sessions/abc", nil, nil, &result)
+			if status != test.status {
+				t.Errorf("Do() status = %d, want %d", status, test.status)
+			}
+			var apiErr *Error
+			if test.err == nil && err != nil || test.err != nil && (!errors.As(err, &apiErr) || apiErr.Error() != test.err.Error()) {
+				t.Errorf("Do() error = %v, want %v", err, test.err)
+			}
+			if gotJSON, wantJSON := fieldsOf(t, result), fieldsOf(t, test.want); gotJSON != wantJSON {
+				t.Errorf("Do() result = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+
+	ts, _ := newStatusServer(t, http.StatusOK, nil, "{")
+	var result sfu.GetSessionResult
+	if _, err := New(ts.URL).Do(context.Background(), http.MethodGet, "/sessions/abc", nil, nil, &result); err == nil {
+		t.Error("Do() of an invalid body error = nil, want an error")
+	}
+}
+
+// fieldsOf returns result, including its fields hidden from JSON, as JSON.
+func fieldsOf(t *testing.T, result sfu.GetSessionResult) string {
+	t.Helper()
+	data, err := json.Marshal(struct {
+		sfu.GetSessionResult
+		FieldErrors []sfu.FieldError
+	}{result, result.FieldErrors})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRetries(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond}
+	unavailable := http.Header{"Retry-After": {"0"}}
+	tests := []struct {
+		name     string
+		status   int
+		method   string
+		key      string
+		requests int32
+	}{
+		{"GET", http.StatusServiceUnavailable, http.MethodGet, "", 3},
+		{"PUT", http.StatusBadGateway, http.MethodPut, "", 3},
+		{"DELETE", http.StatusGatewayTimeout, http.MethodDelete, "", 3},
+		{"too many requests", http.StatusTooManyRequests, http.MethodGet, "", 3},
+		{"POST", http.StatusServiceUnavailable, http.MethodPost, "", 1},
+		{"POST with an idempotency key", http.StatusServiceUnavailable, http.MethodPost, "create-standup", 3},
+		{"PATCH", http.StatusServiceUnavailable, http.MethodPatch, "", 1},
+		{"internal error", http.StatusInternalServerError, http.MethodGet, "", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts, requests := newStatusServer(t, test.status, unavailable, "")
+			c := New(ts.URL, WithRetryPolicy(policy))
+			c.do(context.Background(), test.method, "/sessions", nil, idempotencyHeader(test.key), nil, nil)
+			if n := requests.Load(); n != test.requests {
+				t.Errorf("%s sent %d requests, want %d", test.method, n, test.requests)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"session":{"id":"abc"}}`))
+	}))
+	defer ts.Close()
+
+	// the backoff of the policy would outlast the context, Retry-After
+	// must be used instead
+	c := New(ts.URL, WithRetryPolicy(RetryPolicy{MaxRetries: 1, InitialBackoff: time.Hour}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	result, err := c.GetSessionContext(ctx, sfu.GetSessionParams{Id: "abc"})
+	if err != nil || result.Session == nil {
+		t.Fatalf("GetSessionContext() = %+v, %v", result, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("GetSessionContext() retried after %s, want at least 1s", elapsed)
+	}
+
+	// a context done while waiting stops the retries
+	requests.Store(0)
+	c = New(ts.URL, WithRetryPolicy(RetryPolicy{MaxRetries: 1, InitialBackoff: time.Hour}))
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = c.GetSessionContext(ctx, sfu.GetSessionParams{Id: "abc"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetSessionContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"alovenio.com/blackbird/sfu"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EventHandler is called for every event received from an event stream,
+// along with the event's id. Returning an error stops the stream.
+type EventHandler func(id string, e sfu.Event) error
+
+// StreamEvents streams the events of session sessionId, or of all sessions
+// if it is blank, starting after the event with id lastEventId, or with
+// new events if it is blank. The stream is resumed whenever the server
+// closes it, until ctx is done, handler returns an error or the server
+// rejects the stream, which is reported as an *Error. When events were
+// lost before the stream was resumed, handler gets an sfu.StreamReset
+// event first.
+func (c *Client) StreamEvents(ctx context.Context, sessionId string, lastEventId string, handler EventHandler) error {
+	path := "/events"
+	if len(sessionId) > 0 {
+		path = sessionPath(sessionId) + "/events"
+	}
+	backoff := c.retry.InitialBackoff
+	for {
+		received, err := c.streamEvents(ctx, path, &lastEventId, handler)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var apiErr *Error
+		var handlerErr handlerError
+		if errors.As(err, &apiErr) {
+			return err
+		}
+		if errors.As(err, &handlerErr) {
+			return handlerErr.err
+		}
+		if received {
+			backoff = c.retry.InitialBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if c.retry.MaxBackoff > 0 && backoff > c.retry.MaxBackoff {
+			backoff = c.retry.MaxBackoff
+		}
+	}
+}
+
+// handlerError wraps errors returned by an EventHandler.
+type handlerError struct {
+	err error
+}
+
+func (e handlerError) Error() string {
+	return e.err.Error()
+}
+
+// streamEvents calls handler for every event of the stream at path, until
+// the server closes it. lastEventId is sent to resume the stream, and
+// updated with the id of every event received. It returns whether any
+// event was received.
+func (c *Client) streamEvents(ctx context.Context, path string, lastEventId *string, handler EventHandler) (bool, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if len(*lastEventId) > 0 {
+		req.Header.Set("Last-Event-ID", *lastEventId)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, &Error{Status: resp.StatusCode}
+	}
+	received := false
+	scanner := bufio.NewScanner(resp.Body)
+	id, data := "", ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case len(line) == 0 && len(data) > 0:
+			var e sfu.Event
+			if err = json.Unmarshal([]byte(data), &e); err != nil {
+				return received, err
+			}
+			if err = handler(id, e); err != nil {
+				return received, handlerError{err}
+			}
+			*lastEventId, data, received = id, "", true
+		}
+	}
+	return received, scanner.Err()
+}
@@ -0,0 +1,3 @@
+module alovenio.com/blackbird/client
+
+go 1.20
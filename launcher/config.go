@@ -0,0 +1,497 @@
+package main
+
+import (
+	"alovenio.com/blackbird/logger"
+	"alovenio.com/blackbird/sfu"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// envPrefix is the prefix of the environment variables overriding keys of
+// the configuration file.
+const envPrefix = "BLACKBIRD_"
+
+// configKeys maps the keys of the configuration file, in section.key form,
+// to the flags they set. Every key can be overridden by an environment
+// variable named after it, e.g. BLACKBIRD_LIMITS_MAX_SESSIONS for
+// limits.maxSessions.
+var configKeys = map[string]string{
+	"server.address":                 "address",
+	"server.shutdownTimeout":         "shutdownTimeout",
+	"server.eventBufferSize":         "eventBufferSize",
+	"server.legacyErrors":            "legacyErrors",
+	"tls.cert":                       "tlsCert",
+	"tls.key":                        "tlsKey",
+	"tls.selfSigned":                 "tlsSelfSigned",
+	"tls.reloadInterval":             "tlsReloadInterval",
+	"tls.redirectAddress":            "httpsRedirectAddress",
+	"auth.adminToken":                "adminToken",
+	"ice.servers":                    "iceServers",
+	"ice.username":                   "iceUsername",
+	"ice.credential":                 "iceCredential",
+	"ice.portMin":                    "icePortMin",
+	"ice.portMax":                    "icePortMax",
+	"limits.maxSessions":             "maxSessions",
+	"limits.maxParticipants":         "maxParticipants",
+	"limits.session.maxParticipants": "maxSessionParticipants",
+	"limits.session.maxPublishers":   "maxSessionPublishers",
+	"limits.session.maxVideoTracks":  "maxSessionVideoTracks",
+	"reaper.interval":                "reapInterval",
+	"reaper.emptySessionTimeout":     "emptySessionTimeout",
+	"reaper.maxSessionLifetime":      "maxSessionLifetime",
+	"reaper.heartbeatTimeout":        "heartbeatTimeout",
+	"logging.level":                  "logLevel",
+	"logging.format":                 "logFormat",
+	"logging.components":             "logComponents",
+	"logging.sinks":                  "logSink",
+	"webhooks.urls":                  "webhookUrls",
+	"webhooks.secret":                "webhookSecret",
+	"store.path":                     "storePath",
+	"recording.path":                 "recordingPath",
+	"store.idempotencyWindow":        "idempotencyWindow",
+	"cluster.bind":                   "clusterBind",
+	"cluster.nodeName":               "clusterNodeName",
+	"cluster.advertise":              "clusterAdvertise",
+	"cluster.join":                   "clusterJoin",
+	"cluster.redirect":               "clusterRedirect",
+	"cluster.relay":                  "clusterRelay",
+	"cluster.secretKey":              "clusterSecretKey",
+	"metrics.enabled":                "metrics",
+	"metrics.address":                "metricsAddress",
+	"metrics.perSession":             "metricsPerSession",
+	"tracing.exporter":               "tracing",
+	"tracing.otlpEndpoint":           "otlpEndpoint",
+	"tracing.otlpInsecure":           "otlpInsecure",
+	"api.deprecations":               "apiDeprecations",
+	"api.sunsets":                    "apiSunsets",
+}
+
+// repeatedFlags are set once per value of a list, rather than once with
+// all values joined by commas. Their environment variables separate values
+// with semicolons.
+var repeatedFlags = map[string]bool{
+	"logSink": true,
+}
+
+// configure sets every flag not given on the command line from the
+// environment or, failing that, from the configuration file, then checks
+// the resulting configuration. Flags take precedence over environment
+// variables, which take precedence over the configuration file. All errors
+// found are returned.
+func configure() []error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	path := *configPath
+	if !explicit["config"] {
+		if v, ok := os.LookupEnv(envPrefix + "CONFIG"); ok {
+			path = v
+		}
+	}
+	values := make(map[string][]string)
+	var errs []error
+	if len(path) > 0 {
+		errs = append(errs, readConfigFile(path, values)...)
+	}
+	readEnv(values)
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if explicit[name] {
+			continue
+		}
+		for _, v := range values[name] {
+			if err := flag.Set(name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", configKey(name), err))
+			}
+		}
+	}
+	return append(errs, validate()...)
+}
+
+// readConfigFile reads the YAML configuration file at path into values,
+// by flag name.
+func readConfigFile(path string, values map[string][]string) []error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{err}
+	}
+	var doc map[string]any
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return []error{fmt.Errorf("%s: %w", path, err)}
+	}
+	return readConfigSection(path, "", doc, values)
+}
+
+// readConfigSection reads the keys of a section of the configuration file,
+// and of all its nested sections, into values.
+func readConfigSection(path string, prefix string, section map[string]any, values map[string][]string) []error {
+	var errs []error
+	for k, v := range section {
+		key := prefix + k
+		if name, ok := configKeys[key]; ok {
+			vs, err := configValues(v, repeatedFlags[name])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", path, key, err))
+				continue
+			}
+			values[name] = vs
+		} else if nested, ok := v.(map[string]any); ok && isConfigSection(key) {
+			errs = append(errs, readConfigSection(path, key+".", nested, values)...)
+		} else {
+			errs = append(errs, fmt.Errorf("%s: unknown key %s", path, key))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+	return errs
+}
+
+// isConfigSection returns whether key is a section holding other keys.
+func isConfigSection(key string) bool {
+	for k := range configKeys {
+		if strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// configValues converts a value of the configuration file to flag values.
+// Lists are joined with commas, unless repeated is set, and maps are
+// converted to comma separated key=value pairs.
+func configValues(v any, repeated bool) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			switch item.(type) {
+			case []any, map[string]any:
+				return nil, errors.New("lists must only hold plain values")
+			}
+			items[i] = configValue(item)
+		}
+		if repeated {
+			return items, nil
+		}
+		return []string{strings.Join(items, ",")}, nil
+	case map[string]any:
+		pairs := make([]string, 0, len(v))
+		for k, item := range v {
+			pairs = append(pairs, k+"="+configValue(item))
+		}
+		sort.Strings(pairs)
+		return []string{strings.Join(pairs, ",")}, nil
+	}
+	return []string{configValue(v)}, nil
+}
+
+// configValue converts a plain value of the configuration file to a flag
+// value. Timestamps are formatted as per RFC 3339.
+func configValue(v any) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// readEnv reads the environment variables overriding keys of the
+// configuration file into values, by flag name.
+func readEnv(values map[string][]string) {
+	for key, name := range configKeys {
+		v, ok := os.LookupEnv(envName(key))
+		if !ok {
+			continue
+		}
+		if repeatedFlags[name] {
+			values[name] = strings.Split(v, ";")
+		} else {
+			values[name] = []string{v}
+		}
+	}
+}
+
+// envName returns the name of the environment variable overriding a key
+// of the configuration file.
+func envName(key string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	for i, r := range key {
+		switch {
+		case r == '.':
+			b.WriteByte('_')
+		case unicode.IsUpper(r) && i > 0 && key[i-1] != '.':
+			b.WriteByte('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// configKey returns the key of the configuration file setting a flag.
+func configKey(name string) string {
+	for k, n := range configKeys {
+		if n == name {
+			return k
+		}
+	}
+	return name
+}
+
+// validate checks the values of all flags, returning every error found.
+func validate() []error {
+	var errs []error
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", configKey(name), err))
+		}
+	}
+	checkNotNegative := func(name string, v int64) {
+		if v < 0 {
+			check(name, errors.New("must not be negative"))
+		}
+	}
+	check("address", checkHostPort(*address, true))
+	checkNotNegative("shutdownTimeout", int64(*shutdownTimeout))
+	checkNotNegative("eventBufferSize", int64(*eventBufferSize))
+	_, err := logger.ParseLogLevel(*logLevel)
+	check("logLevel", err)
+	_, err = logger.ParseFormat(*logFormat)
+	check("logFormat", err)
+	_, err = parseComponentLevels(*logComponents)
+	check("logComponents", err)
+	checkNotNegative("maxSessions", int64(*maxSessions))
+	checkNotNegative("maxParticipants", int64(*maxParticipants))
+	checkNotNegative("maxSessionParticipants", int64(*maxSessionParticipants))
+	checkNotNegative("maxSessionPublishers", int64(*maxSessionPublishers))
+	checkNotNegative("maxSessionVideoTracks", int64(*maxSessionVideoTracks))
+	checkNotNegative("reapInterval", int64(*reapInterval))
+	checkNotNegative("emptySessionTimeout", int64(*emptySessionTimeout))
+	checkNotNegative("maxSessionLifetime", int64(*maxSessionLifetime))
+	checkNotNegative("heartbeatTimeout", int64(*heartbeatTimeout))
+	checkNotNegative("idempotencyWindow", int64(*idempotencyWindow))
+	if (len(*tlsCert) > 0) != (len(*tlsKey) > 0) {
+		check("tlsCert", errors.New("certificate and key files must be given together"))
+	}
+	for _, name := range []string{"tlsCert", "tlsKey"} {
+		if path := flag.Lookup(name).Value.String(); len(path) > 0 {
+			_, err = os.Stat(path)
+			check(name, err)
+		}
+	}
+	if len(*httpsRedirectAddress) > 0 {
+		if len(*tlsCert) == 0 && !*tlsSelfSigned {
+			check("httpsRedirectAddress", errors.New("requires tls"))
+		}
+		check("httpsRedirectAddress", checkHostPort(*httpsRedirectAddress, true))
+	}
+	for _, s := range splitList(*iceServers) {
+		if !strings.HasPrefix(s, "stun:") && !strings.HasPrefix(s, "stuns:") &&
+			!strings.HasPrefix(s, "turn:") && !strings.HasPrefix(s, "turns:") {
+			check("iceServers", fmt.Errorf("%q is not a stun or turn url", s))
+		}
+	}
+	if *icePortMin != 0 || *icePortMax != 0 {
+		checkPort := func(name string, port int) {
+			if port < 1 || port > 65535 {
+				check(name, fmt.Errorf("port %d is not between 1 and 65535", port))
+			}
+		}
+		checkPort("icePortMin", *icePortMin)
+		checkPort("icePortMax", *icePortMax)
+		if *icePortMin > *icePortMax {
+			check("icePortMin", fmt.Errorf("port %d is above ice.portMax %d", *icePortMin, *icePortMax))
+		}
+	}
+	if len(*recordingPath) > 0 {
+		check("recordingPath", checkWritableDir(*recordingPath))
+	}
+	for _, u := range splitList(*webhookUrls) {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			check("webhookUrls", fmt.Errorf("%q is not an http url", u))
+		}
+	}
+	if len(*clusterBind) > 0 {
+		check("clusterBind", checkHostPort(*clusterBind, false))
+	}
+	for _, a := range splitList(*clusterJoin) {
+		check("clusterJoin", checkHostPort(a, false))
+	}
+	if key, err := base64.StdEncoding.DecodeString(*clusterSecretKey); err != nil {
+		check("clusterSecretKey", err)
+	} else if n := len(key); n != 0 && n != 16 && n != 24 && n != 32 {
+		check("clusterSecretKey", fmt.Errorf("key must be 16, 24 or 32 bytes long, not %d", n))
+	}
+	if len(*metricsAddress) > 0 {
+		check("metricsAddress", checkHostPort(*metricsAddress, true))
+	}
+	if len(*tracing) > 0 && *tracing != "otlp" && *tracing != "stdout" {
+		check("tracing", fmt.Errorf("unknown span exporter %q", *tracing))
+	}
+	_, err = parseDeprecations(*apiDeprecations, *apiSunsets)
+	check("apiDeprecations", err)
+	return errs
+}
+
+// checkHostPort checks whether addr is in host:port form, where host may
+// be blank if blankHost is set.
+func checkHostPort(addr string, blankHost bool) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if len(host) == 0 && !blankHost {
+		return fmt.Errorf("address %s has no host", addr)
+	}
+	if len(port) == 0 {
+		return fmt.Errorf("address %s has no port", addr)
+	}
+	return nil
+}
+
+// checkWritableDir checks whether files can be created in the directory at
+// path or, if it does not exist yet, in its closest existing parent, where
+// it is created.
+func checkWritableDir(path string) error {
+	dir := path
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(dir) == dir {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+	f, err := os.CreateTemp(dir, ".blackbird-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// splitList splits a comma separated list, ignoring blank items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseComponentLevels parses comma separated component=level pairs.
+func parseComponentLevels(list string) (map[string]int, error) {
+	levels := make(map[string]int)
+	for _, pair := range splitList(list) {
+		component, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not in component=level form", pair)
+		}
+		if !isComponent(component) {
+			return nil, fmt.Errorf("unknown log component %q", component)
+		}
+		level, err := logger.ParseLogLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		levels[component] = level
+	}
+	return levels, nil
+}
+
+// isComponent returns whether name is a logger component.
+func isComponent(name string) bool {
+	for _, c := range logger.Components() {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDeprecations parses the comma separated version=time pairs of the
+// deprecations and sunsets of versions of the REST API, where times are
+// RFC 3339 times or dates.
+func parseDeprecations(deprecations string, sunsets string) (map[string]sfu.Deprecation, error) {
+	parsed := make(map[string]sfu.Deprecation)
+	for _, pair := range splitList(deprecations) {
+		version, date, err := parseVersionTime(pair)
+		if err != nil {
+			return nil, err
+		}
+		parsed[version] = sfu.Deprecation{Date: date}
+	}
+	for _, pair := range splitList(sunsets) {
+		version, sunset, err := parseVersionTime(pair)
+		if err != nil {
+			return nil, err
+		}
+		d, ok := parsed[version]
+		if !ok {
+			return nil, fmt.Errorf("api version %s has a sunset but no deprecation", version)
+		}
+		if sunset.Before(d.Date) {
+			return nil, fmt.Errorf("sunset of api version %s is before its deprecation", version)
+		}
+		d.Sunset = sunset
+		parsed[version] = d
+	}
+	return parsed, nil
+}
+
+// parseVersionTime parses a version=time pair, where time is an RFC 3339
+// time or date.
+func parseVersionTime(pair string) (string, time.Time, error) {
+	version, value, ok := strings.Cut(pair, "=")
+	if !ok {
+		return "", time.Time{}, fmt.Errorf("%q is not in version=time form", pair)
+	}
+	if !isAPIVersion(version) {
+		return "", time.Time{}, fmt.Errorf("unknown api version %q", version)
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.Parse(time.DateOnly, value); err != nil {
+			return "", time.Time{}, fmt.Errorf("%q is not an RFC 3339 time or date", value)
+		}
+	}
+	return version, t, nil
+}
+
+// isAPIVersion returns whether name is a version of the REST API.
+func isAPIVersion(name string) bool {
+	for _, v := range sfu.APIVersions() {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseFlags resets every flag of the launcher to its default and parses
+// args as the command line.
+func parseFlags(t *testing.T, args ...string) {
+	t.Helper()
+	fs := flag.NewFlagSet("launcher", flag.ContinueOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "test.") && f.Name != "logSink" {
+			if err := f.Value.Set(f.DefValue); err != nil {
+				t.Fatalf("resetting -%s: %v", f.Name, err)
+			}
+		}
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	logSinks = nil
+	flag.CommandLine = fs
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeConfig writes a configuration file and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blackbird.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	file := writeConfig(t, `
+limits:
+  maxSessions: 10
+reaper:
+  interval: 5m
+logging:
+  sinks:
+    - stdout
+    - stderr,level=error
+webhooks:
+  urls:
+    - https://example.org/a
+    - https://example.org/b
+api:
+  deprecations:
+    v1: 2027-04-01
+`)
+	other := writeConfig(t, "limits:\n  maxSessions: 40\n")
+	tests := []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		maxSessions int
+		interval    time.Duration
+		sinks       int
+	}{
+		{"defaults", nil, nil, 0, time.Minute, 0},
+		{"file", []string{"-config", file}, nil, 10, 5 * time.Minute, 2},
+		{"file from the environment", nil, map[string]string{"BLACKBIRD_CONFIG": file}, 10, 5 * time.Minute, 2},
+		{"file flag over the environment", []string{"-config", other}, map[string]string{"BLACKBIRD_CONFIG": file}, 40, time.Minute, 0},
+		{"environment over file", []string{"-config", file},
+			map[string]string{"BLACKBIRD_LIMITS_MAX_SESSIONS": "20", "BLACKBIRD_LOGGING_SINKS": "stdout"}, 20, 5 * time.Minute, 1},
+		{"flag over environment and file", []string{"-config", file, "-maxSessions", "30", "-logSink", "stderr"},
+			map[string]string{"BLACKBIRD_LIMITS_MAX_SESSIONS": "20", "BLACKBIRD_REAPER_INTERVAL": "2m"}, 30, 2 * time.Minute, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			parseFlags(t, test.args...)
+			if errs := configure(); len(errs) > 0 {
+				t.Fatalf("configure() errors = %v", errs)
+			}
+			if *maxSessions != test.maxSessions || *reapInterval != test.interval || len(logSinks) != test.sinks {
+				t.Errorf("maxSessions = %d, reapInterval = %s, %d log sinks, want %d, %s, %d",
+					*maxSessions, *reapInterval, len(logSinks), test.maxSessions, test.interval, test.sinks)
+			}
+		})
+	}
+
+	// lists are joined with commas, maps are converted to key=value pairs
+	parseFlags(t, "-config", file)
+	if errs := configure(); len(errs) > 0 {
+		t.Fatalf("configure() errors = %v", errs)
+	}
+	if want := "https://example.org/a,https://example.org/b"; *webhookUrls != want {
+		t.Errorf("webhookUrls = %q, want %q", *webhookUrls, want)
+	}
+	if want := "v1=2027-04-01T00:00:00Z"; *apiDeprecations != want {
+		t.Errorf("apiDeprecations = %q, want %q", *apiDeprecations, want)
+	}
+}
+
+func TestICEAndRecordingPrecedence(t *testing.T) {
+	fromFile, fromEnv, fromFlag := t.TempDir(), t.TempDir(), t.TempDir()
+	file := writeConfig(t, `
+ice:
+  servers:
+    - stun:stun.example.org:3478
+  portMin: 50000
+  portMax: 50100
+recording:
+  path: `+fromFile+`
+`)
+	tests := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		iceServers    string
+		icePortMin    int
+		recordingPath string
+	}{
+		{"defaults", nil, nil, "", 0, ""},
+		{"file", []string{"-config", file}, nil, "stun:stun.example.org:3478", 50000, fromFile},
+		{"environment over file", []string{"-config", file},
+			map[string]string{"BLACKBIRD_ICE_SERVERS": "stun:a.example.org,turn:b.example.org", "BLACKBIRD_ICE_PORT_MIN": "50010",
+				"BLACKBIRD_RECORDING_PATH": fromEnv},
+			"stun:a.example.org,turn:b.example.org", 50010, fromEnv},
+		{"flag over environment and file",
+			[]string{"-config", file, "-iceServers", "stun:c.example.org", "-icePortMin", "50020", "-recordingPath", fromFlag},
+			map[string]string{"BLACKBIRD_ICE_PORT_MIN": "50010", "BLACKBIRD_RECORDING_PATH": fromEnv},
+			"stun:c.example.org", 50020, fromFlag},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			parseFlags(t, test.args...)
+			if errs := configure(); len(errs) > 0 {
+				t.Fatalf("configure() errors = %v", errs)
+			}
+			if *iceServers != test.iceServers || *icePortMin != test.icePortMin || *recordingPath != test.recordingPath {
+				t.Errorf("iceServers = %q, icePortMin = %d, recordingPath = %q, want %q, %d, %q",
+					*iceServers, *icePortMin, *recordingPath, test.iceServers, test.icePortMin, test.recordingPath)
+			}
+		})
+	}
+}
+
+func TestConfigValidateCollectsErrors(t *testing.T) {
+	file := writeConfig(t, `
+server:
+  unknown: true
+limits:
+  maxSessions: many
+  maxParticipants: -1
+  session:
+    maxVideoTracks: -1
+ice:
+  servers:
+    - http://stun.example.org
+  portMin: 70000
+  portMax: 50100
+recording:
+  path: /dev/null/recordings
+tracing:
+  exporter: zipkin
+cluster:
+  secretKey: c2hvcnQ=
+`)
+	t.Setenv("BLACKBIRD_REAPER_INTERVAL", "-1m")
+	parseFlags(t, "-config", file, "-webhookUrls", "ftp://example.org")
+	errs := configure()
+	want := []string{
+		"unknown key server.unknown",
+		"limits.maxSessions",
+		"limits.maxParticipants",
+		"limits.session.maxVideoTracks",
+		"ice.servers",
+		"ice.portMin: port 70000 is not between",
+		"ice.portMin: port 70000 is above ice.portMax",
+		"recording.path",
+		"reaper.interval",
+		"tracing.exporter",
+		"cluster.secretKey",
+		"webhooks.urls",
+	}
+	if len(errs) != len(want) {
+		t.Errorf("configure() = %d errors %v, want %d", len(errs), errs, len(want))
+	}
+	for _, w := range want {
+		found := false
+		for _, err := range errs {
+			found = found || strings.Contains(err.Error(), w)
+		}
+		if !found {
+			t.Errorf("configure() errors %v do not report %s", errs, w)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"server.address":                 "BLACKBIRD_SERVER_ADDRESS",
+		"limits.maxSessions":             "BLACKBIRD_LIMITS_MAX_SESSIONS",
+		"limits.session.maxParticipants": "BLACKBIRD_LIMITS_SESSION_MAX_PARTICIPANTS",
+		"tls.redirectAddress":            "BLACKBIRD_TLS_REDIRECT_ADDRESS",
+		"ice.portMin":                    "BLACKBIRD_ICE_PORT_MIN",
+		"recording.path":                 "BLACKBIRD_RECORDING_PATH",
+	}
+	for key, want := range tests {
+		if got := envName(key); got != want {
+			t.Errorf("envName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestParseDeprecations(t *testing.T) {
+	date := time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		deprecations string
+		sunsets      string
+		valid        bool
+	}{
+		{"", "", true},
+		{"v1=2027-04-01", "", true},
+		{"v1=2027-04-01T00:00:00Z", "v1=2027-10-01", true},
+		{"v1=2027-04-01", "v1=2027-01-01", false},
+		{"", "v1=2027-10-01", false},
+		{"v9=2027-04-01", "", false},
+		{"v1", "", false},
+		{"v1=April", "", false},
+	}
+	for _, test := range tests {
+		parsed, err := parseDeprecations(test.deprecations, test.sunsets)
+		if (err == nil) != test.valid {
+			t.Errorf("parseDeprecations(%q, %q) error = %v, want valid %v", test.deprecations, test.sunsets, err, test.valid)
+			continue
+		}
+		if test.valid && test.deprecations != "" && !parsed["v1"].Date.Equal(date) {
+			t.Errorf("parseDeprecations(%q, %q) = %+v, want v1 deprecated on %s", test.deprecations, test.sunsets, parsed, date)
+		}
+	}
+}
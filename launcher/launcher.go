@@ -4,23 +4,310 @@ package main
 import (
 	"alovenio.com/blackbird/logger"
 	"alovenio.com/blackbird/sfu"
+	"context"
+	"encoding/base64"
 	"flag"
+	"fmt"
+	"github.com/pion/webrtc/v3"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+var configPath = flag.String("config", "", "path of the YAML configuration file (BLACKBIRD_CONFIG when not given); "+
+	"flags override BLACKBIRD_* environment variables, which override the file")
 var address = flag.String("address", "localhost:8000", "server address")
 var logLevel = flag.String("logLevel", "info", "log level (debug, info, warn, error)")
+var logFormat = flag.String("logFormat", "text", "log format (text, json)")
+var logComponents = flag.String("logComponents", "", "comma separated component=level pairs overriding the log level of components")
+var maxSessions = flag.Int("maxSessions", 0, "maximum number of sessions (0 means unlimited)")
+var maxParticipants = flag.Int("maxParticipants", 0, "maximum number of participants across all sessions (0 means unlimited)")
+var maxSessionParticipants = flag.Int("maxSessionParticipants", 0, "maximum number of participants per session (0 means unlimited)")
+var maxSessionPublishers = flag.Int("maxSessionPublishers", 0, "maximum number of participants publishing tracks per session (0 means unlimited)")
+var maxSessionVideoTracks = flag.Int("maxSessionVideoTracks", 0, "maximum number of video tracks published per session (0 means unlimited)")
+var reapInterval = flag.Duration("reapInterval", time.Minute, "interval between runs of the session reaper (0 disables it)")
+var emptySessionTimeout = flag.Duration("emptySessionTimeout", 0, "delete sessions without participants for longer than this (0 disables it)")
+var maxSessionLifetime = flag.Duration("maxSessionLifetime", 0, "delete sessions older than this (0 disables it)")
+var heartbeatTimeout = flag.Duration("heartbeatTimeout", 0, "remove participants without heartbeats for longer than this (0 disables it)")
+var webhookUrls = flag.String("webhookUrls", "", "comma separated URLs receiving session lifecycle events")
+var webhookSecret = flag.String("webhookSecret", "", "secret used to sign webhooks")
+var storePath = flag.String("storePath", "", "path of the file where sessions are persisted (in memory only when blank)")
+var idempotencyWindow = flag.Duration("idempotencyWindow", sfu.DefaultIdempotencyWindow, "duration during which requests with an Idempotency-Key are replayed (0 disables idempotency keys)")
+var clusterBind = flag.String("clusterBind", "", "gossip address, in host:port form, enabling cluster mode")
+var clusterNodeName = flag.String("clusterNodeName", "", "unique name of this cluster node (host name when blank)")
+var clusterAdvertise = flag.String("clusterAdvertise", "", "base url where other cluster nodes reach this node (http://address when blank)")
+var clusterJoin = flag.String("clusterJoin", "", "comma separated gossip addresses of cluster nodes to join")
+var clusterRedirect = flag.Bool("clusterRedirect", false, "redirect requests for sessions of other nodes instead of proxying them")
+var clusterRelay = flag.Bool("clusterRelay", false, "negotiate with participants of sessions of other nodes, relaying their tracks to those nodes")
+var clusterSecretKey = flag.String("clusterSecretKey", "", "base64 encoded key of 16, 24 or 32 bytes encrypting gossip, shared by all cluster nodes (required in production, gossip is in clear text when blank)")
+var metrics = flag.Bool("metrics", false, "enable the Prometheus /metrics endpoint")
+var metricsAddress = flag.String("metricsAddress", "", "address of a separate listener serving /metrics (main address when blank)")
+var metricsPerSession = flag.Bool("metricsPerSession", false, "export per session metrics labelled with session ids")
+var tracing = flag.String("tracing", "", "span exporter enabling tracing (otlp, or stdout writing spans to the standard error)")
+var otlpEndpoint = flag.String("otlpEndpoint", "", "OTLP collector endpoint in host:port form (OTEL_EXPORTER_OTLP_* variables when blank)")
+var otlpInsecure = flag.Bool("otlpInsecure", false, "connect to the OTLP collector without TLS")
+var adminToken = flag.String("adminToken", "", "bearer token required by admin routes (admin routes disabled when blank)")
+var eventBufferSize = flag.Int("eventBufferSize", sfu.DefaultEventBufferSize, "number of recent events kept to resume event streams")
+var tlsCert = flag.String("tlsCert", "", "path of the PEM encoded TLS certificate chain, enabling HTTPS")
+var tlsKey = flag.String("tlsKey", "", "path of the PEM encoded TLS private key")
+var tlsSelfSigned = flag.Bool("tlsSelfSigned", false, "serve HTTPS with a generated self-signed certificate (development only)")
+var tlsReloadInterval = flag.Duration("tlsReloadInterval", sfu.DefaultTLSReloadInterval, "interval between checks for changes of the TLS certificate and key files")
+var httpsRedirectAddress = flag.String("httpsRedirectAddress", "", "address of a plain HTTP listener redirecting to HTTPS (disabled when blank)")
+var iceServers = flag.String("iceServers", "", "comma separated STUN and TURN server URLs of participant PeerConnections")
+var iceUsername = flag.String("iceUsername", "", "username of the TURN servers")
+var iceCredential = flag.String("iceCredential", "", "credential of the TURN servers")
+var icePortMin = flag.Int("icePortMin", 0, "lowest UDP port of ICE candidates (any port when 0)")
+var icePortMax = flag.Int("icePortMax", 0, "highest UDP port of ICE candidates (any port when 0)")
+var recordingPath = flag.String("recordingPath", "", "directory where sessions are recorded (recording disabled when blank)")
+var legacyErrors = flag.Bool("legacyErrors", false, "report errors of v1 of the REST API as plain messages in the errors of results instead of RFC 7807 problem details, for older clients")
+var apiDeprecations = flag.String("apiDeprecations", "", "comma separated version=time pairs deprecating versions of the REST API as of an RFC 3339 time or date, e.g. v1=2027-04-01")
+var apiSunsets = flag.String("apiSunsets", "", "comma separated version=time pairs announcing when deprecated versions of the REST API are removed")
+var shutdownTimeout = flag.Duration("shutdownTimeout", sfu.DefaultShutdownTimeout, "maximum duration of a graceful shutdown on SIGINT or SIGTERM")
+var logSinks sinkFlags
+
+func init() {
+	flag.Var(&logSinks, "logSink", "log sink, e.g. stderr,level=error or file:/var/log/blackbird.log,maxSize=104857600,maxAge=24h,maxBackups=7 "+
+		"or syslog:udp://localhost:514,level=warn (repeatable, stdout when none)")
+}
+
+// sinkFlags collects the log sinks given with repeated -logSink flags.
+type sinkFlags []logger.SinkConfig
+
+func (f *sinkFlags) String() string {
+	return ""
+}
+
+func (f *sinkFlags) Set(spec string) error {
+	c, err := logger.ParseSink(spec)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, c)
+	return nil
+}
 
 func main() {
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "validate" {
+		validateConfig(os.Args[3:])
+		return
+	}
 	flag.Parse()
+	if errs := configure(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Print(err)
+		}
+		os.Exit(1)
+	}
+	if err := configureLogs(); err != nil {
+		log.Fatal(err)
+	}
+	// exits once run returned, so its deferred cleanup, e.g. closing the
+	// store, is done
+	if err := run(); err != nil {
+		logger.ErrorW("server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// configureLogs sets the log level, format, component levels and sinks.
+func configureLogs() error {
 	logLevel, err := logger.ParseLogLevel(*logLevel)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	logger.LogLevel = logLevel
-	server := new(sfu.Server)
-	handler := sfu.NewWebRtcSessionHandler()
-	if err = server.Start(*address, handler); err != nil {
-		logger.LogFatalF(err)
+	format, err := logger.ParseFormat(*logFormat)
+	if err != nil {
+		return err
+	}
+	logger.SetFormat(format)
+	levels, err := parseComponentLevels(*logComponents)
+	if err != nil {
+		return err
+	}
+	for component, level := range levels {
+		if err = logger.SetComponentLevel(component, level); err != nil {
+			return err
+		}
+	}
+	if len(logSinks) > 0 {
+		if err = logger.SetSinks(logSinks); err != nil {
+			return err
+		}
+	}
+	go reopenLogsOnHangup()
+	return nil
+}
+
+// run starts the server and serves until it is shut down. It returns any
+// error preventing the server from starting or serving, once everything
+// started so far was stopped.
+func run() error {
+	var err error
+	store := sfu.NewMemoryStore()
+	if len(*storePath) > 0 {
+		if store, err = sfu.OpenBoltStore(*storePath); err != nil {
+			return err
+		}
+	}
+	defer store.Close()
+	deprecations, err := parseDeprecations(*apiDeprecations, *apiSunsets)
+	if err != nil {
+		return err
+	}
+	server := &sfu.Server{EventBufferSize: *eventBufferSize, AdminToken: *adminToken, ShutdownTimeout: *shutdownTimeout,
+		LegacyErrors: *legacyErrors, Deprecations: deprecations}
+	// stops what was started when the server fails to start, e.g. leaves
+	// the cluster, and has no effect once the server was shut down
+	defer shutdown(server)
+	options := []sfu.WebRtcSessionHandlerOption{sfu.WithStore(store), sfu.WithIdempotencyWindow(*idempotencyWindow),
+		sfu.WithRecordingPath(*recordingPath)}
+	if servers := splitList(*iceServers); len(servers) > 0 {
+		options = append(options, sfu.WithICEServers([]webrtc.ICEServer{{
+			URLs:       servers,
+			Username:   *iceUsername,
+			Credential: *iceCredential,
+		}}))
+	}
+	if *icePortMin > 0 {
+		settings := sfu.NewSettingEngine()
+		if err = settings.SetEphemeralUDPPortRange(uint16(*icePortMin), uint16(*icePortMax)); err != nil {
+			return err
+		}
+		options = append(options, sfu.WithSettingEngine(settings))
+	}
+	if len(*tlsCert) > 0 || len(*tlsKey) > 0 || *tlsSelfSigned {
+		config := sfu.TLSConfig{
+			CertFile:        *tlsCert,
+			KeyFile:         *tlsKey,
+			SelfSigned:      *tlsSelfSigned,
+			ReloadInterval:  *tlsReloadInterval,
+			RedirectAddress: *httpsRedirectAddress,
+		}
+		if host, _, err := net.SplitHostPort(*address); err == nil && host != "localhost" && host != "127.0.0.1" && len(host) > 0 {
+			config.Hosts = []string{"localhost", "127.0.0.1", host}
+		}
+		if server.TLS, err = sfu.NewTLS(config); err != nil {
+			return err
+		}
+	}
+	if len(*clusterBind) > 0 {
+		config := sfu.ClusterConfig{
+			NodeName:     *clusterNodeName,
+			BindAddr:     *clusterBind,
+			AdvertiseURL: *clusterAdvertise,
+			Redirect:     *clusterRedirect,
+			Relay:        *clusterRelay,
+		}
+		if len(config.AdvertiseURL) == 0 {
+			config.AdvertiseURL = "http://" + *address
+			if server.TLS != nil {
+				config.AdvertiseURL = "https://" + *address
+			}
+		}
+		if len(*clusterJoin) > 0 {
+			config.Join = splitList(*clusterJoin)
+		}
+		if config.SecretKey, err = base64.StdEncoding.DecodeString(*clusterSecretKey); err != nil {
+			return fmt.Errorf("invalid cluster secret key: %w", err)
+		}
+		if server.Cluster, err = sfu.NewCluster(config); err != nil {
+			return err
+		}
+		options = append(options, sfu.WithSessionIdPrefix(server.Cluster.SessionIdPrefix()))
+		if config.Relay {
+			options = append(options, sfu.WithRelay(server.Cluster))
+		}
+	}
+	if len(*tracing) > 0 {
+		if server.Tracing, err = sfu.NewTracing(sfu.TracingConfig{
+			Exporter: *tracing,
+			Endpoint: *otlpEndpoint,
+			Insecure: *otlpInsecure,
+		}); err != nil {
+			return err
+		}
+		options = append(options, sfu.WithTracing(server.Tracing))
+	}
+	handler := sfu.NewWebRtcSessionHandler(append(options, sfu.WithLimits(sfu.Limits{
+		MaxSessions:     *maxSessions,
+		MaxParticipants: *maxParticipants,
+		Session: sfu.SessionLimits{
+			MaxParticipants: *maxSessionParticipants,
+			MaxPublishers:   *maxSessionPublishers,
+			MaxVideoTracks:  *maxSessionVideoTracks,
+		},
+	}), sfu.WithReaperPolicy(sfu.ReaperPolicy{
+		Interval:            *reapInterval,
+		EmptySessionTimeout: *emptySessionTimeout,
+		MaxSessionLifetime:  *maxSessionLifetime,
+		HeartbeatTimeout:    *heartbeatTimeout,
+	}))...)
+	if err = handler.Restore(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handler.RunReaper(ctx)
+	if server.Cluster != nil {
+		server.Cluster.AdoptSessions(handler.SessionIds())
+		if err = server.Cluster.Start(handler.Stats); err != nil {
+			return err
+		}
+	}
+	if len(*webhookUrls) > 0 {
+		config := sfu.DefaultWebhookConfig()
+		config.URLs = splitList(*webhookUrls)
+		config.Secret = *webhookSecret
+		server.Webhooks = sfu.NewWebhookDispatcher(config)
+	}
+	if *metrics {
+		server.Metrics = sfu.NewMetrics(sfu.MetricsConfig{
+			Address:          *metricsAddress,
+			PerSessionLabels: *metricsPerSession,
+		})
+	}
+	signals, stopSignals := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	return server.Start(signals, *address, handler)
+}
+
+// shutdown shuts server down within its shutdown timeout.
+func shutdown(server *sfu.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	server.Shutdown(ctx)
+}
+
+// validateConfig parses the command line args, loads the configuration
+// and reports every error found, exiting with a non-zero status if any.
+func validateConfig(args []string) {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		os.Exit(2)
+	}
+	errs := configure()
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("configuration is valid")
+}
+
+// reopenLogsOnHangup reopens the log files every time SIGHUP is received,
+// so they can be rotated by external tools.
+func reopenLogsOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := logger.Reopen(); err != nil {
+			logger.LogErrorF("failed to reopen log files: %s", err)
+			continue
+		}
+		logger.LogInfoF("reopened log files")
 	}
 }
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"alovenio.com/blackbird/sfu"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunClosesStoreOnStartupFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	path := filepath.Join(t.TempDir(), "blackbird.db")
+	parseFlags(t, "-storePath", path, "-address", taken.Addr().String(), "-reapInterval", "0")
+
+	if err = run(); err == nil {
+		t.Fatal("run() on an address in use error = nil, want an error")
+	}
+	// the store is locked until it is closed
+	store, err := sfu.OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("OpenBoltStore() after run() failed = %v, want the store closed", err)
+	}
+	store.Close()
+}
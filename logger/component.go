@@ -0,0 +1,196 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// Components with their own log level. Records of a component carry its
+// name in the ComponentKey field.
+const (
+	ComponentAPI       = "api"
+	ComponentSignaling = "signaling"
+	ComponentICE       = "ice"
+	ComponentDTLS      = "dtls"
+	ComponentSCTP      = "sctp"
+	ComponentRTP       = "rtp"
+	ComponentRecording = "recording"
+	ComponentPion      = "pion"
+	ComponentCluster   = "cluster"
+	ComponentWebhook   = "webhook"
+	ComponentReaper    = "reaper"
+	ComponentTLS       = "tls"
+	ComponentStore     = "store"
+)
+
+// ComponentKey is the name of the field holding the component of a record.
+const ComponentKey = "component"
+
+// minLevel is the lowest level of any record. Output handlers accept every
+// record, filtering happens in componentHandler.
+const minLevel = slog.LevelDebug
+
+var components = map[string]bool{
+	ComponentAPI:       true,
+	ComponentSignaling: true,
+	ComponentICE:       true,
+	ComponentDTLS:      true,
+	ComponentSCTP:      true,
+	ComponentRTP:       true,
+	ComponentRecording: true,
+	ComponentPion:      true,
+	ComponentCluster:   true,
+	ComponentWebhook:   true,
+	ComponentReaper:    true,
+	ComponentTLS:       true,
+	ComponentStore:     true,
+}
+
+var (
+	componentLevels = make(map[string]int)
+	levelsLocker    sync.RWMutex
+)
+
+// SetComponentLevel sets the log level of a component, overriding LogLevel
+// for all its records.
+func SetComponentLevel(component string, level int) error {
+	if !components[component] {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	levelsLocker.Lock()
+	defer levelsLocker.Unlock()
+	componentLevels[component] = level
+	return nil
+}
+
+// ResetComponentLevel removes the log level of a component, so LogLevel
+// applies to its records again.
+func ResetComponentLevel(component string) error {
+	if !components[component] {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	levelsLocker.Lock()
+	defer levelsLocker.Unlock()
+	delete(componentLevels, component)
+	return nil
+}
+
+// ComponentLevels returns the effective log level of every component.
+func ComponentLevels() map[string]int {
+	levelsLocker.RLock()
+	defer levelsLocker.RUnlock()
+	levels := make(map[string]int, len(components))
+	for c := range components {
+		levels[c] = componentLevelLocked(c)
+	}
+	return levels
+}
+
+// Components returns the names of all components, sorted.
+func Components() []string {
+	names := make([]string, 0, len(components))
+	for c := range components {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// componentLevel returns the effective log level of a component. LogLevel
+// applies to blank or unknown components and to those without a level.
+func componentLevel(component string) int {
+	levelsLocker.RLock()
+	defer levelsLocker.RUnlock()
+	return componentLevelLocked(component)
+}
+
+func componentLevelLocked(component string) int {
+	if level, ok := componentLevels[component]; ok {
+		return level
+	}
+	return LogLevel
+}
+
+// For returns a logger for records of a component.
+func For(component string) *slog.Logger {
+	return With(ComponentKey, component)
+}
+
+// lowestLevel returns the lowest of LogLevel and the levels of all
+// components.
+func lowestLevel() int {
+	levelsLocker.RLock()
+	defer levelsLocker.RUnlock()
+	lowest := LogLevel
+	for _, level := range componentLevels {
+		lowest = min(lowest, level)
+	}
+	return lowest
+}
+
+// componentHandler is a slog.Handler filtering records by the level of
+// the component they belong to, given by the ComponentKey field of the
+// logger, e.g. as returned by For, or else of the record itself.
+type componentHandler struct {
+	next      slog.Handler
+	component string
+}
+
+// Enabled reports whether records of level may be logged. Without a
+// component, records may still carry one in their own fields, so the
+// lowest level of any component is accepted until Handle knows it.
+func (h *componentHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	threshold := lowestLevel()
+	if h.component != "" {
+		threshold = componentLevel(h.component)
+	}
+	return level >= toSlogLevel(threshold) && h.next.Enabled(ctx, level)
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	component := h.component
+	if component == "" {
+		r.Attrs(func(a slog.Attr) bool {
+			if a.Key == ComponentKey {
+				component = a.Value.String()
+				return false
+			}
+			return true
+		})
+	}
+	if r.Level < toSlogLevel(componentLevel(component)) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := &componentHandler{next: h.next.WithAttrs(attrs), component: h.component}
+	for _, a := range attrs {
+		if a.Key == ComponentKey {
+			c.component = a.Value.String()
+		}
+	}
+	return c
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return &componentHandler{next: h.next.WithGroup(name), component: h.component}
+}
+
+// SetLogLevel sets LogLevel safely while records are being logged.
+func SetLogLevel(level int) {
+	levelsLocker.Lock()
+	defer levelsLocker.Unlock()
+	LogLevel = level
+}
+
+// GetLogLevel returns LogLevel safely while it may be changed by SetLogLevel.
+func GetLogLevel() int {
+	levelsLocker.RLock()
+	defer levelsLocker.RUnlock()
+	return LogLevel
+}
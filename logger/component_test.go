@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestComponentLevelOfRecordFields(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := slog.New(&componentHandler{next: &textHandler{w: buf, plain: true, locker: &sync.Mutex{}}})
+	SetLogLevel(Info)
+	if err := SetComponentLevel(ComponentICE, Debug); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetComponentLevel(ComponentRTP, Error); err != nil {
+		t.Fatal(err)
+	}
+	defer ResetComponentLevel(ComponentICE)
+	defer ResetComponentLevel(ComponentRTP)
+
+	l.Debug("ice debug", ComponentKey, ComponentICE)
+	l.Debug("plain debug")
+	l.Warn("rtp warn", ComponentKey, ComponentRTP)
+	l.Info("plain info")
+	l.With(ComponentKey, ComponentRTP).Error("rtp error")
+
+	out := buf.String()
+	for _, want := range []string{"ice debug", "plain info", "rtp error"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output misses %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"plain debug", "rtp warn"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("output has %q:\n%s", unwanted, out)
+		}
+	}
+}
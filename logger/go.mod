@@ -1,3 +1,3 @@
 module alovenio.com/blackbird/logger
 
-go 1.20
+go 1.21
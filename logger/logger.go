@@ -1,10 +1,15 @@
 package logger
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"runtime"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -14,12 +19,32 @@ const (
 	Error
 )
 
+// Output formats.
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
 var LogLevel = Info
 
-var debugLogger = log.New(os.Stdout, "[DEBUG] ", 0xF)
-var infoLogger = log.New(os.Stdout, "\u001B[36m[INFO] \u001B[0m", 0xF)
-var warnLogger = log.New(os.Stdout, "\u001B[33m[WARN] \u001B[0m", 0xF)
-var errorLogger = log.New(os.Stdout, "\u001b[31m[ERROR] \u001b[0m", 0xF)
+// Names of the structured fields commonly attached to log records.
+const (
+	SessionIdKey     = "session_id"
+	ParticipantIdKey = "participant_id"
+	RequestIdKey     = "request_id"
+	RemoteAddrKey    = "remote_addr"
+)
+
+var (
+	base   *slog.Logger
+	format = TextFormat
+	sinks  = []*sink{{config: SinkConfig{Type: StdoutSink}, writer: os.Stdout}}
+	locker sync.Mutex
+)
+
+func init() {
+	base = slog.New(newHandler(format, sinks))
+}
 
 func ParseLogLevel(level string) (int, error) {
 	var l = Info
@@ -39,31 +64,259 @@ func ParseLogLevel(level string) (int, error) {
 	return l, e
 }
 
-func LogDebugF(fmt string, args ...any) {
-	if LogLevel <= Debug {
-		debugLogger.Printf(fmt, args...)
+// ParseFormat validates an output format name, returning it normalized.
+func ParseFormat(f string) (string, error) {
+	switch strings.ToLower(f) {
+	case TextFormat:
+		return TextFormat, nil
+	case JSONFormat:
+		return JSONFormat, nil
 	}
+	return "", fmt.Errorf("unknown log format %q", f)
 }
 
-func LogInfoF(fmt string, args ...any) {
-	if LogLevel <= Info {
-		infoLogger.Printf(fmt, args...)
+// SetFormat sets the output format of all log records: TextFormat or
+// JSONFormat.
+func SetFormat(f string) {
+	locker.Lock()
+	defer locker.Unlock()
+	format = f
+	base = slog.New(newHandler(format, sinks))
+}
+
+// SetSinks replaces the sinks all log records are written to. The current
+// sinks are kept if any of the new ones can't be opened.
+func SetSinks(configs []SinkConfig) error {
+	var opened []*sink
+	for _, c := range configs {
+		s, err := openSink(c)
+		if err != nil {
+			for _, o := range opened {
+				o.close()
+			}
+			return err
+		}
+		opened = append(opened, s)
 	}
+	locker.Lock()
+	previous := sinks
+	sinks = opened
+	base = slog.New(newHandler(format, sinks))
+	locker.Unlock()
+	for _, s := range previous {
+		s.close()
+	}
+	return nil
 }
 
-func LogWarnF(fmt string, args ...any) {
-	if LogLevel <= Warn {
-		warnLogger.Printf(fmt, args...)
+// Reopen closes and reopens all file sinks, so log files moved away by
+// external tools, e.g. logrotate, are recreated.
+func Reopen() error {
+	locker.Lock()
+	defer locker.Unlock()
+	for _, s := range sinks {
+		if err := s.reopen(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-func LogErrorF(fmt string, args ...any) {
-	if LogLevel <= Error {
-		errorLogger.Printf(fmt, args...)
+// newHandler creates the slog handler writing records in format f to all
+// sinks. Records are filtered by the level of their component and by the
+// minimum level of every sink.
+func newHandler(f string, sinks []*sink) slog.Handler {
+	handlers := make([]sinkHandler, len(sinks))
+	for i, s := range sinks {
+		handlers[i] = sinkHandler{next: s.handler(f), level: toSlogLevel(s.config.Level)}
 	}
+	return &componentHandler{next: fanoutHandler(handlers)}
+}
+
+// newFormatHandler creates the slog handler writing records in format f to
+// w. Text records are not colored when plain is set.
+func newFormatHandler(f string, w io.Writer, plain bool) slog.Handler {
+	if f == JSONFormat {
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: minLevel, ReplaceAttr: renameJSONAttr})
+	}
+	return &textHandler{w: w, plain: plain, locker: &sync.Mutex{}}
+}
+
+// renameJSONAttr renames the built-in time and message attributes of JSON
+// records to timestamp and message.
+func renameJSONAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 {
+		switch a.Key {
+		case slog.TimeKey:
+			a.Key = "timestamp"
+		case slog.MessageKey:
+			a.Key = "message"
+		}
+	}
+	return a
+}
+
+// Logger returns the logger all log records are written to.
+func Logger() *slog.Logger {
+	locker.Lock()
+	defer locker.Unlock()
+	return base
+}
+
+// With returns a logger which adds the given fields, as key value pairs,
+// to every record.
+func With(args ...any) *slog.Logger {
+	return Logger().With(args...)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the given fields, as key value
+// pairs, in addition to the fields ctx already carries.
+func NewContext(ctx context.Context, args ...any) context.Context {
+	fields, _ := ctx.Value(contextKey{}).([]any)
+	fields = append(append([]any(nil), fields...), args...)
+	return context.WithValue(ctx, contextKey{}, fields)
+}
+
+// FromContext returns a logger which adds the fields carried by ctx to
+// every record.
+func FromContext(ctx context.Context) *slog.Logger {
+	fields, _ := ctx.Value(contextKey{}).([]any)
+	if len(fields) == 0 {
+		return Logger()
+	}
+	return Logger().With(fields...)
+}
+
+// DebugW logs a message with the given fields, as key value pairs, at debug level.
+func DebugW(msg string, args ...any) {
+	logW(slog.LevelDebug, msg, args...)
+}
+
+// InfoW logs a message with the given fields, as key value pairs, at info level.
+func InfoW(msg string, args ...any) {
+	logW(slog.LevelInfo, msg, args...)
+}
+
+// WarnW logs a message with the given fields, as key value pairs, at warn level.
+func WarnW(msg string, args ...any) {
+	logW(slog.LevelWarn, msg, args...)
+}
+
+// ErrorW logs a message with the given fields, as key value pairs, at error level.
+func ErrorW(msg string, args ...any) {
+	logW(slog.LevelError, msg, args...)
+}
+
+func LogDebugF(fmt string, args ...any) {
+	logF(context.Background(), slog.LevelDebug, fmt, args...)
+}
+
+func LogInfoF(fmt string, args ...any) {
+	logF(context.Background(), slog.LevelInfo, fmt, args...)
+}
+
+func LogWarnF(fmt string, args ...any) {
+	logF(context.Background(), slog.LevelWarn, fmt, args...)
+}
+
+func LogErrorF(fmt string, args ...any) {
+	logF(context.Background(), slog.LevelError, fmt, args...)
+}
+
+// LogDebugC logs a formatted message, with the fields carried by ctx, at debug level.
+func LogDebugC(ctx context.Context, fmt string, args ...any) {
+	logF(ctx, slog.LevelDebug, fmt, args...)
+}
+
+// LogInfoC logs a formatted message, with the fields carried by ctx, at info level.
+func LogInfoC(ctx context.Context, fmt string, args ...any) {
+	logF(ctx, slog.LevelInfo, fmt, args...)
+}
+
+// LogWarnC logs a formatted message, with the fields carried by ctx, at warn level.
+func LogWarnC(ctx context.Context, fmt string, args ...any) {
+	logF(ctx, slog.LevelWarn, fmt, args...)
+}
+
+// LogErrorC logs a formatted message, with the fields carried by ctx, at error level.
+func LogErrorC(ctx context.Context, fmt string, args ...any) {
+	logF(ctx, slog.LevelError, fmt, args...)
 }
 
 func LogFatalF(err error) {
-	errorLogger.Println(err)
+	logW(slog.LevelError, fmt.Sprint(err))
 	os.Exit(1)
 }
+
+// logF formats and logs a message, with the fields carried by ctx, unless
+// level is disabled. It must be called by the exported logging functions
+// only, so records get the source of their caller.
+func logF(ctx context.Context, level slog.Level, f string, args ...any) {
+	l := FromContext(ctx)
+	if l.Enabled(ctx, level) {
+		logRecord(ctx, l, level, fmt.Sprintf(f, args...))
+	}
+}
+
+// logW logs a message with the given fields, as key value pairs, unless
+// level is disabled. It must be called by the exported logging functions
+// only, so records get the source of their caller.
+func logW(level slog.Level, msg string, args ...any) {
+	l := Logger()
+	if l.Enabled(context.Background(), level) {
+		logRecord(context.Background(), l, level, msg, args...)
+	}
+}
+
+// logRecord logs a record through l, whose source is the caller of the
+// exported logging function calling logF or logW.
+func logRecord(ctx context.Context, l *slog.Logger, level slog.Level, msg string, args ...any) {
+	var pcs [1]uintptr
+	// skip Callers, logRecord, logF or logW and the exported function
+	runtime.Callers(4, pcs[:])
+	r := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	r.Add(args...)
+	_ = l.Handler().Handle(ctx, r)
+}
+
+// toSlogLevel maps a Debug, Info, Warn or Error level to its slog level.
+func toSlogLevel(level int) slog.Level {
+	switch level {
+	case Debug:
+		return slog.LevelDebug
+	case Warn:
+		return slog.LevelWarn
+	case Error:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// fromSlogLevel maps a slog level to a Debug, Info, Warn or Error level.
+func fromSlogLevel(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return Error
+	case level >= slog.LevelWarn:
+		return Warn
+	case level >= slog.LevelInfo:
+		return Info
+	}
+	return Debug
+}
+
+// LevelName returns the name of a Debug, Info, Warn or Error level, as
+// accepted by ParseLogLevel.
+func LevelName(level int) string {
+	switch level {
+	case Debug:
+		return "debug"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	}
+	return "info"
+}
@@ -0,0 +1,162 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat is the format of the suffix of rotated log files.
+const rotatedTimeFormat = "20060102T150405.000000000"
+
+// rotatingFile is an io.Writer appending to a log file which is rotated
+// once it grows above a maximum size or gets older than a maximum age.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	// Time the log file was started, from which its age is measured.
+	started time.Time
+	locker  sync.Mutex
+}
+
+// openRotatingFile opens, creating it if needed, the log file at path.
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) Write(b []byte) (int, error) {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	if f.shouldRotate(len(b)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) Close() error {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return f.file.Close()
+}
+
+// reopen closes and reopens the log file, creating it if it was moved away.
+func (f *rotatingFile) reopen() error {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	f.file.Close()
+	return f.open()
+}
+
+// open opens the log file for appending.
+func (f *rotatingFile) open() error {
+	file, size, started, err := openLogFile(f.path)
+	if err != nil {
+		return err
+	}
+	f.file, f.size, f.started = file, size, started
+	return nil
+}
+
+// openLogFile opens, creating it if needed, the log file at path for
+// appending. It returns the file along with its size and the time it was
+// started: the modification time of existing files, as their creation
+// time is not portably available, so reopening the file, e.g. on
+// restarts, does not make it younger than its last record.
+func openLogFile(path string) (*os.File, int64, time.Time, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, time.Time{}, err
+	}
+	started := time.Now()
+	if info.Size() > 0 {
+		started = info.ModTime()
+	}
+	return file, info.Size(), started, nil
+}
+
+// shouldRotate returns whether the log file must be rotated before n more
+// bytes are written.
+func (f *rotatingFile) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+int64(n) > f.maxSize {
+		return true
+	}
+	return f.maxAge > 0 && time.Since(f.started) > f.maxAge
+}
+
+// rotate renames the log file with a timestamp suffix, opens a new one and
+// removes the oldest rotated files above the retention count. The current
+// file is kept open, and renamed back, if the new one can't be opened, so
+// records are not lost.
+func (f *rotatingFile) rotate() error {
+	backup := f.path + "." + time.Now().Format(rotatedTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+	file, size, started, err := openLogFile(f.path)
+	if err != nil {
+		os.Rename(backup, f.path)
+		return err
+	}
+	previous := f.file
+	f.file, f.size, f.started = file, size, started
+	if err = previous.Close(); err != nil {
+		return err
+	}
+	return f.pruneBackups()
+}
+
+// pruneBackups removes the oldest rotated files above the retention count.
+// Only files named after the log file with a timestamp suffix are rotated
+// files, other files sharing its prefix are left alone.
+func (f *rotatingFile) pruneBackups() error {
+	if f.maxBackups <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return err
+	}
+	prefix := filepath.Base(f.path) + "."
+	var backups []string
+	for _, e := range entries {
+		suffix, found := strings.CutPrefix(e.Name(), prefix)
+		if !found || e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(rotatedTimeFormat, suffix); err == nil {
+			backups = append(backups, filepath.Join(filepath.Dir(f.path), e.Name()))
+		}
+	}
+	// timestamp suffixes sort chronologically
+	sort.Strings(backups)
+	for len(backups) > f.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rotatedFiles returns the names of the rotated files of the log file at
+// path.
+func rotatedFiles(t *testing.T, path string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		suffix, found := strings.CutPrefix(e.Name(), filepath.Base(path)+".")
+		if _, err := time.Parse(rotatedTimeFormat, suffix); found && err == nil {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blackbird.log")
+	other := path + ".keep"
+	if err := os.WriteFile(other, []byte("not a backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openRotatingFile(path, 20, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	line := []byte("0123456789abcde\n")
+	for i := 0; i < 5; i++ {
+		if _, err = f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backups := rotatedFiles(t, path); len(backups) != 2 {
+		t.Errorf("rotated files = %v, want 2", backups)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != string(line) {
+		t.Errorf("log file = %q, %v, want %q", data, err, line)
+	}
+	if _, err = os.Stat(other); err != nil {
+		t.Errorf("file sharing the prefix of the log file was removed: %v", err)
+	}
+}
+
+func TestRotateByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blackbird.log")
+	if err := os.WriteFile(path, []byte("old record\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write([]byte("new record\n")); err != nil {
+		t.Fatal(err)
+	}
+	if backups := rotatedFiles(t, path); len(backups) != 1 {
+		t.Fatalf("rotated files = %v, want 1", backups)
+	}
+	if _, err = f.Write([]byte("newer record\n")); err != nil {
+		t.Fatal(err)
+	}
+	if backups := rotatedFiles(t, path); len(backups) != 1 {
+		t.Errorf("rotated files = %v, want the young file not rotated", backups)
+	}
+}
@@ -0,0 +1,274 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sink types.
+const (
+	StdoutSink = "stdout"
+	StderrSink = "stderr"
+	FileSink   = "file"
+	SyslogSink = "syslog"
+)
+
+// SinkConfig holds the configuration of a destination of log records.
+type SinkConfig struct {
+	// Type of the sink: StdoutSink, StderrSink, FileSink or SyslogSink.
+	Type string
+	// Minimum level of the records written to the sink. Records must also
+	// be enabled by the level of their component.
+	Level int
+	// Output format of the sink. The format set with SetFormat is used
+	// when blank.
+	Format string
+	// Path of the log file of a FileSink.
+	Path string
+	// Size, in bytes, above which the log file of a FileSink is rotated.
+	// Zero disables rotation by size.
+	MaxSize int64
+	// Age above which the log file of a FileSink is rotated. Zero disables
+	// rotation by age.
+	MaxAge time.Duration
+	// Number of rotated log files of a FileSink which are kept. Zero keeps
+	// all of them.
+	MaxBackups int
+	// Network (udp, tcp or unix) and address of the syslog daemon of a
+	// SyslogSink. The local syslog daemon is used when blank.
+	Network string
+	Address string
+	// Tag of the messages of a SyslogSink. The program's name is used when
+	// blank.
+	Tag string
+}
+
+// ParseSink parses a sink specification in the form
+// type[:target][,key=value...], e.g.
+//
+//	stdout
+//	stderr,level=error
+//	file:/var/log/blackbird.log,level=info,maxSize=104857600,maxAge=24h,maxBackups=7,format=json
+//	syslog:udp://localhost:514,level=warn,tag=blackbird
+func ParseSink(spec string) (SinkConfig, error) {
+	parts := strings.Split(spec, ",")
+	kind, target, _ := strings.Cut(parts[0], ":")
+	c := SinkConfig{Type: strings.ToLower(kind), Level: Debug}
+	switch c.Type {
+	case StdoutSink, StderrSink:
+	case FileSink:
+		if target == "" {
+			return c, fmt.Errorf("file sink %q has no path", spec)
+		}
+		c.Path = target
+	case SyslogSink:
+		if target != "" {
+			u, err := url.Parse(target)
+			if err != nil || u.Scheme == "" {
+				return c, fmt.Errorf("syslog sink %q has an invalid address", spec)
+			}
+			c.Network = u.Scheme
+			c.Address = u.Host
+			if u.Scheme == "unix" || u.Scheme == "unixgram" {
+				c.Address = u.Path
+			}
+		}
+	default:
+		return c, fmt.Errorf("unknown sink type %q", kind)
+	}
+	for _, option := range parts[1:] {
+		k, v, _ := strings.Cut(option, "=")
+		var err error
+		switch k {
+		case "level":
+			c.Level, err = ParseLogLevel(v)
+		case "format":
+			c.Format, err = ParseFormat(v)
+		case "maxSize":
+			c.MaxSize, err = strconv.ParseInt(v, 10, 64)
+		case "maxAge":
+			c.MaxAge, err = time.ParseDuration(v)
+		case "maxBackups":
+			c.MaxBackups, err = strconv.Atoi(v)
+		case "tag":
+			c.Tag = v
+		default:
+			err = fmt.Errorf("unknown option %q", k)
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid sink %q: %w", spec, err)
+		}
+	}
+	return c, nil
+}
+
+// sink is an open destination of log records.
+type sink struct {
+	config SinkConfig
+	writer io.Writer
+	file   *rotatingFile
+	syslog *syslog.Writer
+}
+
+// openSink opens the destination of a sink.
+func openSink(c SinkConfig) (*sink, error) {
+	s := &sink{config: c}
+	var err error
+	switch c.Type {
+	case StdoutSink:
+		s.writer = os.Stdout
+	case StderrSink:
+		s.writer = os.Stderr
+	case FileSink:
+		s.file, err = openRotatingFile(c.Path, c.MaxSize, c.MaxAge, c.MaxBackups)
+		s.writer = s.file
+	case SyslogSink:
+		s.syslog, err = syslog.Dial(c.Network, c.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, c.Tag)
+	default:
+		err = fmt.Errorf("unknown sink type %q", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// handler creates the slog handler writing records to the sink in its own
+// format, or in format f if it has none.
+func (s *sink) handler(f string) slog.Handler {
+	if s.config.Format != "" {
+		f = s.config.Format
+	}
+	if s.syslog != nil {
+		return syslogHandler{
+			slog.LevelDebug: newFormatHandler(f, syslogWriter(s.syslog.Debug), true),
+			slog.LevelInfo:  newFormatHandler(f, syslogWriter(s.syslog.Info), true),
+			slog.LevelWarn:  newFormatHandler(f, syslogWriter(s.syslog.Warning), true),
+			slog.LevelError: newFormatHandler(f, syslogWriter(s.syslog.Err), true),
+		}
+	}
+	return newFormatHandler(f, s.writer, s.file != nil)
+}
+
+// reopen reopens the log file of file sinks.
+func (s *sink) reopen() error {
+	if s.file != nil {
+		return s.file.reopen()
+	}
+	return nil
+}
+
+// close releases the resources of the sink.
+func (s *sink) close() {
+	if s.file != nil {
+		s.file.Close()
+	}
+	if s.syslog != nil {
+		s.syslog.Close()
+	}
+}
+
+// sinkHandler is a slog.Handler dropping records below a sink's level.
+type sinkHandler struct {
+	next  slog.Handler
+	level slog.Level
+}
+
+// fanoutHandler is a slog.Handler writing records to several sinks.
+type fanoutHandler []sinkHandler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, s := range h {
+		if level >= s.level && s.next.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, s := range h {
+		if r.Level >= s.level && s.next.Enabled(ctx, r.Level) {
+			errs = append(errs, s.next.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := make(fanoutHandler, len(h))
+	for i, s := range h {
+		c[i] = sinkHandler{next: s.next.WithAttrs(attrs), level: s.level}
+	}
+	return c
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	c := make(fanoutHandler, len(h))
+	for i, s := range h {
+		c[i] = sinkHandler{next: s.next.WithGroup(name), level: s.level}
+	}
+	return c
+}
+
+// syslogWriter is an io.Writer sending every write as a single syslog
+// message with the priority of the wrapped syslog.Writer method.
+type syslogWriter func(m string) error
+
+func (w syslogWriter) Write(b []byte) (int, error) {
+	if err := w(strings.TrimSuffix(string(b), "\n")); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// syslogHandler is a slog.Handler writing records with a syslog priority
+// matching their level.
+type syslogHandler map[slog.Level]slog.Handler
+
+// forLevel returns the handler of records of the given level.
+func (h syslogHandler) forLevel(level slog.Level) slog.Handler {
+	switch {
+	case level >= slog.LevelError:
+		return h[slog.LevelError]
+	case level >= slog.LevelWarn:
+		return h[slog.LevelWarn]
+	case level >= slog.LevelInfo:
+		return h[slog.LevelInfo]
+	}
+	return h[slog.LevelDebug]
+}
+
+func (h syslogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.forLevel(level).Enabled(ctx, level)
+}
+
+func (h syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.forLevel(r.Level).Handle(ctx, r)
+}
+
+func (h syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := make(syslogHandler, len(h))
+	for l, next := range h {
+		c[l] = next.WithAttrs(attrs)
+	}
+	return c
+}
+
+func (h syslogHandler) WithGroup(name string) slog.Handler {
+	c := make(syslogHandler, len(h))
+	for l, next := range h {
+		c[l] = next.WithGroup(name)
+	}
+	return c
+}
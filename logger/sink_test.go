@@ -0,0 +1,37 @@
+package logger
+
+import (
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s, err := openSink(SinkConfig{Type: SyslogSink, Network: "udp", Address: conn.LocalAddr().String(), Tag: "blackbird-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	slog.New(s.handler(TextFormat)).Warn("disk almost full", "free", 42)
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// warning (4) of the daemon facility (3)
+	for _, want := range []string{"<28>", "blackbird-test", "[WARN]", "disk almost full", "free=42"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("syslog message %q misses %q", msg, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const textTimeFormat = "2006/01/02 15:04:05.000000"
+
+// textHandler is a slog.Handler writing human readable lines with a
+// colored level prefix and the source file and line of the record, as the
+// standard logger's Llongfile flag does, followed by the record's fields
+// as key=value pairs. The level prefix is not colored when plain is set.
+type textHandler struct {
+	w      io.Writer
+	plain  bool
+	attrs  []slog.Attr
+	groups []string
+	locker *sync.Mutex
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= minLevel
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := &bytes.Buffer{}
+	buf.WriteString(levelPrefix(r.Level, h.plain))
+	buf.WriteString(r.Time.Format(textTimeFormat))
+	buf.WriteByte(' ')
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		buf.WriteString(frame.File + ":" + strconv.Itoa(frame.Line) + ": ")
+	}
+	buf.WriteString(r.Message)
+	for _, a := range h.attrs {
+		writeAttr(buf, "", a)
+	}
+	prefix := groupPrefix(h.groups)
+	r.Attrs(func(a slog.Attr) bool {
+		writeAttr(buf, prefix, a)
+		return true
+	})
+	buf.WriteByte('\n')
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefix := groupPrefix(h.groups)
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		c.attrs = append(c.attrs, slog.Attr{Key: prefix + a.Key, Value: a.Value})
+	}
+	return &c
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.groups = append(append([]string(nil), h.groups...), name)
+	return &c
+}
+
+// levelPrefix returns the prefix of records of the given level, colored
+// unless plain is set.
+func levelPrefix(level slog.Level, plain bool) string {
+	if plain {
+		return "[" + strings.ToUpper(LevelName(fromSlogLevel(level))) + "] "
+	}
+	switch {
+	case level >= slog.LevelError:
+		return "\u001b[31m[ERROR] \u001b[0m"
+	case level >= slog.LevelWarn:
+		return "\u001B[33m[WARN] \u001B[0m"
+	case level >= slog.LevelInfo:
+		return "\u001B[36m[INFO] \u001B[0m"
+	}
+	return "[DEBUG] "
+}
+
+// groupPrefix returns the prefix of the keys of attributes in groups.
+func groupPrefix(groups []string) string {
+	prefix := ""
+	for _, g := range groups {
+		prefix += g + "."
+	}
+	return prefix
+}
+
+// writeAttr writes a single attribute as a key=value pair.
+func writeAttr(buf *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			writeAttr(buf, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(prefix + a.Key)
+	buf.WriteByte('=')
+	var v string
+	switch a.Value.Kind() {
+	case slog.KindTime:
+		v = a.Value.Time().Format(time.RFC3339Nano)
+	default:
+		v = fmt.Sprint(a.Value.Any())
+	}
+	if needsQuoting(v) {
+		v = strconv.Quote(v)
+	}
+	buf.WriteString(v)
+}
+
+// needsQuoting returns whether a value must be quoted to be unambiguous.
+func needsQuoting(v string) bool {
+	if v == "" {
+		return true
+	}
+	for _, c := range v {
+		if c <= ' ' || c == '=' || c == '"' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// LogLevelParams holds the parameters to change log levels at runtime.
+type LogLevelParams struct {
+	// Component whose level is changed. The default level, applying to all
+	// components without their own level, is changed when blank.
+	Component string `json:"component,omitempty"`
+	// New level: debug, info, warn or error. A blank level removes the
+	// component's own level.
+	Level string `json:"level"`
+}
+
+// LogLevelResult holds the log levels in effect.
+type LogLevelResult struct {
+	Level      string            `json:"level,omitempty"`
+	Components map[string]string `json:"components,omitempty"`
+	Errors     []FieldError      `json:"errors,omitempty"`
+}
+
+// newLogLevelResult returns the log levels currently in effect.
+func newLogLevelResult() LogLevelResult {
+	result := LogLevelResult{
+		Level:      logger.LevelName(logger.GetLogLevel()),
+		Components: make(map[string]string),
+	}
+	for c, l := range logger.ComponentLevels() {
+		result.Components[c] = logger.LevelName(l)
+	}
+	return result
+}
+
+// adminMiddleware rejects requests without the server's admin token as
+// bearer token. Admin routes are disabled when the server has no token.
+func (s *Server) adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.AdminToken) == 0 {
+			logger.LogWarnC(r.Context(), "admin api disabled, no admin token configured")
+			writeError(w, r, http.StatusNotFound, nil, nil, "")
+			return
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			logger.LogWarnC(r.Context(), "unauthorized admin request")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="blackbird-admin"`)
+			writeError(w, r, http.StatusUnauthorized, nil, nil, "")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// onAdminLogLevelRequest is called for every request to /admin/log-level
+func (s *Server) onAdminLogLevelRequest(w http.ResponseWriter, r *http.Request) {
+	if isGet(r) {
+		writeJSON(w, r, http.StatusOK, newLogLevelResult())
+	} else if r.Method == http.MethodPut {
+		params := LogLevelParams{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			writeDecodingError(w, r, err)
+			return
+		}
+		if errors := setLogLevel(params); errors != nil {
+			writeOutcome(w, r, outcome{result: LogLevelResult{Errors: errors}, errors: errors})
+			return
+		}
+		logger.LogInfoC(r.Context(), "log level of %q set to %q", params.Component, params.Level)
+		writeJSON(w, r, http.StatusOK, newLogLevelResult())
+	} else {
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPut)
+	}
+}
+
+// setLogLevel applies a log level change. It will return a slice with all
+// the errors found or nil if no errors exist.
+func setLogLevel(params LogLevelParams) []FieldError {
+	if params.Component == "" {
+		level, err := logger.ParseLogLevel(params.Level)
+		if err != nil {
+			return []FieldError{{Field: "level", Code: ErrorInvalidValue, Detail: err.Error()}}
+		}
+		logger.SetLogLevel(level)
+		return nil
+	}
+	if params.Level == "" {
+		if err := logger.ResetComponentLevel(params.Component); err != nil {
+			return []FieldError{{Field: "component", Code: ErrorInvalidValue, Detail: err.Error()}}
+		}
+		return nil
+	}
+	level, err := logger.ParseLogLevel(params.Level)
+	if err != nil {
+		return []FieldError{{Field: "level", Code: ErrorInvalidValue, Detail: err.Error()}}
+	}
+	if err = logger.SetComponentLevel(params.Component, level); err != nil {
+		return []FieldError{{Field: "component", Code: ErrorInvalidValue, Detail: err.Error()}}
+	}
+	return nil
+}
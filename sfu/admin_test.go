@@ -0,0 +1,111 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// adminRequest sends a request with token as bearer token, if not blank,
+// to the admin log level route of ts and decodes its JSON body into v.
+func adminRequest(t *testing.T, ts *httptest.Server, method string, token string, body string, v any) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+"/admin/log-level", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s /admin/log-level: %v", method, err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s /admin/log-level: %v", method, err)
+		}
+	}
+	return resp
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	disabled := httptest.NewServer((&Server{}).newRouter(NewWebRtcSessionHandler()))
+	defer disabled.Close()
+	if resp := adminRequest(t, disabled, http.MethodGet, "secret", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /admin/log-level without an admin token = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	ts := httptest.NewServer((&Server{AdminToken: "secret"}).newRouter(NewWebRtcSessionHandler()))
+	defer ts.Close()
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		resp := adminRequest(t, ts, http.MethodGet, test.token, "", nil)
+		if resp.StatusCode != test.want {
+			t.Errorf("GET /admin/log-level with token %q = %d, want %d", test.token, resp.StatusCode, test.want)
+		}
+		if test.want == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("GET /admin/log-level with token %q has no WWW-Authenticate header", test.token)
+		}
+	}
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	level := logger.GetLogLevel()
+	defer logger.SetLogLevel(level)
+	defer logger.ResetComponentLevel(logger.ComponentICE)
+	ts := httptest.NewServer((&Server{AdminToken: "secret"}).newRouter(NewWebRtcSessionHandler()))
+	defer ts.Close()
+
+	var result LogLevelResult
+	resp := adminRequest(t, ts, http.MethodPut, "secret", `{"component":"ice","level":"debug"}`, &result)
+	if resp.StatusCode != http.StatusOK || result.Components[logger.ComponentICE] != "debug" {
+		t.Errorf("PUT /admin/log-level of ice = %d, %+v, want debug", resp.StatusCode, result)
+	}
+	result = LogLevelResult{}
+	adminRequest(t, ts, http.MethodGet, "secret", "", &result)
+	if result.Components[logger.ComponentICE] != "debug" || result.Level != logger.LevelName(level) {
+		t.Errorf("GET /admin/log-level = %+v, want ice at debug and %s by default", result, logger.LevelName(level))
+	}
+
+	result = LogLevelResult{}
+	adminRequest(t, ts, http.MethodPut, "secret", `{"level":"error"}`, &result)
+	if result.Level != "error" || logger.GetLogLevel() != logger.Error {
+		t.Errorf("PUT /admin/log-level = %+v, want error by default", result)
+	}
+	result = LogLevelResult{}
+	adminRequest(t, ts, http.MethodPut, "secret", `{"component":"ice"}`, &result)
+	if result.Components[logger.ComponentICE] != "error" {
+		t.Errorf("PUT /admin/log-level without a level = %+v, want ice at the default level", result)
+	}
+
+	invalid := []string{
+		`{"level":"verbose"}`,
+		`{"component":"ice","level":"verbose"}`,
+		`{"component":"unknown","level":"debug"}`,
+		`{"level":`,
+	}
+	for _, body := range invalid {
+		if resp := adminRequest(t, ts, http.MethodPut, "secret", body, nil); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("PUT /admin/log-level %s = %d, want %d", body, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+	if logger.GetLogLevel() != logger.Error {
+		t.Errorf("log level = %s after invalid changes, want error", logger.LevelName(logger.GetLogLevel()))
+	}
+	if resp := adminRequest(t, ts, http.MethodDelete, "secret", "", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /admin/log-level = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
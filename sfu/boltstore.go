@@ -0,0 +1,173 @@
+package sfu
+
+import (
+	"encoding/json"
+	"go.etcd.io/bbolt"
+	"strings"
+	"time"
+)
+
+var (
+	sessionsBucket     = []byte("sessions")
+	participantsBucket = []byte("participants")
+	idempotencyBucket  = []byte("idempotency")
+)
+
+// boltStore is a Store keeping all data in an embedded bbolt database file.
+type boltStore struct {
+	db *bbolt.DB
+}
+
+// OpenBoltStore opens, creating it if needed, the bbolt database file at
+// path and returns a Store backed by it.
+func OpenBoltStore(path string) (Store, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{sessionsBucket, participantsBucket, idempotencyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// participantKey returns the key of a participant, prefixed by the id of
+// its session so all participants of a session can be scanned together.
+func participantKey(sessionId string, participantId string) []byte {
+	return []byte(sessionId + "/" + participantId)
+}
+
+func (b *boltStore) CreateSession(s Session, r *IdempotencyRecord) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(sessionsBucket).Put([]byte(s.Id), data); err != nil {
+			return err
+		}
+		return putIdempotencyRecord(tx, r)
+	})
+}
+
+func (b *boltStore) SaveSession(s Session) error {
+	return b.CreateSession(s, nil)
+}
+
+func (b *boltStore) DeleteSession(sessionId string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		if err := tx.Bucket(sessionsBucket).Delete([]byte(sessionId)); err != nil {
+			return err
+		}
+		prefix := []byte(sessionId + "/")
+		c := tx.Bucket(participantsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Seek(prefix) {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltStore) CreateParticipant(p Participant, r *IdempotencyRecord) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(sessionsBucket).Get([]byte(p.SessionId)) == nil {
+			return sessionNotStoredError(p.SessionId)
+		}
+		if err := tx.Bucket(participantsBucket).Put(participantKey(p.SessionId, p.Id), data); err != nil {
+			return err
+		}
+		return putIdempotencyRecord(tx, r)
+	})
+}
+
+func (b *boltStore) SaveParticipant(p Participant) error {
+	return b.CreateParticipant(p, nil)
+}
+
+func (b *boltStore) DeleteParticipant(sessionId string, participantId string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(participantsBucket).Delete(participantKey(sessionId, participantId))
+	})
+}
+
+func (b *boltStore) LoadSessions() ([]SessionRecord, error) {
+	var records []SessionRecord
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		indexes := make(map[string]int)
+		err := tx.Bucket(sessionsBucket).ForEach(func(k, v []byte) error {
+			r := SessionRecord{}
+			if err := json.Unmarshal(v, &r.Session); err != nil {
+				return err
+			}
+			indexes[r.Session.Id] = len(records)
+			records = append(records, r)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(participantsBucket).ForEach(func(k, v []byte) error {
+			p := Participant{}
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			if i, ok := indexes[p.SessionId]; ok {
+				records[i].Participants = append(records[i].Participants, p)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+// putIdempotencyRecord stores record r, if not nil, in transaction tx.
+func putIdempotencyRecord(tx *bbolt.Tx, r *IdempotencyRecord) error {
+	if r == nil {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(idempotencyBucket).Put([]byte(r.Key), data)
+}
+
+func (b *boltStore) DeleteIdempotencyRecord(key string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(idempotencyBucket).Delete([]byte(key))
+	})
+}
+
+func (b *boltStore) LoadIdempotencyRecords() ([]IdempotencyRecord, error) {
+	var records []IdempotencyRecord
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(idempotencyBucket).ForEach(func(k, v []byte) error {
+			r := IdempotencyRecord{}
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
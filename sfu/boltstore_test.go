@@ -0,0 +1,93 @@
+package sfu
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStoreRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blackbird.db")
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("OpenBoltStore() error = %v", err)
+	}
+	h := NewWebRtcSessionHandler(WithStore(store))
+	session, _ := h.CreateSession(CreateSessionParams{Name: "session", IdempotencyKey: "create-session"})
+	alice, _ := h.AddParticipant(AddParticipantParams{SessionId: session.Session.Id, Name: "alice", IdempotencyKey: "add-alice"})
+	bob, _ := h.AddParticipant(AddParticipantParams{SessionId: session.Session.Id, Name: "bob"})
+	connected, err := h.Heartbeat(HeartbeatParams{SessionId: session.Session.Id, ParticipantId: alice.Participant.Id, ConnectionState: ConnectionConnected})
+	if err != nil || connected.Participant == nil || connected.Participant.ConnectionState != ConnectionConnected {
+		t.Fatalf("Heartbeat() = %+v, %v", connected, err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	store, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatalf("OpenBoltStore() error = %v", err)
+	}
+	defer store.Close()
+	h = NewWebRtcSessionHandler(WithStore(store))
+	if err = h.Restore(); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	restored, _ := h.GetSession(GetSessionParams{Id: session.Session.Id})
+	if restored.Session == nil || restored.Session.Name != "session" {
+		t.Fatalf("GetSession() = %+v, want session %s", restored, session.Session.Id)
+	}
+	participants, _ := h.GetParticipants(GetParticipantsParams{SessionId: session.Session.Id})
+	if len(participants.Participants) != 2 {
+		t.Fatalf("GetParticipants() = %+v, want alice and bob", participants)
+	}
+	for _, p := range participants.Participants {
+		if p.ConnectionState != ConnectionDisconnected {
+			t.Errorf("participant %s is %s, want %s", p.Name, p.ConnectionState, ConnectionDisconnected)
+		}
+	}
+
+	// the disconnections are persisted with a new revision
+	records, _ := store.LoadSessions()
+	if len(records) != 1 || len(records[0].Participants) != 2 {
+		t.Fatalf("LoadSessions() = %+v, want one session with two participants", records)
+	}
+	for _, p := range records[0].Participants {
+		want := bob.Participant.Revision + 1
+		if p.Id == alice.Participant.Id {
+			want = connected.Participant.Revision + 1
+		}
+		if p.ConnectionState != ConnectionDisconnected || p.Revision != want {
+			t.Errorf("stored participant %s = %s at revision %d, want %s at revision %d", p.Name, p.ConnectionState, p.Revision, ConnectionDisconnected, want)
+		}
+	}
+
+	// idempotency keys replay the results of the previous handler
+	replayed, _ := h.CreateSession(CreateSessionParams{Name: "session", IdempotencyKey: "create-session"})
+	if replayed.Session == nil || replayed.Session.Id != session.Session.Id {
+		t.Errorf("replayed CreateSession() = %+v, want session %s", replayed, session.Session.Id)
+	}
+	again, _ := h.AddParticipant(AddParticipantParams{SessionId: session.Session.Id, Name: "alice", IdempotencyKey: "add-alice"})
+	if again.Participant == nil || again.Participant.Id != alice.Participant.Id {
+		t.Errorf("replayed AddParticipant() = %+v, want participant %s", again, alice.Participant.Id)
+	}
+}
+
+func TestStoreParticipantOfMissingSession(t *testing.T) {
+	bolt, err := OpenBoltStore(filepath.Join(t.TempDir(), "blackbird.db"))
+	if err != nil {
+		t.Fatalf("OpenBoltStore() error = %v", err)
+	}
+	defer bolt.Close()
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "bolt": bolt} {
+		p := Participant{Id: "p", SessionId: "missing", Name: "alice"}
+		if err := store.CreateParticipant(p, &IdempotencyRecord{Key: "add-alice"}); err == nil {
+			t.Errorf("%s CreateParticipant() error = nil, want an error", name)
+		}
+		if err := store.SaveParticipant(p); err == nil {
+			t.Errorf("%s SaveParticipant() error = nil, want an error", name)
+		}
+		if records, _ := store.LoadIdempotencyRecords(); len(records) != 0 {
+			t.Errorf("%s LoadIdempotencyRecords() = %+v, want none", name, records)
+		}
+	}
+}
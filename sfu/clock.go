@@ -0,0 +1,17 @@
+package sfu
+
+import "time"
+
+// Clock provides the current time to session handlers. It can be
+// replaced to control time-based behaviour deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the Clock implementation backed by the system's
+// wall clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
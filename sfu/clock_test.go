@@ -0,0 +1,29 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a Clock whose time only changes when advanced.
+type fakeClock struct {
+	now    time.Time
+	locker sync.Mutex
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.now
+}
+
+// advance moves the clock forward by d.
+func (c *fakeClock) advance(d time.Duration) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,621 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/memberlist"
+	"go.opentelemetry.io/otel/propagation"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clusterLogContext carries the component of the log records of the cluster.
+var clusterLogContext = logger.NewContext(context.Background(), logger.ComponentKey, logger.ComponentCluster)
+
+const (
+	// clusterTagLen is the length of the node tag every session id of a
+	// clustered node starts with. Tags of 5 hex digits keep collisions
+	// between node names unlikely in clusters of hundreds of nodes, while
+	// leaving IdLen-5 random hex digits to the ids of the sessions of each
+	// node.
+	clusterTagLen = 5
+	// clusterForwardedHeader is set on requests forwarded to another node,
+	// to the name of the forwarding node, the time of the forwarding and
+	// their signature, separated by semicolons. Forwarded requests are
+	// always handled by the receiving node, so the header is ignored
+	// unless it is signed with the forwarding key of a live node.
+	clusterForwardedHeader = "X-Blackbird-Forwarded-By"
+	// clusterForwardedMaxAge is the maximum age of a forwarded request,
+	// limiting the replay of captured ones.
+	clusterForwardedMaxAge = time.Minute
+	// clusterRelayHeader is set on the forwarded requests of relays, so the
+	// participants they create are relays. It is dropped from requests
+	// which were not forwarded by a live node.
+	clusterRelayHeader = "X-Blackbird-Relay"
+	// clusterLoadInterval is the interval between two gossips of a node's load.
+	clusterLoadInterval = 2 * time.Second
+)
+
+// ClusterConfig holds the configuration of a Cluster.
+type ClusterConfig struct {
+	// Unique name of the node. The host name is used when blank.
+	NodeName string
+	// Address, in host:port form, used for gossip.
+	BindAddr string
+	// Base URL, e.g. http://10.0.0.1:8000, where other nodes reach the
+	// REST API of this node.
+	AdvertiseURL string
+	// Gossip addresses of existing nodes to join.
+	Join []string
+	// Whether requests for sessions hosted by other nodes are redirected
+	// rather than proxied.
+	Redirect bool
+	// Whether nodes serve the negotiations of the participants of sessions
+	// hosted by other nodes, relaying their tracks to the hosting node,
+	// rather than forwarding them. The session handler must be created
+	// with WithRelay.
+	Relay bool
+	// Key of 16, 24 or 32 bytes encrypting and authenticating gossip with
+	// AES. Every node must use the same key. Without it, gossip is sent in
+	// clear text and any host reaching BindAddr can join the cluster, so a
+	// key is required in production.
+	SecretKey []byte
+}
+
+// ClusterNode holds information about a single node of a cluster.
+type ClusterNode struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Tag  string `json:"tag"`
+	// Tags of the sessions the node hosts since it was renamed.
+	FormerTags   []string `json:"formerTags,omitempty"`
+	Sessions     int      `json:"sessions"`
+	Participants int      `json:"participants"`
+	Self         bool     `json:"self"`
+}
+
+// clusterMeta is the metadata a node gossips to the others.
+type clusterMeta struct {
+	ClusterNode
+	// Key signing the requests the node forwards to the others. It is
+	// only known to the nodes of the cluster, as long as gossip is
+	// encrypted.
+	ForwardingKey []byte `json:"forwardingKey"`
+}
+
+// tags returns all the tags of the sessions hosted by node n.
+func (n ClusterNode) tags() []string {
+	return append([]string{n.Tag}, n.FormerTags...)
+}
+
+// hasTag returns whether node n hosts the sessions with tag.
+func (n ClusterNode) hasTag(tag string) bool {
+	for _, t := range n.tags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadReporter returns the number of sessions and participants hosted by
+// a node.
+type LoadReporter func() (sessions int, participants int)
+
+// Cluster tracks the membership of a set of Blackbird nodes through
+// gossip and routes session requests to the node hosting each session.
+// Every session id starts with the tag of the node hosting it, so routing
+// does not depend on the current membership.
+//
+// Listing sessions and streaming the events of all sessions only cover
+// the sessions of the node receiving the request, as they are not
+// forwarded to other nodes.
+//
+// A session is hosted by a single node. With ClusterConfig.Relay, its
+// participants may negotiate their media with any node, which relays
+// their tracks to the hosting node, so a session can span several nodes.
+type Cluster struct {
+	config ClusterConfig
+	self   ClusterNode
+	// Key signing the requests forwarded by this node.
+	forwardingKey []byte
+	load          LoadReporter
+	members       *memberlist.Memberlist
+	done          chan struct{}
+}
+
+// NewCluster creates a Cluster node. The node does not gossip nor join
+// other nodes until Start is called.
+func NewCluster(config ClusterConfig) (*Cluster, error) {
+	if _, err := url.Parse(config.AdvertiseURL); err != nil || config.AdvertiseURL == "" {
+		return nil, fmt.Errorf("cluster advertise url must be a valid url")
+	}
+	if n := len(config.SecretKey); n != 0 && n != 16 && n != 24 && n != 32 {
+		return nil, fmt.Errorf("cluster secret key must be 16, 24 or 32 bytes long, not %d", n)
+	}
+	name := config.NodeName
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("cluster node name must not be blank: %w", err)
+		}
+		name = hostname
+	}
+	forwardingKey := make([]byte, 32)
+	if _, err := rand.Read(forwardingKey); err != nil {
+		return nil, fmt.Errorf("failed to generate the cluster forwarding key: %w", err)
+	}
+	c := &Cluster{
+		config: config,
+		self: ClusterNode{
+			Name: name,
+			URL:  strings.TrimSuffix(config.AdvertiseURL, "/"),
+			Tag:  clusterTag(name),
+			Self: true,
+		},
+		forwardingKey: forwardingKey,
+		done:          make(chan struct{}),
+	}
+	return c, nil
+}
+
+// Start starts gossiping and joins the configured nodes. The load reporter
+// is used to gossip this node's load to the others.
+func (c *Cluster) Start(load LoadReporter) error {
+	host, portStr, err := net.SplitHostPort(c.config.BindAddr)
+	if err != nil {
+		return fmt.Errorf("invalid cluster bind address: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("invalid cluster bind port: %w", err)
+	}
+	c.load = load
+	mlConfig := memberlist.DefaultLANConfig()
+	mlConfig.Name = c.self.Name
+	mlConfig.BindAddr = host
+	mlConfig.BindPort = port
+	mlConfig.AdvertisePort = port
+	mlConfig.SecretKey = c.config.SecretKey
+	mlConfig.LogOutput = clusterLogWriter{}
+	mlConfig.Delegate = clusterDelegate{c}
+	mlConfig.Events = clusterEvents{c}
+	mlConfig.Merge = clusterEvents{c}
+	mlConfig.Alive = clusterEvents{c}
+	if len(mlConfig.SecretKey) == 0 {
+		logger.LogWarnC(clusterLogContext, "cluster: gossip is not encrypted, set a secret key in production")
+	}
+	if c.members, err = memberlist.Create(mlConfig); err != nil {
+		return err
+	}
+	if len(c.config.Join) > 0 {
+		if _, err = c.members.Join(c.config.Join); err != nil {
+			c.members.Shutdown()
+			return fmt.Errorf("failed to join cluster: %w", err)
+		}
+	}
+	go c.gossipLoad()
+	return nil
+}
+
+// SessionIdPrefix returns the prefix of the ids of sessions hosted by
+// this node.
+func (c *Cluster) SessionIdPrefix() string {
+	return c.self.Tag
+}
+
+// AdoptSessions makes the node route the sessions with the given ids to
+// itself, when their tag is not the one of the node, e.g. sessions it
+// restored after it was renamed. It must be called before Start.
+func (c *Cluster) AdoptSessions(sessionIds []string) {
+	for _, id := range sessionIds {
+		if len(id) < clusterTagLen {
+			continue
+		}
+		if tag := id[:clusterTagLen]; !c.self.hasTag(tag) {
+			logger.LogInfoC(clusterLogContext, "cluster: adopting sessions with tag %s", tag)
+			c.self.FormerTags = append(c.self.FormerTags, tag)
+		}
+	}
+}
+
+// Leave gracefully leaves the cluster and stops gossiping. It has no
+// effect if the node was not started.
+func (c *Cluster) Leave(timeout time.Duration) error {
+	if c.members == nil {
+		return nil
+	}
+	close(c.done)
+	if err := c.members.Leave(timeout); err != nil {
+		return err
+	}
+	return c.members.Shutdown()
+}
+
+// Nodes returns all live nodes of the cluster, sorted by name. It only
+// returns this node if the node was not started.
+func (c *Cluster) Nodes() []ClusterNode {
+	if c.members == nil {
+		return []ClusterNode{c.localNode()}
+	}
+	var nodes []ClusterNode
+	for _, m := range c.members.Members() {
+		if m.Name == c.self.Name {
+			nodes = append(nodes, c.localNode())
+			continue
+		}
+		meta := clusterMeta{}
+		if err := json.Unmarshal(m.Meta, &meta); err != nil {
+			logger.LogWarnC(clusterLogContext, "cluster: invalid metadata from node %s: %s", m.Name, err)
+			continue
+		}
+		meta.Self = false
+		nodes = append(nodes, meta.ClusterNode)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
+
+// localNode returns this node with its current load, which is zero if the
+// node was not started.
+func (c *Cluster) localNode() ClusterNode {
+	n := c.self
+	if c.load != nil {
+		n.Sessions, n.Participants = c.load()
+	}
+	return n
+}
+
+// owner returns the node hosting session sessionId, or nil if no live
+// node has its tag.
+func (c *Cluster) owner(sessionId string) *ClusterNode {
+	if len(sessionId) < clusterTagLen {
+		return nil
+	}
+	tag := sessionId[:clusterTagLen]
+	for _, n := range c.Nodes() {
+		if n.hasTag(tag) {
+			return &n
+		}
+	}
+	return nil
+}
+
+// checkTags returns an error if node n hosts sessions with a tag of this
+// node, so requests for them could not be routed.
+func (c *Cluster) checkTags(n *memberlist.Node) error {
+	if n.Name == c.self.Name {
+		return nil
+	}
+	other := ClusterNode{Tag: clusterTag(n.Name)}
+	if len(n.Meta) > 0 {
+		if err := json.Unmarshal(n.Meta, &other); err != nil {
+			return fmt.Errorf("invalid metadata from node %s: %w", n.Name, err)
+		}
+	}
+	for _, tag := range other.tags() {
+		if c.self.hasTag(tag) {
+			return fmt.Errorf("node %s has the session tag %s of node %s, rename one of them", n.Name, tag, c.self.Name)
+		}
+	}
+	return nil
+}
+
+// placement returns the node where a new session should be created: the
+// one hosting the fewest participants, then the fewest sessions.
+func (c *Cluster) placement() ClusterNode {
+	nodes := c.Nodes()
+	best := c.localNode()
+	for _, n := range nodes {
+		if n.Participants < best.Participants ||
+			(n.Participants == best.Participants && n.Sessions < best.Sessions) {
+			best = n
+		}
+	}
+	return best
+}
+
+// keyPlacement returns the node where a session requested with
+// idempotency key should be created. The node is chosen by rendezvous
+// hashing of the key and the node names, so every retry of the request
+// reaches the node which recorded the key, as long as the membership does
+// not change, whatever the load of the nodes.
+func (c *Cluster) keyPlacement(key string) ClusterNode {
+	best := c.localNode()
+	bestScore := placementScore(key, best.Name)
+	for _, n := range c.Nodes() {
+		if score := placementScore(key, n.Name); score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+	return best
+}
+
+// placementScore returns the score of node name for idempotency key.
+func placementScore(key string, name string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key+"\x00"+name)))
+}
+
+// gossipLoad periodically updates this node's metadata with its load.
+func (c *Cluster) gossipLoad() {
+	ticker := time.NewTicker(clusterLoadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.members.UpdateNode(clusterLoadInterval); err != nil {
+				logger.LogDebugC(clusterLogContext, "cluster: failed to gossip load: %s", err)
+			}
+		}
+	}
+}
+
+// forwardingKeyOf returns the forwarding key of live node name, or nil if
+// there is no such node.
+func (c *Cluster) forwardingKeyOf(name string) []byte {
+	if name == c.self.Name {
+		return c.forwardingKey
+	}
+	if c.members == nil {
+		return nil
+	}
+	for _, m := range c.members.Members() {
+		if m.Name != name {
+			continue
+		}
+		meta := clusterMeta{}
+		if err := json.Unmarshal(m.Meta, &meta); err != nil {
+			return nil
+		}
+		return meta.ForwardingKey
+	}
+	return nil
+}
+
+// forwardedHeader returns the value of the forwarded header of request r,
+// forwarded by this node at time now.
+func (c *Cluster) forwardedHeader(r *http.Request, now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	return c.self.Name + ";" + timestamp + ";" + forwardingSignature(c.forwardingKey, c.self.Name, timestamp, r)
+}
+
+// forwardedBy returns the name of the node which forwarded request r, or
+// false if r was not forwarded, or its forwarded header is not signed
+// with the key of the node it names or is older than
+// clusterForwardedMaxAge.
+func (c *Cluster) forwardedBy(r *http.Request, now time.Time) (string, bool) {
+	parts := strings.Split(r.Header.Get(clusterForwardedHeader), ";")
+	if len(parts) < 3 {
+		return "", false
+	}
+	name := strings.Join(parts[:len(parts)-2], ";")
+	timestamp, signature := parts[len(parts)-2], parts[len(parts)-1]
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", false
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > clusterForwardedMaxAge || age < -clusterForwardedMaxAge {
+		return "", false
+	}
+	key := c.forwardingKeyOf(name)
+	if key == nil {
+		return "", false
+	}
+	expected := forwardingSignature(key, name, timestamp, r)
+	return name, hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// forwardingSignature returns the signature with key of request r,
+// forwarded by node name at timestamp.
+func forwardingSignature(key []byte, name string, timestamp string, r *http.Request) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "\n" + timestamp + "\n" + r.Method + "\n" + r.URL.RequestURI()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// middleware forwards requests for sessions hosted by other nodes, and
+// session creations placed on other nodes, to those nodes. Creations with
+// an idempotency key are placed by key rather than by load, as keys are
+// only recorded by the node which created the session.
+func (c *Cluster) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(clusterForwardedHeader) != "" {
+			if _, ok := c.forwardedBy(r, time.Now()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// forged, or replayed, so the request is routed like any other
+			logger.LogWarnC(r.Context(), "ignoring unauthenticated forwarded header from %s", r.RemoteAddr)
+			r.Header.Del(clusterForwardedHeader)
+		}
+		// only nodes create relays
+		r.Header.Del(clusterRelayHeader)
+		if c.config.Relay && isNegotiateRoute(r) {
+			// the media of the participant is relayed to the hosting node
+			next.ServeHTTP(w, r)
+			return
+		}
+		var target *ClusterNode
+		if sessionId, ok := mux.Vars(r)["sessionId"]; ok {
+			target = c.owner(sessionId)
+			if target == nil {
+				logger.LogDebugC(r.Context(), "no node hosts session %s", sessionId)
+				writeError(w, r, http.StatusNotFound, nil, []FieldError{noSuchSession(sessionId)}, "")
+				return
+			}
+		} else if isSessionsRoute(r) && isPutOrPost(r) {
+			n := c.placement()
+			if key := r.Header.Get(idempotencyKeyHeader); key != "" {
+				// retries must reach the node holding the key
+				n = c.keyPlacement(key)
+			}
+			target = &n
+		}
+		if target == nil || target.Self {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.forward(w, r, *target)
+	})
+}
+
+// isSessionsRoute returns whether a request was routed to /{version}/sessions.
+func isSessionsRoute(r *http.Request) bool {
+	return isRoute(r, "/{version}/sessions")
+}
+
+// isNegotiateRoute returns whether a request was routed to
+// /{version}/sessions/{sessionId}/participants/{participantId}/negotiate.
+func isNegotiateRoute(r *http.Request) bool {
+	return isRoute(r, "/{version}/sessions/{sessionId}/participants/{participantId}/negotiate")
+}
+
+// isRoute returns whether a request was routed to the route with template.
+func isRoute(r *http.Request, template string) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	t, err := route.GetPathTemplate()
+	return err == nil && t == template
+}
+
+// isRelayRequest returns whether request r was sent by the relay of
+// another node.
+func (c *Cluster) isRelayRequest(r *http.Request) bool {
+	if r.Header.Get(clusterRelayHeader) == "" {
+		return false
+	}
+	_, ok := c.forwardedBy(r, time.Now())
+	return ok
+}
+
+// forward redirects or proxies a request to node n.
+func (c *Cluster) forward(w http.ResponseWriter, r *http.Request, n ClusterNode) {
+	target, err := url.Parse(n.URL)
+	if err != nil {
+		logger.LogErrorC(r.Context(), "invalid url of node %s: %s", n.Name, err)
+		writeError(w, r, http.StatusBadGateway, nil, nil, "")
+		return
+	}
+	if c.config.Redirect {
+		location := *r.URL
+		location.Scheme = target.Scheme
+		location.Host = target.Host
+		logger.LogDebugC(r.Context(), "redirecting to node %s", n.Name)
+		http.Redirect(w, r, location.String(), http.StatusTemporaryRedirect)
+		return
+	}
+	logger.LogDebugC(r.Context(), "proxying to node %s", n.Name)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		logger.LogWarnC(r.Context(), "failed to proxy to node %s: %s", n.Name, err)
+		writeError(w, r, http.StatusBadGateway, nil, nil, "")
+	}
+	r.Header.Set(clusterForwardedHeader, c.forwardedHeader(r, time.Now()))
+	// the span of the request, if traced, is the parent of the other node's
+	propagation.TraceContext{}.Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+	proxy.ServeHTTP(w, r)
+}
+
+// onClusterNodesRequest is called for every request to /cluster/nodes
+func (s *Server) onClusterNodesRequest(w http.ResponseWriter, r *http.Request) {
+	if isGet(r) == false {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, s.Cluster.Nodes())
+}
+
+// clusterTag returns the tag of node name.
+func clusterTag(name string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(name)))[:clusterTagLen]
+}
+
+// clusterDelegate gossips the metadata of the local node.
+type clusterDelegate struct {
+	c *Cluster
+}
+
+func (d clusterDelegate) NodeMeta(limit int) []byte {
+	meta, err := json.Marshal(clusterMeta{ClusterNode: d.c.localNode(), ForwardingKey: d.c.forwardingKey})
+	if err != nil || len(meta) > limit {
+		logger.LogErrorC(clusterLogContext, "cluster: node metadata does not fit %d bytes", limit)
+		return nil
+	}
+	return meta
+}
+
+func (d clusterDelegate) NotifyMsg([]byte) {}
+
+func (d clusterDelegate) GetBroadcasts(overhead, limit int) [][]byte {
+	return nil
+}
+
+func (d clusterDelegate) LocalState(join bool) []byte {
+	return nil
+}
+
+func (d clusterDelegate) MergeRemoteState(buf []byte, join bool) {}
+
+// clusterEvents logs membership changes, and refuses nodes whose session
+// tags collide with the ones of this node.
+type clusterEvents struct {
+	c *Cluster
+}
+
+func (e clusterEvents) NotifyJoin(n *memberlist.Node) {
+	logger.LogInfoC(clusterLogContext, "cluster: node %s joined from %s", n.Name, n.Address())
+}
+
+func (e clusterEvents) NotifyMerge(peers []*memberlist.Node) error {
+	for _, n := range peers {
+		if err := e.c.checkTags(n); err != nil {
+			logger.LogErrorC(clusterLogContext, "cluster: refusing to join: %s", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (e clusterEvents) NotifyAlive(n *memberlist.Node) error {
+	if err := e.c.checkTags(n); err != nil {
+		logger.LogErrorC(clusterLogContext, "cluster: ignoring node: %s", err)
+		return err
+	}
+	return nil
+}
+
+func (e clusterEvents) NotifyLeave(n *memberlist.Node) {
+	logger.LogInfoC(clusterLogContext, "cluster: node %s left", n.Name)
+}
+
+func (e clusterEvents) NotifyUpdate(n *memberlist.Node) {
+	logger.LogDebugC(clusterLogContext, "cluster: node %s updated", n.Name)
+}
+
+// clusterLogWriter writes memberlist's log output as debug messages.
+type clusterLogWriter struct{}
+
+func (clusterLogWriter) Write(p []byte) (int, error) {
+	logger.LogDebugC(clusterLogContext, "cluster: %s", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
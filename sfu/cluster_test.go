@@ -0,0 +1,306 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clusterNode is a node of an in-process cluster, serving the REST API.
+type clusterNode struct {
+	cluster *Cluster
+	handler *WebRtcSessionHandler
+	api     *httptest.Server
+}
+
+// newClusterNode creates a node named name, with sessions tagged with
+// the tag of node sessionsOf, and starts it, joining the nodes of join.
+func newClusterNode(t *testing.T, name string, sessionsOf string, join ...*clusterNode) (*clusterNode, error) {
+	t.Helper()
+	api := httptest.NewUnstartedServer(nil)
+	config := ClusterConfig{NodeName: name, BindAddr: freeAddress(t), AdvertiseURL: "http://" + api.Listener.Addr().String()}
+	for _, n := range join {
+		config.Join = append(config.Join, n.cluster.config.BindAddr)
+	}
+	c, err := NewCluster(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewWebRtcSessionHandler(WithSessionIdPrefix(clusterTag(sessionsOf)))
+	if _, err = h.CreateSession(CreateSessionParams{Name: name}); err != nil {
+		t.Fatal(err)
+	}
+	c.AdoptSessions(h.SessionIds())
+	if err = c.Start(h.Stats); err != nil {
+		api.Close()
+		return nil, err
+	}
+	api.Config.Handler = (&Server{Cluster: c}).newRouter(h)
+	api.Start()
+	return &clusterNode{cluster: c, handler: h, api: api}, nil
+}
+
+func (n *clusterNode) close() {
+	n.api.Close()
+	n.cluster.Leave(time.Second)
+}
+
+// status returns the status of a request sent to node n.
+func (n *clusterNode) status(t *testing.T, method string, path string) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, n.api.URL+path, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// collidingName returns a node name with the session tag of node name.
+func collidingName(name string) string {
+	for i := 0; ; i++ {
+		if other := name + "-" + strconv.Itoa(i); clusterTag(other) == clusterTag(name) {
+			return other
+		}
+	}
+}
+
+func TestClusterRouting(t *testing.T) {
+	a, err := newClusterNode(t, "node-a", "node-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+	// node-b was renamed, its sessions still have the tag of its former name
+	b, err := newClusterNode(t, "node-b", "node-b-former", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+	for _, n := range []*clusterNode{a, b} {
+		if nodes := n.cluster.Nodes(); len(nodes) != 2 {
+			t.Fatalf("node %s sees %d nodes, want 2", n.cluster.self.Name, len(nodes))
+		}
+	}
+
+	sessionOfB := "/v1/sessions/" + b.handler.SessionIds()[0]
+	if status := a.status(t, http.MethodGet, sessionOfB); status != http.StatusOK {
+		t.Errorf("GET %s through node-a = %d, want %d", sessionOfB, status, http.StatusOK)
+	}
+	if status := a.status(t, http.MethodDelete, sessionOfB); status != http.StatusOK {
+		t.Errorf("DELETE %s through node-a = %d, want %d", sessionOfB, status, http.StatusOK)
+	}
+	if sessions, _ := b.handler.Stats(); sessions != 0 {
+		t.Errorf("node-b has %d sessions, want 0", sessions)
+	}
+	sessionOfA := "/v1/sessions/" + a.handler.SessionIds()[0]
+	if status := b.status(t, http.MethodGet, sessionOfA); status != http.StatusOK {
+		t.Errorf("GET %s through node-b = %d, want %d", sessionOfA, status, http.StatusOK)
+	}
+	if status := b.status(t, http.MethodGet, "/v1/sessions/fffffffff0"); status != http.StatusNotFound {
+		t.Errorf("GET an unknown session through node-b = %d, want %d", status, http.StatusNotFound)
+	}
+
+	if c, err := newClusterNode(t, collidingName("node-a"), "node-c", a); err == nil {
+		c.close()
+		t.Error("node with the tag of node-a joined the cluster")
+	}
+	if c, err := newClusterNode(t, "node-c", "node-b-former", a); err == nil {
+		c.close()
+		t.Error("node with a former tag of node-b joined the cluster")
+	}
+}
+
+func TestClusterIgnoresSpoofedForwardedHeader(t *testing.T) {
+	a, err := newClusterNode(t, "node-a", "node-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+	b, err := newClusterNode(t, "node-b", "node-b", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+
+	sessionOfB := "/v1/sessions/" + b.handler.SessionIds()[0]
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for _, spoofed := range []string{"node-b", "node-b;" + now + ";00", "node-c;" + now + ";00"} {
+		req, _ := http.NewRequest(http.MethodGet, a.api.URL+sessionOfB, nil)
+		req.Header.Set(clusterForwardedHeader, spoofed)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		// served by node-a itself, the session would not be found
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s through node-a forwarded by %q = %d, want %d", sessionOfB, spoofed, resp.StatusCode, http.StatusOK)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, sessionOfB, nil)
+	sent := time.Now()
+	r.Header.Set(clusterForwardedHeader, b.cluster.forwardedHeader(r, sent))
+	if name, ok := a.cluster.forwardedBy(r, sent); !ok || name != "node-b" {
+		t.Errorf("forwardedBy() of a request forwarded by node-b = %q, %v, want node-b", name, ok)
+	}
+	if _, ok := a.cluster.forwardedBy(r, sent.Add(2*clusterForwardedMaxAge)); ok {
+		t.Error("forwardedBy() of a replayed request = true, want false")
+	}
+	other := httptest.NewRequest(http.MethodDelete, sessionOfB, nil)
+	other.Header = r.Header
+	if _, ok := a.cluster.forwardedBy(other, sent); ok {
+		t.Error("forwardedBy() of another request with the same header = true, want false")
+	}
+}
+
+func TestClusterIdempotencyKeys(t *testing.T) {
+	a, err := newClusterNode(t, "node-a", "node-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.close()
+	b, err := newClusterNode(t, "node-b", "node-b", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.close()
+
+	// retries reach either node, and the load changes after the first one
+	var ids []string
+	for _, n := range []*clusterNode{a, b, a, b} {
+		req, _ := http.NewRequest(http.MethodPost, n.api.URL+"/v1/sessions", strings.NewReader(`{"name":"retried"}`))
+		req.Header.Set(idempotencyKeyHeader, "retried-create")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result CreateSessionResult
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusCreated || result.Session == nil {
+			t.Fatalf("POST /v1/sessions through %s: status %d, %+v, %v", n.cluster.self.Name, resp.StatusCode, result, err)
+		}
+		ids = append(ids, result.Session.Id)
+	}
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Errorf("retries created sessions %v, want a single one", ids)
+			break
+		}
+	}
+	sessionsOfA, _ := a.handler.Stats()
+	sessionsOfB, _ := b.handler.Stats()
+	if total := sessionsOfA + sessionsOfB; total != 3 {
+		t.Errorf("cluster has %d sessions, want 3", total)
+	}
+}
+
+func TestClusterLeaveBeforeStart(t *testing.T) {
+	c, err := NewCluster(ClusterConfig{NodeName: "idle", AdvertiseURL: "http://localhost:8000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Leave(time.Second); err != nil {
+		t.Errorf("Leave() error = %v", err)
+	}
+}
+
+func TestCreateSessionAvoidsIdCollisions(t *testing.T) {
+	// a single random hex digit is left to session ids
+	h := NewWebRtcSessionHandler(WithSessionIdPrefix(strings.Repeat("a", IdLen-1)))
+	for i := 0; i < 16; i++ {
+		if result, err := h.CreateSession(CreateSessionParams{Name: "colliding"}); err != nil || result.Session == nil {
+			t.Fatalf("CreateSession() = %+v, %v", result, err)
+		}
+	}
+	if sessions, _ := h.Stats(); sessions != 16 {
+		t.Errorf("handler has %d sessions, want 16", sessions)
+	}
+}
+
+func TestClusterBeforeStart(t *testing.T) {
+	c, err := NewCluster(ClusterConfig{NodeName: "idle", AdvertiseURL: "http://localhost:8000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes := c.Nodes(); len(nodes) != 1 || !nodes[0].Self || nodes[0].Sessions != 0 {
+		t.Errorf("Nodes() = %+v, want only this node", nodes)
+	}
+	if n := c.owner(c.SessionIdPrefix() + "00000"); n == nil || !n.Self {
+		t.Errorf("owner() of a session of this node = %+v, want this node", n)
+	}
+	if n := c.owner(clusterTag("other") + "00000"); n != nil {
+		t.Errorf("owner() of a session of another node = %+v, want nil", n)
+	}
+}
+
+func TestClusterSecretKey(t *testing.T) {
+	config := ClusterConfig{NodeName: "node-a", AdvertiseURL: "http://localhost:8000", SecretKey: []byte("too short")}
+	if _, err := NewCluster(config); err == nil {
+		t.Errorf("NewCluster() with a %d bytes key error = nil, want an error", len(config.SecretKey))
+	}
+
+	start := func(name string, key string, join ...*Cluster) (*Cluster, error) {
+		config := ClusterConfig{NodeName: name, BindAddr: freeAddress(t), AdvertiseURL: "http://localhost:8000", SecretKey: []byte(key)}
+		for _, c := range join {
+			config.Join = append(config.Join, c.config.BindAddr)
+		}
+		c, err := NewCluster(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, c.Start(func() (int, int) { return 0, 0 })
+	}
+	a, err := start("node-a", "0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Leave(time.Second)
+	b, err := start("node-b", "0123456789abcdef", a)
+	if err != nil {
+		t.Fatalf("Start() with the key of the cluster error = %v", err)
+	}
+	defer b.Leave(time.Second)
+	if c, err := start("node-c", "fedcba9876543210", a); err == nil {
+		c.Leave(time.Second)
+		t.Error("node with another key joined the cluster")
+	}
+	if c, err := start("node-d", "", a); err == nil {
+		c.Leave(time.Second)
+		t.Error("node without a key joined the cluster")
+	}
+}
+
+func TestClusterForwardsTraceContext(t *testing.T) {
+	traceparents := make(chan string, 1)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents <- r.Header.Get("traceparent")
+	}))
+	defer other.Close()
+	c, err := NewCluster(ClusterConfig{NodeName: "node-a", AdvertiseURL: "http://localhost:8000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceId,
+		SpanID:     spanId,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/v1/sessions/0000000000", nil).WithContext(ctx)
+	c.forward(httptest.NewRecorder(), r, ClusterNode{Name: "node-b", URL: other.URL})
+	if got, want := <-traceparents, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; got != want {
+		t.Errorf("forwarded traceparent = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,133 @@
+package sfu
+
+import "sync"
+
+// EventType identifies the kind of activity an Event reports.
+type EventType string
+
+const (
+	// SessionCreated is emitted when a live view session is created.
+	SessionCreated EventType = "session.created"
+	// SessionUpdated is emitted when the name, limits or metadata of a
+	// live view session are changed.
+	SessionUpdated EventType = "session.updated"
+	// SessionDeleted is emitted when a live view session is removed.
+	SessionDeleted EventType = "session.deleted"
+	// ParticipantJoined is emitted when a participant is added to a
+	// live view session.
+	ParticipantJoined EventType = "participant.joined"
+	// ParticipantUpdated is emitted when a participant of a live view
+	// session is updated.
+	ParticipantUpdated EventType = "participant.updated"
+	// ParticipantLeft is emitted when a participant is removed from a
+	// live view session.
+	ParticipantLeft EventType = "participant.left"
+	// TrackPublished is emitted when a participant of a live view session
+	// starts publishing a track.
+	TrackPublished EventType = "track.published"
+	// TrackUnpublished is emitted when a track published by a participant
+	// of a live view session ends, unless the participant left the
+	// session.
+	TrackUnpublished EventType = "track.unpublished"
+	// ActiveSpeakerChanged is emitted when another participant of a live
+	// view session becomes its loudest speaker, which is the participant
+	// of the event.
+	ActiveSpeakerChanged EventType = "session.activeSpeakerChanged"
+	// RecordingStarted is emitted when the recording of the tracks
+	// published to a live view session starts.
+	RecordingStarted EventType = "recording.started"
+	// RecordingStopped is emitted when the recording of the tracks
+	// published to a live view session stops.
+	RecordingStopped EventType = "recording.stopped"
+	// SessionInterrupted is emitted for every live view session when the
+	// server shuts down. Participants should reconnect once it is back.
+	SessionInterrupted EventType = "session.interrupted"
+	// StreamReset is sent by an event stream resumed after events its
+	// client did not receive are no longer available, before the events
+	// still buffered. Clients should reload the sessions they follow.
+	StreamReset EventType = "stream.reset"
+)
+
+// Reasons reported by events emitted for objects removed by the reaper,
+// or interrupted by a server shutdown.
+const (
+	ReasonIdle             = "idle"
+	ReasonLifetimeExceeded = "lifetimeExceeded"
+	ReasonHeartbeatTimeout = "heartbeatTimeout"
+	ReasonConnectionFailed = "connectionFailed"
+	ReasonServerShutdown   = "serverShutdown"
+)
+
+// Reasons reported by StreamReset events.
+const (
+	// The Last-Event-ID of the client was not issued since the server
+	// started.
+	ReasonUnknownEventId = "unknownEventId"
+	// Events following the Last-Event-ID of the client were evicted from
+	// the buffer of recent events.
+	ReasonEventsEvicted = "eventsEvicted"
+)
+
+// Event holds information about activity on a live view session.
+type Event struct {
+	Type          EventType    `json:"type"`
+	DateTime      string       `json:"dateTime"`
+	SessionId     string       `json:"sessionId"`
+	ParticipantId string       `json:"participantId,omitempty"`
+	Reason        string       `json:"reason,omitempty"`
+	Session       *Session     `json:"session,omitempty"`
+	Participant   *Participant `json:"participant,omitempty"`
+	// Track published or unpublished, for track events.
+	Track *Track `json:"track,omitempty"`
+}
+
+// EventListener is called for every event emitted by a session handler.
+// Listeners are called synchronously, in the order of the changes events
+// report, while the session handler holds its lock: they must not block,
+// nor call the session handler.
+type EventListener func(e Event)
+
+// EventSource is implemented by session handlers that emit events.
+type EventSource interface {
+	// Subscribe registers a listener for all future events. The returned
+	// function removes the listener.
+	Subscribe(l EventListener) (unsubscribe func())
+}
+
+// eventBus dispatches events to a dynamic set of listeners.
+type eventBus struct {
+	listeners map[int]EventListener
+	nextId    int
+	locker    sync.Mutex
+}
+
+func (b *eventBus) Subscribe(l EventListener) func() {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if b.listeners == nil {
+		b.listeners = make(map[int]EventListener)
+	}
+	id := b.nextId
+	b.nextId++
+	b.listeners[id] = l
+	return func() {
+		b.locker.Lock()
+		delete(b.listeners, id)
+		b.locker.Unlock()
+	}
+}
+
+// emit dispatches all given events, in order, to every listener.
+func (b *eventBus) emit(events ...Event) {
+	b.locker.Lock()
+	listeners := make([]EventListener, 0, len(b.listeners))
+	for _, l := range b.listeners {
+		listeners = append(listeners, l)
+	}
+	b.locker.Unlock()
+	for _, e := range events {
+		for _, l := range listeners {
+			l(e)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package sfu
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestEventOrder checks events are emitted in the order of the changes
+// they report while a session and its participant are changed
+// concurrently.
+func TestEventOrder(t *testing.T) {
+	h := NewWebRtcSessionHandler()
+	created, err := h.CreateSession(CreateSessionParams{Name: "ordered"})
+	if err != nil || created.Session == nil {
+		t.Fatalf("CreateSession() = %+v, %v", created, err)
+	}
+	added, err := h.AddParticipant(AddParticipantParams{SessionId: created.Session.Id, Name: "participant"})
+	if err != nil || added.Participant == nil {
+		t.Fatalf("AddParticipant() = %+v, %v", added, err)
+	}
+	var locker sync.Mutex
+	revisions := make(map[EventType][]int64)
+	unsubscribe := h.Subscribe(func(e Event) {
+		locker.Lock()
+		defer locker.Unlock()
+		switch e.Type {
+		case SessionUpdated:
+			revisions[e.Type] = append(revisions[e.Type], e.Session.Revision)
+		case ParticipantUpdated:
+			revisions[e.Type] = append(revisions[e.Type], e.Participant.Revision)
+		}
+	})
+	defer unsubscribe()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				patch := json.RawMessage(fmt.Sprintf(`{"metadata":{"writer":"%d-%d"}}`, i, j))
+				if _, err := h.UpdateSession(UpdateSessionParams{Id: created.Session.Id, Patch: patch}); err != nil {
+					t.Errorf("UpdateSession() error = %v", err)
+				}
+				if _, err := h.UpdateParticipant(UpdateParticipantParams{SessionId: created.Session.Id,
+					ParticipantId: added.Participant.Id, Patch: patch}); err != nil {
+					t.Errorf("UpdateParticipant() error = %v", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, eventType := range []EventType{SessionUpdated, ParticipantUpdated} {
+		got := revisions[eventType]
+		if len(got) != 200 {
+			t.Errorf("%d %s events, want 200", len(got), eventType)
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i] != got[i-1]+1 {
+				t.Errorf("%s events out of order: revision %d after %d", eventType, got[i], got[i-1])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEventBufferSize is the number of events kept in memory to
+	// resume event streams when no other size is configured.
+	DefaultEventBufferSize = 1024
+	// eventStreamKeepAlive is the interval between keep alive comments
+	// sent to idle event stream clients.
+	eventStreamKeepAlive = 15 * time.Second
+	// eventSubscriberQueueSize is the number of events queued for a single
+	// client before it is considered too slow and disconnected.
+	eventSubscriberQueueSize = 64
+)
+
+// streamedEvent is an event with the id it was given by an event stream.
+type streamedEvent struct {
+	id    uint64
+	event Event
+}
+
+// eventStream keeps a bounded buffer of recent events and fans out new
+// events to its subscribers.
+type eventStream struct {
+	// Prefix of the ids of the events of the stream, telling them apart
+	// from the ids given before the server restarted.
+	epoch  string
+	buffer []streamedEvent
+	size   int
+	lastId uint64
+	// Subscribers, with the id of the session whose events they receive,
+	// blank for all sessions.
+	subscribers map[chan streamedEvent]string
+	closed      bool
+	locker      sync.Mutex
+}
+
+func newEventStream(size int) *eventStream {
+	return &eventStream{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		size:        size,
+		subscribers: make(map[chan streamedEvent]string),
+	}
+}
+
+// formatId returns the id of the event with sequence number id, as sent
+// to clients.
+func (s *eventStream) formatId(id uint64) string {
+	return s.epoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// parseId returns the sequence number of an event id sent to clients, and
+// whether it was given by this stream, rather than before a restart.
+func (s *eventStream) parseId(v string) (id uint64, known bool, err error) {
+	epoch, seq, found := strings.Cut(v, "-")
+	if !found {
+		// ids had no epoch before it was introduced
+		epoch, seq = "", v
+	}
+	if id, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return 0, false, err
+	}
+	return id, epoch == s.epoch, nil
+}
+
+// publish assigns an id to e, buffers it and sends it to every subscriber
+// of its session. Subscribers that can't keep up are disconnected.
+func (s *eventStream) publish(e Event) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.lastId++
+	se := streamedEvent{id: s.lastId, event: e}
+	s.buffer = append(s.buffer, se)
+	if len(s.buffer) > s.size {
+		s.buffer = s.buffer[len(s.buffer)-s.size:]
+	}
+	for ch, sessionId := range s.subscribers {
+		if !se.matches(sessionId) {
+			continue
+		}
+		select {
+		case ch <- se:
+		default:
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe returns all buffered events with an id greater than lastId and
+// a channel receiving all future events of session sessionId, or of all
+// sessions if it is blank. The channel is closed when the
+// subscriber is disconnected or cancel is called. When resuming, lastId
+// is checked against the buffer: if events after it are no longer
+// available, or it is unknown, backlog starts with a StreamReset event
+// followed by all buffered events.
+func (s *eventStream) subscribe(lastId uint64, resume bool, sessionId string) (backlog []streamedEvent, ch chan streamedEvent, cancel func()) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	reason := ""
+	if resume && lastId > s.lastId {
+		reason = ReasonUnknownEventId
+	} else if resume && len(s.buffer) > 0 && lastId+1 < s.buffer[0].id {
+		reason = ReasonEventsEvicted
+	}
+	if reason != "" {
+		// the id of the reset resumes the stream before the buffered events
+		lastId = s.lastId - uint64(len(s.buffer))
+		backlog = append(backlog, streamedEvent{id: lastId, event: Event{
+			Type:     StreamReset,
+			DateTime: formatDateTime(time.Now()),
+			Reason:   reason,
+		}})
+	}
+	backlog = append(backlog, s.after(lastId)...)
+	ch = make(chan streamedEvent, eventSubscriberQueueSize)
+	if s.closed {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	s.subscribers[ch] = sessionId
+	cancel = func() {
+		s.locker.Lock()
+		defer s.locker.Unlock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel
+}
+
+// after returns all buffered events with an id greater than lastId. The
+// stream must be locked.
+func (s *eventStream) after(lastId uint64) []streamedEvent {
+	var events []streamedEvent
+	for _, se := range s.buffer {
+		if se.id > lastId {
+			events = append(events, se)
+		}
+	}
+	return events
+}
+
+// remaining returns the buffered events with an id greater than lastId
+// once the stream is closed, so subscribers disconnected while it was
+// closing can still send them. It returns nil while the stream is open.
+func (s *eventStream) remaining(lastId uint64) []streamedEvent {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if !s.closed {
+		return nil
+	}
+	return s.after(lastId)
+}
+
+// close disconnects all subscribers, once they received the events queued
+// for them, and any future one.
+func (s *eventStream) close() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.closed = true
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// onEventsRequest is called for every request to /{version}/events
+func (s *Server) onEventsRequest(w http.ResponseWriter, r *http.Request) {
+	s.streamEvents(w, r, "")
+}
+
+// onSessionEventsRequest is called for every request to /{version}/sessions/{sessionId}/events
+func (s *Server) onSessionEventsRequest(w http.ResponseWriter, r *http.Request) {
+	sessionId := mux.Vars(r)["sessionId"]
+	if isGet(r) {
+		result, err := s.sessionHandler(r).GetSession(GetSessionParams{Id: sessionId})
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		if result.Errors != nil || result.Session == nil {
+			writeOutcome(w, r, outcome{errors: result.FieldErrors, notFound: result.Session == nil})
+			return
+		}
+	}
+	s.streamEvents(w, r, sessionId)
+}
+
+// streamEvents streams events as server-sent events until the client goes
+// away. Only events of session sessionId are streamed, unless it is blank.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, sessionId string) {
+	if isGet(r) == false {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok || s.events == nil {
+		logger.LogWarnC(r.Context(), "event streaming not supported")
+		writeError(w, r, http.StatusNotImplemented, nil, nil, "")
+		return
+	}
+	var lastId uint64
+	v := r.Header.Get("Last-Event-ID")
+	if v != "" {
+		id, known, err := s.events.parseId(v)
+		if err != nil {
+			logger.LogWarnC(r.Context(), "invalid Last-Event-ID: %s", v)
+			writeError(w, r, http.StatusBadRequest, nil, []FieldError{{Field: "Last-Event-ID", Code: ErrorInvalidValue,
+				Detail: "Last-Event-ID must be the id of an event"}}, "")
+			return
+		}
+		if known {
+			lastId = id
+		} else {
+			// given before a restart, beyond any id of this stream
+			lastId = ^uint64(0)
+		}
+	}
+	backlog, ch, cancel := s.events.subscribe(lastId, v != "", sessionId)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	logger.LogDebugC(r.Context(), "streaming events after id %s", v)
+	for _, se := range backlog {
+		if err := s.events.write(w, se, sessionId); err != nil {
+			return
+		}
+		lastId = se.id
+	}
+	flusher.Flush()
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case se, open := <-ch:
+			if !open {
+				// the events of a shutdown can outnumber the queue of
+				// a subscriber, send what it missed before closing
+				for _, se := range s.events.remaining(lastId) {
+					if err := s.events.write(w, se, sessionId); err != nil {
+						return
+					}
+				}
+				flusher.Flush()
+				logger.LogDebugC(r.Context(), "event stream closed or client too slow, disconnecting")
+				return
+			}
+			if err := s.events.write(w, se, sessionId); err != nil {
+				return
+			}
+			lastId = se.id
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// matches returns whether the event belongs to session sessionId. A blank
+// sessionId matches events of all sessions, and events about no session,
+// like StreamReset, match every sessionId.
+func (se streamedEvent) matches(sessionId string) bool {
+	return sessionId == "" || se.event.SessionId == "" || se.event.SessionId == sessionId
+}
+
+// write writes a single server-sent event, unless it does not belong to
+// session sessionId.
+func (s *eventStream) write(w http.ResponseWriter, se streamedEvent, sessionId string) error {
+	if !se.matches(sessionId) {
+		return nil
+	}
+	data, err := json.Marshal(se.event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", s.formatId(se.id), se.event.Type, data)
+	return err
+}
@@ -0,0 +1,138 @@
+package sfu
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sse is a server-sent event read from an event stream.
+type sse struct {
+	id    string
+	event Event
+}
+
+// readEvents reads n events from the event stream of ts, resumed after
+// lastEventId unless it is blank.
+func readEvents(t *testing.T, ts *httptest.Server, lastEventId string, n int) []sse {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/events", nil)
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET events after %q: status %d", lastEventId, resp.StatusCode)
+	}
+	var events []sse
+	scanner := bufio.NewScanner(resp.Body)
+	var current sse
+	for len(events) < n && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			current.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &current.event); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && current.id != "":
+			events = append(events, current)
+			current = sse{}
+		}
+	}
+	if len(events) < n {
+		t.Fatalf("got %d events after %q, want %d: %v", len(events), lastEventId, n, scanner.Err())
+	}
+	return events
+}
+
+func TestEventStreamResume(t *testing.T) {
+	h := NewWebRtcSessionHandler()
+	s := &Server{EventBufferSize: 4}
+	ts := httptest.NewServer(s.newRouter(h))
+	defer ts.Close()
+	create := func(count int) {
+		for i := 0; i < count; i++ {
+			if _, err := h.CreateSession(CreateSessionParams{Name: "resumed"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	id := s.events.formatId
+
+	create(2)
+	events := readEvents(t, ts, "", 2)
+	if events[0].id != id(1) || events[1].id != id(2) {
+		t.Errorf("event ids = %s, %s, want %s, %s", events[0].id, events[1].id, id(1), id(2))
+	}
+	if events = readEvents(t, ts, id(1), 1); events[0].id != id(2) || events[0].event.Type != SessionCreated {
+		t.Errorf("resumed after %s with %+v", id(1), events[0])
+	}
+
+	for _, stale := range []string{"0-1", "1", id(3)} {
+		events = readEvents(t, ts, stale, 3)
+		if reset := events[0]; reset.event.Type != StreamReset || reset.event.Reason != ReasonUnknownEventId || reset.id != id(0) {
+			t.Errorf("resumed after %s with %+v, want a %s reset", stale, reset, ReasonUnknownEventId)
+		}
+		if events[1].id != id(1) || events[2].id != id(2) {
+			t.Errorf("resumed after %s with ids %s, %s after the reset", stale, events[1].id, events[2].id)
+		}
+	}
+
+	create(4)
+	events = readEvents(t, ts, id(1), 5)
+	if reset := events[0]; reset.event.Type != StreamReset || reset.event.Reason != ReasonEventsEvicted || reset.id != id(2) {
+		t.Errorf("resumed after evicted %s with %+v, want a %s reset", id(1), reset, ReasonEventsEvicted)
+	}
+	for i, e := range events[1:] {
+		if e.id != id(uint64(i+3)) {
+			t.Errorf("event %d after the reset has id %s, want %s", i, e.id, id(uint64(i+3)))
+		}
+	}
+	if events = readEvents(t, ts, id(2), 1); events[0].id != id(3) || events[0].event.Type != SessionCreated {
+		t.Errorf("resumed after %s with %+v", id(2), events[0])
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/v1/events", nil)
+	req.Header.Set("Last-Event-ID", "not-an-id")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid Last-Event-ID status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+// TestEventStreamSessionQueue checks subscribers of a session only queue
+// the events of their session, so those of other sessions do not
+// disconnect them.
+func TestEventStreamSessionQueue(t *testing.T) {
+	s := newEventStream(DefaultEventBufferSize)
+	_, ch, cancel := s.subscribe(0, false, "followed")
+	defer cancel()
+	for i := 0; i < 2*eventSubscriberQueueSize; i++ {
+		s.publish(Event{Type: SessionUpdated, SessionId: "other"})
+	}
+	s.publish(Event{Type: SessionUpdated, SessionId: "followed"})
+	select {
+	case se, open := <-ch:
+		if !open || se.event.SessionId != "followed" {
+			t.Errorf("subscriber of followed received %+v, open %t", se, open)
+		}
+	default:
+		t.Error("subscriber of followed received no event")
+	}
+	if queued := len(ch); queued != 0 {
+		t.Errorf("subscriber of followed has %d more events queued", queued)
+	}
+}
@@ -0,0 +1,188 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// idempotencyKeyHeader holds the idempotency key of a request creating an
+// object, so the request can safely be retried.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyWindow is the duration during which the results of
+// operations requested with an idempotency key are replayed, when no
+// other window is configured.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// idempotencySweepInterval is the minimum interval between two sweeps of
+// expired idempotency keys when recording new ones.
+const idempotencySweepInterval = time.Minute
+
+// MaxIdempotencyKeyLen is the maximum length, in bytes, of idempotency
+// keys.
+const MaxIdempotencyKeyLen = 255
+
+// WithIdempotencyWindow sets the duration during which the results of
+// operations requested with an idempotency key are replayed. Zero disables
+// idempotency keys. DefaultIdempotencyWindow is used by default.
+func WithIdempotencyWindow(window time.Duration) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.idempotencyWindow = window
+	}
+}
+
+// isIdempotencyKey verifies whether v, if not blank, is a valid
+// idempotency key.
+func isIdempotencyKey(n string, v string) *FieldError {
+	if len(v) > MaxIdempotencyKeyLen {
+		return &FieldError{Field: n, Code: ErrorTooLarge,
+			Detail: fmt.Sprintf("%s must not be longer than %d bytes", n, MaxIdempotencyKeyLen)}
+	}
+	return nil
+}
+
+// idempotentCall is a call of an operation with an idempotency key.
+type idempotentCall struct {
+	key       string
+	operation string
+	// Hash of the parameters of the call.
+	fingerprint string
+}
+
+// idempotentResult is the recorded result of an idempotent call.
+type idempotentResult struct {
+	IdempotencyRecord
+	created time.Time
+}
+
+// newIdempotentCall returns the call of operation with params and key, or
+// nil if key is blank or idempotency keys are disabled.
+func (h *WebRtcSessionHandler) newIdempotentCall(key string, operation string, params any) (*idempotentCall, error) {
+	if len(key) == 0 || h.idempotencyWindow <= 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	return &idempotentCall{key: key, operation: operation, fingerprint: hex.EncodeToString(hash[:])}, nil
+}
+
+// replay returns the result recorded for the key of call, if any and not
+// expired, or the error reported when the key was used for another call.
+// Expired results are deleted. The handler's lock must be held.
+func replay[R any](h *WebRtcSessionHandler, call *idempotentCall) (*R, *FieldError) {
+	if call == nil {
+		return nil, nil
+	}
+	r, ok := h.idempotency[call.key]
+	if !ok {
+		return nil, nil
+	}
+	if expired(r.created, h.idempotencyWindow, h.clock.Now()) {
+		h.forgetIdempotencyKey(call.key)
+		return nil, nil
+	}
+	if r.Operation != call.operation || r.Fingerprint != call.fingerprint {
+		return nil, &FieldError{Field: idempotencyKeyHeader, Code: ErrorIdempotencyKeyReused,
+			Detail: fmt.Sprintf("%s was used for another request", idempotencyKeyHeader)}
+	}
+	result := new(R)
+	if err := json.Unmarshal(r.Result, result); err != nil {
+		logger.LogErrorC(storeLogContext, "failed to decode result of idempotency key %s: %s", call.key, err)
+		return nil, nil
+	}
+	return result, nil
+}
+
+// newRecord returns the record of result as the result of call, or nil if
+// call is nil. Results which cannot be encoded are only logged, as the
+// operation itself can succeed, and nil is returned as well.
+func (h *WebRtcSessionHandler) newRecord(call *idempotentCall, result any) *idempotentResult {
+	if call == nil {
+		return nil
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		logger.LogErrorC(storeLogContext, "failed to encode result of idempotency key %s: %s", call.key, err)
+		return nil
+	}
+	now := h.clock.Now()
+	return &idempotentResult{
+		IdempotencyRecord: IdempotencyRecord{
+			Key:              call.key,
+			Operation:        call.operation,
+			Fingerprint:      call.fingerprint,
+			Result:           data,
+			CreationDateTime: formatDateTime(now),
+		},
+		created: now,
+	}
+}
+
+// stored returns the record persisted for r, or nil if r is nil.
+func (r *idempotentResult) stored() *IdempotencyRecord {
+	if r == nil {
+		return nil
+	}
+	return &r.IdempotencyRecord
+}
+
+// remember keeps r, if not nil, to replay it when its call is repeated,
+// once it has been persisted along with the object its call created.
+// Expired results are swept at most every idempotencySweepInterval, so
+// they do not pile up when the reaper does not run. The handler's lock
+// must be held.
+func (h *WebRtcSessionHandler) remember(r *idempotentResult) {
+	if r == nil {
+		return
+	}
+	if r.created.Sub(h.idempotencySwept) >= idempotencySweepInterval {
+		h.reapIdempotencyKeys(r.created)
+		h.idempotencySwept = r.created
+	}
+	h.idempotency[r.Key] = *r
+}
+
+// reapIdempotencyKeys deletes the expired results of idempotent calls.
+// The handler's lock must be held.
+func (h *WebRtcSessionHandler) reapIdempotencyKeys(now time.Time) {
+	for key, r := range h.idempotency {
+		if expired(r.created, h.idempotencyWindow, now) {
+			h.forgetIdempotencyKey(key)
+		}
+	}
+}
+
+// forgetIdempotencyKey deletes the result recorded for key. Results which
+// cannot be deleted from the store are kept, to be deleted later. The
+// handler's lock must be held.
+func (h *WebRtcSessionHandler) forgetIdempotencyKey(key string) {
+	if err := h.store.DeleteIdempotencyRecord(key); err != nil {
+		logger.LogErrorC(storeLogContext, "failed to delete idempotency key %s: %s", key, err)
+		return
+	}
+	delete(h.idempotency, key)
+}
+
+// restoreIdempotencyKeys loads the results of idempotent calls persisted
+// in the handler's store. The handler's lock must be held.
+func (h *WebRtcSessionHandler) restoreIdempotencyKeys() error {
+	records, err := h.store.LoadIdempotencyRecords()
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		created, err := time.Parse(timeFormat, r.CreationDateTime)
+		if err != nil {
+			return fmt.Errorf("idempotency key %s has an invalid creation date time: %w", r.Key, err)
+		}
+		h.idempotency[r.Key] = idempotentResult{IdempotencyRecord: r, created: created}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayAddParticipantAfterSessionDeletion(t *testing.T) {
+	h := NewWebRtcSessionHandler()
+	session, _ := h.CreateSession(CreateSessionParams{Name: "session"})
+	params := AddParticipantParams{SessionId: session.Session.Id, Name: "alice", IdempotencyKey: "add-alice"}
+	added, err := h.AddParticipant(params)
+	if err != nil || added.Participant == nil {
+		t.Fatalf("AddParticipant() = %+v, %v", added, err)
+	}
+	if _, err = h.DeleteSession(DeleteSessionParams{Id: session.Session.Id}); err != nil {
+		t.Fatalf("DeleteSession() error = %v", err)
+	}
+	replayed, err := h.AddParticipant(params)
+	if err != nil || replayed.Participant == nil || replayed.Participant.Id != added.Participant.Id {
+		t.Fatalf("replayed AddParticipant() = %+v, %v, want participant %s", replayed, err, added.Participant.Id)
+	}
+}
+
+func TestExpiredIdempotencyKeys(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryStore()
+	h := NewWebRtcSessionHandler(WithClock(clock), WithStore(store), WithIdempotencyWindow(time.Hour))
+	first, _ := h.CreateSession(CreateSessionParams{Name: "session", IdempotencyKey: "a"})
+	if replayed, _ := h.CreateSession(CreateSessionParams{Name: "session", IdempotencyKey: "a"}); replayed.Session.Id != first.Session.Id {
+		t.Fatalf("CreateSession() within the window created session %s, want %s", replayed.Session.Id, first.Session.Id)
+	}
+
+	clock.advance(2 * time.Hour)
+	again, _ := h.CreateSession(CreateSessionParams{Name: "session", IdempotencyKey: "a"})
+	if again.Session.Id == first.Session.Id {
+		t.Fatalf("CreateSession() after the window replayed session %s", first.Session.Id)
+	}
+
+	// keys never used again are swept when recording others
+	h.CreateSession(CreateSessionParams{Name: "session", IdempotencyKey: "b"})
+	clock.advance(2 * time.Hour)
+	h.CreateSession(CreateSessionParams{Name: "session", IdempotencyKey: "c"})
+	records, _ := store.LoadIdempotencyRecords()
+	if len(h.idempotency) != 1 || len(records) != 1 || records[0].Key != "c" {
+		t.Fatalf("idempotency keys = %v, stored %v, want only c", h.idempotency, records)
+	}
+}
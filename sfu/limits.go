@@ -0,0 +1,126 @@
+package sfu
+
+import "fmt"
+
+// Limits holds the capacity limits enforced by a session handler. A
+// zero value for any of its properties means no limit is enforced.
+type Limits struct {
+	// Maximum number of live view sessions hosted at the same time.
+	MaxSessions int
+	// Maximum number of participants, across all sessions, hosted at
+	// the same time.
+	MaxParticipants int
+	// Upper bounds for the limits of every single session. Sessions
+	// created without their own limits inherit these values.
+	Session SessionLimits
+}
+
+// SessionLimits holds the capacity limits of a single live view
+// session. A zero value for any of its properties means no limit is
+// enforced.
+type SessionLimits struct {
+	MaxParticipants int `json:"maxParticipants,omitempty"`
+	// Maximum number of participants publishing tracks.
+	MaxPublishers int `json:"maxPublishers,omitempty"`
+	// Maximum number of video tracks published by all participants.
+	MaxVideoTracks int `json:"maxVideoTracks,omitempty"`
+}
+
+// check verifies whether all limits are valid. It will return a slice
+// with all the errors found or nil if no errors exist.
+func (l SessionLimits) check() []FieldError {
+	var errors []FieldError
+	if err := isNotNegative("limits.maxParticipants", l.MaxParticipants); err != nil {
+		errors = append(errors, *err)
+	}
+	if err := isNotNegative("limits.maxPublishers", l.MaxPublishers); err != nil {
+		errors = append(errors, *err)
+	}
+	if err := isNotNegative("limits.maxVideoTracks", l.MaxVideoTracks); err != nil {
+		errors = append(errors, *err)
+	}
+	return errors
+}
+
+// capTo returns a copy of l where every limit is capped by the
+// corresponding limit in upper.
+func (l SessionLimits) capTo(upper SessionLimits) SessionLimits {
+	return SessionLimits{
+		MaxParticipants: capLimit(l.MaxParticipants, upper.MaxParticipants),
+		MaxPublishers:   capLimit(l.MaxPublishers, upper.MaxPublishers),
+		MaxVideoTracks:  capLimit(l.MaxVideoTracks, upper.MaxVideoTracks),
+	}
+}
+
+// capLimit caps a single limit value v by upper, where zero means
+// unlimited for both values.
+func capLimit(v int, upper int) int {
+	if upper == 0 || (v != 0 && v < upper) {
+		return v
+	}
+	return upper
+}
+
+// reached returns whether a limit has been reached by the given count.
+func reached(limit int, count int) bool {
+	return limit > 0 && count >= limit
+}
+
+// LimitKind identifies the capacity limit that prevented an operation
+// from completing.
+type LimitKind string
+
+const (
+	// ServerSessionsLimit is reported when the server hosts as many
+	// sessions as it is allowed to.
+	ServerSessionsLimit LimitKind = "server.maxSessions"
+	// ServerParticipantsLimit is reported when the server hosts as many
+	// participants as it is allowed to.
+	ServerParticipantsLimit LimitKind = "server.maxParticipants"
+	// SessionParticipantsLimit is reported when a session already has
+	// as many participants as it is allowed to.
+	SessionParticipantsLimit LimitKind = "session.maxParticipants"
+	// SessionPublishersLimit is reported when a participant offers to
+	// publish tracks to a session already having as many publishers as it
+	// is allowed to.
+	SessionPublishersLimit LimitKind = "session.maxPublishers"
+	// SessionVideoTracksLimit is reported when a participant offers to
+	// publish more video tracks than its session still allows.
+	SessionVideoTracksLimit LimitKind = "session.maxVideoTracks"
+)
+
+// IsServerWide returns whether the limit applies to the whole server
+// rather than to a single session.
+func (k LimitKind) IsServerWide() bool {
+	return k == ServerSessionsLimit || k == ServerParticipantsLimit
+}
+
+// limitError creates the error reported when limit k, with value v, has
+// been reached.
+func limitError(k LimitKind, v int) FieldError {
+	var detail string
+	switch k {
+	case ServerSessionsLimit:
+		detail = fmt.Sprintf("server reached its limit of %d sessions", v)
+	case ServerParticipantsLimit:
+		detail = fmt.Sprintf("server reached its limit of %d participants", v)
+	case SessionPublishersLimit:
+		detail = fmt.Sprintf("session reached its limit of %d publishers", v)
+	case SessionVideoTracksLimit:
+		detail = fmt.Sprintf("session reached its limit of %d video tracks", v)
+	default:
+		detail = fmt.Sprintf("session reached its limit of %d participants", v)
+	}
+	return FieldError{Code: ErrorLimitReached, Detail: detail}
+}
+
+// Size limits of the metadata of sessions and participants.
+const (
+	// MaxMetadataEntries is the maximum number of entries of metadata.
+	MaxMetadataEntries = 32
+	// MaxMetadataKeyLen is the maximum length, in bytes, of metadata keys.
+	MaxMetadataKeyLen = 64
+	// MaxMetadataValueLen is the maximum length, in bytes, of metadata
+	// values.
+	MaxMetadataValueLen = 512
+)
@@ -0,0 +1,168 @@
+package sfu
+
+import (
+	"encoding/json"
+	"github.com/pion/webrtc/v3"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSessionLimitsCapTo(t *testing.T) {
+	for _, test := range []struct {
+		limits SessionLimits
+		upper  SessionLimits
+		want   SessionLimits
+	}{
+		{limits: SessionLimits{}, upper: SessionLimits{}, want: SessionLimits{}},
+		{limits: SessionLimits{MaxParticipants: 5}, upper: SessionLimits{}, want: SessionLimits{MaxParticipants: 5}},
+		{limits: SessionLimits{}, upper: SessionLimits{MaxParticipants: 3}, want: SessionLimits{MaxParticipants: 3}},
+		{limits: SessionLimits{MaxParticipants: 2}, upper: SessionLimits{MaxParticipants: 3}, want: SessionLimits{MaxParticipants: 2}},
+		{limits: SessionLimits{MaxParticipants: 5}, upper: SessionLimits{MaxParticipants: 3}, want: SessionLimits{MaxParticipants: 3}},
+		{limits: SessionLimits{MaxPublishers: 1, MaxVideoTracks: 4}, upper: SessionLimits{MaxPublishers: 2, MaxVideoTracks: 2},
+			want: SessionLimits{MaxPublishers: 1, MaxVideoTracks: 2}},
+	} {
+		if got := test.limits.capTo(test.upper); got != test.want {
+			t.Errorf("%+v.capTo(%+v) = %+v, want %+v", test.limits, test.upper, got, test.want)
+		}
+	}
+}
+
+func TestSessionsLimit(t *testing.T) {
+	h := NewWebRtcSessionHandler(WithLimits(Limits{MaxSessions: 1}))
+	first, err := h.CreateSession(CreateSessionParams{Name: "first"})
+	if err != nil || first.Session == nil {
+		t.Fatalf("CreateSession() = %+v, %v", first, err)
+	}
+	second, err := h.CreateSession(CreateSessionParams{Name: "second"})
+	if err != nil || second.Session != nil || second.Limit != ServerSessionsLimit ||
+		!hasErrorCode(second.FieldErrors, ErrorLimitReached) || len(second.Errors) != 1 {
+		t.Fatalf("CreateSession() over the limit = %+v, %v", second, err)
+	}
+	if _, err = h.DeleteSession(DeleteSessionParams{Id: first.Session.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if third, err := h.CreateSession(CreateSessionParams{Name: "third"}); err != nil || third.Session == nil {
+		t.Errorf("CreateSession() after a deletion = %+v, %v", third, err)
+	}
+}
+
+func TestPublishersLimits(t *testing.T) {
+	h := NewWebRtcSessionHandler(WithLimits(Limits{Session: SessionLimits{MaxPublishers: 2, MaxVideoTracks: 2}}),
+		WithSettingEngine(loopbackSettings()))
+	created, err := h.CreateSession(CreateSessionParams{Name: "capped", Limits: &SessionLimits{MaxPublishers: 3, MaxVideoTracks: 2}})
+	if err != nil || created.Session == nil || created.Session.Limits != (SessionLimits{MaxPublishers: 2, MaxVideoTracks: 2}) {
+		t.Fatalf("CreateSession() = %+v, %v", created, err)
+	}
+	sessionId := created.Session.Id
+	defer h.DeleteSession(DeleteSessionParams{Id: sessionId})
+	var ids []string
+	for i := 0; i < 4; i++ {
+		added, _ := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: "participant"})
+		ids = append(ids, added.Participant.Id)
+	}
+	sendonly := webrtc.RTPTransceiverDirectionSendonly
+	audio := webrtc.RTPCodecTypeAudio
+	video := webrtc.RTPCodecTypeVideo
+	for _, test := range []struct {
+		participant int
+		offer       string
+		limit       LimitKind
+	}{
+		{participant: 0, offer: newOffer(t, sendonly, audio, video)},
+		{participant: 1, offer: newOffer(t, sendonly, video, video), limit: SessionVideoTracksLimit},
+		{participant: 1, offer: newOffer(t, sendonly, audio)},
+		{participant: 2, offer: newOffer(t, sendonly, audio), limit: SessionPublishersLimit},
+		// subscribing does not count as publishing
+		{participant: 2, offer: newRecvonlyOffer(t)},
+		// renegotiating releases the tracks of the previous offer
+		{participant: 0, offer: newOffer(t, sendonly, audio)},
+		{participant: 1, offer: newOffer(t, sendonly, video, video)},
+	} {
+		result, err := h.Negotiate(NegotiateParams{SessionId: sessionId, ParticipantId: ids[test.participant], Offer: test.offer})
+		if err != nil || result.Limit != test.limit || (result.Answer == "") != (test.limit != "") ||
+			hasErrorCode(result.FieldErrors, ErrorLimitReached) != (test.limit != "") {
+			t.Errorf("Negotiate(%d) = %v, %v, want limit %q", test.participant, result.Errors, err, test.limit)
+		}
+	}
+}
+
+func TestParticipantsLimits(t *testing.T) {
+	h := NewWebRtcSessionHandler(WithLimits(Limits{MaxParticipants: 3, Session: SessionLimits{MaxParticipants: 2}}))
+	capped, err := h.CreateSession(CreateSessionParams{Name: "capped", Limits: &SessionLimits{MaxParticipants: 10}})
+	if err != nil || capped.Session == nil || capped.Session.Limits.MaxParticipants != 2 {
+		t.Fatalf("CreateSession() = %+v, %v", capped, err)
+	}
+	small, err := h.CreateSession(CreateSessionParams{Name: "small", Limits: &SessionLimits{MaxParticipants: 1}})
+	if err != nil || small.Session == nil || small.Session.Limits.MaxParticipants != 1 {
+		t.Fatalf("CreateSession() = %+v, %v", small, err)
+	}
+	for _, test := range []struct {
+		sessionId string
+		limit     LimitKind
+	}{
+		{sessionId: small.Session.Id},
+		{sessionId: small.Session.Id, limit: SessionParticipantsLimit},
+		{sessionId: capped.Session.Id},
+		{sessionId: capped.Session.Id},
+		{sessionId: capped.Session.Id, limit: ServerParticipantsLimit},
+	} {
+		result, err := h.AddParticipant(AddParticipantParams{SessionId: test.sessionId, Name: "participant"})
+		if err != nil || result.Limit != test.limit || (result.Participant == nil) != (test.limit != "") ||
+			hasErrorCode(result.FieldErrors, ErrorLimitReached) != (test.limit != "") {
+			t.Errorf("AddParticipant(%s) = %+v, %v, want limit %q", test.sessionId, result, err, test.limit)
+		}
+	}
+	if _, err = h.DeleteSession(DeleteSessionParams{Id: small.Session.Id}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := h.AddParticipant(AddParticipantParams{SessionId: capped.Session.Id, Name: "participant"})
+	if err != nil || result.Participant != nil || result.Limit != SessionParticipantsLimit {
+		t.Errorf("AddParticipant() over the session limit = %+v, %v", result, err)
+	}
+}
+
+// TestLimitStatuses checks server-wide limits are reported with 429 and
+// limits of sessions with 409, as problem details naming the limit.
+func TestLimitStatuses(t *testing.T) {
+	h := NewWebRtcSessionHandler(WithLimits(Limits{MaxSessions: 1, Session: SessionLimits{MaxParticipants: 1}}))
+	ts := httptest.NewServer((&Server{}).newRouter(h))
+	defer ts.Close()
+	post := func(path string, body string) (int, Problem) {
+		resp, err := http.Post(ts.URL+"/v2"+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var problem Problem
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == problemContentType {
+			if err = json.NewDecoder(resp.Body).Decode(&problem); err != nil {
+				t.Fatalf("POST %s: %v", path, err)
+			}
+		}
+		return resp.StatusCode, problem
+	}
+	created, err := h.CreateSession(CreateSessionParams{Name: "limited"})
+	if err != nil || created.Session == nil {
+		t.Fatalf("CreateSession() = %+v, %v", created, err)
+	}
+	participants := "/sessions/" + created.Session.Id + "/participants"
+	for _, test := range []struct {
+		path   string
+		body   string
+		status int
+		limit  LimitKind
+	}{
+		{path: "/sessions", body: `{"name":"over"}`, status: http.StatusTooManyRequests, limit: ServerSessionsLimit},
+		{path: participants, body: `{"name":"first"}`, status: http.StatusCreated},
+		{path: participants, body: `{"name":"second"}`, status: http.StatusConflict, limit: SessionParticipantsLimit},
+	} {
+		status, problem := post(test.path, test.body)
+		if status != test.status || problem.Limit != test.limit ||
+			(test.limit != "" && !hasErrorCode(problem.Errors, ErrorLimitReached)) {
+			t.Errorf("POST %s = %d, %+v, want %d with limit %q", test.path, status, problem, test.status, test.limit)
+		}
+	}
+}
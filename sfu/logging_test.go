@@ -0,0 +1,88 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureLogs writes all log records to a file until the test ends, and
+// returns a function reading the records written so far.
+func captureLogs(t *testing.T) func() string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "blackbird.log")
+	if err := logger.SetSinks([]logger.SinkConfig{{Type: logger.FileSink, Path: path}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		logger.SetSinks([]logger.SinkConfig{{Type: logger.StdoutSink}})
+	})
+	return func() string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+}
+
+func TestSubsystemLogComponents(t *testing.T) {
+	tests := []struct {
+		component string
+		// level of the record logged by log
+		level int
+		log   func(t *testing.T)
+		want  string
+	}{
+		{logger.ComponentCluster, logger.Info, func(t *testing.T) {
+			c, err := NewCluster(ClusterConfig{NodeName: "node-a", AdvertiseURL: "http://localhost:8000"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.AdoptSessions([]string{clusterTag("node-a-former") + "000000000000000"})
+		}, "adopting sessions"},
+		{logger.ComponentReaper, logger.Info, func(t *testing.T) {
+			h := NewWebRtcSessionHandler()
+			created, _ := h.CreateSession(CreateSessionParams{Name: "standup"})
+			added, _ := h.AddParticipant(AddParticipantParams{SessionId: created.Session.Id, Name: "alice"})
+			h.Heartbeat(HeartbeatParams{SessionId: created.Session.Id, ParticipantId: added.Participant.Id, ConnectionState: ConnectionFailed})
+			h.Reap()
+		}, "reaped participant"},
+		{logger.ComponentStore, logger.Info, func(t *testing.T) {
+			if err := NewWebRtcSessionHandler().Restore(); err != nil {
+				t.Fatal(err)
+			}
+		}, "restored 0 sessions"},
+		{logger.ComponentWebhook, logger.Warn, func(t *testing.T) {
+			// the queue is full, as the dispatcher was not started
+			NewWebhookDispatcher(WebhookConfig{URLs: []string{"http://localhost:8000"}}).Dispatch(Event{Type: SessionCreated})
+		}, "dropping session.created event"},
+	}
+	level := logger.GetLogLevel()
+	defer logger.SetLogLevel(level)
+	for _, test := range tests {
+		t.Run(test.component, func(t *testing.T) {
+			defer logger.ResetComponentLevel(test.component)
+			read := captureLogs(t)
+
+			// the default level would log the record, the component's does not
+			logger.SetLogLevel(logger.Debug)
+			logger.SetComponentLevel(test.component, test.level+1)
+			test.log(t)
+			if out := read(); strings.Contains(out, test.want) {
+				t.Errorf("%s record logged at level %s of its component:\n%s", test.component, logger.LevelName(test.level+1), out)
+			}
+
+			// the default level would not log the record, the component's does
+			logger.SetLogLevel(logger.Error)
+			logger.SetComponentLevel(test.component, test.level)
+			test.log(t)
+			out := read()
+			if !strings.Contains(out, test.want) || !strings.Contains(out, logger.ComponentKey+"="+test.component) {
+				t.Errorf("%s record %q not logged at level %s of its component:\n%s", test.component, test.want, logger.LevelName(test.level), out)
+			}
+		})
+	}
+}
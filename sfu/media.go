@@ -0,0 +1,646 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+)
+
+// mediaLogContext carries the component of the log records of forwarded
+// tracks.
+var mediaLogContext = logger.NewContext(context.Background(), logger.ComponentKey, logger.ComponentRTP)
+
+// NewSettingEngine returns the webrtc.SettingEngine used for participant
+// PeerConnections by default, logging through PionLoggerFactory. Settings
+// given to WithSettingEngine should start from it.
+func NewSettingEngine() webrtc.SettingEngine {
+	return webrtc.SettingEngine{LoggerFactory: PionLoggerFactory{}}
+}
+
+// newMediaAPI returns the API creating participant PeerConnections with
+// settings, the default codecs and the default interceptors, answering
+// NACKs and sending RTCP reports.
+func newMediaAPI(settings webrtc.SettingEngine) *webrtc.API {
+	m := &webrtc.MediaEngine{}
+	// none fails with an empty media engine and registry
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		panic(err)
+	}
+	if err := m.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI}, webrtc.RTPCodecTypeAudio); err != nil {
+		panic(err)
+	}
+	registry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(m, registry); err != nil {
+		panic(err)
+	}
+	return webrtc.NewAPI(webrtc.WithSettingEngine(settings), webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(registry))
+}
+
+// participantMedia holds the PeerConnection of a participant, along with
+// the tracks it publishes and those forwarded to it.
+type participantMedia struct {
+	// Serializes the negotiations of the participant.
+	negotiating sync.Mutex
+	// PeerConnection of the participant, nil until its first offer. It is
+	// set while holding both negotiating and the lock of the handler.
+	pc *webrtc.PeerConnection
+	// Span of the participant, set along with pc and ended once pc is
+	// closed.
+	span trace.Span
+	// Whether the participant is the relay of another node, whose tracks
+	// keep the ids and streams given by that node.
+	relay bool
+	// Tracks published by the participant, by id, guarded by the lock of
+	// the handler.
+	published map[string]*forwardedTrack
+	// Senders of the tracks of the other participants forwarded to the
+	// participant, by track id, guarded by negotiating.
+	subscribed map[string]*webrtc.RTPSender
+	// Tracks the participant offered to publish in its last offer, counted
+	// by the limits of its session before they are published. It is
+	// guarded by the lock of the handler.
+	offered offeredTracks
+}
+
+func newParticipantMedia() *participantMedia {
+	return &participantMedia{
+		published:  make(map[string]*forwardedTrack),
+		subscribed: make(map[string]*webrtc.RTPSender),
+	}
+}
+
+// offeredTracks holds the number of tracks, of each kind, a participant
+// offers to publish.
+type offeredTracks struct {
+	audio int
+	video int
+}
+
+// countOfferedTracks counts the tracks offered to be published by offer,
+// i.e. its media sections which are neither rejected, nor recvonly or
+// inactive.
+func countOfferedTracks(offer *sdp.SessionDescription) offeredTracks {
+	var offered offeredTracks
+	for _, m := range offer.MediaDescriptions {
+		if m.MediaName.Port.Value == 0 {
+			continue
+		}
+		if _, ok := m.Attribute(sdp.AttrKeyRecvOnly); ok {
+			continue
+		}
+		if _, ok := m.Attribute(sdp.AttrKeyInactive); ok {
+			continue
+		}
+		switch m.MediaName.Media {
+		case string(AudioTrack):
+			offered.audio++
+		case string(VideoTrack):
+			offered.video++
+		}
+	}
+	return offered
+}
+
+// exceededLimit returns the limit of session s, along with its value,
+// which participant p would exceed by publishing the tracks offered, or
+// an empty LimitKind if none would be. It must be called while holding the
+// lock of the handler.
+func (s *webRtcSession) exceededLimit(p *webRtcParticipant, offered offeredTracks) (LimitKind, int) {
+	var others []offeredTracks
+	for _, other := range s.participants {
+		if other != p && !other.Relay {
+			others = append(others, other.publishedTracks())
+		}
+	}
+	return exceededLimit(s.Limits, others, offered)
+}
+
+// publishedTracks returns the tracks participant p offered to publish in
+// its last offer or, without media on this node, those it publishes
+// through the relay of another node.
+func (p *webRtcParticipant) publishedTracks() offeredTracks {
+	if p.media != nil {
+		return p.media.offered
+	}
+	return countTracks(p.Tracks)
+}
+
+// countTracks counts tracks by kind.
+func countTracks(tracks []Track) offeredTracks {
+	var counted offeredTracks
+	for _, t := range tracks {
+		switch t.Kind {
+		case AudioTrack:
+			counted.audio++
+		case VideoTrack:
+			counted.video++
+		}
+	}
+	return counted
+}
+
+// exceededLimit returns the limit of limits, along with its value, which
+// a participant would exceed by publishing the tracks offered while the
+// other participants of its session publish others, or an empty LimitKind
+// if none would be.
+func exceededLimit(limits SessionLimits, others []offeredTracks, offered offeredTracks) (LimitKind, int) {
+	publishers := 0
+	videoTracks := 0
+	for _, other := range others {
+		if other != (offeredTracks{}) {
+			publishers++
+		}
+		videoTracks += other.video
+	}
+	if offered != (offeredTracks{}) && reached(limits.MaxPublishers, publishers) {
+		return SessionPublishersLimit, limits.MaxPublishers
+	}
+	if limits.MaxVideoTracks > 0 && videoTracks+offered.video > limits.MaxVideoTracks {
+		return SessionVideoTracksLimit, limits.MaxVideoTracks
+	}
+	return "", 0
+}
+
+// forwardedTrack is a track published by a participant, forwarded to the
+// other participants of its session.
+type forwardedTrack struct {
+	Track
+	// Track written to the PeerConnections of the other participants.
+	local *webrtc.TrackLocalStaticRTP
+	codec webrtc.RTPCodecParameters
+	// Recording of the track, while its session is recorded.
+	recorder trackRecorder
+	// Audio levels of the track, for audio tracks.
+	speech speech
+	// Asks the publisher for a key frame, e.g. for a new subscriber.
+	requestKeyFrame func()
+	// Counters of the handler and session forwarding the track, set once
+	// it is published.
+	counters []*mediaCounters
+	// Id of the participant publishing the track, which is not the one
+	// forwarding it for tracks relayed by another node.
+	publisher string
+	// Id of the participant the track is reported as published by, set
+	// once it is published.
+	owner string
+}
+
+// count applies f to the counters of the handler and session forwarding t.
+func (t *forwardedTrack) count(f func(c *mediaCounters)) {
+	for _, c := range t.counters {
+		f(c)
+	}
+}
+
+// peerConnection returns the PeerConnection of participant p, if any.
+// It must be called while holding the lock of the handler.
+func (p *webRtcParticipant) peerConnection() *webrtc.PeerConnection {
+	if p.media == nil {
+		return nil
+	}
+	return p.media.pc
+}
+
+// peerConnections returns the PeerConnections of all participants of
+// session s. It must be called while holding the lock of the handler.
+func (s *webRtcSession) peerConnections() []*webrtc.PeerConnection {
+	var pcs []*webrtc.PeerConnection
+	for _, p := range s.participants {
+		if pc := p.peerConnection(); pc != nil {
+			pcs = append(pcs, pc)
+		}
+	}
+	return pcs
+}
+
+// closePeerConnections closes all pcs. It must not be called while
+// holding the lock of the handler, as closing waits for the callbacks of
+// the PeerConnections, which take the lock.
+func closePeerConnections(pcs []*webrtc.PeerConnection) {
+	for _, pc := range pcs {
+		if err := pc.Close(); err != nil {
+			logger.LogWarnC(signalingLogContext, "failed to close PeerConnection: %s", err)
+		}
+	}
+}
+
+// signalingLogContext carries the component of the log records of
+// PeerConnection negotiations.
+var signalingLogContext = logger.NewContext(context.Background(), logger.ComponentKey, logger.ComponentSignaling)
+
+func (h *WebRtcSessionHandler) Negotiate(params NegotiateParams) (NegotiateResult, error) {
+	if errors := params.check(); errors != nil {
+		return NegotiateResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: params.Offer}
+	parsed, err := offer.Unmarshal()
+	if err != nil {
+		errors := []FieldError{invalidOffer(err)}
+		return NegotiateResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	offered := countOfferedTracks(parsed)
+	if owner := h.relayOwner(params.SessionId); owner != nil {
+		return h.negotiateRelayed(params, owner, offer, offered)
+	}
+	var media *participantMedia
+	var limit LimitKind
+	var limitValue int
+	action := func(s *webRtcSession) {
+		p := s.participants[params.ParticipantId]
+		if p == nil {
+			return
+		}
+		// the limits of the participants of relays are checked by the
+		// nodes serving their media
+		if limit, limitValue = s.exceededLimit(p, offered); limit != "" && !p.Relay {
+			return
+		}
+		limit = ""
+		if p.media == nil {
+			p.media = newParticipantMedia()
+			p.media.relay = p.Relay
+		}
+		p.media.offered = offered
+		media = p.media
+	}
+	if ok := h.doActionOnSession(params.SessionId, action); !ok {
+		errors := []FieldError{noSuchSession(params.SessionId)}
+		return NegotiateResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	if limit != "" {
+		errors := []FieldError{limitError(limit, limitValue)}
+		return NegotiateResult{Errors: errorDetails(errors), FieldErrors: errors, Limit: limit}, nil
+	}
+	if media == nil {
+		return NegotiateResult{}, nil
+	}
+	return h.negotiate(params, media, offer)
+}
+
+// negotiate answers the offer of a participant with media, creating its
+// PeerConnection on its first offer.
+func (h *WebRtcSessionHandler) negotiate(params NegotiateParams, media *participantMedia, offer webrtc.SessionDescription) (NegotiateResult, error) {
+	media.negotiating.Lock()
+	defer media.negotiating.Unlock()
+	if media.pc != nil {
+		media.span.AddEvent("renegotiation")
+	} else if attached, err := h.attachPeerConnection(params.SessionId, params.ParticipantId, media); err != nil || !attached {
+		return NegotiateResult{}, err
+	}
+	answer, errors, err := h.answer(params, media, offer)
+	participant := h.participantOf(params.SessionId, params.ParticipantId, media)
+	if participant == nil {
+		// the PeerConnection was closed along with the participant
+		return NegotiateResult{}, nil
+	}
+	if err != nil {
+		return NegotiateResult{}, err
+	}
+	if errors != nil {
+		return NegotiateResult{Participant: participant, Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	return NegotiateResult{Answer: answer, Participant: participant}, nil
+}
+
+// attachPeerConnection creates the PeerConnection of a participant with
+// media, while holding its negotiating lock. It returns false if the
+// participant was removed meanwhile.
+func (h *WebRtcSessionHandler) attachPeerConnection(sessionId string, participantId string, media *participantMedia) (bool, error) {
+	pc, span, err := h.newPeerConnection(sessionId, participantId, media.relay)
+	if err != nil {
+		return false, err
+	}
+	attached := false
+	h.doActionOnMedia(sessionId, func(s *webRtcSession) {
+		if p := s.participants[participantId]; p != nil && p.media == media {
+			media.pc = pc
+			media.span = span
+			attached = true
+		}
+	})
+	if !attached {
+		closePeerConnections([]*webrtc.PeerConnection{pc})
+	}
+	return attached, nil
+}
+
+// invalidOffer creates the error reported for offers which cannot be
+// answered.
+func invalidOffer(err error) FieldError {
+	return FieldError{Field: "offer", Code: ErrorInvalidValue, Detail: "offer cannot be answered: " + err.Error()}
+}
+
+// participantOf returns a copy of a participant, or nil if it no longer
+// exists with media.
+func (h *WebRtcSessionHandler) participantOf(sessionId string, participantId string, media *participantMedia) *Participant {
+	var participant *Participant
+	h.doActionOnMedia(sessionId, func(s *webRtcSession) {
+		if p := s.participants[participantId]; p != nil && p.media == media {
+			participant = p.clone()
+		}
+	})
+	return participant
+}
+
+// answer subscribes a participant to the tracks of the other participants
+// and answers its offer, once all ICE candidates are gathered, as the
+// answer is not followed by trickled candidates.
+func (h *WebRtcSessionHandler) answer(params NegotiateParams, media *participantMedia, offer webrtc.SessionDescription) (string, []FieldError, error) {
+	if err := h.subscribe(params.SessionId, params.ParticipantId, media); err != nil {
+		return "", nil, err
+	}
+	if err := media.pc.SetRemoteDescription(offer); err != nil {
+		logger.LogDebugC(signalingLogContext, "offer of participant %s cannot be answered: %s", params.ParticipantId, err)
+		return "", []FieldError{invalidOffer(err)}, nil
+	}
+	answer, err := media.pc.CreateAnswer(nil)
+	if err != nil {
+		return "", nil, err
+	}
+	gathered := webrtc.GatheringCompletePromise(media.pc)
+	if err = media.pc.SetLocalDescription(answer); err != nil {
+		return "", nil, err
+	}
+	<-gathered
+	return media.pc.LocalDescription().SDP, nil, nil
+}
+
+// newPeerConnection creates the PeerConnection of a participant, and the
+// span of the participant recording its media events until it is closed.
+func (h *WebRtcSessionHandler) newPeerConnection(sessionId string, participantId string, relay bool) (*webrtc.PeerConnection, trace.Span, error) {
+	pc, err := h.api.NewPeerConnection(webrtc.Configuration{ICEServers: h.iceServers})
+	if err != nil {
+		return nil, nil, err
+	}
+	_, span := h.tracer.Start(context.Background(), "Participant", trace.WithAttributes(
+		attribute.String("blackbird.sessionId", sessionId),
+		attribute.String("blackbird.participantId", participantId)))
+	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		h.forward(sessionId, participantId, pc, span, relay, remote, receiver)
+	})
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		if state == webrtc.ICEConnectionStateConnected {
+			span.AddEvent("ice connected")
+		}
+	})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateClosed {
+			span.End()
+		}
+		h.onConnectionStateChange(sessionId, participantId, state)
+	})
+	return pc, span, nil
+}
+
+// subscribe adds the tracks published by the other participants of a
+// session to the PeerConnection of a participant, and removes those no
+// longer published.
+func (h *WebRtcSessionHandler) subscribe(sessionId string, participantId string, media *participantMedia) error {
+	var added []*forwardedTrack
+	removed := make(map[string]*webrtc.RTPSender)
+	h.doActionOnMedia(sessionId, func(s *webRtcSession) {
+		published := make(map[string]*forwardedTrack)
+		for _, p := range s.participants {
+			if p.Id != participantId && p.media != nil {
+				for id, t := range p.media.published {
+					published[id] = t
+				}
+			}
+		}
+		for id, t := range published {
+			if _, ok := media.subscribed[id]; !ok {
+				added = append(added, t)
+			}
+		}
+		for id, sender := range media.subscribed {
+			if published[id] == nil {
+				removed[id] = sender
+			}
+		}
+	})
+	for id, sender := range removed {
+		if err := media.pc.RemoveTrack(sender); err != nil {
+			return err
+		}
+		delete(media.subscribed, id)
+	}
+	for _, t := range added {
+		transceiver, err := media.pc.AddTransceiverFromTrack(t.local,
+			webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+		if err != nil {
+			return err
+		}
+		media.subscribed[t.Id] = transceiver.Sender()
+		go readRTCP(transceiver.Sender(), t)
+		t.requestKeyFrame()
+	}
+	return nil
+}
+
+// readRTCP reads the RTCP packets about a track forwarded to a
+// participant, until the track is no longer forwarded, asking the
+// publisher for key frames on behalf of the participant.
+func readRTCP(sender *webrtc.RTPSender, t *forwardedTrack) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.PictureLossIndication:
+				t.count(func(c *mediaCounters) { c.plis.Add(1) })
+				t.requestKeyFrame()
+			case *rtcp.FullIntraRequest:
+				t.requestKeyFrame()
+			case *rtcp.TransportLayerNack:
+				t.count(func(c *mediaCounters) { c.nacks.Add(1) })
+			}
+		}
+	}
+}
+
+// forward forwards a track published by a participant to the other
+// participants of its session, until the track ends. Tracks of relays
+// keep the id and stream given by the node relaying them.
+func (h *WebRtcSessionHandler) forward(sessionId string, participantId string, pc *webrtc.PeerConnection, span trace.Span,
+	relay bool, remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	// the stream of the track is its publisher, so subscribers can tell
+	// whose tracks they receive
+	id, publisher := generateTrackId(), participantId
+	if relay {
+		id, publisher = remote.ID(), remote.StreamID()
+	}
+	local, err := webrtc.NewTrackLocalStaticRTP(remote.Codec().RTPCodecCapability, id, publisher)
+	if err != nil {
+		logger.LogErrorC(mediaLogContext, "failed to forward track of participant %s: %s", participantId, err)
+		return
+	}
+	t := &forwardedTrack{
+		Track:     Track{Id: id, Kind: TrackKind(remote.Kind().String())},
+		publisher: publisher,
+		local:     local,
+		codec:     remote.Codec(),
+		requestKeyFrame: func() {
+			if remote.Kind() == webrtc.RTPCodecTypeVideo {
+				pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(remote.SSRC())}})
+			}
+		},
+	}
+	if !h.setPublished(sessionId, participantId, t, true) {
+		return
+	}
+	defer h.setPublished(sessionId, participantId, t, false)
+	// the participant may be removed before the track is unpublished
+	defer t.recorder.stop()
+	var audioLevelId uint8
+	for _, e := range receiver.GetParameters().HeaderExtensions {
+		if e.URI == sdp.AudioLevelURI {
+			audioLevelId = uint8(e.ID)
+		}
+	}
+	logger.LogDebugC(mediaLogContext, "forwarding %s track %s of participant %s", t.Kind, t.Id, participantId)
+	evaluated := h.clock.Now()
+	var sequence sequenceTracker
+	for {
+		packet, _, err := remote.ReadRTP()
+		if err != nil {
+			// the track ended, or the PeerConnection was closed
+			return
+		}
+		if !sequence.started {
+			span.AddEvent("first packet", trace.WithAttributes(
+				attribute.String("blackbird.trackId", t.Id),
+				attribute.String("blackbird.trackKind", string(t.Kind))))
+		}
+		lost := sequence.lost(packet.SequenceNumber)
+		if audioLevelId != 0 {
+			t.speech.hear(packet, audioLevelId)
+			if now := h.clock.Now(); now.Sub(evaluated) >= activeSpeakerInterval {
+				evaluated = now
+				h.updateActiveSpeaker(sessionId)
+			}
+		}
+		t.recorder.write(packet)
+		// failing to write to a single subscriber, e.g. one leaving, does
+		// not stop forwarding to the others
+		local.WriteRTP(packet)
+		t.count(func(c *mediaCounters) {
+			c.packets.Add(1)
+			c.bytes.Add(uint64(packet.MarshalSize()))
+			c.lost.Add(lost)
+		})
+	}
+}
+
+// setPublished adds track t to the tracks published by a participant, or
+// removes it when published is false. Tracks relayed by a participant are
+// reported as tracks of their publisher, unless the publisher is not a
+// participant of the session served by another node. It returns false if
+// the participant no longer exists.
+func (h *WebRtcSessionHandler) setPublished(sessionId string, participantId string, t *forwardedTrack, published bool) bool {
+	found := false
+	h.doActionOnMedia(sessionId, func(s *webRtcSession) {
+		p := s.participants[participantId]
+		if p == nil || p.media == nil {
+			return
+		}
+		found = true
+		if published {
+			t.counters = []*mediaCounters{&h.counters, &s.counters}
+			t.owner = p.Id
+			if o := s.participants[t.publisher]; p.media.relay && o != nil && o.media == nil && !o.Relay {
+				t.owner = o.Id
+			}
+			p.media.published[t.Id] = t
+		} else {
+			delete(p.media.published, t.Id)
+			t.recorder.stop()
+		}
+		if s.relay != nil {
+			// the node hosting the session reports the track once the
+			// relay forwards it
+			if p.Id != s.relay.participantId {
+				go h.updateRelay(sessionId)
+			}
+			return
+		}
+		owner := s.participants[t.owner]
+		if owner == nil {
+			// the publisher of the relayed track left
+			return
+		}
+		updated := owner.Participant
+		updated.Tracks = nil
+		for _, track := range owner.Tracks {
+			if track.Id != t.Id {
+				updated.Tracks = append(updated.Tracks, track)
+			}
+		}
+		eventType := TrackUnpublished
+		if published {
+			updated.Tracks = append(updated.Tracks, t.Track)
+			h.startRecording(s, owner, t)
+			eventType = TrackPublished
+		}
+		updated.Revision++
+		if err := h.store.SaveParticipant(updated); err != nil {
+			// forwarding goes on, the tracks of restored participants
+			// are dropped anyway
+			logger.LogErrorC(storeLogContext, "failed to save the tracks of participant %s: %s", owner.Id, err)
+		}
+		owner.Participant = updated
+		// other participants negotiate again to subscribe to the track
+		h.events.emit(h.newParticipantEvent(ParticipantUpdated, owner, ""))
+		event := h.newParticipantEvent(eventType, owner, "")
+		track := t.Track
+		event.Track = &track
+		h.events.emit(event)
+	})
+	return found
+}
+
+// onConnectionStateChange records the state of the PeerConnection of a
+// participant as its connection state.
+func (h *WebRtcSessionHandler) onConnectionStateChange(sessionId string, participantId string, state webrtc.PeerConnectionState) {
+	var c ConnectionState
+	switch state {
+	case webrtc.PeerConnectionStateConnected:
+		c = ConnectionConnected
+	case webrtc.PeerConnectionStateDisconnected:
+		c = ConnectionDisconnected
+	case webrtc.PeerConnectionStateFailed:
+		c = ConnectionFailed
+	default:
+		return
+	}
+	logger.LogDebugC(signalingLogContext, "PeerConnection of participant %s is %s", participantId, state)
+	var relay *sessionRelay
+	h.doActionOnMedia(sessionId, func(s *webRtcSession) {
+		p := s.participants[participantId]
+		if p == nil {
+			return
+		}
+		if relay = s.relay; relay != nil {
+			return
+		}
+		if err := h.setConnectionState(p, c); err != nil {
+			logger.LogErrorC(storeLogContext, "failed to save the connection state of participant %s: %s", participantId, err)
+		}
+	})
+	switch {
+	case relay == nil:
+	case participantId != relay.participantId:
+		// the node hosting the session records it
+		go h.relayConnectionState(sessionId, participantId, c)
+	case c == ConnectionFailed:
+		logger.LogWarnC(clusterLogContext, "relay of session %s failed", sessionId)
+		go h.closeRelay(sessionId)
+	}
+}
@@ -0,0 +1,211 @@
+package sfu
+
+import (
+	"github.com/pion/ice/v3"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"testing"
+	"time"
+)
+
+// loopbackSettings returns settings restricting PeerConnections to the
+// loopback interface, so tests do not depend on the network of the host.
+func loopbackSettings() webrtc.SettingEngine {
+	settings := NewSettingEngine()
+	settings.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	settings.SetIncludeLoopbackCandidate(true)
+	settings.SetNetworkTypes([]webrtc.NetworkType{webrtc.NetworkTypeUDP4})
+	settings.SetInterfaceFilter(func(name string) bool { return name == "lo" })
+	return settings
+}
+
+// newTestPeer creates the PeerConnection of a participant, closed when the
+// test ends.
+func newTestPeer(t *testing.T) *webrtc.PeerConnection {
+	t.Helper()
+	pc, err := newMediaAPI(loopbackSettings()).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// offer returns the SDP offer of pc, with all its ICE candidates.
+func offer(t *testing.T, pc *webrtc.PeerConnection) string {
+	t.Helper()
+	o, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err = pc.SetLocalDescription(o); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	return pc.LocalDescription().SDP
+}
+
+// negotiate negotiates the PeerConnection pc of a participant with h.
+func negotiate(t *testing.T, h SessionHandler, sessionId string, participantId string, pc *webrtc.PeerConnection) {
+	t.Helper()
+	result, err := h.Negotiate(NegotiateParams{SessionId: sessionId, ParticipantId: participantId, Offer: offer(t, pc)})
+	if err != nil || result.Errors != nil || result.Participant == nil {
+		t.Fatalf("negotiating: %v %v %v", result.Participant, result.Errors, err)
+	}
+	if err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: result.Answer}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newOffer returns the offer of a participant with a transceiver of the
+// given direction for every kind of track.
+func newOffer(t *testing.T, direction webrtc.RTPTransceiverDirection, kinds ...webrtc.RTPCodecType) string {
+	t.Helper()
+	pc := newTestPeer(t)
+	for _, kind := range kinds {
+		if _, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: direction}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return offer(t, pc)
+}
+
+// newRecvonlyOffer returns the offer of a participant receiving a video
+// track.
+func newRecvonlyOffer(t *testing.T) string {
+	return newOffer(t, webrtc.RTPTransceiverDirectionRecvonly, webrtc.RTPCodecTypeVideo)
+}
+
+// publishVideo publishes a VP8 track as a participant, writing a key
+// frame to it every 20ms until the test ends. It returns the
+// PeerConnection of the participant and the sender of the track.
+func publishVideo(t *testing.T, h SessionHandler, sessionId string, participantId string) (*webrtc.PeerConnection, *webrtc.RTPSender) {
+	t.Helper()
+	return publishVideoWith(t, h, sessionId, participantId, newTestPeer(t))
+}
+
+// publishVideoWith publishes a VP8 track like publishVideo, with the
+// PeerConnection publisher of the participant.
+func publishVideoWith(t *testing.T, h SessionHandler, sessionId string, participantId string,
+	publisher *webrtc.PeerConnection) (*webrtc.PeerConnection, *webrtc.RTPSender) {
+	t.Helper()
+	local, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "camera", participantId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// on a media section of its own, rather than one publisher receives on
+	transceiver, err := publisher.AddTransceiverFromTrack(local,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendrecv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := transceiver.Sender()
+	negotiate(t, h, sessionId, participantId, publisher)
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for seq := uint16(0); ; seq++ {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				local.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, Marker: true, SequenceNumber: seq, Timestamp: uint32(seq) * 3000},
+					Payload: []byte{0x10, 0x00, 0x00, 0x00}})
+			}
+		}
+	}()
+	return publisher, sender
+}
+
+func TestForwardTrack(t *testing.T) {
+	h := NewWebRtcSessionHandler(WithSettingEngine(loopbackSettings()))
+	created, _ := h.CreateSession(CreateSessionParams{Name: "standup"})
+	sessionId := created.Session.Id
+	alice, _ := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: "alice"})
+	bob, _ := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: "bob"})
+	defer h.DeleteSession(DeleteSessionParams{Id: sessionId})
+
+	events := make(chan Event, 16)
+	unsubscribe := h.Subscribe(func(e Event) {
+		if e.Type == TrackPublished || e.Type == TrackUnpublished {
+			events <- e
+		}
+	})
+	defer unsubscribe()
+
+	// alice publishes a video track
+	publisher, sender := publishVideo(t, h, sessionId, alice.Participant.Id)
+	e := waitForEvent(t, events)
+	if e.Type != TrackPublished || e.ParticipantId != alice.Participant.Id || e.Track.Kind != VideoTrack ||
+		len(e.Participant.Tracks) != 1 || e.Participant.Tracks[0] != *e.Track {
+		t.Fatalf("event = %+v, want the video track of alice published", e)
+	}
+	track := *e.Track
+
+	// bob receives it once he negotiates again
+	subscriber := newTestPeer(t)
+	if _, err := subscriber.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+		webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan *webrtc.TrackRemote, 1)
+	subscriber.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if _, _, err := remote.ReadRTP(); err == nil {
+			received <- remote
+		}
+	})
+	negotiate(t, h, sessionId, bob.Participant.Id, subscriber)
+	select {
+	case remote := <-received:
+		if remote.StreamID() != alice.Participant.Id || remote.ID() != track.Id {
+			t.Errorf("received track %s of stream %s, want track %s of alice", remote.ID(), remote.StreamID(), track.Id)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("bob did not receive the track of alice")
+	}
+
+	// alice stops publishing it
+	if err := publisher.RemoveTrack(sender); err != nil {
+		t.Fatal(err)
+	}
+	negotiate(t, h, sessionId, alice.Participant.Id, publisher)
+	if e = waitForEvent(t, events); e.Type != TrackUnpublished || *e.Track != track {
+		t.Errorf("event = %+v, want the track of alice unpublished", e)
+	}
+}
+
+// waitForEvent returns the next event of events.
+func waitForEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(10 * time.Second):
+		t.Fatal("no event")
+		return Event{}
+	}
+}
+
+func TestNegotiateErrors(t *testing.T) {
+	h := NewWebRtcSessionHandler(WithSettingEngine(loopbackSettings()))
+	created, _ := h.CreateSession(CreateSessionParams{Name: "standup"})
+	sessionId := created.Session.Id
+	alice, _ := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: "alice"})
+	defer h.DeleteSession(DeleteSessionParams{Id: sessionId})
+
+	result, err := h.Negotiate(NegotiateParams{SessionId: sessionId, ParticipantId: alice.Participant.Id, Offer: "v=0"})
+	if err != nil || len(result.FieldErrors) != 1 || result.FieldErrors[0].Field != "offer" {
+		t.Errorf("negotiating an invalid offer: %v %v", result.FieldErrors, err)
+	}
+	result, err = h.Negotiate(NegotiateParams{SessionId: sessionId, ParticipantId: "0000000000", Offer: newRecvonlyOffer(t)})
+	if err != nil || result.Errors != nil || result.Participant != nil {
+		t.Errorf("negotiating for a missing participant: %v %v %v", result.Participant, result.Errors, err)
+	}
+	result, err = h.Negotiate(NegotiateParams{SessionId: sessionId, ParticipantId: alice.Participant.Id, Offer: newRecvonlyOffer(t)})
+	if err != nil || result.Errors != nil || result.Answer == "" || result.Participant.Tracks != nil {
+		t.Errorf("negotiating: %v %v %v", result.Participant, result.Errors, err)
+	}
+}
@@ -0,0 +1,90 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+// mergePatchContentType is the content type of JSON Merge Patch documents.
+const mergePatchContentType = "application/merge-patch+json"
+
+// mergePatch applies the JSON Merge Patch patch to target, both decoded
+// JSON values, as per RFC 7396, and returns the patched value.
+func mergePatch(target any, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// applyMergePatch applies the JSON Merge Patch patch to the JSON document
+// of v and returns the patched document, decoded as a new T. Patches
+// setting fields unknown to T are rejected.
+func applyMergePatch[T any](v T, patch json.RawMessage) (T, error) {
+	var patched T
+	data, err := json.Marshal(v)
+	if err != nil {
+		return patched, err
+	}
+	var target, p any
+	if err = json.Unmarshal(data, &target); err != nil {
+		return patched, err
+	}
+	if err = json.Unmarshal(patch, &p); err != nil {
+		return patched, err
+	}
+	if data, err = json.Marshal(mergePatch(target, p)); err != nil {
+		return patched, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&patched)
+	return patched, err
+}
+
+// patchError creates the error reported for patches which cannot be
+// applied.
+func patchError(err error) FieldError {
+	return FieldError{Field: "patch", Code: ErrorInvalidValue, Detail: fmt.Sprintf("patch cannot be applied: %s", err)}
+}
+
+// readOnlyError creates the error reported for patches changing the read
+// only field n.
+func readOnlyError(n string) FieldError {
+	return FieldError{Field: n, Code: ErrorReadOnly, Detail: fmt.Sprintf("%s cannot be changed", n)}
+}
+
+// readMergePatch reads the JSON Merge Patch in the body of r. Patches are
+// accepted as application/merge-patch+json or application/json. When the
+// patch cannot be read, the response is written and false returned.
+func readMergePatch(w http.ResponseWriter, r *http.Request) (json.RawMessage, bool) {
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil &&
+		t != mergePatchContentType && t != "application/json" {
+		logger.LogWarnC(r.Context(), "unsupported patch type: %s", t)
+		w.Header().Set("Accept-Patch", mergePatchContentType)
+		writeError(w, r, http.StatusUnsupportedMediaType, nil, nil, "")
+		return nil, false
+	}
+	var patch json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeDecodingError(w, r, err)
+		return nil, false
+	}
+	return patch, true
+}
@@ -0,0 +1,211 @@
+package sfu
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// examples of RFC 7396, appendix A
+	tests := []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		var target, patch any
+		if err := json.Unmarshal([]byte(test.target), &target); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(test.patch), &patch); err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(mergePatch(target, patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("mergePatch(%s, %s) = %s, want %s", test.target, test.patch, got, test.want)
+		}
+	}
+}
+
+func TestUpdateSessionPatch(t *testing.T) {
+	h := NewWebRtcSessionHandler(WithLimits(Limits{Session: SessionLimits{MaxParticipants: 10}}))
+	created, _ := h.CreateSession(CreateSessionParams{Name: "standup", Metadata: map[string]string{"team": "core", "floor": "2"}})
+	id := created.Session.Id
+
+	result, err := h.UpdateSession(UpdateSessionParams{Id: id, Patch: json.RawMessage(`{"name":"retro","metadata":{"floor":null,"room":"b"}}`)})
+	if err != nil || result.Errors != nil || result.Session == nil {
+		t.Fatalf("UpdateSession() = %+v, %v", result, err)
+	}
+	want := map[string]string{"team": "core", "room": "b"}
+	if s := result.Session; s.Name != "retro" || !equalMetadata(s.Metadata, want) || s.Revision != created.Session.Revision+1 {
+		t.Errorf("UpdateSession() = %+v, want retro with metadata %v at revision %d", s, want, created.Session.Revision+1)
+	}
+	result, _ = h.UpdateSession(UpdateSessionParams{Id: id, Patch: json.RawMessage(`{"limits":{"maxParticipants":50}}`)})
+	if result.Session == nil || result.Session.Limits.MaxParticipants != 10 {
+		t.Errorf("UpdateSession() of limits above the server's = %+v, want limits capped to 10", result)
+	}
+	revision := result.Session.Revision
+	if result, _ = h.UpdateSession(UpdateSessionParams{Id: id, Patch: json.RawMessage(`{"name":"retro"}`)}); result.Session == nil || result.Session.Revision != revision {
+		t.Errorf("UpdateSession() changing nothing = %+v, want revision %d", result, revision)
+	}
+
+	tests := []struct {
+		patch string
+		field string
+		code  ErrorCode
+	}{
+		{`{"id":"0000000000"}`, "id", ErrorReadOnly},
+		{`{"creationDateTime":"2020-01-01T00:00:00Z"}`, "creationDateTime", ErrorReadOnly},
+		{`{"revision":99}`, "revision", ErrorReadOnly},
+		{`{"name":null}`, "name", ErrorBlank},
+		{`{"limits":{"maxParticipants":-1}}`, "limits.maxParticipants", ErrorNegative},
+		{`{"owner":"alice"}`, "patch", ErrorInvalidValue},
+		{`{"name":1}`, "patch", ErrorInvalidValue},
+		{`{"metadata":{"notes":"` + strings.Repeat("x", MaxMetadataValueLen+1) + `"}}`, "metadata.notes", ErrorTooLarge},
+		{`{"metadata":{"` + strings.Repeat("k", MaxMetadataKeyLen+1) + `":"v"}}`, "metadata." + strings.Repeat("k", MaxMetadataKeyLen+1), ErrorTooLarge},
+		{`{"metadata":` + manyEntries(MaxMetadataEntries) + `}`, "metadata", ErrorTooLarge},
+	}
+	for _, test := range tests {
+		result, err := h.UpdateSession(UpdateSessionParams{Id: id, Patch: json.RawMessage(test.patch)})
+		if err != nil || !hasFieldError(result.FieldErrors, test.field, test.code) {
+			t.Errorf("UpdateSession(%.80s) = %+v, %v, want %s error of %s", test.patch, result.FieldErrors, err, test.code, test.field)
+		}
+	}
+	got, _ := h.GetSession(GetSessionParams{Id: id})
+	if got.Session.Revision != revision {
+		t.Errorf("session revision = %d after rejected patches, want %d", got.Session.Revision, revision)
+	}
+}
+
+func TestUpdateParticipantPatch(t *testing.T) {
+	h := NewWebRtcSessionHandler()
+	created, _ := h.CreateSession(CreateSessionParams{Name: "standup"})
+	added, _ := h.AddParticipant(AddParticipantParams{SessionId: created.Session.Id, Name: "alice", Metadata: map[string]string{"role": "host"}})
+	p := added.Participant
+
+	result, err := h.UpdateParticipant(UpdateParticipantParams{SessionId: p.SessionId, ParticipantId: p.Id,
+		Patch: json.RawMessage(`{"metadata":{"role":null,"camera":"off"}}`)})
+	if err != nil || result.Errors != nil || result.Participant == nil {
+		t.Fatalf("UpdateParticipant() = %+v, %v", result, err)
+	}
+	want := map[string]string{"camera": "off"}
+	if got := result.Participant; got.Name != "alice" || !equalMetadata(got.Metadata, want) || got.Revision != p.Revision+1 {
+		t.Errorf("UpdateParticipant() = %+v, want alice with metadata %v at revision %d", got, want, p.Revision+1)
+	}
+
+	tests := []struct {
+		patch string
+		field string
+		code  ErrorCode
+	}{
+		{`{"id":"0000000000"}`, "id", ErrorReadOnly},
+		{`{"sessionId":"0000000000"}`, "sessionId", ErrorReadOnly},
+		{`{"connectionState":"connected"}`, "connectionState", ErrorReadOnly},
+		{`{"revision":99}`, "revision", ErrorReadOnly},
+		{`{"name":" "}`, "name", ErrorBlank},
+		{`{"muted":true}`, "patch", ErrorInvalidValue},
+		{`{"metadata":{"notes":"` + strings.Repeat("x", MaxMetadataValueLen+1) + `"}}`, "metadata.notes", ErrorTooLarge},
+	}
+	for _, test := range tests {
+		result, err := h.UpdateParticipant(UpdateParticipantParams{SessionId: p.SessionId, ParticipantId: p.Id, Patch: json.RawMessage(test.patch)})
+		if err != nil || !hasFieldError(result.FieldErrors, test.field, test.code) {
+			t.Errorf("UpdateParticipant(%.80s) = %+v, %v, want %s error of %s", test.patch, result.FieldErrors, err, test.code, test.field)
+		}
+	}
+}
+
+func TestPatchContentType(t *testing.T) {
+	h := NewWebRtcSessionHandler()
+	ts := httptest.NewServer((&Server{}).newRouter(h))
+	defer ts.Close()
+	created, _ := h.CreateSession(CreateSessionParams{Name: "standup"})
+	tests := []struct {
+		contentType string
+		body        string
+		want        int
+	}{
+		{mergePatchContentType, `{"name":"retro"}`, http.StatusOK},
+		{"application/json; charset=utf-8", `{"name":"daily"}`, http.StatusOK},
+		{"text/plain", `{"name":"weekly"}`, http.StatusUnsupportedMediaType},
+		{"application/json-patch+json", `[{"op":"replace","path":"/name","value":"weekly"}]`, http.StatusUnsupportedMediaType},
+		{mergePatchContentType, `{"name":`, http.StatusBadRequest},
+		{mergePatchContentType, `{"id":"0000000000"}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodPatch, ts.URL+"/v2/sessions/"+created.Session.Id, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", test.contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.want {
+			t.Errorf("PATCH %s as %s = %d, want %d", test.body, test.contentType, resp.StatusCode, test.want)
+		}
+		if test.want == http.StatusUnsupportedMediaType && resp.Header.Get("Accept-Patch") != mergePatchContentType {
+			t.Errorf("PATCH as %s Accept-Patch = %q, want %s", test.contentType, resp.Header.Get("Accept-Patch"), mergePatchContentType)
+		}
+	}
+	got, _ := h.GetSession(GetSessionParams{Id: created.Session.Id})
+	if got.Session.Name != "daily" {
+		t.Errorf("session name = %s, want daily", got.Session.Name)
+	}
+}
+
+// manyEntries returns a JSON object with n+1 metadata entries.
+func manyEntries(n int) string {
+	m := make(map[string]string)
+	for i := 0; i <= n; i++ {
+		m[strings.Repeat("k", i+1)] = "v"
+	}
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+// hasFieldError returns whether errors has an error of field with code.
+func hasFieldError(errors []FieldError, field string, code ErrorCode) bool {
+	for _, e := range errors {
+		if e.Field == field && e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// equalMetadata returns whether metadata a and b have the same entries.
+func equalMetadata(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,298 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsConfig holds the configuration of Metrics.
+type MetricsConfig struct {
+	// Address of a separate listener serving /metrics. Metrics are served
+	// on the server's own router when blank.
+	Address string
+	// Whether per session metrics, labelled with session ids, are exported.
+	// Off by default since it creates one time series per session.
+	PerSessionLabels bool
+}
+
+// Metrics collects Prometheus metrics of a Server and its session handler.
+type Metrics struct {
+	config   MetricsConfig
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	server   *http.Server
+}
+
+// NewMetrics creates and returns a properly initialized Metrics instance.
+func NewMetrics(config MetricsConfig) *Metrics {
+	m := &Metrics{
+		config:   config,
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "blackbird_http_requests_total",
+			Help: "Number of REST API requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "blackbird_http_request_duration_seconds",
+			Help:    "Latency of REST API requests by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+	}
+	m.registry.MustRegister(m.requests, m.latency,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	if len(config.Address) > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.Handler())
+		m.server = &http.Server{Addr: config.Address, Handler: mux}
+	}
+	return m
+}
+
+// Handler returns the http handler serving all metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// listenAndServe serves all metrics on the configured separate listener.
+func (m *Metrics) listenAndServe() {
+	logger.LogInfoF("Serving metrics on %s...", m.config.Address)
+	if err := m.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.LogErrorF("metrics listener failed: %s", err)
+	}
+}
+
+// shutdown stops the separate listener, if any, once all pending scrapes
+// are served.
+func (m *Metrics) shutdown(ctx context.Context) error {
+	if m.server == nil {
+		return nil
+	}
+	return m.server.Shutdown(ctx)
+}
+
+// middleware records the count and latency of every request.
+func (m *Metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+		m.latency.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(route, r.Method, strconv.Itoa(sw.status)).Inc()
+	})
+}
+
+// statusWriter is a http.ResponseWriter which keeps track of the response
+// status.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush supports streaming responses through the writer.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// snapshotSource is implemented by session handlers which can provide a
+// snapshot of all their sessions and participants.
+type snapshotSource interface {
+	snapshot() []SessionRecord
+}
+
+// mediaSource is implemented by session handlers which forward media,
+// counting it in total and per session.
+type mediaSource interface {
+	mediaCounts() (total mediaCounts, sessions map[string]mediaCounts)
+}
+
+// registerHandler registers the metrics of a session handler.
+func (m *Metrics) registerHandler(source snapshotSource) {
+	media, _ := source.(mediaSource)
+	m.registry.MustRegister(&handlerCollector{
+		source:           source,
+		media:            media,
+		perSessionLabels: m.config.PerSessionLabels,
+	})
+}
+
+// mediaCounters counts the media forwarded by a session handler or one of
+// its sessions, and the feedback of the participants receiving it. They
+// are updated by the forwarding goroutines without holding any lock.
+type mediaCounters struct {
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+	// Packets of published tracks never received by the handler.
+	lost  atomic.Uint64
+	nacks atomic.Uint64
+	plis  atomic.Uint64
+}
+
+// mediaCounts is a snapshot of mediaCounters.
+type mediaCounts struct {
+	packets, bytes, lost, nacks, plis uint64
+}
+
+// load returns a snapshot of c.
+func (c *mediaCounters) load() mediaCounts {
+	return mediaCounts{
+		packets: c.packets.Load(),
+		bytes:   c.bytes.Load(),
+		lost:    c.lost.Load(),
+		nacks:   c.nacks.Load(),
+		plis:    c.plis.Load(),
+	}
+}
+
+// sequenceTracker follows the sequence numbers of the packets of a track
+// to count the packets lost on their way to the handler.
+type sequenceTracker struct {
+	last    uint16
+	started bool
+}
+
+// lost returns the number of packets lost since the last packet, given the
+// sequence number of the next received one. Late and duplicate packets
+// lose none.
+func (s *sequenceTracker) lost(sequence uint16) uint64 {
+	if !s.started {
+		s.last, s.started = sequence, true
+		return 0
+	}
+	// sequence numbers wrap around
+	gap := sequence - s.last
+	if gap == 0 || gap >= 1<<15 {
+		return 0
+	}
+	s.last = sequence
+	return uint64(gap - 1)
+}
+
+var (
+	sessionsDesc = prometheus.NewDesc("blackbird_sessions",
+		"Number of active sessions.", nil, nil)
+	participantsDesc = prometheus.NewDesc("blackbird_participants",
+		"Number of active participants.", nil, nil)
+	connectionStatesDesc = prometheus.NewDesc("blackbird_participant_connection_states",
+		"Number of participants by their last reported connection state.", []string{"state"}, nil)
+	sessionParticipantsDesc = prometheus.NewDesc("blackbird_session_participants",
+		"Number of participants of a session.", []string{"session_id"}, nil)
+	// media counters, in total or labelled with session ids
+	mediaDescs = func(prefix string, labels ...string) [5]*prometheus.Desc {
+		return [5]*prometheus.Desc{
+			prometheus.NewDesc(prefix+"forwarded_packets_total",
+				"Number of RTP packets forwarded to participants.", labels, nil),
+			prometheus.NewDesc(prefix+"forwarded_bytes_total",
+				"Number of bytes of RTP packets forwarded to participants.", labels, nil),
+			prometheus.NewDesc(prefix+"lost_packets_total",
+				"Number of RTP packets of published tracks lost before reaching the server.", labels, nil),
+			prometheus.NewDesc(prefix+"nacks_total",
+				"Number of NACKs sent by participants receiving tracks.", labels, nil),
+			prometheus.NewDesc(prefix+"plis_total",
+				"Number of PLIs sent by participants receiving tracks.", labels, nil),
+		}
+	}
+	mediaTotalDescs   = mediaDescs("blackbird_")
+	sessionMediaDescs = mediaDescs("blackbird_session_", "session_id")
+)
+
+// collectMedia sends the counter metrics of counts described by descs.
+func collectMedia(ch chan<- prometheus.Metric, descs [5]*prometheus.Desc, counts mediaCounts, labels ...string) {
+	for i, v := range []uint64{counts.packets, counts.bytes, counts.lost, counts.nacks, counts.plis} {
+		ch <- prometheus.MustNewConstMetric(descs[i], prometheus.CounterValue, float64(v), labels...)
+	}
+}
+
+// handlerCollector collects session and participant gauges from a
+// snapshot of a session handler taken on every scrape.
+type handlerCollector struct {
+	source snapshotSource
+	// Media counters of the handler, if it forwards media.
+	media            mediaSource
+	perSessionLabels bool
+}
+
+func (c *handlerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sessionsDesc
+	ch <- participantsDesc
+	ch <- connectionStatesDesc
+	if c.perSessionLabels {
+		ch <- sessionParticipantsDesc
+	}
+	if c.media != nil {
+		for i := range mediaTotalDescs {
+			ch <- mediaTotalDescs[i]
+			if c.perSessionLabels {
+				ch <- sessionMediaDescs[i]
+			}
+		}
+	}
+}
+
+func (c *handlerCollector) Collect(ch chan<- prometheus.Metric) {
+	records := c.source.snapshot()
+	participants := 0
+	states := map[ConnectionState]int{
+		ConnectionNew:          0,
+		ConnectionConnected:    0,
+		ConnectionDisconnected: 0,
+		ConnectionFailed:       0,
+	}
+	for _, r := range records {
+		participants += len(r.Participants)
+		for _, p := range r.Participants {
+			states[p.ConnectionState]++
+		}
+		if c.perSessionLabels {
+			ch <- prometheus.MustNewConstMetric(sessionParticipantsDesc, prometheus.GaugeValue,
+				float64(len(r.Participants)), r.Session.Id)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(sessionsDesc, prometheus.GaugeValue, float64(len(records)))
+	ch <- prometheus.MustNewConstMetric(participantsDesc, prometheus.GaugeValue, float64(participants))
+	for state, count := range states {
+		ch <- prometheus.MustNewConstMetric(connectionStatesDesc, prometheus.GaugeValue, float64(count), string(state))
+	}
+	if c.media == nil {
+		return
+	}
+	total, sessions := c.media.mediaCounts()
+	collectMedia(ch, mediaTotalDescs, total)
+	if c.perSessionLabels {
+		for id, counts := range sessions {
+			collectMedia(ch, sessionMediaDescs, counts, id)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package sfu
+
+import (
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metricValue returns the value of the counter, gauge or histogram sample
+// count name with labels gathered by m, and whether it exists.
+func metricValue(t *testing.T, m *Metrics, name string, labels map[string]string) (float64, bool) {
+	t.Helper()
+	families, err := m.registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range f.GetMetric() {
+			found := 0
+			for _, l := range metric.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok {
+					if v != l.GetValue() {
+						continue metrics
+					}
+					found++
+				}
+			}
+			if found != len(labels) {
+				continue
+			}
+			switch {
+			case metric.GetCounter() != nil:
+				return metric.GetCounter().GetValue(), true
+			case metric.GetGauge() != nil:
+				return metric.GetGauge().GetValue(), true
+			case metric.GetHistogram() != nil:
+				return float64(metric.GetHistogram().GetSampleCount()), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics(MetricsConfig{PerSessionLabels: true})
+	h := NewWebRtcSessionHandler()
+	ts := httptest.NewServer((&Server{Metrics: m}).newRouter(h))
+	defer ts.Close()
+
+	var first, second CreateSessionResult
+	tracedRequest(t, ts, http.MethodPost, "/v1/sessions", `{"name":"first"}`, &first)
+	tracedRequest(t, ts, http.MethodPost, "/v1/sessions", `{"name":"second"}`, &second)
+	unknown, err := http.Get(ts.URL + "/v1/sessions/0000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown.Body.Close()
+	for _, id := range []string{first.Session.Id, first.Session.Id, second.Session.Id} {
+		tracedRequest(t, ts, http.MethodPost, "/v1/sessions/"+id+"/participants", `{"name":"alice"}`, nil)
+	}
+
+	requests := []struct {
+		route  string
+		method string
+		status string
+		want   float64
+	}{
+		{"/{version}/sessions", http.MethodPost, "201", 2},
+		{"/{version}/sessions/{sessionId}", http.MethodGet, "404", 1},
+		{"/{version}/sessions/{sessionId}/participants", http.MethodPost, "201", 3},
+	}
+	for _, r := range requests {
+		labels := map[string]string{"route": r.route, "method": r.method, "status": r.status}
+		if got, _ := metricValue(t, m, "blackbird_http_requests_total", labels); got != r.want {
+			t.Errorf("blackbird_http_requests_total%v = %v, want %v", labels, got, r.want)
+		}
+		delete(labels, "status")
+		if got, _ := metricValue(t, m, "blackbird_http_request_duration_seconds", labels); got != r.want {
+			t.Errorf("blackbird_http_request_duration_seconds%v count = %v, want %v", labels, got, r.want)
+		}
+	}
+
+	gauges := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"blackbird_sessions", nil, 2},
+		{"blackbird_participants", nil, 3},
+		{"blackbird_participant_connection_states", map[string]string{"state": "new"}, 3},
+		{"blackbird_participant_connection_states", map[string]string{"state": "connected"}, 0},
+		{"blackbird_session_participants", map[string]string{"session_id": first.Session.Id}, 2},
+		{"blackbird_session_participants", map[string]string{"session_id": second.Session.Id}, 1},
+	}
+	for _, g := range gauges {
+		if got, ok := metricValue(t, m, g.name, g.labels); !ok || got != g.want {
+			t.Errorf("%s%v = %v, %v, want %v", g.name, g.labels, got, ok, g.want)
+		}
+	}
+
+	tracedRequest(t, ts, http.MethodDelete, "/v1/sessions/"+first.Session.Id, "", nil)
+	if got, _ := metricValue(t, m, "blackbird_sessions", nil); got != 1 {
+		t.Errorf("blackbird_sessions after a deletion = %v, want 1", got)
+	}
+	if got, _ := metricValue(t, m, "blackbird_participants", nil); got != 1 {
+		t.Errorf("blackbird_participants after a deletion = %v, want 1", got)
+	}
+	labels := map[string]string{"session_id": first.Session.Id}
+	if _, ok := metricValue(t, m, "blackbird_session_participants", labels); ok {
+		t.Errorf("blackbird_session_participants%v exists after the deletion of the session", labels)
+	}
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "blackbird_sessions 1") {
+		t.Errorf("GET /metrics = %d, %s", resp.StatusCode, body)
+	}
+}
+
+func TestMetricsWithoutPerSessionLabels(t *testing.T) {
+	m := NewMetrics(MetricsConfig{})
+	h := NewWebRtcSessionHandler()
+	ts := httptest.NewServer((&Server{Metrics: m}).newRouter(h))
+	defer ts.Close()
+	var created CreateSessionResult
+	tracedRequest(t, ts, http.MethodPost, "/v1/sessions", `{"name":"session"}`, &created)
+	tracedRequest(t, ts, http.MethodPost, "/v1/sessions/"+created.Session.Id+"/participants", `{"name":"alice"}`, nil)
+	if _, ok := metricValue(t, m, "blackbird_session_participants", nil); ok {
+		t.Error("blackbird_session_participants exists without per session labels")
+	}
+	if got, _ := metricValue(t, m, "blackbird_participants", nil); got != 1 {
+		t.Errorf("blackbird_participants = %v, want 1", got)
+	}
+}
+
+func TestSequenceTrackerLost(t *testing.T) {
+	var s sequenceTracker
+	tests := []struct {
+		sequence uint16
+		want     uint64
+	}{
+		{65530, 0},
+		{65531, 0},
+		{65534, 2},
+		// late and duplicate packets
+		{65532, 0},
+		{65534, 0},
+		// wrapping around
+		{1, 2},
+	}
+	for _, test := range tests {
+		if got := s.lost(test.sequence); got != test.want {
+			t.Errorf("lost(%d) = %d, want %d", test.sequence, got, test.want)
+		}
+	}
+}
+
+func TestMediaMetrics(t *testing.T) {
+	for _, perSessionLabels := range []bool{true, false} {
+		m := NewMetrics(MetricsConfig{PerSessionLabels: perSessionLabels})
+		h := NewWebRtcSessionHandler(WithSettingEngine(loopbackSettings()))
+		m.registerHandler(h)
+		created, _ := h.CreateSession(CreateSessionParams{Name: "standup"})
+		sessionId := created.Session.Id
+		alice, _ := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: "alice"})
+		bob, _ := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: "bob"})
+
+		publishVideo(t, h, sessionId, alice.Participant.Id)
+		subscriber := newTestPeer(t)
+		if _, err := subscriber.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+			webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			t.Fatal(err)
+		}
+		received := make(chan webrtc.SSRC, 1)
+		subscriber.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+			received <- remote.SSRC()
+			for {
+				if _, _, err := remote.ReadRTP(); err != nil {
+					return
+				}
+			}
+		})
+		// bob subscribes once the track of alice is published
+		waitUntil(t, func() bool {
+			result, _ := h.GetParticipant(GetParticipantParams{SessionId: sessionId, ParticipantId: alice.Participant.Id})
+			return len(result.Participant.Tracks) == 1
+		})
+		negotiate(t, h, sessionId, bob.Participant.Id, subscriber)
+		var ssrc webrtc.SSRC
+		select {
+		case ssrc = <-received:
+		case <-time.After(10 * time.Second):
+			t.Fatal("bob did not receive the track of alice")
+		}
+		if err := subscriber.WriteRTCP([]rtcp.Packet{
+			&rtcp.PictureLossIndication{MediaSSRC: uint32(ssrc)},
+			&rtcp.TransportLayerNack{MediaSSRC: uint32(ssrc), Nacks: []rtcp.NackPair{{PacketID: 1}}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		sessionLabels := map[string]string{"session_id": sessionId}
+		for _, name := range []string{"forwarded_packets_total", "forwarded_bytes_total", "plis_total", "nacks_total"} {
+			waitUntil(t, func() bool {
+				got, _ := metricValue(t, m, "blackbird_"+name, nil)
+				return got > 0
+			})
+			got, ok := metricValue(t, m, "blackbird_session_"+name, sessionLabels)
+			if perSessionLabels && (!ok || got == 0) {
+				t.Errorf("blackbird_session_%s%v = %v, %v, want forwarded media counted", name, sessionLabels, got, ok)
+			} else if !perSessionLabels && ok {
+				t.Errorf("blackbird_session_%s exists without per session labels", name)
+			}
+		}
+		if got, ok := metricValue(t, m, "blackbird_lost_packets_total", nil); !ok || got != 0 {
+			t.Errorf("blackbird_lost_packets_total = %v, %v, want 0", got, ok)
+		}
+
+		// totals outlive sessions
+		h.DeleteSession(DeleteSessionParams{Id: sessionId})
+		if got, _ := metricValue(t, m, "blackbird_forwarded_packets_total", nil); got == 0 {
+			t.Error("blackbird_forwarded_packets_total was reset by the deletion of the session")
+		}
+		if _, ok := metricValue(t, m, "blackbird_session_forwarded_packets_total", sessionLabels); ok {
+			t.Errorf("blackbird_session_forwarded_packets_total%v exists after the deletion of the session", sessionLabels)
+		}
+	}
+}
+
+// waitUntil waits until done returns true.
+func waitUntil(t *testing.T, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+	}
+}
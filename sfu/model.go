@@ -1,34 +1,227 @@
 package sfu
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 // Session holds all information related to a single
 // live view session.
 type Session struct {
-	Name             string `json:"name"`
-	Id               string `json:"id"`
-	CreationDateTime string `json:"creationDateTime"`
+	Name             string        `json:"name"`
+	Id               string        `json:"id"`
+	CreationDateTime string        `json:"creationDateTime"`
+	Limits           SessionLimits `json:"limits"`
+	// Free-form tags of the session, e.g. to find it when listing sessions.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Whether the tracks published to the session are recorded, in the
+	// recording directory of the server.
+	Recording bool `json:"recording,omitempty"`
+	// Revision of the session, incremented on every change. It is given
+	// as the ETag of the session.
+	Revision int64 `json:"revision"`
 }
 
 // Participant holds all information related to a single
 // participant of a live view session.
 type Participant struct {
-	Name             string `json:"name"`
-	Id               string `json:"id"`
-	SessionId        string `json:"sessionId"`
-	CreationDateTime string `json:"creationDateTime"`
+	Name             string          `json:"name"`
+	Id               string          `json:"id"`
+	SessionId        string          `json:"sessionId"`
+	CreationDateTime string          `json:"creationDateTime"`
+	ConnectionState  ConnectionState `json:"connectionState"`
+	// Tracks published by the participant, forwarded to the other
+	// participants of its session.
+	Tracks []Track `json:"tracks,omitempty"`
+	// Free-form tags of the participant, e.g. the id of the user.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Whether the participant is another node of the cluster, relaying
+	// the tracks of the participants whose media it serves. Their tracks
+	// are reported as theirs, not as the relay's.
+	Relay bool `json:"relay,omitempty"`
+	// Revision of the participant, incremented on every change. It is
+	// given as the ETag of the participant.
+	Revision int64 `json:"revision"`
+}
+
+// clone returns a deep copy of the session, safe to use once the lock
+// guarding s is released.
+func (s Session) clone() *Session {
+	s.Metadata = cloneMetadata(s.Metadata)
+	return &s
+}
+
+// clone returns a deep copy of the participant, safe to use once the lock
+// guarding p is released.
+func (p Participant) clone() *Participant {
+	p.Metadata = cloneMetadata(p.Metadata)
+	if p.Tracks != nil {
+		p.Tracks = append([]Track(nil), p.Tracks...)
+	}
+	return &p
+}
+
+// TrackKind identifies the kind of media of a track.
+type TrackKind string
+
+const (
+	AudioTrack TrackKind = "audio"
+	VideoTrack TrackKind = "video"
+)
+
+// Track holds information about a media track published by a
+// participant of a live view session.
+type Track struct {
+	Id   string    `json:"id"`
+	Kind TrackKind `json:"kind"`
+}
+
+// cloneMetadata returns a copy of metadata m, or nil if m is nil.
+func cloneMetadata(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	cloned := make(map[string]string, len(m))
+	for k, v := range m {
+		cloned[k] = v
+	}
+	return cloned
+}
+
+// ConnectionState holds the state of a participant's connection, as
+// last reported by the participant or observed on its PeerConnection.
+type ConnectionState string
+
+const (
+	ConnectionNew          ConnectionState = "new"
+	ConnectionConnected    ConnectionState = "connected"
+	ConnectionDisconnected ConnectionState = "disconnected"
+	ConnectionFailed       ConnectionState = "failed"
+)
+
+// check verifies whether the connection state is a known one.
+func (c ConnectionState) check(n string) *FieldError {
+	switch c {
+	case ConnectionNew, ConnectionConnected, ConnectionDisconnected, ConnectionFailed:
+		return nil
+	}
+	return &FieldError{Field: n, Code: ErrorInvalidValue,
+		Detail: fmt.Sprintf("%s must be one of new, connected, disconnected or failed", n)}
+}
+
+// ErrorCode is a machine-readable code identifying an error found in the
+// parameters of an operation.
+type ErrorCode string
+
+const (
+	// ErrorBlank is reported for required fields which are blank.
+	ErrorBlank ErrorCode = "blank"
+	// ErrorInvalidId is reported for fields which are not valid ids.
+	ErrorInvalidId ErrorCode = "invalidId"
+	// ErrorNegative is reported for fields which must not be negative.
+	ErrorNegative ErrorCode = "negative"
+	// ErrorInvalidValue is reported for fields with a value outside of the
+	// values they accept.
+	ErrorInvalidValue ErrorCode = "invalidValue"
+	// ErrorNotFound is reported for fields referring to objects which do not
+	// exist.
+	ErrorNotFound ErrorCode = "notFound"
+	// ErrorLimitReached is reported when a capacity limit prevents an
+	// operation.
+	ErrorLimitReached ErrorCode = "limitReached"
+	// ErrorMalformedBody is reported for request bodies which cannot be
+	// decoded.
+	ErrorMalformedBody ErrorCode = "malformedBody"
+	// ErrorTooLarge is reported for fields exceeding their maximum size.
+	ErrorTooLarge ErrorCode = "tooLarge"
+	// ErrorReadOnly is reported for updates of fields which cannot be
+	// changed.
+	ErrorReadOnly ErrorCode = "readOnly"
+	// ErrorPreconditionFailed is reported for operations whose conditions
+	// on the revision of their object do not hold.
+	ErrorPreconditionFailed ErrorCode = "preconditionFailed"
+	// ErrorIdempotencyKeyReused is reported for calls repeating the
+	// idempotency key of a call with other parameters.
+	ErrorIdempotencyKeyReused ErrorCode = "idempotencyKeyReused"
+)
+
+// FieldError holds an error found in the parameters of an operation, or
+// preventing it.
+type FieldError struct {
+	// Field the error is about, blank for errors not about a single field.
+	Field string `json:"field,omitempty"`
+	// Machine-readable code of the error.
+	Code ErrorCode `json:"code"`
+	// Human-readable description of the error.
+	Detail string `json:"detail"`
+}
+
+func (e FieldError) Error() string {
+	return e.Detail
+}
+
+// UnmarshalJSON decodes an error, accepting the plain messages reported
+// by v1 of the REST API as well.
+func (e *FieldError) UnmarshalJSON(data []byte) error {
+	var detail string
+	if err := json.Unmarshal(data, &detail); err == nil {
+		*e = FieldError{Detail: detail}
+		return nil
+	}
+	type fieldError FieldError
+	return json.Unmarshal(data, (*fieldError)(e))
+}
+
+// errorDetails returns the details of errors.
+func errorDetails(errors []FieldError) []string {
+	if errors == nil {
+		return nil
+	}
+	details := make([]string, len(errors))
+	for i, e := range errors {
+		details[i] = e.Detail
+	}
+	return details
+}
+
+// hasErrorCode returns whether any of errors has code c.
+func hasErrorCode(errors []FieldError, c ErrorCode) bool {
+	for _, e := range errors {
+		if e.Code == c {
+			return true
+		}
+	}
+	return false
 }
 
 // CreateSessionParams holds all parameters required
 // to create a new live view session.
 type CreateSessionParams struct {
 	Name string `json:"name"`
+	// Optional session limits. They are capped by the limits of the
+	// server hosting the session.
+	Limits *SessionLimits `json:"limits,omitempty"`
+	// Optional metadata of the session.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Optional idempotency key. Repeating the call with the same key and
+	// parameters returns the result of the first call instead of creating
+	// another session.
+	IdempotencyKey string `json:"-"`
 }
 
 // check verifies whether all provided parameters are valid. It will
 // return a slice with all the errors found or nil if no errors exist.
-func (p CreateSessionParams) check() []string {
-	var errors []string
+func (p CreateSessionParams) check() []FieldError {
+	var errors []FieldError
 	if err := isNotBlank("name", p.Name); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
+	}
+	if p.Limits != nil {
+		errors = append(errors, p.Limits.check()...)
+	}
+	errors = append(errors, checkMetadata("metadata", p.Metadata)...)
+	if err := isIdempotencyKey(idempotencyKeyHeader, p.IdempotencyKey); err != nil {
+		errors = append(errors, *err)
 	}
 	return errors
 }
@@ -40,6 +233,42 @@ type CreateSessionResult struct {
 	Session *Session `json:"session,omitempty"`
 	// Slices with all errors that prevented a session to be created. Can be nil.
 	Errors []string `json:"errors,omitempty"`
+	// Errors, with a machine-readable code each. Details of the errors are
+	// the messages of Errors.
+	FieldErrors []FieldError `json:"-"`
+	// Capacity limit that prevented a session to be created, if any.
+	Limit LimitKind `json:"limit,omitempty"`
+}
+
+// UpdateSessionParams holds the parameters to update an existing live
+// view session.
+type UpdateSessionParams struct {
+	Id string `json:"id"`
+	// JSON Merge Patch document (RFC 7396) applied to the session. Only
+	// the name, limits, metadata and recording of sessions can be changed.
+	Patch json.RawMessage `json:"patch"`
+	// Conditions on the revision of the session to update.
+	Conditions Conditions `json:"-"`
+}
+
+// check verifies whether all provided parameters are valid. It will
+// return a slice with all the errors found or nil if no errors exist.
+func (p UpdateSessionParams) check() []FieldError {
+	var errors []FieldError
+	if err := isId("id", p.Id); err != nil {
+		errors = append(errors, *err)
+	}
+	if err := isMergePatch("patch", p.Patch); err != nil {
+		errors = append(errors, *err)
+	}
+	return errors
+}
+
+// UpdateSessionResult holds the result of UpdateSession operations.
+type UpdateSessionResult struct {
+	Session     *Session     `json:"session,omitempty"`
+	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
 }
 
 // GetSessionParams holds all parameters required to
@@ -50,10 +279,10 @@ type GetSessionParams struct {
 
 // check verifies whether all provided parameters are valid. It will
 // return a slice with all the errors found or nil if no errors exist.
-func (p GetSessionParams) check() []string {
-	var errors []string
+func (p GetSessionParams) check() []FieldError {
+	var errors []FieldError
 	if err := isId("id", p.Id); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
 	return errors
 }
@@ -61,22 +290,25 @@ func (p GetSessionParams) check() []string {
 // GetSessionResult holds the result of GetSession
 // operations.
 type GetSessionResult struct {
-	Session *Session `json:"session,omitempty"`
-	Errors  []string `json:"errors,omitempty"`
+	Session     *Session     `json:"session,omitempty"`
+	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
 }
 
 // DeleteSessionParams holds the parameters required to
 // delete an existing live view session.
 type DeleteSessionParams struct {
 	Id string `json:"id"`
+	// Conditions on the revision of the session to delete.
+	Conditions Conditions `json:"-"`
 }
 
 // check verifies whether all provided parameters are valid. It will
 // return a slice with all the errors found or nil if no errors exist.
-func (p DeleteSessionParams) check() []string {
-	var errors []string
+func (p DeleteSessionParams) check() []FieldError {
+	var errors []FieldError
 	if err := isId("id", p.Id); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
 	return errors
 }
@@ -84,8 +316,90 @@ func (p DeleteSessionParams) check() []string {
 // DeleteSessionResult holds the result of DeleteSession
 // operations.
 type DeleteSessionResult struct {
-	Session *Session `json:"session,omitempty"`
-	Errors  []string `json:"errors,omitempty"`
+	Session     *Session     `json:"session,omitempty"`
+	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
+}
+
+// SessionSort identifies the order in which sessions are listed.
+type SessionSort string
+
+const (
+	// SortByCreation lists sessions from the oldest to the newest.
+	SortByCreation SessionSort = "creationDateTime"
+	// SortByName lists sessions by name, in lexical order.
+	SortByName SessionSort = "name"
+)
+
+const (
+	// DefaultListLimit is the number of sessions of a page when no other
+	// limit is requested.
+	DefaultListLimit = 50
+	// MaxListLimit is the maximum number of sessions of a page.
+	MaxListLimit = 500
+)
+
+// ListSessionsParams holds the parameters to list existing live view
+// sessions, a page at a time. Only sessions matching all filters are
+// listed.
+type ListSessionsParams struct {
+	// Prefix of the names of listed sessions.
+	NamePrefix string `json:"namePrefix,omitempty"`
+	// Only sessions created before this RFC 3339 date time are listed.
+	CreatedBefore string `json:"createdBefore,omitempty"`
+	// Only sessions created after this RFC 3339 date time are listed.
+	CreatedAfter string `json:"createdAfter,omitempty"`
+	// Whether only sessions with, or without, participants are listed.
+	// Sessions are listed regardless of their participants when nil.
+	HasParticipants *bool `json:"hasParticipants,omitempty"`
+	// Metadata entries all listed sessions have.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Order of listed sessions, SortByCreation when blank.
+	Sort SessionSort `json:"sort,omitempty"`
+	// Whether sessions are listed in descending order.
+	Descending bool `json:"descending,omitempty"`
+	// Maximum number of sessions of the page, DefaultListLimit when zero.
+	Limit int `json:"limit,omitempty"`
+	// Cursor of the page, as returned with the previous page. The first
+	// page is listed when blank.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// check verifies whether all provided parameters are valid. It will
+// return a slice with all the errors found or nil if no errors exist.
+func (p ListSessionsParams) check() []FieldError {
+	var errors []FieldError
+	if err := isDateTime("createdBefore", p.CreatedBefore); err != nil {
+		errors = append(errors, *err)
+	}
+	if err := isDateTime("createdAfter", p.CreatedAfter); err != nil {
+		errors = append(errors, *err)
+	}
+	if p.Sort != "" && p.Sort != SortByCreation && p.Sort != SortByName {
+		errors = append(errors, FieldError{Field: "sort", Code: ErrorInvalidValue,
+			Detail: "sort must be one of creationDateTime or name"})
+	}
+	if p.Limit < 0 || p.Limit > MaxListLimit {
+		errors = append(errors, FieldError{Field: "limit", Code: ErrorInvalidValue,
+			Detail: fmt.Sprintf("limit must be between 0 and %d", MaxListLimit)})
+	}
+	if p.Cursor != "" {
+		if _, err := p.cursor(); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+	return errors
+}
+
+// ListSessionsResult holds the result of ListSessions operations.
+type ListSessionsResult struct {
+	// Sessions of the page, never nil so that empty pages are encoded as
+	// an empty array.
+	Sessions []*Session `json:"sessions"`
+	// Cursor of the next page, blank on the last page.
+	NextCursor  string       `json:"nextCursor,omitempty"`
+	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
 }
 
 // AddParticipantParams encapsulates the parameters
@@ -94,17 +408,30 @@ type DeleteSessionResult struct {
 type AddParticipantParams struct {
 	SessionId string `json:"sessionId"`
 	Name      string `json:"name"`
+	// Optional metadata of the participant.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Optional idempotency key. Repeating the call with the same key and
+	// parameters returns the result of the first call instead of adding
+	// another participant.
+	IdempotencyKey string `json:"-"`
+	// Whether the participant is the relay of another node of the cluster.
+	// Relays are not counted by the participant limits.
+	Relay bool `json:"-"`
 }
 
 // check verifies whether all provided parameters are valid. It will
 // return a slice with all the errors found or nil if no errors exist.
-func (p AddParticipantParams) check() []string {
-	var errors []string
+func (p AddParticipantParams) check() []FieldError {
+	var errors []FieldError
 	if err := isId("sessionId", p.SessionId); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
 	if err := isNotBlank("name", p.Name); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
+	}
+	errors = append(errors, checkMetadata("metadata", p.Metadata)...)
+	if err := isIdempotencyKey(idempotencyKeyHeader, p.IdempotencyKey); err != nil {
+		errors = append(errors, *err)
 	}
 	return errors
 }
@@ -114,6 +441,9 @@ func (p AddParticipantParams) check() []string {
 type AddParticipantResult struct {
 	Participant *Participant `json:"participant,omitempty"`
 	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
+	// Capacity limit that prevented the participant to be added, if any.
+	Limit LimitKind `json:"limit,omitempty"`
 }
 
 // GetParticipantParams hold the required parameters to
@@ -126,13 +456,13 @@ type GetParticipantParams struct {
 
 // check verifies whether all provided parameters are valid. It will
 // return a slice with all the errors found or nil if no errors exist.
-func (p GetParticipantParams) check() []string {
-	var errors []string
+func (p GetParticipantParams) check() []FieldError {
+	var errors []FieldError
 	if err := isId("sessionId", p.SessionId); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
 	if err := isId("participantId", p.ParticipantId); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
 	return errors
 }
@@ -142,6 +472,7 @@ func (p GetParticipantParams) check() []string {
 type GetParticipantResult struct {
 	Participant *Participant `json:"participant,omitempty"`
 	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
 }
 
 // UpdateParticipantParams holds the parameters to
@@ -151,20 +482,30 @@ type UpdateParticipantParams struct {
 	SessionId     string `json:"sessionId"`
 	ParticipantId string `json:"participantId"`
 	Name          string `json:"name"`
+	// Optional JSON Merge Patch document (RFC 7396) applied to the
+	// participant instead of setting its name. Only the name and metadata
+	// of participants can be changed.
+	Patch json.RawMessage `json:"-"`
+	// Conditions on the revision of the participant to update.
+	Conditions Conditions `json:"-"`
 }
 
 // check verifies whether all provided parameters are valid. It will
 // return a slice with all the errors found or nil if no errors exist.
-func (p UpdateParticipantParams) check() []string {
-	var errors []string
+func (p UpdateParticipantParams) check() []FieldError {
+	var errors []FieldError
 	if err := isId("sessionId", p.SessionId); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
 	if err := isId("participantId", p.ParticipantId); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
-	if err := isNotBlank("name", p.Name); err != nil {
-		errors = append(errors, err.Error())
+	if p.Patch != nil {
+		if err := isMergePatch("patch", p.Patch); err != nil {
+			errors = append(errors, *err)
+		}
+	} else if err := isNotBlank("name", p.Name); err != nil {
+		errors = append(errors, *err)
 	}
 	return errors
 }
@@ -174,6 +515,7 @@ func (p UpdateParticipantParams) check() []string {
 type UpdateParticipantResult struct {
 	Participant *Participant `json:"participant,omitempty"`
 	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
 }
 
 // DeleteParticipantParams holds all required parameters
@@ -182,17 +524,19 @@ type UpdateParticipantResult struct {
 type DeleteParticipantParams struct {
 	SessionId     string `json:"sessionId"`
 	ParticipantId string `json:"participantId"`
+	// Conditions on the revision of the participant to remove.
+	Conditions Conditions `json:"-"`
 }
 
 // check verifies whether all provided parameters are valid. It will
 // return a slice with all the errors found or nil if no errors exist.
-func (p DeleteParticipantParams) check() []string {
-	var errors []string
+func (p DeleteParticipantParams) check() []FieldError {
+	var errors []FieldError
 	if err := isId("sessionId", p.SessionId); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
 	if err := isId("participantId", p.ParticipantId); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
 	return errors
 }
@@ -202,6 +546,7 @@ func (p DeleteParticipantParams) check() []string {
 type DeleteParticipantResult struct {
 	Participant *Participant `json:"participant,omitempty"`
 	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
 }
 
 // GetParticipantsParams holds the required parameters to
@@ -210,10 +555,10 @@ type GetParticipantsParams struct {
 	SessionId string `json:"sessionId"`
 }
 
-func (p GetParticipantsParams) check() []string {
-	var errors []string
+func (p GetParticipantsParams) check() []FieldError {
+	var errors []FieldError
 	if err := isId("sessionId", p.SessionId); err != nil {
-		errors = append(errors, err.Error())
+		errors = append(errors, *err)
 	}
 	return errors
 }
@@ -223,6 +568,79 @@ func (p GetParticipantsParams) check() []string {
 type GetParticipantsResult struct {
 	Participants []*Participant `json:"participants,omitempty"`
 	Errors       []string       `json:"errors,omitempty"`
+	FieldErrors  []FieldError   `json:"-"`
+}
+
+// HeartbeatParams holds the parameters of a heartbeat sent by
+// a participant of a live view session to signal it is still alive.
+type HeartbeatParams struct {
+	SessionId     string `json:"sessionId"`
+	ParticipantId string `json:"participantId"`
+	// Optional state of the participant's connection.
+	ConnectionState ConnectionState `json:"connectionState,omitempty"`
+}
+
+// check verifies whether all provided parameters are valid. It will
+// return a slice with all the errors found or nil if no errors exist.
+func (p HeartbeatParams) check() []FieldError {
+	var errors []FieldError
+	if err := isId("sessionId", p.SessionId); err != nil {
+		errors = append(errors, *err)
+	}
+	if err := isId("participantId", p.ParticipantId); err != nil {
+		errors = append(errors, *err)
+	}
+	if p.ConnectionState != "" {
+		if err := p.ConnectionState.check("connectionState"); err != nil {
+			errors = append(errors, *err)
+		}
+	}
+	return errors
+}
+
+// HeartbeatResult returns the result of Heartbeat API calls.
+type HeartbeatResult struct {
+	Participant *Participant `json:"participant,omitempty"`
+	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
+}
+
+// NegotiateParams holds the SDP offer of a participant of a live view
+// session, negotiating its PeerConnection with the server.
+type NegotiateParams struct {
+	SessionId     string `json:"sessionId"`
+	ParticipantId string `json:"participantId"`
+	// SDP offer of the participant. It must offer a recvonly media section
+	// for every track published by the other participants of the session.
+	Offer string `json:"offer"`
+}
+
+// check verifies whether all provided parameters are valid. It will
+// return a slice with all the errors found or nil if no errors exist.
+func (p NegotiateParams) check() []FieldError {
+	var errors []FieldError
+	if err := isId("sessionId", p.SessionId); err != nil {
+		errors = append(errors, *err)
+	}
+	if err := isId("participantId", p.ParticipantId); err != nil {
+		errors = append(errors, *err)
+	}
+	if err := isNotBlank("offer", p.Offer); err != nil {
+		errors = append(errors, *err)
+	}
+	return errors
+}
+
+// NegotiateResult returns the result of Negotiate API calls.
+type NegotiateResult struct {
+	// SDP answer of the server.
+	Answer      string       `json:"answer,omitempty"`
+	Participant *Participant `json:"participant,omitempty"`
+	Errors      []string     `json:"errors,omitempty"`
+	FieldErrors []FieldError `json:"-"`
+	// Capacity limit of the session that prevented the offer to be
+	// answered, if any.
+	Limit LimitKind `json:"limit,omitempty"`
 }
 
 // SessionHandler defines the interface for implementors
@@ -252,6 +670,22 @@ type SessionHandler interface {
 	// error outside the results object will be the case when unexpected conditions
 	// are detected, and should be interpreted as an internal server error.
 	DeleteSession(p DeleteSessionParams) (DeleteSessionResult, error)
+	// UpdateSession applies a JSON Merge Patch to an existing live view
+	// session. On success, a pointer to the updated session will be present
+	// in the results object. If no such session exists, the pointer will be
+	// nil. If the update fails due to expected conditions, the results
+	// object will have its errors slice populated. If an unexpected error is
+	// encountered, this call will return an error which should be
+	// interpreted as an internal server error.
+	UpdateSession(p UpdateSessionParams) (UpdateSessionResult, error)
+	// ListSessions lists existing live view sessions matching the filters
+	// of the parameters, a page at a time. The results object holds the
+	// sessions of the page and the cursor of the next one, if any. If
+	// expected errors are detected, the Errors property of the results
+	// object will be populated. If an unexpected error is encountered, this
+	// call will return an error which should be interpreted as an internal
+	// server error.
+	ListSessions(p ListSessionsParams) (ListSessionsResult, error)
 	// AddParticipant adds a new participant to an existing live view session.
 	// On success, a pointer to the newly added participant will be available
 	// inside results object. If participant addition fails due to an expected
@@ -288,4 +722,22 @@ type SessionHandler interface {
 	// this call will return an error which should be interpreted as an internal
 	// server error.
 	GetParticipants(p GetParticipantsParams) (GetParticipantsResult, error)
+	// Heartbeat records that an existing participant of a live view session is
+	// still alive, optionally updating its connection state. On success, a pointer
+	// to the participant will be present in the results object. If no such
+	// participant exists, the pointer will be nil. If expected errors are detected,
+	// the Errors property of the results object will be populated. If an unexpected
+	// error is encountered, this call will return an error which should be
+	// interpreted as an internal server error.
+	Heartbeat(p HeartbeatParams) (HeartbeatResult, error)
+	// Negotiate answers the SDP offer of an existing participant of a live view
+	// session, creating the participant's PeerConnection on its first offer.
+	// Participants send a new offer whenever the tracks published by the other
+	// participants change. On success, the answer and a pointer to the
+	// participant will be present in the results object. If no such participant
+	// exists, the pointer will be nil. If expected errors are detected, the
+	// Errors property of the results object will be populated. If an unexpected
+	// error is encountered, this call will return an error which should be
+	// interpreted as an internal server error.
+	Negotiate(p NegotiateParams) (NegotiateResult, error)
 }
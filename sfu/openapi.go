@@ -0,0 +1,584 @@
+package sfu
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// openAPIVersion is the version of the OpenAPI specification documents
+// conform to.
+const openAPIVersion = "3.0.3"
+
+// apiOperation describes an operation of the REST API in the OpenAPI
+// document.
+type apiOperation struct {
+	method  string
+	path    string
+	id      string
+	summary string
+	// Longer description of the operation, if any.
+	description string
+	// Other methods the route accepts for the operation, documented as
+	// operations of their own.
+	aliases []string
+	// Type of the request's body, if any.
+	request reflect.Type
+	// Type whose fields are the query parameters of the request, if any.
+	query reflect.Type
+	// Whether the request's body may be omitted.
+	optionalRequest bool
+	// Whether the request's body is a JSON Merge Patch of request.
+	patch bool
+	// Properties of request which patches cannot change.
+	readOnly []string
+	// Whether the operation honors If-Match and If-None-Match conditions on
+	// the revision of its object, whose ETag is given in responses.
+	conditional bool
+	// Whether the operation accepts an Idempotency-Key header, replaying
+	// its result when the request is repeated.
+	idempotent bool
+	// Type of the response's body by status.
+	responses map[int]reflect.Type
+	// Whether the response is a stream of server-sent events.
+	events bool
+}
+
+// typeOf returns the reflect.Type of T.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// apiOperations lists all operations of the REST API, with paths relative
+// to the API version.
+var apiOperations = []apiOperation{
+	{method: http.MethodPost, path: "/sessions", id: "createSession", summary: "Create a live view session",
+		aliases: []string{http.MethodPut}, request: typeOf[CreateSessionParams](), idempotent: true,
+		responses: map[int]reflect.Type{
+			http.StatusCreated:             typeOf[CreateSessionResult](),
+			http.StatusBadRequest:          typeOf[CreateSessionResult](),
+			http.StatusUnprocessableEntity: typeOf[CreateSessionResult](),
+			http.StatusTooManyRequests:     typeOf[CreateSessionResult](),
+		}},
+	{method: http.MethodGet, path: "/sessions", id: "listSessions", summary: "List live view sessions, a page at a time",
+		description: "In a cluster, only the sessions hosted by the node receiving the request are listed.",
+		query:       typeOf[ListSessionsParams](),
+		responses: map[int]reflect.Type{
+			http.StatusOK:         typeOf[ListSessionsResult](),
+			http.StatusBadRequest: typeOf[ListSessionsResult](),
+		}},
+	{method: http.MethodGet, path: "/sessions/{sessionId}", id: "getSession", summary: "Get a live view session",
+		conditional: true,
+		responses: map[int]reflect.Type{
+			http.StatusOK:                 typeOf[GetSessionResult](),
+			http.StatusBadRequest:         typeOf[GetSessionResult](),
+			http.StatusNotFound:           nil,
+			http.StatusPreconditionFailed: typeOf[GetSessionResult](),
+		}},
+	{method: http.MethodPatch, path: "/sessions/{sessionId}", id: "updateSession", summary: "Update a live view session",
+		request: typeOf[Session](), patch: true, readOnly: []string{"id", "creationDateTime", "revision"}, conditional: true,
+		responses: map[int]reflect.Type{
+			http.StatusOK:                   typeOf[UpdateSessionResult](),
+			http.StatusBadRequest:           typeOf[UpdateSessionResult](),
+			http.StatusNotFound:             nil,
+			http.StatusPreconditionFailed:   typeOf[UpdateSessionResult](),
+			http.StatusUnsupportedMediaType: nil,
+		}},
+	{method: http.MethodDelete, path: "/sessions/{sessionId}", id: "deleteSession", summary: "Delete a live view session",
+		conditional: true,
+		responses: map[int]reflect.Type{
+			http.StatusOK:                 typeOf[DeleteSessionResult](),
+			http.StatusBadRequest:         typeOf[DeleteSessionResult](),
+			http.StatusNotFound:           nil,
+			http.StatusPreconditionFailed: typeOf[DeleteSessionResult](),
+		}},
+	{method: http.MethodGet, path: "/sessions/{sessionId}/participants", id: "getParticipants", summary: "List the participants of a live view session",
+		responses: map[int]reflect.Type{
+			http.StatusOK:         typeOf[GetParticipantsResult](),
+			http.StatusBadRequest: typeOf[GetParticipantsResult](),
+			http.StatusNotFound:   typeOf[GetParticipantsResult](),
+		}},
+	{method: http.MethodPost, path: "/sessions/{sessionId}/participants", id: "addParticipant", summary: "Add a participant to a live view session",
+		aliases: []string{http.MethodPut}, request: typeOf[AddParticipantParams](), idempotent: true,
+		responses: map[int]reflect.Type{
+			http.StatusCreated:             typeOf[AddParticipantResult](),
+			http.StatusBadRequest:          typeOf[AddParticipantResult](),
+			http.StatusNotFound:            typeOf[AddParticipantResult](),
+			http.StatusConflict:            typeOf[AddParticipantResult](),
+			http.StatusUnprocessableEntity: typeOf[AddParticipantResult](),
+			http.StatusTooManyRequests:     typeOf[AddParticipantResult](),
+		}},
+	{method: http.MethodGet, path: "/sessions/{sessionId}/participants/{participantId}", id: "getParticipant", summary: "Get a participant of a live view session",
+		conditional: true,
+		responses: map[int]reflect.Type{
+			http.StatusOK:                 typeOf[GetParticipantResult](),
+			http.StatusBadRequest:         typeOf[GetParticipantResult](),
+			http.StatusNotFound:           nil,
+			http.StatusPreconditionFailed: typeOf[GetParticipantResult](),
+		}},
+	{method: http.MethodPut, path: "/sessions/{sessionId}/participants/{participantId}", id: "updateParticipant", summary: "Update a participant of a live view session",
+		aliases: []string{http.MethodPost}, request: typeOf[UpdateParticipantParams](), conditional: true,
+		responses: map[int]reflect.Type{
+			http.StatusOK:                 typeOf[UpdateParticipantResult](),
+			http.StatusBadRequest:         typeOf[UpdateParticipantResult](),
+			http.StatusNotFound:           nil,
+			http.StatusPreconditionFailed: typeOf[UpdateParticipantResult](),
+		}},
+	{method: http.MethodPatch, path: "/sessions/{sessionId}/participants/{participantId}", id: "patchParticipant", summary: "Patch a participant of a live view session",
+		request: typeOf[Participant](), patch: true, conditional: true,
+		readOnly: []string{"id", "sessionId", "creationDateTime", "connectionState", "revision", "tracks", "relay"},
+		responses: map[int]reflect.Type{
+			http.StatusOK:                   typeOf[UpdateParticipantResult](),
+			http.StatusBadRequest:           typeOf[UpdateParticipantResult](),
+			http.StatusNotFound:             nil,
+			http.StatusPreconditionFailed:   typeOf[UpdateParticipantResult](),
+			http.StatusUnsupportedMediaType: nil,
+		}},
+	{method: http.MethodDelete, path: "/sessions/{sessionId}/participants/{participantId}", id: "deleteParticipant", summary: "Remove a participant from a live view session",
+		conditional: true,
+		responses: map[int]reflect.Type{
+			http.StatusOK:                 typeOf[DeleteParticipantResult](),
+			http.StatusBadRequest:         typeOf[DeleteParticipantResult](),
+			http.StatusNotFound:           nil,
+			http.StatusPreconditionFailed: typeOf[DeleteParticipantResult](),
+		}},
+	{method: http.MethodPut, path: "/sessions/{sessionId}/participants/{participantId}/heartbeat", id: "heartbeat", summary: "Signal a participant is still alive",
+		aliases: []string{http.MethodPost}, request: typeOf[HeartbeatParams](), optionalRequest: true,
+		responses: map[int]reflect.Type{
+			http.StatusOK:         typeOf[HeartbeatResult](),
+			http.StatusBadRequest: typeOf[HeartbeatResult](),
+			http.StatusNotFound:   nil,
+		}},
+	{method: http.MethodPost, path: "/sessions/{sessionId}/participants/{participantId}/negotiate", id: "negotiate", summary: "Answer the SDP offer of a participant",
+		description: "The answer forwards the tracks published by the other participants of the session. Participants negotiate again whenever those change.",
+		request:     typeOf[NegotiateParams](),
+		responses: map[int]reflect.Type{
+			http.StatusOK:         typeOf[NegotiateResult](),
+			http.StatusBadRequest: typeOf[NegotiateResult](),
+			http.StatusNotFound:   nil,
+			http.StatusConflict:   typeOf[NegotiateResult](),
+		}},
+	{method: http.MethodGet, path: "/events", id: "streamEvents", summary: "Stream the events of all live view sessions",
+		description: "In a cluster, only the events of the sessions hosted by the node receiving the request are streamed.",
+		events:      true,
+		responses: map[int]reflect.Type{
+			http.StatusBadRequest: nil,
+		}},
+	{method: http.MethodGet, path: "/sessions/{sessionId}/events", id: "streamSessionEvents", summary: "Stream the events of a live view session",
+		events: true,
+		responses: map[int]reflect.Type{
+			http.StatusBadRequest: nil,
+			http.StatusNotFound:   nil,
+		}},
+	{method: http.MethodGet, path: "/openapi.json", id: "getOpenAPI", summary: "Get the OpenAPI document of the REST API",
+		responses: map[int]reflect.Type{http.StatusOK: typeOf[map[string]any]()}},
+}
+
+// enumValues holds the values of the string types of the model which only
+// accept a fixed set of values.
+var enumValues = map[reflect.Type][]string{
+	typeOf[ConnectionState](): {
+		string(ConnectionNew), string(ConnectionConnected), string(ConnectionDisconnected), string(ConnectionFailed),
+	},
+	typeOf[LimitKind](): {
+		string(ServerSessionsLimit), string(ServerParticipantsLimit), string(SessionParticipantsLimit),
+		string(SessionPublishersLimit), string(SessionVideoTracksLimit),
+	},
+	typeOf[ErrorCode](): {
+		string(ErrorBlank), string(ErrorInvalidId), string(ErrorNegative), string(ErrorInvalidValue),
+		string(ErrorNotFound), string(ErrorLimitReached), string(ErrorMalformedBody), string(ErrorTooLarge),
+		string(ErrorReadOnly), string(ErrorPreconditionFailed), string(ErrorIdempotencyKeyReused),
+	},
+	typeOf[SessionSort](): {
+		string(SortByCreation), string(SortByName),
+	},
+	typeOf[EventType](): {
+		string(SessionCreated), string(SessionUpdated), string(SessionDeleted), string(ParticipantJoined), string(ParticipantUpdated),
+		string(ParticipantLeft), string(TrackPublished), string(TrackUnpublished), string(ActiveSpeakerChanged),
+		string(RecordingStarted), string(RecordingStopped), string(SessionInterrupted), string(StreamReset),
+	},
+}
+
+// openAPIDocument builds the OpenAPI document of version v of the REST
+// API, holding the operations of the routes v serves, marked deprecated
+// when v is. Errors are described as problem details, and as plain
+// messages in the errors of results as well when legacyErrors is set.
+func openAPIDocument(v *apiVersion, deprecated bool, legacyErrors bool) map[string]any {
+	schemas := schemaBuilder{schemas: make(map[string]any), legacyErrors: legacyErrors}
+	paths := make(map[string]map[string]any)
+	for _, op := range apiOperations {
+		if v.handler(op.path) == nil {
+			continue
+		}
+		operation := map[string]any{
+			"operationId": op.id,
+			"summary":     op.summary,
+		}
+		if op.description != "" {
+			operation["description"] = op.description
+		}
+		if deprecated {
+			operation["deprecated"] = true
+		}
+		params := pathParameters(op.path)
+		if op.query != nil {
+			params = append(params, queryParameters(op.query)...)
+		}
+		if op.conditional {
+			params = append(params, conditionParameters()...)
+		}
+		if op.events {
+			params = append(params, map[string]any{
+				"name":        "Last-Event-ID",
+				"in":          "header",
+				"description": "Id of the last event received, resuming the stream after it",
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		if op.idempotent {
+			params = append(params, map[string]any{
+				"name":        idempotencyKeyHeader,
+				"in":          "header",
+				"description": "Key of the request, whose result is replayed when it is repeated",
+				"schema":      map[string]any{"type": "string", "maxLength": MaxIdempotencyKeyLen},
+			})
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if op.request != nil {
+			contentType := "application/json"
+			if op.patch {
+				contentType = mergePatchContentType
+			}
+			operation["requestBody"] = map[string]any{
+				"required": !op.optionalRequest,
+				"content": map[string]any{
+					contentType: map[string]any{"schema": schemas.requestSchema(op)},
+				},
+			}
+		}
+		responses := make(map[string]any)
+		if op.events {
+			responses["200"] = map[string]any{
+				"description": "Stream of server-sent events, each holding an Event as data. A stream resumed with " +
+					"the Last-Event-ID header starts with a stream.reset event when events after it were lost",
+				"content": map[string]any{
+					"text/event-stream": map[string]any{"schema": schemas.schemaOf(typeOf[Event]())},
+				},
+			}
+		}
+		for status, t := range op.responses {
+			response := schemas.response(status, t)
+			if op.conditional && (status < http.StatusMultipleChoices || status == http.StatusPreconditionFailed) {
+				response["headers"] = map[string]any{
+					"ETag": map[string]any{
+						"description": "Entity tag of the current revision of the object",
+						"schema":      map[string]any{"type": "string"},
+					},
+				}
+			}
+			responses[statusKey(status)] = response
+		}
+		if op.conditional && op.method == http.MethodGet {
+			responses[statusKey(http.StatusNotModified)] = map[string]any{
+				"description": http.StatusText(http.StatusNotModified),
+			}
+		}
+		notAllowed := schemas.response(http.StatusMethodNotAllowed, nil)
+		notAllowed["headers"] = map[string]any{
+			"Allow": map[string]any{
+				"description": "Methods the route accepts",
+				"schema":      map[string]any{"type": "string"},
+			},
+		}
+		responses[statusKey(http.StatusMethodNotAllowed)] = notAllowed
+		responses[statusKey(http.StatusInternalServerError)] = schemas.response(http.StatusInternalServerError, nil)
+		operation["responses"] = responses
+		if paths[op.path] == nil {
+			paths[op.path] = make(map[string]any)
+		}
+		paths[op.path][strings.ToLower(op.method)] = operation
+		for _, method := range op.aliases {
+			alias := make(map[string]any, len(operation))
+			for k, v := range operation {
+				alias[k] = v
+			}
+			alias["operationId"] = op.id + "Using" + method[:1] + strings.ToLower(method[1:])
+			alias["summary"] = op.summary + ", with " + method + " rather than " + op.method
+			paths[op.path][strings.ToLower(method)] = alias
+		}
+	}
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":   "Blackbird SFU",
+			"version": v.name,
+		},
+		"servers":    []any{map[string]any{"url": "/" + v.name}},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas.schemas},
+	}
+}
+
+// statusKey returns the key of the response with status code status.
+func statusKey(status int) string {
+	return strconv.Itoa(status)
+}
+
+// pathParameters returns the parameters of the path template path.
+func pathParameters(path string) []any {
+	var params []any
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]any{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+	}
+	return params
+}
+
+// conditionParameters returns the header parameters of the conditions of
+// requests on the revision of their object.
+func conditionParameters() []any {
+	var params []any
+	for _, name := range []string{"If-Match", "If-None-Match"} {
+		params = append(params, map[string]any{
+			"name":        name,
+			"in":          "header",
+			"description": "Comma separated entity tags, or * for any",
+			"schema":      map[string]any{"type": "string"},
+		})
+	}
+	return params
+}
+
+// queryParameters returns the query parameters of a request, one per
+// field of t. Maps are given as repeated key:value parameters and sorts
+// are prefixed with - for descending order.
+func queryParameters(t reflect.Type) []any {
+	var params []any
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		var schema map[string]any
+		switch {
+		case f.Type == typeOf[SessionSort]():
+			values := make([]string, 0, 4)
+			for _, v := range enumValues[f.Type] {
+				values = append(values, v, "-"+v)
+			}
+			schema = map[string]any{"type": "string", "enum": values}
+		case f.Type.Kind() == reflect.Map:
+			schema = map[string]any{"type": "array", "items": map[string]any{"type": "string", "pattern": "^[^:]+:.*$"}}
+		case f.Name == "Descending":
+			// given by the sort parameter
+			continue
+		default:
+			schema = schemaBuilder{}.schemaOf(f.Type)
+		}
+		params = append(params, map[string]any{"name": name, "in": "query", "schema": schema})
+	}
+	return params
+}
+
+// schemaBuilder builds the JSON schemas of the types of the model, as
+// encoded by a version of the REST API.
+type schemaBuilder struct {
+	// Schemas of structs, by name.
+	schemas map[string]any
+	// Whether error responses hold results with plain error messages,
+	// to requests not accepting problem details.
+	legacyErrors bool
+}
+
+// response returns the response with status, whose body holds a value of
+// type t, if not nil. Error responses hold a Problem instead, or, when
+// errors are encoded as plain messages and problem details are not
+// accepted, a value of type t, if not nil, or no body.
+func (b schemaBuilder) response(status int, t reflect.Type) map[string]any {
+	response := map[string]any{"description": http.StatusText(status)}
+	content := make(map[string]any)
+	if status >= http.StatusBadRequest {
+		content[problemContentType] = map[string]any{"schema": b.schemaOf(typeOf[Problem]())}
+	}
+	if t != nil && (status < http.StatusBadRequest || b.legacyErrors) {
+		content["application/json"] = map[string]any{"schema": b.schemaOf(t)}
+	}
+	if status >= http.StatusBadRequest && b.legacyErrors {
+		description := http.StatusText(status) + ", as problem details when the request accepts " +
+			problemContentType + ", otherwise "
+		if t != nil {
+			description += "as the result with plain error messages, or without a body when the request is malformed"
+		} else {
+			description += "without a body"
+		}
+		response["description"] = description
+	}
+	if len(content) > 0 {
+		response["content"] = content
+	}
+	return response
+}
+
+// schemaOf returns the JSON schema of values of type t, as encoded by
+// encoding/json. Schemas of structs are added to the builder's schemas, by
+// name, and referenced.
+func (b schemaBuilder) schemaOf(t reflect.Type) map[string]any {
+	if values, ok := enumValues[t]; ok {
+		return map[string]any{"type": "string", "enum": values}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schemaOf(t.Elem())
+	case reflect.Struct:
+		if _, ok := b.schemas[t.Name()]; !ok {
+			// placeholder for recursive types
+			b.schemas[t.Name()] = nil
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": b.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schemaOf(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{}
+}
+
+// requestSchema returns the JSON schema of the body of requests of
+// operation op: the schema of op.request without the properties given by
+// the path, or the schema of JSON Merge Patches of op.request.
+func (b schemaBuilder) requestSchema(op apiOperation) map[string]any {
+	if op.patch {
+		schema := b.patchSchema(op.request)
+		properties := schema["properties"].(map[string]any)
+		for _, name := range op.readOnly {
+			readOnly := make(map[string]any)
+			for k, v := range properties[name].(map[string]any) {
+				readOnly[k] = v
+			}
+			readOnly["readOnly"] = true
+			properties[name] = readOnly
+		}
+		return schema
+	}
+	var bound []string
+	for _, p := range pathParameters(op.path) {
+		bound = append(bound, p.(map[string]any)["name"].(string))
+	}
+	schema := b.structSchema(op.request)
+	properties := schema["properties"].(map[string]any)
+	found := false
+	for _, name := range bound {
+		if _, ok := properties[name]; ok {
+			delete(properties, name)
+			found = true
+		}
+	}
+	if !found {
+		return b.schemaOf(op.request)
+	}
+	if required, ok := schema["required"].([]string); ok {
+		var kept []string
+		for _, name := range required {
+			if _, ok := properties[name]; ok {
+				kept = append(kept, name)
+			}
+		}
+		delete(schema, "required")
+		if len(kept) > 0 {
+			schema["required"] = kept
+		}
+	}
+	return schema
+}
+
+// patchSchema returns the JSON schema of JSON Merge Patches of values of
+// type t: no property is required, and properties may be null to remove
+// them.
+func (b schemaBuilder) patchSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.patchSchema(t.Elem())
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": nullable(b.patchSchema(t.Elem()))}
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = nullable(b.patchSchema(f.Type))
+		}
+		return map[string]any{"type": "object", "properties": properties}
+	}
+	return b.schemaOf(t)
+}
+
+// nullable returns a copy of schema accepting null as well.
+func nullable(schema map[string]any) map[string]any {
+	n := map[string]any{"nullable": true}
+	for k, v := range schema {
+		n[k] = v
+	}
+	return n
+}
+
+// structSchema returns the JSON schema of a struct, with a property per
+// exported field. Fields without omitempty are required.
+func (b schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = b.schemaOf(f.Type)
+		if !strings.Contains(options, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// onOpenAPIRequest is called for every request to /{version}/openapi.json
+func (s *Server) onOpenAPIRequest(w http.ResponseWriter, r *http.Request) {
+	if isGet(r) == false {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	v := requestAPIVersion(r)
+	writeJSON(w, r, http.StatusOK, openAPIDocument(v, s.Deprecations[v.name].isDeprecated(s.now()), s.LegacyErrors && v.legacyErrors))
+}
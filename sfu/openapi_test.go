@@ -0,0 +1,523 @@
+package sfu
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pion/webrtc/v3"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// contract checks the responses of a server against the OpenAPI document
+// it serves.
+type contract struct {
+	t      *testing.T
+	ts     *httptest.Server
+	doc    map[string]any
+	prefix string
+	// Accept header of every request, if not blank.
+	accept string
+	// Whether the server reports errors in the legacy format.
+	legacy bool
+	// Statuses seen by operation, reported when the test fails.
+	seen map[string][]int
+}
+
+// newContract starts a server serving handler, and loads the OpenAPI
+// document of version. Requests are sent with the Accept header accept,
+// unless blank.
+func newContract(t *testing.T, s *Server, handler SessionHandler, version string, accept string) *contract {
+	c := &contract{t: t, ts: httptest.NewServer(s.newRouter(handler)), prefix: "/" + version, accept: accept,
+		legacy: s.LegacyErrors && apiVersions[version].legacyErrors && accept == "", seen: make(map[string][]int)}
+	resp, err := http.Get(c.ts.URL + c.prefix + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(&c.doc); err != nil {
+		t.Fatalf("decoding the OpenAPI document: %v", err)
+	}
+	return c
+}
+
+// pathItem returns the path template and the path item of the document
+// matching path, relative to the API version.
+func (c *contract) pathItem(path string) (string, map[string]any) {
+	path, _, _ = strings.Cut(path, "?")
+	segments := strings.Split(path, "/")
+	for template, item := range c.doc["paths"].(map[string]any) {
+		templateSegments := strings.Split(template, "/")
+		if len(templateSegments) != len(segments) {
+			continue
+		}
+		matches := true
+		for i, s := range templateSegments {
+			if s != segments[i] && !strings.HasPrefix(s, "{") {
+				matches = false
+			}
+		}
+		if matches {
+			return template, item.(map[string]any)
+		}
+	}
+	return "", nil
+}
+
+// operation returns the path template and the operation of the document
+// serving method on path, relative to the API version.
+func (c *contract) operation(method string, path string) (string, map[string]any) {
+	template, item := c.pathItem(path)
+	op, _ := item[strings.ToLower(method)].(map[string]any)
+	return template, op
+}
+
+// send sends a request with the Accept header of the contract.
+func (c *contract) send(method string, path string, header http.Header, body string) (*http.Response, []byte) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.ts.URL+c.prefix+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if c.accept != "" {
+		req.Header.Set("Accept", c.accept)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp, raw
+}
+
+// do sends a request and checks its response is one the document
+// describes, as well as its body when the request succeeded. It returns
+// the status and the decoded body of the response.
+func (c *contract) do(method string, path string, header http.Header, body string) (int, map[string]any) {
+	c.t.Helper()
+	resp, raw := c.send(method, path, header, body)
+	template, op := c.operation(method, path)
+	if op == nil {
+		c.t.Fatalf("%s %s has no operation in the document", method, path)
+	}
+	c.seen[method+" "+template] = append(c.seen[method+" "+template], resp.StatusCode)
+	if resp.StatusCode < http.StatusMultipleChoices && body != "" {
+		contentType := header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		c.checkRequest(method+" "+path, op, contentType, body)
+	}
+	return c.checkResponse(method+" "+path, op, resp, raw)
+}
+
+// checkRequest checks the body of a request the server accepted, sent with
+// contentType, is one operation op describes.
+func (c *contract) checkRequest(request string, op map[string]any, contentType string, body string) {
+	c.t.Helper()
+	requestBody, ok := op["requestBody"].(map[string]any)
+	if !ok {
+		c.t.Errorf("%s: the request has a body the document does not describe", request)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := requestBody["content"].(map[string]any)[mediaType].(map[string]any)
+	if !ok {
+		c.t.Errorf("%s: the request has content type %q, not one of %v", request, mediaType,
+			keys(requestBody["content"].(map[string]any)))
+		return
+	}
+	var decoded any
+	if err := json.Unmarshal([]byte(body), &decoded); err != nil {
+		c.t.Errorf("%s: the request has an invalid body: %v", request, err)
+		return
+	}
+	for _, problem := range c.validate(media["schema"].(map[string]any), decoded, "request", true) {
+		c.t.Errorf("%s: %s", request, problem)
+	}
+}
+
+// checkResponse checks the response to request, with body raw, is one
+// operation op describes. It returns the status and the decoded body of
+// the response.
+func (c *contract) checkResponse(request string, op map[string]any, resp *http.Response, raw []byte) (int, map[string]any) {
+	c.t.Helper()
+	response, ok := op["responses"].(map[string]any)[strconv.Itoa(resp.StatusCode)].(map[string]any)
+	if !ok {
+		c.t.Errorf("%s: status %d is not documented: %s", request, resp.StatusCode, raw)
+		return resp.StatusCode, nil
+	}
+	if headers, ok := response["headers"].(map[string]any); ok {
+		for name := range headers {
+			if resp.Header.Get(name) == "" {
+				c.t.Errorf("%s: status %d misses header %s", request, resp.StatusCode, name)
+			}
+		}
+	}
+	content, _ := response["content"].(map[string]any)
+	if len(raw) == 0 && c.legacy && resp.StatusCode >= http.StatusBadRequest &&
+		(content["application/json"] == nil || resp.StatusCode == http.StatusBadRequest) {
+		// legacy errors without a result, or of malformed requests, have
+		// no body
+		return resp.StatusCode, nil
+	}
+	if len(content) == 0 {
+		if len(raw) > 0 {
+			c.t.Errorf("%s: status %d has an undocumented body: %s", request, resp.StatusCode, raw)
+		}
+		return resp.StatusCode, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	media, ok := content[mediaType].(map[string]any)
+	if !ok {
+		c.t.Errorf("%s: status %d has content type %q, not one of %v", request, resp.StatusCode,
+			mediaType, keys(content))
+		return resp.StatusCode, nil
+	}
+	var decoded any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		c.t.Errorf("%s: status %d has an invalid body: %v", request, resp.StatusCode, err)
+		return resp.StatusCode, nil
+	}
+	for _, problem := range c.validate(media["schema"].(map[string]any), decoded, "body", false) {
+		c.t.Errorf("%s: status %d: %s", request, resp.StatusCode, problem)
+	}
+	result, _ := decoded.(map[string]any)
+	return resp.StatusCode, result
+}
+
+// notAllowed sends a request with a method the route at path does not
+// accept, and checks the response is the 405 the document describes, with
+// the methods of the route's operations as Allow header.
+func (c *contract) notAllowed(method string, path string) {
+	c.t.Helper()
+	resp, raw := c.send(method, path, nil, "")
+	template, item := c.pathItem(path)
+	if item == nil || item[strings.ToLower(method)] != nil {
+		c.t.Fatalf("%s %s is not a method the document excludes", method, path)
+	}
+	c.seen["405 "+template] = append(c.seen["405 "+template], resp.StatusCode)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		c.t.Errorf("%s %s: status %d, want %d", method, path, resp.StatusCode, http.StatusMethodNotAllowed)
+		return
+	}
+	var methods []string
+	for m := range item {
+		methods = append(methods, strings.ToUpper(m))
+	}
+	sort.Strings(methods)
+	allowed := strings.Split(resp.Header.Get("Allow"), ", ")
+	sort.Strings(allowed)
+	if strings.Join(allowed, ", ") != strings.Join(methods, ", ") {
+		c.t.Errorf("%s %s: Allow = %v, want %v", method, path, allowed, methods)
+	}
+	for _, op := range item {
+		c.checkResponse(method+" "+path, op.(map[string]any), resp, raw)
+		break
+	}
+}
+
+// expect sends a request with do, and checks its response has status want.
+func (c *contract) expect(want int, method string, path string, header http.Header, body string) {
+	c.t.Helper()
+	if status, _ := c.do(method, path, header, body); status != want {
+		c.t.Errorf("%s %s: status %d, want %d", method, path, status, want)
+	}
+}
+
+// stream opens an event stream, checks the response is the one the
+// document describes and validates its first event, sent by trigger once
+// the stream is open.
+func (c *contract) stream(path string, trigger func()) {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, c.ts.URL+c.prefix+path, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	template, op := c.operation(http.MethodGet, path)
+	c.seen["GET "+template] = append(c.seen["GET "+template], resp.StatusCode)
+	response := op["responses"].(map[string]any)["200"].(map[string]any)
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	media, ok := response["content"].(map[string]any)[mediaType].(map[string]any)
+	if resp.StatusCode != http.StatusOK || !ok {
+		c.t.Fatalf("GET %s: status %d with content type %q", path, resp.StatusCode, mediaType)
+	}
+	trigger()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, found := strings.CutPrefix(scanner.Text(), "data: "); found {
+			var decoded any
+			if err = json.Unmarshal([]byte(data), &decoded); err != nil {
+				c.t.Fatalf("GET %s: invalid event: %v", path, err)
+			}
+			for _, problem := range c.validate(media["schema"].(map[string]any), decoded, "event", false) {
+				c.t.Errorf("GET %s: %s", path, problem)
+			}
+			return
+		}
+	}
+	c.t.Errorf("GET %s: no event received: %v", path, scanner.Err())
+}
+
+// validate returns the problems found validating value v, at location at,
+// against schema, as the body of a request when request is true, of a
+// response otherwise. It supports the keywords used by openAPIDocument.
+func (c *contract) validate(schema map[string]any, v any, at string, request bool) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		resolved, ok := c.doc["components"].(map[string]any)["schemas"].(map[string]any)[name].(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: unknown schema %s", at, ref)}
+		}
+		return c.validate(resolved, v, at, request)
+	}
+	if v == nil && schema["nullable"] == true {
+		return nil
+	}
+	if request && schema["readOnly"] == true {
+		return []string{fmt.Sprintf("%s: is read only", at)}
+	}
+	var problems []string
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == v
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", at, v, enum))
+		}
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := v.(map[string]any)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: %v is not an object", at, v))
+		}
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: misses required property %s", at, name))
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		for name, value := range object {
+			if property, ok := properties[name].(map[string]any); ok {
+				problems = append(problems, c.validate(property, value, at+"."+name, request)...)
+			} else if additional != nil {
+				problems = append(problems, c.validate(additional, value, at+"."+name, request)...)
+			} else {
+				problems = append(problems, fmt.Sprintf("%s: has undocumented property %s", at, name))
+			}
+		}
+	case "array":
+		array, ok := v.([]any)
+		if !ok {
+			return append(problems, fmt.Sprintf("%s: %v is not an array", at, v))
+		}
+		for i, item := range array {
+			problems = append(problems, c.validate(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", at, i), request)...)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			problems = append(problems, fmt.Sprintf("%s: %v is not a string", at, v))
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			problems = append(problems, fmt.Sprintf("%s: %v is not an integer", at, v))
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			problems = append(problems, fmt.Sprintf("%s: %v is not a number", at, v))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s: %v is not a boolean", at, v))
+		}
+	}
+	return problems
+}
+
+// keys returns the sorted keys of m.
+func keys(m map[string]any) []string {
+	var k []string
+	for key := range m {
+		k = append(k, key)
+	}
+	sort.Strings(k)
+	return k
+}
+
+// header returns a header with a single value.
+func header(name string, value string) http.Header {
+	return http.Header{http.CanonicalHeaderKey(name): {value}}
+}
+
+// TestOpenAPIContract sends requests to every route, covering their
+// success and error responses, and checks each response against the
+// OpenAPI document, with legacy errors and with problem details.
+func TestOpenAPIContract(t *testing.T) {
+	for _, test := range []struct {
+		version      string
+		accept       string
+		legacyErrors bool
+	}{
+		{"v1", "", false}, {"v1", "", true}, {"v1", problemContentType, true}, {"v2", "", true},
+	} {
+		t.Run(fmt.Sprintf("%s/accept=%q/legacyErrors=%t", test.version, test.accept, test.legacyErrors), func(t *testing.T) {
+			h := NewWebRtcSessionHandler(WithLimits(Limits{MaxSessions: 2, Session: SessionLimits{MaxParticipants: 1, MaxVideoTracks: 1}}),
+				WithSettingEngine(loopbackSettings()))
+			c := newContract(t, &Server{LegacyErrors: test.legacyErrors}, h, test.version, test.accept)
+			defer c.ts.Close()
+			c.do(http.MethodGet, "/openapi.json", nil, "")
+			status, created := c.do(http.MethodPost, "/sessions", header("Idempotency-Key", "k1"), `{"name":"contract","metadata":{"a":"b"}}`)
+			if status != http.StatusCreated {
+				t.Fatalf("creating a session: status %d", status)
+			}
+			session := created["session"].(map[string]any)
+			id := session["id"].(string)
+			etag := ETag(int64(session["revision"].(float64)))
+			c.expect(http.StatusCreated, http.MethodPost, "/sessions", header("Idempotency-Key", "k1"), `{"name":"contract","metadata":{"a":"b"}}`)
+			c.expect(http.StatusUnprocessableEntity, http.MethodPost, "/sessions", header("Idempotency-Key", "k1"), `{"name":"other"}`)
+			c.expect(http.StatusBadRequest, http.MethodPost, "/sessions", nil, `{"name":""}`)
+			c.expect(http.StatusBadRequest, http.MethodPost, "/sessions", nil, `{"name":`)
+			c.expect(http.StatusCreated, http.MethodPost, "/sessions", nil, `{"name":"second"}`)
+			c.expect(http.StatusTooManyRequests, http.MethodPost, "/sessions", nil, `{"name":"third"}`)
+			c.expect(http.StatusTooManyRequests, http.MethodPut, "/sessions", nil, `{"name":"third"}`)
+			c.notAllowed(http.MethodDelete, "/sessions")
+
+			c.expect(http.StatusOK, http.MethodGet, "/sessions?limit=1&sort=-name&metadata=a:b", nil, "")
+			c.expect(http.StatusBadRequest, http.MethodGet, "/sessions?limit=-1", nil, "")
+			c.expect(http.StatusOK, http.MethodGet, "/sessions/"+id, nil, "")
+			c.expect(http.StatusNotModified, http.MethodGet, "/sessions/"+id, header("If-None-Match", etag), "")
+			c.expect(http.StatusPreconditionFailed, http.MethodGet, "/sessions/"+id, header("If-Match", `"999"`), "")
+			c.expect(http.StatusNotFound, http.MethodGet, "/sessions/0000000000", nil, "")
+			c.expect(http.StatusBadRequest, http.MethodGet, "/sessions/not-an-id", nil, "")
+			c.expect(http.StatusOK, http.MethodPatch, "/sessions/"+id, header("Content-Type", mergePatchContentType), `{"name":"renamed","metadata":{"a":null}}`)
+			c.expect(http.StatusBadRequest, http.MethodPatch, "/sessions/"+id, header("Content-Type", mergePatchContentType), `{"id":"other"}`)
+			c.expect(http.StatusUnsupportedMediaType, http.MethodPatch, "/sessions/"+id, header("Content-Type", "text/plain"), `name`)
+			c.expect(http.StatusPreconditionFailed, http.MethodPatch, "/sessions/"+id, http.Header{
+				"Content-Type": {mergePatchContentType}, "If-Match": {etag}}, `{"name":"stale"}`)
+			c.expect(http.StatusNotFound, http.MethodPatch, "/sessions/0000000000", header("Content-Type", mergePatchContentType), `{"name":"x"}`)
+			c.notAllowed(http.MethodPut, "/sessions/"+id)
+
+			participants := "/sessions/" + id + "/participants"
+			status, added := c.do(http.MethodPost, participants, nil, `{"name":"alice","metadata":{"role":"host"}}`)
+			if status != http.StatusCreated {
+				t.Fatalf("adding a participant: status %d", status)
+			}
+			participant := participants + "/" + added["participant"].(map[string]any)["id"].(string)
+			c.expect(http.StatusConflict, http.MethodPost, participants, nil, `{"name":"bob"}`)
+			c.expect(http.StatusConflict, http.MethodPut, participants, nil, `{"name":"bob"}`)
+			c.expect(http.StatusNotFound, http.MethodPost, "/sessions/0000000000/participants", nil, `{"name":"bob"}`)
+			c.notAllowed(http.MethodDelete, participants)
+			c.expect(http.StatusBadRequest, http.MethodPost, participants, nil, `{"name":""}`)
+			c.expect(http.StatusOK, http.MethodGet, participants, nil, "")
+			c.expect(http.StatusOK, http.MethodGet, participant, nil, "")
+			c.expect(http.StatusNotFound, http.MethodGet, participants+"/0000000000", nil, "")
+			c.expect(http.StatusOK, http.MethodPut, participant, nil, `{"name":"alicia"}`)
+			c.expect(http.StatusBadRequest, http.MethodPut, participant, nil, `{"name":""}`)
+			c.expect(http.StatusOK, http.MethodPost, participant, nil, `{"name":"alice"}`)
+			c.expect(http.StatusOK, http.MethodPatch, participant, header("Content-Type", mergePatchContentType), `{"metadata":{"role":"guest"}}`)
+			c.expect(http.StatusPreconditionFailed, http.MethodDelete, participant, header("If-Match", `"999"`), "")
+			c.expect(http.StatusOK, http.MethodPut, participant+"/heartbeat", nil, "")
+			c.expect(http.StatusOK, http.MethodPut, participant+"/heartbeat", nil, `{"connectionState":"connected"}`)
+			c.expect(http.StatusBadRequest, http.MethodPut, participant+"/heartbeat", nil, `{"connectionState":"asleep"}`)
+			c.expect(http.StatusNotFound, http.MethodPut, participants+"/0000000000/heartbeat", nil, "")
+			c.expect(http.StatusOK, http.MethodPost, participant+"/heartbeat", nil, "")
+			c.notAllowed(http.MethodGet, participant+"/heartbeat")
+			negotiation, _ := json.Marshal(map[string]string{"offer": newRecvonlyOffer(t)})
+			c.expect(http.StatusOK, http.MethodPost, participant+"/negotiate", nil, string(negotiation))
+			c.expect(http.StatusBadRequest, http.MethodPost, participant+"/negotiate", nil, `{"offer":"v=0"}`)
+			c.expect(http.StatusNotFound, http.MethodPost, participants+"/0000000000/negotiate", nil, string(negotiation))
+			publishing, _ := json.Marshal(map[string]string{"offer": newOffer(t, webrtc.RTPTransceiverDirectionSendonly,
+				webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeVideo)})
+			c.expect(http.StatusConflict, http.MethodPost, participant+"/negotiate", nil, string(publishing))
+			c.notAllowed(http.MethodPut, participant+"/negotiate")
+
+			c.stream("/events", func() {
+				c.do(http.MethodPut, participant+"/heartbeat", nil, `{"connectionState":"disconnected"}`)
+			})
+			c.stream("/sessions/"+id+"/events", func() {
+				c.do(http.MethodPatch, participant, header("Content-Type", mergePatchContentType), `{"name":"al"}`)
+			})
+			c.expect(http.StatusNotFound, http.MethodGet, "/sessions/0000000000/events", nil, "")
+			c.notAllowed(http.MethodPost, "/events")
+			c.notAllowed(http.MethodPost, "/sessions/"+id+"/events")
+			c.notAllowed(http.MethodPost, "/openapi.json")
+
+			c.expect(http.StatusOK, http.MethodDelete, participant, nil, "")
+			c.expect(http.StatusNotFound, http.MethodDelete, participant, nil, "")
+			c.expect(http.StatusOK, http.MethodDelete, "/sessions/"+id, nil, "")
+			c.expect(http.StatusNotFound, http.MethodDelete, "/sessions/"+id, nil, "")
+
+			for _, op := range apiOperations {
+				if c.seen[op.method+" "+op.path] == nil {
+					t.Errorf("%s %s was not exercised", op.method, op.path)
+				}
+				for _, method := range op.aliases {
+					if c.seen[method+" "+op.path] == nil {
+						t.Errorf("%s %s was not exercised", method, op.path)
+					}
+				}
+			}
+			if t.Failed() {
+				t.Logf("statuses seen: %v", c.seen)
+			}
+		})
+	}
+}
+
+// TestProblemDetailsOutsideRoutes checks requests for unknown versions
+// and routes are answered with problem details matching the document,
+// even by servers reporting legacy errors.
+func TestProblemDetailsOutsideRoutes(t *testing.T) {
+	c := newContract(t, &Server{LegacyErrors: true}, NewWebRtcSessionHandler(), "v1", "")
+	defer c.ts.Close()
+	problem := map[string]any{"$ref": "#/components/schemas/Problem"}
+	for _, path := range []string{"/v9/sessions", "/v1/nothing/here", "/"} {
+		resp, err := http.Get(c.ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want %d", path, resp.StatusCode, http.StatusNotFound)
+		}
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != problemContentType {
+			t.Errorf("GET %s: content type %q, want %q", path, mediaType, problemContentType)
+		}
+		var decoded any
+		if err = json.NewDecoder(bytes.NewReader(raw)).Decode(&decoded); err != nil {
+			t.Errorf("GET %s: invalid body %s: %v", path, raw, err)
+			continue
+		}
+		for _, p := range c.validate(problem, decoded, "body", false) {
+			t.Errorf("GET %s: %s", path, p)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"fmt"
+	"github.com/pion/logging"
+	"log/slog"
+	"strings"
+)
+
+// pionScopeKey is the name of the field holding the pion scope of a record.
+const pionScopeKey = "scope"
+
+// pionComponents maps pion logging scopes to the component their records
+// belong to. Records of any other scope belong to logger.ComponentPion.
+var pionComponents = map[string]string{
+	"ice":                     logger.ComponentICE,
+	"agent":                   logger.ComponentICE,
+	"mux":                     logger.ComponentICE,
+	"mdns":                    logger.ComponentICE,
+	"stun":                    logger.ComponentICE,
+	"turn":                    logger.ComponentICE,
+	"turnc":                   logger.ComponentICE,
+	"dtls":                    logger.ComponentDTLS,
+	"dtlstransport":           logger.ComponentDTLS,
+	"sctp":                    logger.ComponentSCTP,
+	"datachannel":             logger.ComponentSCTP,
+	"pc":                      logger.ComponentSignaling,
+	"ortc":                    logger.ComponentSignaling,
+	"sdp":                     logger.ComponentSignaling,
+	"rtp":                     logger.ComponentRTP,
+	"rtcp":                    logger.ComponentRTP,
+	"srtp":                    logger.ComponentRTP,
+	"interceptor":             logger.ComponentRTP,
+	"nack_generator":          logger.ComponentRTP,
+	"nack_responder":          logger.ComponentRTP,
+	"pli_generator":           logger.ComponentRTP,
+	"receiver_interceptor":    logger.ComponentRTP,
+	"sender_interceptor":      logger.ComponentRTP,
+	"twcc_sender_interceptor": logger.ComponentRTP,
+	"stats_recorder":          logger.ComponentRTP,
+}
+
+// pionComponent returns the component of the records of a pion scope.
+func pionComponent(scope string) string {
+	if c, ok := pionComponents[strings.ToLower(scope)]; ok {
+		return c
+	}
+	return logger.ComponentPion
+}
+
+// PionLoggerFactory is a logging.LoggerFactory writing the records of pion
+// libraries through the logger package, so they honor the level of the
+// component their scope maps to. NewSettingEngine sets it as the
+// LoggerFactory of participant PeerConnections.
+type PionLoggerFactory struct{}
+
+// NewLogger returns the logger of a pion scope.
+func (PionLoggerFactory) NewLogger(scope string) logging.LeveledLogger {
+	return pionLogger{logger.For(pionComponent(scope)).With(pionScopeKey, scope)}
+}
+
+// pionLogger is a logging.LeveledLogger backed by a slog.Logger. Trace
+// records are logged at debug level.
+type pionLogger struct {
+	l *slog.Logger
+}
+
+func (p pionLogger) log(level slog.Level, msg string) {
+	p.l.Log(context.Background(), level, msg)
+}
+
+func (p pionLogger) logf(level slog.Level, format string, args ...interface{}) {
+	if p.l.Enabled(context.Background(), level) {
+		p.l.Log(context.Background(), level, fmt.Sprintf(format, args...))
+	}
+}
+
+func (p pionLogger) Trace(msg string) { p.log(slog.LevelDebug, msg) }
+func (p pionLogger) Tracef(format string, args ...interface{}) {
+	p.logf(slog.LevelDebug, format, args...)
+}
+func (p pionLogger) Debug(msg string) { p.log(slog.LevelDebug, msg) }
+func (p pionLogger) Debugf(format string, args ...interface{}) {
+	p.logf(slog.LevelDebug, format, args...)
+}
+func (p pionLogger) Info(msg string) { p.log(slog.LevelInfo, msg) }
+func (p pionLogger) Infof(format string, args ...interface{}) {
+	p.logf(slog.LevelInfo, format, args...)
+}
+func (p pionLogger) Warn(msg string) { p.log(slog.LevelWarn, msg) }
+func (p pionLogger) Warnf(format string, args ...interface{}) {
+	p.logf(slog.LevelWarn, format, args...)
+}
+func (p pionLogger) Error(msg string) { p.log(slog.LevelError, msg) }
+func (p pionLogger) Errorf(format string, args ...interface{}) {
+	p.logf(slog.LevelError, format, args...)
+}
@@ -0,0 +1,53 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"strings"
+	"testing"
+)
+
+func TestPionComponent(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  string
+	}{
+		{"ice", logger.ComponentICE},
+		{"turnc", logger.ComponentICE},
+		{"dtls", logger.ComponentDTLS},
+		{"DTLSTransport", logger.ComponentDTLS},
+		{"sctp", logger.ComponentSCTP},
+		{"pc", logger.ComponentSignaling},
+		{"nack_responder", logger.ComponentRTP},
+		{"gcc_delay_controller", logger.ComponentPion},
+	}
+	for _, test := range tests {
+		if got := pionComponent(test.scope); got != test.want {
+			t.Errorf("pionComponent(%q) = %q, want %q", test.scope, got, test.want)
+		}
+	}
+}
+
+func TestPionLoggerLevels(t *testing.T) {
+	level := logger.GetLogLevel()
+	defer logger.SetLogLevel(level)
+	defer logger.ResetComponentLevel(logger.ComponentDTLS)
+	read := captureLogs(t)
+	logger.SetLogLevel(logger.Debug)
+	l := NewSettingEngine().LoggerFactory.NewLogger("dtls")
+
+	logger.SetComponentLevel(logger.ComponentDTLS, logger.Info)
+	l.Debugf("handshake %s", "suppressed")
+	l.Tracef("handshake %s", "suppressed")
+	if out := read(); strings.Contains(out, "suppressed") {
+		t.Errorf("dtls record logged below the level of its component:\n%s", out)
+	}
+
+	logger.SetComponentLevel(logger.ComponentDTLS, logger.Debug)
+	l.Debugf("handshake %s", "started")
+	out := read()
+	for _, want := range []string{"[DEBUG]", "handshake started", logger.ComponentKey + "=" + logger.ComponentDTLS, pionScopeKey + "=dtls"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dtls record without %q:\n%s", want, out)
+		}
+	}
+}
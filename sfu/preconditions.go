@@ -1,27 +1,86 @@
 package sfu
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 )
 
-func isNotBlank(n string, v string) error {
+func isNotBlank(n string, v string) *FieldError {
 	if len(strings.TrimSpace(v)) == 0 {
-		return fmt.Errorf("%s must not be blank", n)
+		return &FieldError{Field: n, Code: ErrorBlank, Detail: fmt.Sprintf("%s must not be blank", n)}
 	}
 	return nil
 }
 
-func isId(n string, v string) error {
+func isId(n string, v string) *FieldError {
 	valid := false
-	if len(v) == IdLen {
+	if len(v) == IdLen || len(v) == legacyIdLen {
 		if match, _ := regexp.MatchString("[A-Za-z0-9=+\\-]", v); match {
 			valid = true
 		}
 	}
 	if !valid {
-		return fmt.Errorf("%s must be a valid id", v)
+		return &FieldError{Field: n, Code: ErrorInvalidId, Detail: fmt.Sprintf("%s must be a valid id", v)}
+	}
+	return nil
+}
+
+func isDateTime(n string, v string) *FieldError {
+	if v == "" {
+		return nil
+	}
+	if _, err := time.Parse(time.RFC3339, v); err != nil {
+		return &FieldError{Field: n, Code: ErrorInvalidValue, Detail: fmt.Sprintf("%s must be an RFC 3339 date time", n)}
+	}
+	return nil
+}
+
+func isMergePatch(n string, v json.RawMessage) *FieldError {
+	var patch map[string]any
+	if err := json.Unmarshal(v, &patch); err != nil || patch == nil {
+		return &FieldError{Field: n, Code: ErrorMalformedBody, Detail: fmt.Sprintf("%s must be a JSON object", n)}
+	}
+	return nil
+}
+
+// checkMetadata verifies whether metadata m is within the size limits of
+// metadata. It will return a slice with all the errors found, in the order
+// of their keys, or nil if no errors exist.
+func checkMetadata(n string, m map[string]string) []FieldError {
+	var errors []FieldError
+	if len(m) > MaxMetadataEntries {
+		errors = append(errors, FieldError{Field: n, Code: ErrorTooLarge,
+			Detail: fmt.Sprintf("%s must not have more than %d entries", n, MaxMetadataEntries)})
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := m[k]
+		if len(strings.TrimSpace(k)) == 0 {
+			errors = append(errors, FieldError{Field: n, Code: ErrorBlank,
+				Detail: fmt.Sprintf("%s keys must not be blank", n)})
+		} else if len(k) > MaxMetadataKeyLen {
+			errors = append(errors, FieldError{Field: n + "." + k, Code: ErrorTooLarge,
+				Detail: fmt.Sprintf("%s keys must not be longer than %d bytes", n, MaxMetadataKeyLen)})
+		}
+		if len(v) > MaxMetadataValueLen {
+			errors = append(errors, FieldError{Field: n + "." + k, Code: ErrorTooLarge,
+				Detail: fmt.Sprintf("%s values must not be longer than %d bytes", n, MaxMetadataValueLen)})
+		}
+	}
+	return errors
+}
+
+func isNotNegative(n string, v int) *FieldError {
+	if v < 0 {
+		return &FieldError{Field: n, Code: ErrorNegative, Detail: fmt.Sprintf("%s must not be negative", n)}
 	}
 	return nil
 }
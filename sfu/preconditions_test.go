@@ -0,0 +1,25 @@
+package sfu
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckMetadataOrder(t *testing.T) {
+	long := strings.Repeat("x", MaxMetadataValueLen+1)
+	m := map[string]string{"d": long, "b": long, "a": long, "c": long, "e": long}
+	want := checkMetadata("metadata", m)
+	fields := make([]string, len(want))
+	for i, e := range want {
+		fields[i] = e.Field
+	}
+	if !reflect.DeepEqual(fields, []string{"metadata.a", "metadata.b", "metadata.c", "metadata.d", "metadata.e"}) {
+		t.Fatalf("checkMetadata() fields = %v", fields)
+	}
+	for i := 0; i < 20; i++ {
+		if got := checkMetadata("metadata", m); !reflect.DeepEqual(got, want) {
+			t.Fatalf("checkMetadata() = %+v, want %+v", got, want)
+		}
+	}
+}
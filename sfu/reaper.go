@@ -0,0 +1,124 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"github.com/pion/webrtc/v3"
+	"time"
+)
+
+// ReaperPolicy defines when idle or broken sessions and participants are
+// removed by a session handler. A zero value for any of its durations
+// disables the corresponding policy.
+type ReaperPolicy struct {
+	// Interval between two consecutive reaper runs.
+	Interval time.Duration
+	// Sessions without participants for longer than this are deleted.
+	EmptySessionTimeout time.Duration
+	// Sessions older than this are deleted, regardless of their participants.
+	MaxSessionLifetime time.Duration
+	// Participants without a heartbeat for longer than this are removed.
+	HeartbeatTimeout time.Duration
+}
+
+// WithReaperPolicy sets the policy applied by the handler's reaper.
+func WithReaperPolicy(policy ReaperPolicy) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.reaperPolicy = policy
+	}
+}
+
+// WithClock sets the clock used by the handler to timestamp sessions and
+// participants and to evaluate its reaper policy.
+func WithClock(clock Clock) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.clock = clock
+	}
+}
+
+// RunReaper reaps sessions and participants periodically, according to the
+// handler's reaper policy, until ctx is done. It returns immediately if the
+// policy has no interval.
+func (h *WebRtcSessionHandler) RunReaper(ctx context.Context) {
+	if h.reaperPolicy.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(h.reaperPolicy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Reap()
+		}
+	}
+}
+
+// Reap removes, in a single pass, all sessions and participants that
+// violate the handler's reaper policy, along with expired idempotency
+// keys. An event is emitted for every removed object.
+func (h *WebRtcSessionHandler) Reap() {
+	policy := h.reaperPolicy
+	var events []Event
+	var pcs []*webrtc.PeerConnection
+	h.locker.Lock()
+	now := h.clock.Now()
+	for id, s := range h.sessions {
+		for pid, p := range s.participants {
+			reason := ""
+			if p.ConnectionState == ConnectionFailed {
+				reason = ReasonConnectionFailed
+			} else if expired(p.lastSeen, policy.HeartbeatTimeout, now) {
+				reason = ReasonHeartbeatTimeout
+			}
+			if reason != "" {
+				if err := h.store.DeleteParticipant(id, pid); err != nil {
+					logger.For(logger.ComponentReaper).Error("failed to reap participant", logger.SessionIdKey, id,
+						logger.ParticipantIdKey, pid, "error", err)
+					continue
+				}
+				s.removeParticipant(pid, now)
+				h.participants--
+				if pc := p.peerConnection(); pc != nil {
+					pcs = append(pcs, pc)
+				}
+				events = append(events, h.newParticipantEvent(ParticipantLeft, p, reason))
+			}
+		}
+		reason := ""
+		if expired(s.created, policy.MaxSessionLifetime, now) {
+			reason = ReasonLifetimeExceeded
+		} else if len(s.participants) == 0 && expired(s.emptySince, policy.EmptySessionTimeout, now) {
+			reason = ReasonIdle
+		}
+		if reason != "" {
+			if err := h.store.DeleteSession(id); err != nil {
+				logger.For(logger.ComponentReaper).Error("failed to reap session", logger.SessionIdKey, id, "error", err)
+				continue
+			}
+			h.participants -= len(s.participants)
+			delete(h.sessions, id)
+			pcs = append(pcs, s.peerConnections()...)
+			events = append(events, h.newSessionEvent(SessionDeleted, s, reason))
+		}
+	}
+	h.reapIdempotencyKeys(now)
+	h.events.emit(events...)
+	h.locker.Unlock()
+	closePeerConnections(pcs)
+	for _, e := range events {
+		if e.Type == ParticipantLeft {
+			logger.For(logger.ComponentReaper).Info("reaped participant", logger.SessionIdKey, e.SessionId,
+				logger.ParticipantIdKey, e.ParticipantId, "reason", e.Reason)
+		} else {
+			logger.For(logger.ComponentReaper).Info("reaped session", logger.SessionIdKey, e.SessionId, "reason", e.Reason)
+		}
+	}
+}
+
+// expired returns whether more than timeout has elapsed between since and
+// now. A zero timeout never expires.
+func expired(since time.Time, timeout time.Duration, now time.Time) bool {
+	return timeout > 0 && now.Sub(since) > timeout
+}
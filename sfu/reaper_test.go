@@ -0,0 +1,81 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+)
+
+// reaped returns the reasons of the removal events emitted by a reap of h,
+// by session or participant id.
+func reaped(h *WebRtcSessionHandler) map[string]string {
+	reasons := make(map[string]string)
+	unsubscribe := h.Subscribe(func(e Event) {
+		switch e.Type {
+		case SessionDeleted:
+			reasons[e.SessionId] = e.Reason
+		case ParticipantLeft:
+			reasons[e.ParticipantId] = e.Reason
+		}
+	})
+	defer unsubscribe()
+	h.Reap()
+	return reasons
+}
+
+func TestReap(t *testing.T) {
+	clock := newFakeClock()
+	h := NewWebRtcSessionHandler(WithClock(clock), WithReaperPolicy(ReaperPolicy{
+		EmptySessionTimeout: 10 * time.Minute,
+		HeartbeatTimeout:    time.Minute,
+	}))
+	create := func(name string) string {
+		result, err := h.CreateSession(CreateSessionParams{Name: name})
+		if err != nil || result.Session == nil {
+			t.Fatalf("CreateSession() = %+v, %v", result, err)
+		}
+		return result.Session.Id
+	}
+	add := func(sessionId string, name string) string {
+		result, err := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: name})
+		if err != nil || result.Participant == nil {
+			t.Fatalf("AddParticipant() = %+v, %v", result, err)
+		}
+		return result.Participant.Id
+	}
+	heartbeat := func(sessionId string, participantId string) {
+		result, err := h.Heartbeat(HeartbeatParams{SessionId: sessionId, ParticipantId: participantId})
+		if err != nil || result.Errors != nil {
+			t.Fatalf("Heartbeat() = %+v, %v", result, err)
+		}
+	}
+
+	idle := create("idle")
+	busy := create("busy")
+	active := add(busy, "active")
+	stale := add(busy, "stale")
+
+	clock.advance(45 * time.Second)
+	heartbeat(busy, active)
+	clock.advance(30 * time.Second)
+	if got := reaped(h); len(got) != 1 || got[stale] != ReasonHeartbeatTimeout {
+		t.Fatalf("first reap removed %v, want only %s for %s", got, stale, ReasonHeartbeatTimeout)
+	}
+
+	clock.advance(8 * time.Minute)
+	heartbeat(busy, active)
+	if got := reaped(h); len(got) != 0 {
+		t.Fatalf("second reap removed %v, want nothing", got)
+	}
+
+	clock.advance(time.Minute)
+	heartbeat(busy, active)
+	if got := reaped(h); len(got) != 1 || got[idle] != ReasonIdle {
+		t.Fatalf("third reap removed %v, want only %s for %s", got, idle, ReasonIdle)
+	}
+	if sessions, participants := h.Stats(); sessions != 1 || participants != 1 {
+		t.Errorf("Stats() = %d, %d, want 1, 1", sessions, participants)
+	}
+	if result, _ := h.GetParticipant(GetParticipantParams{SessionId: busy, ParticipantId: active}); result.Participant == nil {
+		t.Errorf("participant %s was reaped despite its heartbeats", active)
+	}
+}
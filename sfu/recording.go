@@ -0,0 +1,135 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"fmt"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// recordingLogContext carries the component of the log records of
+// recordings.
+var recordingLogContext = logger.NewContext(context.Background(), logger.ComponentKey, logger.ComponentRecording)
+
+// WithRecordingPath sets the directory where the tracks published to
+// sessions are recorded, in a subdirectory per session. Sessions cannot be
+// recorded without it.
+func WithRecordingPath(path string) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.recordingPath = path
+	}
+}
+
+// trackRecorder writes the packets of a forwarded track to a file while
+// its session is recorded.
+type trackRecorder struct {
+	locker sync.Mutex
+	// Writer of the recording, nil while the track is not recorded.
+	writer media.Writer
+}
+
+// start starts recording the packets of codec to the file at path, without
+// extension. It does nothing if the track is already recorded.
+func (r *trackRecorder) start(path string, codec webrtc.RTPCodecParameters) error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.writer != nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	writer, err := newMediaWriter(path, codec)
+	if err != nil {
+		return err
+	}
+	r.writer = writer
+	return nil
+}
+
+// newMediaWriter creates the file recording the packets of codec at path,
+// with the extension of its container.
+func newMediaWriter(path string, codec webrtc.RTPCodecParameters) (media.Writer, error) {
+	switch strings.ToLower(codec.MimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		return ivfwriter.New(path + ".ivf")
+	case strings.ToLower(webrtc.MimeTypeAV1):
+		return ivfwriter.New(path+".ivf", ivfwriter.WithCodec(webrtc.MimeTypeAV1))
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return h264writer.New(path + ".h264")
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		return oggwriter.New(path+".ogg", codec.ClockRate, codec.Channels)
+	default:
+		return nil, fmt.Errorf("tracks of codec %s cannot be recorded", codec.MimeType)
+	}
+}
+
+// write records packet, if the track is recorded.
+func (r *trackRecorder) write(packet *rtp.Packet) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.writer == nil {
+		return
+	}
+	if err := r.writer.WriteRTP(packet); err != nil {
+		logger.LogDebugC(recordingLogContext, "failed to record packet: %s", err)
+	}
+}
+
+// stop stops recording the track, closing its file.
+func (r *trackRecorder) stop() {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.writer == nil {
+		return
+	}
+	if err := r.writer.Close(); err != nil {
+		logger.LogErrorC(recordingLogContext, "failed to close recording: %s", err)
+	}
+	r.writer = nil
+}
+
+// startRecording starts recording track t of participant p, if its
+// session s is recorded. Failing to record a track does not prevent
+// forwarding it. It must be called while holding the lock of the handler.
+func (h *WebRtcSessionHandler) startRecording(s *webRtcSession, p *webRtcParticipant, t *forwardedTrack) {
+	if !s.Recording || h.recordingPath == "" {
+		return
+	}
+	path := filepath.Join(h.recordingPath, s.Id, p.Id+"-"+t.Id)
+	if err := t.recorder.start(path, t.codec); err != nil {
+		logger.LogErrorC(recordingLogContext, "failed to record track %s of participant %s: %s", t.Id, p.Id, err)
+	}
+}
+
+// updateRecording starts or stops recording all tracks published to
+// session s, as it became recorded or not. It must be called while holding
+// the lock of the handler.
+func (h *WebRtcSessionHandler) updateRecording(s *webRtcSession) {
+	for _, p := range s.participants {
+		if p.media == nil {
+			continue
+		}
+		for _, t := range p.media.published {
+			if owner := s.participants[t.owner]; owner != nil && s.Recording {
+				h.startRecording(s, owner, t)
+			} else {
+				t.recorder.stop()
+			}
+		}
+	}
+	if s.Recording {
+		h.events.emit(h.newSessionEvent(RecordingStarted, s, ""))
+	} else {
+		h.events.emit(h.newSessionEvent(RecordingStopped, s, ""))
+	}
+}
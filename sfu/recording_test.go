@@ -0,0 +1,59 @@
+package sfu
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordingNotEnabled(t *testing.T) {
+	h := NewWebRtcSessionHandler()
+	created, _ := h.CreateSession(CreateSessionParams{Name: "standup"})
+	result, err := h.UpdateSession(UpdateSessionParams{Id: created.Session.Id, Patch: []byte(`{"recording":true}`)})
+	if err != nil || len(result.FieldErrors) != 1 || result.FieldErrors[0].Field != "recording" {
+		t.Errorf("UpdateSession() = %+v, %v, want recording rejected", result, err)
+	}
+}
+
+func TestRecording(t *testing.T) {
+	path := t.TempDir()
+	h := NewWebRtcSessionHandler(WithSettingEngine(loopbackSettings()), WithRecordingPath(path))
+	created, _ := h.CreateSession(CreateSessionParams{Name: "standup"})
+	sessionId := created.Session.Id
+	alice, _ := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: "alice"})
+	defer h.DeleteSession(DeleteSessionParams{Id: sessionId})
+	events := make(chan Event, 16)
+	unsubscribe := h.Subscribe(func(e Event) {
+		if e.Type == RecordingStarted || e.Type == RecordingStopped || e.Type == TrackPublished {
+			events <- e
+		}
+	})
+	defer unsubscribe()
+
+	publishVideo(t, h, sessionId, alice.Participant.Id)
+	published := waitForEvent(t, events)
+	if published.Type != TrackPublished {
+		t.Fatalf("event = %+v, want a published track", published)
+	}
+	for _, recording := range []bool{true, false} {
+		patch := `{"recording":false}`
+		want := RecordingStopped
+		if recording {
+			patch = `{"recording":true}`
+			want = RecordingStarted
+		}
+		result, err := h.UpdateSession(UpdateSessionParams{Id: sessionId, Patch: []byte(patch)})
+		if err != nil || result.Errors != nil || result.Session.Recording != recording {
+			t.Fatalf("UpdateSession(%s) = %+v, %v", patch, result, err)
+		}
+		if e := waitForEvent(t, events); e.Type != want || e.Session.Recording != recording {
+			t.Errorf("event = %+v, want %s", e, want)
+		}
+	}
+	file := filepath.Join(path, sessionId, alice.Participant.Id+"-"+published.Track.Id+".ivf")
+	data, err := os.ReadFile(file)
+	if err != nil || !strings.HasPrefix(string(data), "DKIF") {
+		t.Errorf("recording %s = %q, %v, want an IVF file", file, data, err)
+	}
+}
@@ -0,0 +1,535 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pion/webrtc/v3"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// relayHeartbeatInterval is the interval between two heartbeats of the
+	// relay of a session to the node hosting it, which also synchronizes the
+	// tracks the relay forwards. It must be shorter than the heartbeat
+	// timeout of the reaper of the nodes.
+	relayHeartbeatInterval = 5 * time.Second
+	// relayTrackTimeout is how long a participant negotiating with a node
+	// waits for the tracks of the participants of other nodes to reach its
+	// node, before it is answered without them.
+	relayTrackTimeout = 5 * time.Second
+)
+
+// relayClient sends the requests of relays to the nodes hosting their
+// sessions.
+var relayClient = &http.Client{Timeout: 10 * time.Second}
+
+// sessionRelay relays the tracks of a session hosted by another node,
+// between the node hosting it and the participants negotiating with this
+// node. The relay is a participant of the session on the hosting node,
+// publishing the tracks of the participants of this node and subscribed to
+// the tracks of the others.
+type sessionRelay struct {
+	// Id of the participant of the relay, in the session and in its mirror.
+	participantId string
+	// Closed once the relay is closed.
+	done chan struct{}
+}
+
+// WithRelay makes the handler serve the media of the participants of the
+// sessions hosted by the other nodes of cluster c, relaying their tracks
+// to the hosting nodes, so participants can negotiate with any node. The
+// cluster must be configured with ClusterConfig.Relay.
+func WithRelay(c *Cluster) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.relay = c
+	}
+}
+
+// doActionOnMedia locates a session, or the mirror of a session hosted by
+// another node, and executes a given action safely. It returns true if the
+// action was executed, false if no such session exists.
+func (h *WebRtcSessionHandler) doActionOnMedia(sessionId string, action func(s *webRtcSession)) bool {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	s := h.sessions[sessionId]
+	if s == nil {
+		s = h.relayed[sessionId]
+	}
+	if s == nil {
+		return false
+	}
+	action(s)
+	return true
+}
+
+// relayOwner returns the node hosting session sessionId, if the handler
+// relays it, or nil if the handler hosts it, does not relay sessions, or no
+// live node hosts it.
+func (h *WebRtcSessionHandler) relayOwner(sessionId string) *ClusterNode {
+	if h.relay == nil {
+		return nil
+	}
+	if n := h.relay.owner(sessionId); n != nil && !n.Self {
+		return n
+	}
+	return nil
+}
+
+// relayOf returns the relay of a session hosted by another node, along with
+// the media of its participant, or nil if it is not relayed.
+func (h *WebRtcSessionHandler) relayOf(sessionId string) (*sessionRelay, *participantMedia) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	s := h.relayed[sessionId]
+	if s == nil {
+		return nil, nil
+	}
+	return s.relay, s.participants[s.relay.participantId].media
+}
+
+// relayRequest sends a request of the relay of a session to node n,
+// hosting the session, as a request forwarded by this node. The response
+// is decoded into v, if any, unless the request failed. It returns the
+// status of the response.
+func (h *WebRtcSessionHandler) relayRequest(n *ClusterNode, method string, path string, body any, v any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, n.URL+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(clusterForwardedHeader, h.relay.forwardedHeader(req, time.Now()))
+	req.Header.Set(clusterRelayHeader, "1")
+	resp, err := relayClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if v != nil && resp.StatusCode < http.StatusMultipleChoices {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return 0, fmt.Errorf("invalid response from node %s: %w", n.Name, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// unexpectedStatus creates the error returned when node n answers a request
+// of a relay with status.
+func unexpectedStatus(n *ClusterNode, method string, path string, status int) error {
+	return fmt.Errorf("node %s answered %s %s with %d", n.Name, method, path, status)
+}
+
+// relayPath returns the path of the participants of session sessionId, or
+// of one of them, in the REST API of the node hosting it.
+func relayPath(sessionId string, participant ...string) string {
+	path := "/v2/sessions/" + sessionId + "/participants"
+	for _, p := range participant {
+		path += "/" + p
+	}
+	return path
+}
+
+// openRelay opens the relay of a session hosted by node owner, unless it is
+// already open. It returns false if no such session exists.
+func (h *WebRtcSessionHandler) openRelay(sessionId string, owner *ClusterNode) (bool, error) {
+	h.relaying.Lock()
+	defer h.relaying.Unlock()
+	if relay, _ := h.relayOf(sessionId); relay != nil {
+		return true, nil
+	}
+	path := relayPath(sessionId)
+	var result AddParticipantResult
+	status, err := h.relayRequest(owner, http.MethodPost, path, AddParticipantParams{Name: "relay " + h.relay.self.Name}, &result)
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+	if status != http.StatusCreated || result.Participant == nil {
+		return false, unexpectedStatus(owner, http.MethodPost, path, status)
+	}
+	relay := &sessionRelay{participantId: result.Participant.Id, done: make(chan struct{})}
+	p := &webRtcParticipant{Participant: *result.Participant, media: newParticipantMedia()}
+	p.media.relay = true
+	h.locker.Lock()
+	h.relayed[sessionId] = &webRtcSession{
+		Session:      Session{Id: sessionId},
+		participants: map[string]*webRtcParticipant{p.Id: p},
+		relay:        relay,
+	}
+	h.locker.Unlock()
+	logger.LogInfoC(clusterLogContext, "relaying session %s to node %s", sessionId, owner.Name)
+	go h.runRelay(sessionId, relay)
+	return true, nil
+}
+
+// negotiateRelayed answers the offer of a participant of a session hosted
+// by another node, once the tracks of the session are relayed to this node.
+// The limits of the session are checked against the participants of all
+// nodes.
+func (h *WebRtcSessionHandler) negotiateRelayed(params NegotiateParams, owner *ClusterNode, offer webrtc.SessionDescription, offered offeredTracks) (NegotiateResult, error) {
+	found, err := h.openRelay(params.SessionId, owner)
+	if err != nil {
+		return NegotiateResult{}, err
+	}
+	var session GetSessionResult
+	var participants GetParticipantsResult
+	if found {
+		path := "/v2/sessions/" + params.SessionId
+		status, err := h.relayRequest(owner, http.MethodGet, path, nil, &session)
+		if err != nil {
+			return NegotiateResult{}, err
+		}
+		if status == http.StatusOK {
+			path = relayPath(params.SessionId)
+			if status, err = h.relayRequest(owner, http.MethodGet, path, nil, &participants); err != nil {
+				return NegotiateResult{}, err
+			}
+		}
+		if status != http.StatusOK && status != http.StatusNotFound {
+			return NegotiateResult{}, unexpectedStatus(owner, http.MethodGet, path, status)
+		}
+	}
+	if session.Session == nil {
+		errors := []FieldError{noSuchSession(params.SessionId)}
+		return NegotiateResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	var participant *Participant
+	for _, p := range participants.Participants {
+		if p.Id == params.ParticipantId && !p.Relay {
+			participant = p
+		}
+	}
+	if participant == nil {
+		return NegotiateResult{}, nil
+	}
+	var media *participantMedia
+	var limit LimitKind
+	var limitValue int
+	action := func(s *webRtcSession) {
+		var others []offeredTracks
+		for _, other := range participants.Participants {
+			if other.Id == participant.Id || other.Relay {
+				continue
+			}
+			if local := s.participants[other.Id]; local != nil {
+				others = append(others, local.publishedTracks())
+			} else {
+				others = append(others, countTracks(other.Tracks))
+			}
+		}
+		if limit, limitValue = exceededLimit(session.Session.Limits, others, offered); limit != "" {
+			return
+		}
+		p := s.participants[participant.Id]
+		if p == nil {
+			p = &webRtcParticipant{Participant: *participant}
+			s.participants[p.Id] = p
+		}
+		if p.media == nil {
+			p.media = newParticipantMedia()
+		}
+		p.media.offered = offered
+		media = p.media
+	}
+	if ok := h.doActionOnMedia(params.SessionId, action); !ok {
+		// the relay was closed meanwhile
+		errors := []FieldError{noSuchSession(params.SessionId)}
+		return NegotiateResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	if limit != "" {
+		errors := []FieldError{limitError(limit, limitValue)}
+		return NegotiateResult{Errors: errorDetails(errors), FieldErrors: errors, Limit: limit}, nil
+	}
+	if err = h.syncRelay(params.SessionId); err != nil {
+		return NegotiateResult{}, err
+	}
+	result, err := h.negotiate(params, media, offer)
+	if result.Participant != nil {
+		// the node hosting the session holds the participant
+		result.Participant = participant
+	}
+	return result, err
+}
+
+// syncRelay negotiates the relay of a session hosted by another node
+// again, if needed, so it publishes the tracks of the participants of this
+// node and receives the tracks of the participants of the others, which it
+// waits for until relayTrackTimeout. Participants who left the session are
+// removed from its mirror.
+func (h *WebRtcSessionHandler) syncRelay(sessionId string) error {
+	owner := h.relayOwner(sessionId)
+	relay, media := h.relayOf(sessionId)
+	if owner == nil || relay == nil {
+		return nil
+	}
+	media.negotiating.Lock()
+	defer media.negotiating.Unlock()
+	path := relayPath(sessionId)
+	var participants GetParticipantsResult
+	status, err := h.relayRequest(owner, http.MethodGet, path, nil, &participants)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		go h.closeRelay(sessionId)
+		return nil
+	}
+	if status != http.StatusOK {
+		return unexpectedStatus(owner, http.MethodGet, path, status)
+	}
+	wanted, removed := h.updateMirror(sessionId, participants.Participants)
+	closePeerConnections(removed)
+	if media.pc == nil {
+		if attached, err := h.attachPeerConnection(sessionId, relay.participantId, media); err != nil || !attached {
+			return err
+		}
+	}
+	subscribed := len(media.subscribed)
+	previous := make(map[string]bool, subscribed)
+	for id := range media.subscribed {
+		previous[id] = true
+	}
+	if err = h.subscribe(sessionId, relay.participantId, media); err != nil {
+		return err
+	}
+	changed := len(media.subscribed) != subscribed
+	for id := range media.subscribed {
+		changed = changed || !previous[id]
+	}
+	// tracks are received on new media sections, as the node hosting the
+	// session answers
+	receiving := make(map[string]bool)
+	for _, t := range media.pc.GetTransceivers() {
+		if t.Direction() != webrtc.RTPTransceiverDirectionRecvonly || t.Receiver() == nil {
+			continue
+		}
+		if track := t.Receiver().Track(); track != nil {
+			receiving[track.ID()] = true
+		}
+	}
+	for id, kind := range wanted {
+		if receiving[id] {
+			continue
+		}
+		codecType := webrtc.NewRTPCodecType(string(kind))
+		if _, err = media.pc.AddTransceiverFromKind(codecType,
+			webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err = h.offerRelay(sessionId, owner, relay, media); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(relayTrackTimeout); !h.relaysAll(sessionId, wanted); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			logger.LogWarnC(clusterLogContext, "tracks of session %s were not relayed by node %s in time", sessionId, owner.Name)
+			break
+		}
+	}
+	return nil
+}
+
+// updateRelay synchronizes the relay of a session hosted by another node,
+// as a participant of this node published or unpublished a track.
+func (h *WebRtcSessionHandler) updateRelay(sessionId string) {
+	if err := h.syncRelay(sessionId); err != nil {
+		logger.LogWarnC(clusterLogContext, "failed to synchronize the relay of session %s: %s", sessionId, err)
+	}
+}
+
+// updateMirror removes the participants of the mirror of a session hosted
+// by another node who left it, given all participants of the session, as
+// well as the tracks relayed to the mirror which were unpublished. It
+// returns the kinds of the tracks the relay of the session should receive,
+// by id, and the PeerConnections of the removed participants, which must
+// be closed without holding the lock of the handler.
+func (h *WebRtcSessionHandler) updateMirror(sessionId string, participants []*Participant) (map[string]TrackKind, []*webrtc.PeerConnection) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	wanted := make(map[string]TrackKind)
+	s := h.relayed[sessionId]
+	if s == nil {
+		return wanted, nil
+	}
+	listed := make(map[string]bool, len(participants))
+	for _, p := range participants {
+		listed[p.Id] = true
+		if p.Relay || s.participants[p.Id] != nil {
+			continue
+		}
+		for _, t := range p.Tracks {
+			wanted[t.Id] = t.Kind
+		}
+	}
+	var removed []*webrtc.PeerConnection
+	for id, p := range s.participants {
+		if id == s.relay.participantId || listed[id] {
+			continue
+		}
+		logger.LogDebugC(clusterLogContext, "participant %s left relayed session %s", id, sessionId)
+		s.removeParticipant(id, h.clock.Now())
+		if pc := p.peerConnection(); pc != nil {
+			removed = append(removed, pc)
+		}
+	}
+	relayed := s.participants[s.relay.participantId].media.published
+	for id, t := range relayed {
+		if _, ok := wanted[id]; !ok {
+			delete(relayed, id)
+			t.recorder.stop()
+		}
+	}
+	return wanted, removed
+}
+
+// offerRelay negotiates the PeerConnection of the relay of a session with
+// node owner, hosting the session. It must be called while holding the
+// negotiating lock of the relay.
+func (h *WebRtcSessionHandler) offerRelay(sessionId string, owner *ClusterNode, relay *sessionRelay, media *participantMedia) error {
+	offer, err := media.pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	gathered := webrtc.GatheringCompletePromise(media.pc)
+	if err = media.pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	<-gathered
+	path := relayPath(sessionId, relay.participantId, "negotiate")
+	var result NegotiateResult
+	status, err := h.relayRequest(owner, http.MethodPost, path, NegotiateParams{Offer: media.pc.LocalDescription().SDP}, &result)
+	if err == nil && status != http.StatusOK {
+		err = unexpectedStatus(owner, http.MethodPost, path, status)
+	}
+	if err != nil {
+		// the offer is negotiated again on the next synchronization
+		media.pc.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback})
+		return err
+	}
+	return media.pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: result.Answer})
+}
+
+// relaysAll returns whether the relay of a session forwards all the tracks
+// wanted.
+func (h *WebRtcSessionHandler) relaysAll(sessionId string, wanted map[string]TrackKind) bool {
+	all := true
+	h.doActionOnMedia(sessionId, func(s *webRtcSession) {
+		if s.relay == nil {
+			return
+		}
+		relayed := s.participants[s.relay.participantId].media.published
+		for id := range wanted {
+			all = all && relayed[id] != nil
+		}
+	})
+	return all
+}
+
+// runRelay checks the relay of a session every relayHeartbeatInterval,
+// until it is closed.
+func (h *WebRtcSessionHandler) runRelay(sessionId string, relay *sessionRelay) {
+	ticker := time.NewTicker(relayHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-relay.done:
+			return
+		case <-ticker.C:
+			h.checkRelay(sessionId)
+		}
+	}
+}
+
+// checkRelay sends a heartbeat of the relay of a session to the node
+// hosting it and synchronizes the relay. The relay is closed once the
+// session, or the node hosting it, is gone or no participant of the session
+// negotiates with this node anymore.
+func (h *WebRtcSessionHandler) checkRelay(sessionId string) {
+	owner := h.relayOwner(sessionId)
+	relay, _ := h.relayOf(sessionId)
+	if relay == nil {
+		return
+	}
+	if owner == nil {
+		h.closeRelay(sessionId)
+		return
+	}
+	path := relayPath(sessionId, relay.participantId, "heartbeat")
+	status, err := h.relayRequest(owner, http.MethodPost, path, nil, nil)
+	if err != nil {
+		logger.LogWarnC(clusterLogContext, "failed to send the heartbeat of the relay of session %s: %s", sessionId, err)
+		return
+	}
+	if status == http.StatusNotFound {
+		h.closeRelay(sessionId)
+		return
+	}
+	if err = h.syncRelay(sessionId); err != nil {
+		logger.LogWarnC(clusterLogContext, "failed to synchronize the relay of session %s: %s", sessionId, err)
+	}
+	idle := false
+	h.doActionOnMedia(sessionId, func(s *webRtcSession) {
+		idle = s.relay != nil && len(s.participants) == 1
+	})
+	if idle {
+		h.closeRelay(sessionId)
+	}
+}
+
+// closeRelay closes the relay of a session hosted by another node, along
+// with the PeerConnections of the participants negotiating with this node,
+// and removes its participant from the session.
+func (h *WebRtcSessionHandler) closeRelay(sessionId string) {
+	h.locker.Lock()
+	s := h.relayed[sessionId]
+	if s == nil {
+		h.locker.Unlock()
+		return
+	}
+	delete(h.relayed, sessionId)
+	close(s.relay.done)
+	pcs := s.peerConnections()
+	h.locker.Unlock()
+	closePeerConnections(pcs)
+	logger.LogInfoC(clusterLogContext, "closed the relay of session %s", sessionId)
+	owner := h.relayOwner(sessionId)
+	if owner == nil {
+		return
+	}
+	path := relayPath(sessionId, s.relay.participantId)
+	if _, err := h.relayRequest(owner, http.MethodDelete, path, nil, nil); err != nil {
+		logger.LogWarnC(clusterLogContext, "failed to remove the relay of session %s from node %s: %s", sessionId, owner.Name, err)
+	}
+}
+
+// relayConnectionState reports the connection state of a participant of a
+// session hosted by another node to that node.
+func (h *WebRtcSessionHandler) relayConnectionState(sessionId string, participantId string, state ConnectionState) {
+	owner := h.relayOwner(sessionId)
+	if owner == nil {
+		return
+	}
+	path := relayPath(sessionId, participantId, "heartbeat")
+	if _, err := h.relayRequest(owner, http.MethodPost, path, HeartbeatParams{ConnectionState: state}, nil); err != nil {
+		logger.LogWarnC(clusterLogContext, "failed to report the connection state of participant %s: %s", participantId, err)
+	}
+}
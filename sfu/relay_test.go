@@ -0,0 +1,182 @@
+package sfu
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pion/webrtc/v3"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRelayNode creates a node named name relaying the sessions of the other
+// nodes, and starts it, joining the nodes of join. It is closed once the
+// test ends.
+func newRelayNode(t *testing.T, name string, join ...*clusterNode) *clusterNode {
+	t.Helper()
+	api := httptest.NewUnstartedServer(nil)
+	config := ClusterConfig{NodeName: name, BindAddr: freeAddress(t), AdvertiseURL: "http://" + api.Listener.Addr().String(), Relay: true}
+	for _, n := range join {
+		config.Join = append(config.Join, n.cluster.config.BindAddr)
+	}
+	c, err := NewCluster(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewWebRtcSessionHandler(WithSessionIdPrefix(c.SessionIdPrefix()), WithSettingEngine(loopbackSettings()), WithRelay(c))
+	if err = c.Start(h.Stats); err != nil {
+		t.Fatal(err)
+	}
+	api.Config.Handler = (&Server{Cluster: c}).newRouter(h)
+	api.Start()
+	n := &clusterNode{cluster: c, handler: h, api: api}
+	t.Cleanup(func() {
+		h.Shutdown(context.Background())
+		n.close()
+	})
+	return n
+}
+
+// newReceiver returns the PeerConnection of a participant receiving count
+// video tracks, and the channel of the tracks it receives media of.
+func newReceiver(t *testing.T, count int) (*webrtc.PeerConnection, <-chan *webrtc.TrackRemote) {
+	t.Helper()
+	pc := newTestPeer(t)
+	for i := 0; i < count; i++ {
+		if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo,
+			webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received := make(chan *webrtc.TrackRemote, count)
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if _, _, err := remote.ReadRTP(); err == nil {
+			received <- remote
+		}
+		for {
+			if _, _, err := remote.ReadRTP(); err != nil {
+				return
+			}
+		}
+	})
+	return pc, received
+}
+
+// waitForTrack returns the next track of received.
+func waitForTrack(t *testing.T, received <-chan *webrtc.TrackRemote) *webrtc.TrackRemote {
+	t.Helper()
+	select {
+	case remote := <-received:
+		return remote
+	case <-time.After(10 * time.Second):
+		t.Fatal("no track received")
+		return nil
+	}
+}
+
+// tracksOf returns the tracks of a participant of a session of h.
+func tracksOf(h SessionHandler, sessionId string, participantId string) []Track {
+	result, _ := h.GetParticipant(GetParticipantParams{SessionId: sessionId, ParticipantId: participantId})
+	if result.Participant == nil {
+		return nil
+	}
+	return result.Participant.Tracks
+}
+
+func TestRelayTracks(t *testing.T) {
+	a := newRelayNode(t, "node-a")
+	b := newRelayNode(t, "node-b", a)
+	created, _ := a.handler.CreateSession(CreateSessionParams{Name: "standup"})
+	sessionId := created.Session.Id
+	participants := "/v1/sessions/" + sessionId + "/participants"
+	var alice, bob, carol, dave AddParticipantResult
+	for name, result := range map[string]*AddParticipantResult{"alice": &alice, "bob": &bob, "carol": &carol, "dave": &dave} {
+		tracedRequest(t, b.api, http.MethodPost, participants, `{"name":"`+name+`"}`, result)
+	}
+
+	// alice publishes on node-a, bob receives her track through node-b
+	publishVideo(t, a.handler, sessionId, alice.Participant.Id)
+	waitUntil(t, func() bool { return len(tracksOf(a.handler, sessionId, alice.Participant.Id)) == 1 })
+	track := tracksOf(a.handler, sessionId, alice.Participant.Id)[0]
+	subscriber, received := newReceiver(t, 1)
+	body, _ := json.Marshal(NegotiateParams{Offer: offer(t, subscriber)})
+	var negotiated NegotiateResult
+	tracedRequest(t, b.api, http.MethodPost, participants+"/"+bob.Participant.Id+"/negotiate", string(body), &negotiated)
+	if err := subscriber.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: negotiated.Answer}); err != nil {
+		t.Fatal(err)
+	}
+	if remote := waitForTrack(t, received); remote.StreamID() != alice.Participant.Id || remote.ID() != track.Id {
+		t.Errorf("bob received track %s of stream %s, want track %s of alice", remote.ID(), remote.StreamID(), track.Id)
+	}
+
+	// carol publishes through node-b, receiving the track of alice, and her
+	// track is hers on node-a
+	publisher, _ := newReceiver(t, 1)
+	publishVideoWith(t, b.handler, sessionId, carol.Participant.Id, publisher)
+	waitUntil(t, func() bool { return len(tracksOf(a.handler, sessionId, carol.Participant.Id)) == 1 })
+	relayed := tracksOf(a.handler, sessionId, carol.Participant.Id)[0]
+	listed, _ := a.handler.GetParticipants(GetParticipantsParams{SessionId: sessionId})
+	relays := 0
+	for _, p := range listed.Participants {
+		if p.Relay {
+			relays++
+			if len(p.Tracks) != 0 {
+				t.Errorf("relay publishes %v, want the tracks of carol reported as hers", p.Tracks)
+			}
+		}
+	}
+	if relays != 1 {
+		t.Errorf("session has %d relays, want 1", relays)
+	}
+
+	// dave receives the tracks of alice and carol on node-a
+	subscriber, received = newReceiver(t, 2)
+	negotiate(t, a.handler, sessionId, dave.Participant.Id, subscriber)
+	streams := map[string]string{}
+	for i := 0; i < 2; i++ {
+		remote := waitForTrack(t, received)
+		streams[remote.StreamID()] = remote.ID()
+	}
+	if streams[alice.Participant.Id] != track.Id || streams[carol.Participant.Id] != relayed.Id {
+		t.Errorf("dave received tracks %v, want %s of alice and %s of carol", streams, track.Id, relayed.Id)
+	}
+
+	// the relay is closed once the participants of node-b left
+	for _, p := range []AddParticipantResult{bob, carol} {
+		tracedRequest(t, b.api, http.MethodDelete, participants+"/"+p.Participant.Id, "", nil)
+	}
+	b.handler.checkRelay(sessionId)
+	if relay, _ := b.handler.relayOf(sessionId); relay != nil {
+		t.Error("relay is open without participants on node-b")
+	}
+	listed, _ = a.handler.GetParticipants(GetParticipantsParams{SessionId: sessionId})
+	for _, p := range listed.Participants {
+		if p.Relay {
+			t.Errorf("relay %s is still a participant after it was closed", p.Id)
+		}
+	}
+}
+
+func TestRelayHeaderOfClients(t *testing.T) {
+	a := newRelayNode(t, "node-a")
+	b := newRelayNode(t, "node-b", a)
+	created, _ := a.handler.CreateSession(CreateSessionParams{Name: "standup"})
+	path := "/v1/sessions/" + created.Session.Id + "/participants"
+	// directly, or proxied by node-b
+	for _, n := range []*clusterNode{a, b} {
+		req, _ := http.NewRequest(http.MethodPost, n.api.URL+path, strings.NewReader(`{"name":"mallory"}`))
+		req.Header.Set(clusterRelayHeader, "1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result AddParticipantResult
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if result.Participant == nil || result.Participant.Relay {
+			t.Errorf("POST %s with a relay header through %s = %d, %+v, want a participant", path, n.cluster.self.Name, resp.StatusCode, result.Participant)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// problemContentType is the content type of problem details.
+const problemContentType = "application/problem+json"
+
+// Problem is the body of error responses of the REST API, as per RFC 7807.
+type Problem struct {
+	// URI identifying the type of problem. Always about:blank, as the
+	// problem is identified by its status and errors.
+	Type string `json:"type"`
+	// Summary of the problem, the text of its status.
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Explanation of this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+	// Path of the request which caused the problem.
+	Instance string `json:"instance,omitempty"`
+	// Errors found in the request, with a machine-readable code each.
+	Errors []FieldError `json:"errors,omitempty"`
+	// Capacity limit that prevented the request, if any.
+	Limit LimitKind `json:"limit,omitempty"`
+}
+
+// outcome holds the outcome of an operation of the REST API.
+type outcome struct {
+	// Result of the operation, written as the body of the response.
+	result any
+	// Status of the response when the operation succeeded.
+	status int
+	// Errors reported in the result, if any.
+	errors []FieldError
+	// Capacity limit reported in the result, if any.
+	limit LimitKind
+	// Whether the object the operation is about does not exist.
+	notFound bool
+	// Entity tag of the object the operation is about, if any.
+	etag string
+	// Whether the object the operation is about is not modified since the
+	// revision given by the request.
+	notModified bool
+}
+
+// writeOutcome writes the response to r reporting outcome o. Operations
+// which reached a capacity limit are reported with limitStatus, those on
+// objects which do not exist with 404, those whose conditions do not hold
+// with 412, those reusing an idempotency key with 422, and those with
+// errors with 400.
+func writeOutcome(w http.ResponseWriter, r *http.Request, o outcome) {
+	if o.etag != "" {
+		w.Header().Set("ETag", o.etag)
+	}
+	switch {
+	case o.limit != "":
+		logger.LogWarnC(r.Context(), "limit reached: %s", o.errors)
+		writeError(w, r, limitStatus(o.limit), o.result, o.errors, o.limit)
+	case hasErrorCode(o.errors, ErrorNotFound):
+		logger.LogDebugC(r.Context(), "not found: %s", o.errors)
+		writeError(w, r, http.StatusNotFound, o.result, o.errors, "")
+	case hasErrorCode(o.errors, ErrorPreconditionFailed):
+		logger.LogDebugC(r.Context(), "precondition failed: %s", o.errors)
+		writeError(w, r, http.StatusPreconditionFailed, o.result, o.errors, "")
+	case hasErrorCode(o.errors, ErrorIdempotencyKeyReused):
+		logger.LogWarnC(r.Context(), "idempotency key reused: %s", o.errors)
+		writeError(w, r, http.StatusUnprocessableEntity, o.result, o.errors, "")
+	case o.errors != nil:
+		logger.LogWarnC(r.Context(), "bad request: %s", o.errors)
+		writeError(w, r, http.StatusBadRequest, o.result, o.errors, "")
+	case o.notFound:
+		logger.LogDebugC(r.Context(), "not found: %s", r.URL.Path)
+		writeError(w, r, http.StatusNotFound, nil, nil, "")
+	case o.notModified:
+		w.WriteHeader(http.StatusNotModified)
+	default:
+		writeJSON(w, r, o.status, o.result)
+	}
+}
+
+// legacyErrorsKey is the key of the compatibility mode for legacy errors in
+// the context of requests.
+type legacyErrorsKey struct{}
+
+// errorFormatMiddleware is called before handling any http request routed
+// to a version of the REST API. When the server reports legacy errors,
+// requests to versions supporting them are served in the compatibility
+// mode for legacy errors, where errors are reported as plain messages in
+// the errors of results, unless they accept problem details. Other
+// requests get problem details.
+func (s *Server) errorFormatMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v, ok := apiVersions[mux.Vars(r)["version"]]; ok && s.LegacyErrors && v.legacyErrors && !acceptsProblemDetails(r) {
+			r = r.WithContext(context.WithValue(r.Context(), legacyErrorsKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptsProblemDetails returns whether the Accept header of request r
+// explicitly accepts problem details.
+func acceptsProblemDetails(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			t, params, err := mime.ParseMediaType(mediaRange)
+			if err == nil && t == problemContentType && params["q"] != "0" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasLegacyErrors returns whether request r is served in the compatibility
+// mode for legacy errors.
+func hasLegacyErrors(r *http.Request) bool {
+	legacy, _ := r.Context().Value(legacyErrorsKey{}).(bool)
+	return legacy
+}
+
+// writeError writes an error response to r with status, a Problem holding
+// errors and limit. In the compatibility mode for legacy errors, result is
+// written instead, with errors as plain messages, or no body when result
+// is nil.
+func writeError(w http.ResponseWriter, r *http.Request, status int, result any, errors []FieldError, limit LimitKind) {
+	if hasLegacyErrors(r) {
+		if result == nil {
+			w.WriteHeader(status)
+			return
+		}
+		body, err := legacyBody(result, errors)
+		if err != nil {
+			writeInternalError(w, r, err)
+			return
+		}
+		writeJSON(w, r, status, body)
+		return
+	}
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   strings.Join(errorDetails(errors), ", "),
+		Instance: r.URL.Path,
+		Errors:   errors,
+		Limit:    limit,
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	writeJSON(w, r, status, problem)
+}
+
+// legacyBody returns result, with errors replaced by their details, as
+// written in the compatibility mode for legacy errors.
+func legacyBody(result any, errors []FieldError) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string]json.RawMessage
+	if err = json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	if errors != nil {
+		if body["errors"], err = json.Marshal(errorDetails(errors)); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// writeJSON writes a response to r with status and body encoded as JSON.
+// The body is encoded before anything is written, so encoding errors are
+// reported as 500.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, body any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		logger.LogErrorC(r.Context(), "failed to encode result: %s", err)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	if _, err = w.Write(append(data, '\n')); err != nil {
+		logger.LogWarnC(r.Context(), "failed to write response: %s", err)
+	}
+}
+
+// writeInternalError writes the response to r when an unexpected error
+// prevented serving it.
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	logger.LogErrorC(r.Context(), "handling error: %s", err)
+	writeError(w, r, http.StatusInternalServerError, nil, nil, "")
+}
+
+// writeDecodingError writes the response to r when its body cannot be
+// decoded.
+func writeDecodingError(w http.ResponseWriter, r *http.Request, err error) {
+	logger.LogWarnC(r.Context(), "decoding error: %s", err)
+	writeError(w, r, http.StatusBadRequest, nil,
+		[]FieldError{{Code: ErrorMalformedBody, Detail: err.Error()}}, "")
+}
+
+// writeMethodNotAllowed writes the response to r when its method is not
+// one of the allowed methods of the route.
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	logger.LogWarnC(r.Context(), "operation not supported: %s", r.Method)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, r, http.StatusMethodNotAllowed, nil, nil, "")
+}
@@ -0,0 +1,93 @@
+package sfu
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Conditions holds the conditions on the current revision of the object
+// of an operation, as given by the If-Match and If-None-Match headers of
+// its request. The operation is only applied when they hold.
+type Conditions struct {
+	// Entity tags one of which must be the current one, "*" matching any.
+	// No condition applies when nil.
+	IfMatch []string
+	// Entity tags none of which may be the current one, "*" matching any.
+	// No condition applies when nil.
+	IfNoneMatch []string
+}
+
+// ETag returns the entity tag of an object at revision.
+func ETag(revision int64) string {
+	return strconv.Quote(strconv.FormatInt(revision, 10))
+}
+
+// check verifies whether the conditions hold for an object at revision.
+// It returns the error reported for the first one which does not, or nil.
+func (c Conditions) check(revision int64) *FieldError {
+	if c.IfMatch != nil && !matchesETag(c.IfMatch, revision, false) {
+		return &FieldError{Field: "If-Match", Code: ErrorPreconditionFailed,
+			Detail: fmt.Sprintf("If-Match does not match the current revision %d", revision)}
+	}
+	if c.IfNoneMatch != nil && matchesETag(c.IfNoneMatch, revision, true) {
+		return &FieldError{Field: "If-None-Match", Code: ErrorPreconditionFailed,
+			Detail: fmt.Sprintf("If-None-Match matches the current revision %d", revision)}
+	}
+	return nil
+}
+
+// matchesETag returns whether one of tags matches the entity tag of an
+// object at revision. Weak tags only match when weak is set, as per the
+// weak comparison of If-None-Match.
+func matchesETag(tags []string, revision int64, weak bool) bool {
+	etag := ETag(revision)
+	for _, t := range tags {
+		if weak {
+			t = strings.TrimPrefix(t, "W/")
+		}
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// requestConditions returns the conditions given by the If-Match and
+// If-None-Match headers of r.
+func requestConditions(r *http.Request) Conditions {
+	return Conditions{
+		IfMatch:     entityTags(r.Header.Values("If-Match")),
+		IfNoneMatch: entityTags(r.Header.Values("If-None-Match")),
+	}
+}
+
+// entityTags returns the entity tags of the comma separated lists of
+// values, or nil if there are none.
+func entityTags(values []string) []string {
+	var tags []string
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// checkRead evaluates the conditions of r, a request reading an object at
+// revision, and sets the entity tag of o. As per RFC 9110, a failed
+// If-Match condition is reported as an error, while a matched
+// If-None-Match one reports the object as not modified.
+func (o *outcome) checkRead(r *http.Request, revision int64) {
+	o.etag = ETag(revision)
+	if failed := requestConditions(r).check(revision); failed != nil {
+		if failed.Field == "If-None-Match" {
+			o.notModified = true
+		} else {
+			o.errors = append(o.errors, *failed)
+		}
+	}
+}
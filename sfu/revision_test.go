@@ -0,0 +1,52 @@
+package sfu
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestETagMatchesBody checks the ETag of session reads is the revision of
+// the session in their body while the session is being updated.
+func TestETagMatchesBody(t *testing.T) {
+	h := NewWebRtcSessionHandler()
+	ts := httptest.NewServer((&Server{}).newRouter(h))
+	defer ts.Close()
+	created, err := h.CreateSession(CreateSessionParams{Name: "etag"})
+	if err != nil || created.Session == nil {
+		t.Fatalf("CreateSession() = %+v, %v", created, err)
+	}
+	id := created.Session.Id
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			patch := fmt.Sprintf(`{"metadata":{"round":"%d"}}`, i)
+			if _, err := h.UpdateSession(UpdateSessionParams{Id: id, Patch: json.RawMessage(patch)}); err != nil {
+				t.Errorf("UpdateSession() error = %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		resp, err := http.Get(ts.URL + "/v1/sessions/" + id)
+		if err != nil {
+			t.Fatalf("GET session: %v", err)
+		}
+		var result GetSessionResult
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil || result.Session == nil {
+			t.Fatalf("GET session = %+v, %v", result, err)
+		}
+		if etag := resp.Header.Get("ETag"); etag != ETag(result.Session.Revision) {
+			t.Fatalf("ETag = %s, want %s", etag, ETag(result.Session.Revision))
+		}
+	}
+	wg.Wait()
+}
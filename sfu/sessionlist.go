@@ -0,0 +1,135 @@
+package sfu
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sessionCursor holds the position, in a list of sessions, of the last
+// session of a page. Cursors are given to clients as opaque strings.
+type sessionCursor struct {
+	Sort       SessionSort `json:"s"`
+	Descending bool        `json:"d,omitempty"`
+	// Sort key and id of the last session of the page.
+	Key string `json:"k"`
+	Id  string `json:"i"`
+}
+
+// encode returns the cursor as an opaque string.
+func (c sessionCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sort returns the order of listed sessions.
+func (p ListSessionsParams) sort() SessionSort {
+	if p.Sort == "" {
+		return SortByCreation
+	}
+	return p.Sort
+}
+
+// cursor decodes the cursor of the page, which must have been returned
+// for a list in the same order.
+func (p ListSessionsParams) cursor() (sessionCursor, *FieldError) {
+	var c sessionCursor
+	data, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return c, &FieldError{Field: "cursor", Code: ErrorInvalidValue, Detail: "cursor must be returned by a previous page"}
+	}
+	if c.Sort != p.sort() || c.Descending != p.Descending {
+		return c, &FieldError{Field: "cursor", Code: ErrorInvalidValue, Detail: "cursor must be used with the sort of its list"}
+	}
+	return c, nil
+}
+
+// matches returns whether session s is listed.
+func (p ListSessionsParams) matches(s *webRtcSession) bool {
+	if !strings.HasPrefix(s.Name, p.NamePrefix) {
+		return false
+	}
+	// both date times were validated by check
+	if before, err := time.Parse(time.RFC3339, p.CreatedBefore); err == nil && !s.created.Before(before) {
+		return false
+	}
+	if after, err := time.Parse(time.RFC3339, p.CreatedAfter); err == nil && !s.created.After(after) {
+		return false
+	}
+	if p.HasParticipants != nil && *p.HasParticipants != (len(s.participants) > 0) {
+		return false
+	}
+	for k, v := range p.Metadata {
+		if value, ok := s.Metadata[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// sortKey returns the key of session s in lists sorted by sort. Keys of
+// creation times are zero padded so they are sorted as strings.
+func sortKey(s *webRtcSession, sort SessionSort) string {
+	if sort == SortByName {
+		return s.Name
+	}
+	return fmt.Sprintf("%020d", s.created.UnixNano())
+}
+
+// listedSession is a session of a list along with its position.
+type listedSession struct {
+	key     string
+	session Session
+}
+
+// before returns whether l is listed before the session at key and id in
+// a list in ascending order, ties on keys being broken by ids.
+func (l listedSession) before(key string, id string) bool {
+	if l.key != key {
+		return l.key < key
+	}
+	return l.session.Id < id
+}
+
+// page returns the page of sessions starting after the cursor of p, along
+// with the cursor of the next page, if any.
+func (p ListSessionsParams) page(sessions []listedSession) ([]*Session, string) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if p.Descending {
+			return sessions[j].before(sessions[i].key, sessions[i].session.Id)
+		}
+		return sessions[i].before(sessions[j].key, sessions[j].session.Id)
+	})
+	if p.Cursor != "" {
+		c, _ := p.cursor()
+		start := sort.Search(len(sessions), func(i int) bool {
+			l := sessions[i]
+			if p.Descending {
+				return l.before(c.Key, c.Id)
+			}
+			return !l.before(c.Key, c.Id) && (l.key != c.Key || l.session.Id != c.Id)
+		})
+		sessions = sessions[start:]
+	}
+	limit := p.Limit
+	if limit == 0 {
+		limit = DefaultListLimit
+	}
+	next := ""
+	if len(sessions) > limit {
+		sessions = sessions[:limit]
+		last := sessions[limit-1]
+		next = sessionCursor{Sort: p.sort(), Descending: p.Descending, Key: last.key, Id: last.session.Id}.encode()
+	}
+	page := make([]*Session, len(sessions))
+	for i := range sessions {
+		page[i] = &sessions[i].session
+	}
+	return page, next
+}
@@ -0,0 +1,188 @@
+package sfu
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newListedSessions creates sessions named names, a minute apart except
+// for the last two created at the same time. Sessions whose name starts
+// with a vowel have a participant and are of team core, the others of
+// team web.
+func newListedSessions(t *testing.T, clock *fakeClock, names ...string) *WebRtcSessionHandler {
+	t.Helper()
+	h := NewWebRtcSessionHandler(WithClock(clock))
+	for i, name := range names {
+		if i < len(names)-1 {
+			clock.advance(time.Minute)
+		}
+		team := "web"
+		if strings.ContainsAny(name[:1], "aeiou") {
+			team = "core"
+		}
+		created, err := h.CreateSession(CreateSessionParams{Name: name, Metadata: map[string]string{"team": team}})
+		if err != nil || created.Session == nil {
+			t.Fatalf("CreateSession() = %+v, %v", created, err)
+		}
+		if team == "core" {
+			h.AddParticipant(AddParticipantParams{SessionId: created.Session.Id, Name: "alice"})
+		}
+	}
+	return h
+}
+
+// listNames lists every session with p, a page of limit sessions at a
+// time, and returns their names.
+func listNames(t *testing.T, h *WebRtcSessionHandler, p ListSessionsParams, limit int) []string {
+	t.Helper()
+	var names []string
+	p.Limit = limit
+	for pages := 0; pages == 0 || p.Cursor != ""; pages++ {
+		result, err := h.ListSessions(p)
+		if err != nil || result.Errors != nil {
+			t.Fatalf("ListSessions(%+v) = %+v, %v", p, result, err)
+		}
+		if len(result.Sessions) > limit || result.NextCursor != "" && len(result.Sessions) != limit {
+			t.Fatalf("ListSessions(%+v) = %d sessions, next cursor %q, want pages of %d", p, len(result.Sessions), result.NextCursor, limit)
+		}
+		for _, s := range result.Sessions {
+			names = append(names, s.Name)
+		}
+		p.Cursor = result.NextCursor
+	}
+	return names
+}
+
+func TestListSessionsOrders(t *testing.T) {
+	h := newListedSessions(t, newFakeClock(), "delta", "alpha", "echo", "bravo", "charlie")
+	// bravo and charlie were created at the same time, their order is the
+	// one of their ids
+	ties := []string{"bravo", "charlie"}
+	listed, _ := h.ListSessions(ListSessionsParams{NamePrefix: "bravo"})
+	bravo := listed.Sessions[0].Id
+	listed, _ = h.ListSessions(ListSessionsParams{NamePrefix: "charlie"})
+	if listed.Sessions[0].Id < bravo {
+		ties = []string{"charlie", "bravo"}
+	}
+	reversed := func(names []string) []string {
+		r := make([]string, len(names))
+		for i, n := range names {
+			r[len(names)-1-i] = n
+		}
+		return r
+	}
+	byCreation := append([]string{"delta", "alpha", "echo"}, ties...)
+	byName := []string{"alpha", "bravo", "charlie", "delta", "echo"}
+	tests := []struct {
+		sort       SessionSort
+		descending bool
+		want       []string
+	}{
+		{"", false, byCreation},
+		{SortByCreation, true, reversed(byCreation)},
+		{SortByName, false, byName},
+		{SortByName, true, reversed(byName)},
+	}
+	for _, test := range tests {
+		p := ListSessionsParams{Sort: test.sort, Descending: test.descending}
+		for _, limit := range []int{1, 2, 5, MaxListLimit} {
+			if got := listNames(t, h, p, limit); strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("ListSessions() sorted by %q, descending %v, in pages of %d = %v, want %v",
+					test.sort, test.descending, limit, got, test.want)
+			}
+		}
+	}
+}
+
+func TestListSessionsFilters(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	h := newListedSessions(t, clock, "standup", "retro", "sprint", "allhands", "demo")
+	minutes := func(n int) string {
+		return start.Add(time.Duration(n) * time.Minute).Format(time.RFC3339)
+	}
+	yes, no := true, false
+	tests := []struct {
+		name string
+		p    ListSessionsParams
+		want []string
+	}{
+		{"name prefix", ListSessionsParams{NamePrefix: "s"}, []string{"standup", "sprint"}},
+		{"created before", ListSessionsParams{CreatedBefore: minutes(3)}, []string{"standup", "retro"}},
+		{"created after", ListSessionsParams{CreatedAfter: minutes(3)}, []string{"allhands", "demo"}},
+		{"created between", ListSessionsParams{CreatedAfter: minutes(1), CreatedBefore: minutes(4)}, []string{"retro", "sprint"}},
+		{"with participants", ListSessionsParams{HasParticipants: &yes}, []string{"allhands"}},
+		{"without participants", ListSessionsParams{HasParticipants: &no}, []string{"standup", "retro", "sprint", "demo"}},
+		{"metadata", ListSessionsParams{Metadata: map[string]string{"team": "core"}}, []string{"allhands"}},
+		{"unknown metadata", ListSessionsParams{Metadata: map[string]string{"floor": "2"}}, nil},
+		{"every filter", ListSessionsParams{NamePrefix: "s", CreatedAfter: minutes(2), Metadata: map[string]string{"team": "web"}}, []string{"sprint"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the order of sessions is tested by TestListSessionsOrders
+			got := listNames(t, h, test.p, 1)
+			sort.Strings(got)
+			sort.Strings(test.want)
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("ListSessions(%+v) = %v, want %v", test.p, got, test.want)
+			}
+		})
+	}
+}
+
+func TestListSessionsInvalidParams(t *testing.T) {
+	h := newListedSessions(t, newFakeClock(), "alpha", "bravo", "charlie")
+	first, _ := h.ListSessions(ListSessionsParams{Limit: 1})
+	byName, _ := h.ListSessions(ListSessionsParams{Sort: SortByName, Limit: 1})
+	tests := []struct {
+		name  string
+		p     ListSessionsParams
+		field string
+	}{
+		{"garbage cursor", ListSessionsParams{Cursor: "not a cursor"}, "cursor"},
+		{"cursor of another sort", ListSessionsParams{Cursor: byName.NextCursor}, "cursor"},
+		{"cursor of another direction", ListSessionsParams{Cursor: first.NextCursor, Descending: true}, "cursor"},
+		{"unknown sort", ListSessionsParams{Sort: "revision"}, "sort"},
+		{"negative limit", ListSessionsParams{Limit: -1}, "limit"},
+		{"limit too large", ListSessionsParams{Limit: MaxListLimit + 1}, "limit"},
+		{"invalid date", ListSessionsParams{CreatedAfter: "yesterday"}, "createdAfter"},
+	}
+	for _, test := range tests {
+		result, err := h.ListSessions(test.p)
+		if err != nil || !hasFieldError(result.FieldErrors, test.field, ErrorInvalidValue) || len(result.Sessions) != 0 {
+			t.Errorf("ListSessions() with %s = %+v, %v, want an error of %s", test.name, result, err, test.field)
+		}
+	}
+	want := listNames(t, h, ListSessionsParams{}, MaxListLimit)[1:]
+	if got := listNames(t, h, ListSessionsParams{Cursor: first.NextCursor}, 1); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ListSessions() after the first page = %v, want %v", got, want)
+	}
+}
+
+// TestListSessionsEmptyPage checks pages without sessions have an empty
+// array of sessions rather than no sessions at all.
+func TestListSessionsEmptyPage(t *testing.T) {
+	ts := httptest.NewServer((&Server{}).newRouter(NewWebRtcSessionHandler()))
+	defer ts.Close()
+	for _, version := range []string{"v1", "v2"} {
+		resp, err := http.Get(ts.URL + "/" + version + "/sessions")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result map[string]json.RawMessage
+		if err = json.Unmarshal(body, &result); err != nil || string(result["sessions"]) != "[]" {
+			t.Errorf("GET /%s/sessions of no sessions = %s, want an empty array of sessions", version, body)
+		}
+	}
+}
@@ -9,18 +9,29 @@ import (
 
 const (
 	timeFormat = "2006-01-02T15:04:05 -070000"
-	IdLen      = 10
+	// IdLen is the length of the ids of sessions and participants.
+	IdLen = 20
+	// legacyIdLen is the length of the ids of sessions and participants
+	// created by earlier versions, which are still valid.
+	legacyIdLen = 10
 )
 
-func generateSessionId() string {
+// generateId generates a random id starting with prefix.
+func generateId(prefix string) string {
 	longId := fmt.Sprintf("%x", sha256.Sum256([]byte(uuid.New().String())))
-	return longId[:IdLen]
+	return prefix + longId[:IdLen-len(prefix)]
 }
 
 func generateParticipantId() string {
-	return generateSessionId()
+	return generateId("")
 }
 
-func generateCreationDateTime() string {
-	return time.Now().Format(timeFormat)
+// generateTrackId generates a random id for a track published by a
+// participant.
+func generateTrackId() string {
+	return generateId("")
+}
+
+func formatDateTime(t time.Time) string {
+	return t.Format(timeFormat)
 }
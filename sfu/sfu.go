@@ -2,38 +2,283 @@ package sfu
 
 import (
 	"alovenio.com/blackbird/logger"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// requestIdHeader holds the id of a request, used to correlate log records.
+const requestIdHeader = "X-Request-Id"
+
+// DefaultShutdownTimeout is the maximum duration of the shutdown started
+// when the context of Start is done, when no other timeout is configured.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// shutdowner is implemented by session handlers releasing resources, or
+// notifying participants, when the server shuts down.
+type shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
 // Server objects represent instances of Blackbird's SFU
 // server.
 type Server struct {
+	// Number of recent events kept in memory to resume event streams.
+	// DefaultEventBufferSize is used when zero.
+	EventBufferSize int
+	// Whether errors of versions of the REST API supporting it, i.e. v1,
+	// are reported as plain messages in the errors of results, rather than
+	// as RFC 7807 problem details, to requests not accepting problem
+	// details. It is meant for clients older than problem details.
+	LegacyErrors bool
+	// Bearer token required by admin routes. Admin routes are disabled
+	// when blank.
+	AdminToken string
+	// Cluster the server is a node of. The server runs standalone when nil.
+	Cluster *Cluster
+	// Metrics collected by the server. No metrics are collected when nil.
+	Metrics *Metrics
+	// Tracing of the server's requests. No spans are created when nil.
+	Tracing *Tracing
+	// Dispatcher delivering the events of the session handler to
+	// webhooks. No webhooks are sent when nil.
+	Webhooks *WebhookDispatcher
+	// TLS of the server's listener. The server serves plain HTTP when nil.
+	TLS *TLS
+	// Maximum duration of the shutdown started when the context of Start
+	// is done. DefaultShutdownTimeout is used when zero.
+	ShutdownTimeout time.Duration
+	// Deprecations of versions of the REST API, by version name, e.g. v1.
+	// Versions without a deprecation are supported.
+	Deprecations map[string]Deprecation
+	// Clock of the server, e.g. checking whether API versions are
+	// deprecated. The system clock is used when nil.
+	Clock         Clock
 	handler       *SessionHandler
+	events        *eventStream
 	startDateTime time.Time
 	address       string
+	httpServer    *http.Server
+	stop          context.CancelFunc
+	shutdownOnce  sync.Once
+	shutdownErr   error
+	locker        sync.Mutex
 }
 
-func (s *Server) Start(addr string, handler SessionHandler) error {
+// Start serves the REST API on addr, using handler for every request. It
+// blocks until the server is shut down, either by Shutdown or because ctx
+// is done, and returns any error preventing the server from serving.
+func (s *Server) Start(ctx context.Context, addr string, handler SessionHandler) error {
 	if err := checkAddr(addr); err != nil {
 		return err
 	}
-	s.startDateTime = time.Now()
+	if err := checkDeprecations(s.Deprecations); err != nil {
+		return err
+	}
+	s.startDateTime = s.now()
 	s.address = addr
+	router := s.newRouter(handler)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.Metrics != nil && len(s.Metrics.config.Address) > 0 {
+		go s.Metrics.listenAndServe()
+	}
+	background, stop := context.WithCancel(context.Background())
+	defer stop()
+	if s.Webhooks != nil {
+		// not cancelled with ctx, so Shutdown delivers the queued events
+		// first, but once the server stopped
+		webhooks, stopWebhooks := context.WithCancel(context.Background())
+		defer stopWebhooks()
+		s.Webhooks.Start(webhooks)
+	}
+	if s.TLS != nil {
+		listener = tls.NewListener(listener, s.TLS.tlsConfig())
+		go s.TLS.watch(background)
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		s.TLS.newRedirect(port)
+		if s.TLS.redirect != nil {
+			go s.TLS.listenAndRedirect()
+		}
+	}
+	s.locker.Lock()
+	s.httpServer = &http.Server{Handler: router}
+	s.stop = stop
+	s.locker.Unlock()
+	logger.LogInfoF("Starting Blackbird SFU server on %s...", addr)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.httpServer.Serve(listener)
+	}()
+	select {
+	case err = <-served:
+		if errors.Is(err, http.ErrServerClosed) {
+			// waits for the Shutdown in progress
+			s.Shutdown(context.Background())
+			return nil
+		}
+		// the other listeners must not outlive the server
+		s.shutdownListeners(context.Background())
+		return err
+	case <-ctx.Done():
+		timeout := s.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = DefaultShutdownTimeout
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return s.Shutdown(shutdownCtx)
+	}
+}
+
+// Handler returns the http handler serving the REST API with handler,
+// for servers listening on their own, e.g. in tests. Webhooks, listeners
+// and the cluster are not started, so it must not be used along with
+// Start.
+func (s *Server) Handler(handler SessionHandler) http.Handler {
+	return s.newRouter(handler)
+}
+
+// newRouter sets handler as the session handler of the server, and
+// returns the handler serving the server's routes.
+func (s *Server) newRouter(handler SessionHandler) http.Handler {
 	s.handler = &handler
+	if source, ok := handler.(EventSource); ok {
+		size := s.EventBufferSize
+		if size <= 0 {
+			size = DefaultEventBufferSize
+		}
+		s.events = newEventStream(size)
+		source.Subscribe(s.events.publish)
+		if s.Webhooks != nil {
+			source.Subscribe(s.Webhooks.Dispatch)
+		}
+	}
 	router := mux.NewRouter()
-	router.HandleFunc("/{version}/sessions", s.onSessionsRequest)
-	router.HandleFunc("/{version}/sessions/{sessionId}", s.onSessionRequest)
-	router.HandleFunc("/{version}/sessions/{sessionId}/participants", s.onSessionParticipantsRequest)
-	router.HandleFunc("/{version}/sessions/{sessionId}/participants/{participantId}", s.onSessionParticipantRequest)
+	router.Use(requestContextMiddleware)
+	router.Use(s.errorFormatMiddleware)
+	if s.Metrics != nil {
+		if source, ok := handler.(snapshotSource); ok {
+			s.Metrics.registerHandler(source)
+		}
+		if len(s.Metrics.config.Address) == 0 {
+			router.Handle("/metrics", s.Metrics.Handler())
+		}
+		router.Use(s.Metrics.middleware)
+	}
+	if s.Tracing != nil {
+		router.Use(s.Tracing.middleware)
+	}
+	if s.Cluster != nil {
+		router.HandleFunc("/cluster/nodes", s.onClusterNodesRequest)
+		router.Use(s.Cluster.middleware)
+	}
+	router.HandleFunc("/admin/log-level", s.adminMiddleware(s.onAdminLogLevelRequest))
+	for _, path := range apiPaths() {
+		router.HandleFunc("/{version}"+path, s.versioned(path))
+	}
 	router.Use(contentTypeMiddleware)
-	logger.LogInfoF("Starting Blackbird SFU server on %s...", addr)
-	logger.LogFatalF(http.ListenAndServe(addr, router))
-	return nil
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.LogDebugC(r.Context(), "no such route: %s", r.URL.Path)
+		writeError(w, r, http.StatusNotFound, nil, nil, "")
+	})
+	return router
+}
+
+// Shutdown gracefully stops the server: participants are notified, event
+// streams are closed once they sent the notifications and in flight
+// requests are served before the API stops listening. Queued webhooks,
+// notifications included, are delivered, the metrics and HTTPS
+// redirect listeners are stopped, the server leaves its cluster and
+// pending spans are flushed. Connections still open and webhooks still
+// pending when ctx is done are dropped. Calling Shutdown more than once
+// has no effect.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		logger.LogInfoF("Shutting down Blackbird SFU server...")
+		var errs []error
+		if s.handler != nil {
+			if h, ok := (*s.handler).(shutdowner); ok {
+				errs = append(errs, h.Shutdown(ctx))
+			}
+		}
+		if s.events != nil {
+			s.events.close()
+		}
+		s.locker.Lock()
+		httpServer, stop := s.httpServer, s.stop
+		s.locker.Unlock()
+		if httpServer != nil {
+			stop()
+			if err := httpServer.Shutdown(ctx); err != nil {
+				errs = append(errs, err, httpServer.Close())
+			}
+		}
+		if s.Webhooks != nil {
+			errs = append(errs, s.Webhooks.Shutdown(ctx))
+		}
+		errs = append(errs, s.shutdownListeners(ctx))
+		if s.Cluster != nil {
+			timeout := time.Second
+			if deadline, ok := ctx.Deadline(); ok {
+				timeout = time.Until(deadline)
+			}
+			errs = append(errs, s.Cluster.Leave(timeout))
+		}
+		if s.Tracing != nil {
+			errs = append(errs, s.Tracing.Shutdown(ctx))
+		}
+		s.shutdownErr = errors.Join(errs...)
+		if s.shutdownErr != nil {
+			logger.LogErrorF("server shutdown failed: %s", s.shutdownErr)
+		} else {
+			logger.LogInfoF("Blackbird SFU server stopped")
+		}
+	})
+	return s.shutdownErr
+}
+
+// shutdownListeners stops the HTTPS redirect and metrics listeners, if
+// any.
+func (s *Server) shutdownListeners(ctx context.Context) error {
+	var errs []error
+	if s.TLS != nil {
+		errs = append(errs, s.TLS.shutdown(ctx))
+	}
+	if s.Metrics != nil {
+		errs = append(errs, s.Metrics.shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// now returns the current time of the server's clock.
+func (s *Server) now() time.Time {
+	if s.Clock == nil {
+		return time.Now()
+	}
+	return s.Clock.Now()
+}
+
+// sessionHandler returns the session handler used to serve request r.
+func (s *Server) sessionHandler(r *http.Request) SessionHandler {
+	if s.Tracing != nil {
+		return tracedSessionHandler{next: *s.handler, ctx: r.Context(), tracer: s.Tracing.tracer}
+	}
+	return *s.handler
 }
 
 // checkAddr checks whether the given addr parameter is a valid server
@@ -57,44 +302,117 @@ func contentTypeMiddleware(next http.Handler) http.Handler {
 
 // onSessionsRequest is called for every request to /{version}/sessions API
 func (s *Server) onSessionsRequest(w http.ResponseWriter, r *http.Request) {
-	if isPutOrPost(r) == false {
-		logger.LogWarnF(requestAwareMsg(r, "operation not supported: %s", r.Method))
-		w.WriteHeader(http.StatusNotImplemented)
+	if isGet(r) {
+		s.onListSessionsRequest(w, r)
+	} else if isPutOrPost(r) {
+		s.onCreateSessionRequest(w, r)
+	} else {
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPost, http.MethodPut)
+	}
+}
+
+// onListSessionsRequest is called for every GET request to /{version}/sessions
+func (s *Server) onListSessionsRequest(w http.ResponseWriter, r *http.Request) {
+	params, errors := listSessionsParams(r.URL.Query())
+	if errors != nil {
+		// report the errors of the other parameters as well
+		errors = append(errors, params.check()...)
+		writeOutcome(w, r, outcome{result: ListSessionsResult{Errors: errorDetails(errors), FieldErrors: errors}, errors: errors})
 		return
 	}
+	result, err := s.sessionHandler(r).ListSessions(params)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeOutcome(w, r, outcome{
+		result: result,
+		status: http.StatusOK,
+		errors: result.FieldErrors,
+	})
+}
+
+// listSessionsParams decodes the parameters of session lists from the
+// query of a request: namePrefix, createdBefore, createdAfter,
+// hasParticipants, metadata as key:value pairs, sort, prefixed with - for
+// descending order, limit and cursor.
+func listSessionsParams(query url.Values) (ListSessionsParams, []FieldError) {
+	params := ListSessionsParams{
+		NamePrefix:    query.Get("namePrefix"),
+		CreatedBefore: query.Get("createdBefore"),
+		CreatedAfter:  query.Get("createdAfter"),
+		Cursor:        query.Get("cursor"),
+	}
+	var errors []FieldError
+	if v := query.Get("hasParticipants"); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			params.HasParticipants = &b
+		} else {
+			errors = append(errors, FieldError{Field: "hasParticipants", Code: ErrorInvalidValue,
+				Detail: "hasParticipants must be true or false"})
+		}
+	}
+	for _, entry := range query["metadata"] {
+		k, v, found := strings.Cut(entry, ":")
+		if !found {
+			errors = append(errors, FieldError{Field: "metadata", Code: ErrorInvalidValue,
+				Detail: "metadata must be a key:value pair"})
+			continue
+		}
+		if params.Metadata == nil {
+			params.Metadata = make(map[string]string)
+		}
+		params.Metadata[k] = v
+	}
+	sort := query.Get("sort")
+	params.Descending = strings.HasPrefix(sort, "-")
+	params.Sort = SessionSort(strings.TrimPrefix(sort, "-"))
+	if v := query.Get("limit"); v != "" {
+		if limit, err := strconv.Atoi(v); err == nil {
+			params.Limit = limit
+		} else {
+			errors = append(errors, FieldError{Field: "limit", Code: ErrorInvalidValue,
+				Detail: "limit must be an integer"})
+		}
+	}
+	return params, errors
+}
+
+// onCreateSessionRequest is called for every POST/PUT request to /{version}/sessions
+func (s *Server) onCreateSessionRequest(w http.ResponseWriter, r *http.Request) {
 	params := CreateSessionParams{}
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "decoding error: %s", err))
-		w.WriteHeader(http.StatusBadRequest)
+		writeDecodingError(w, r, err)
 		return
 	}
-	result, err := (*s.handler).CreateSession(params)
+	params.IdempotencyKey = r.Header.Get(idempotencyKeyHeader)
+	result, err := s.sessionHandler(r).CreateSession(params)
 	if err != nil {
-		logger.LogErrorF(requestAwareMsg(r, "handling error: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
-	if result.Errors != nil {
-		logger.LogWarnF(requestAwareMsg(r, "bad request: %s", result.Errors))
-		w.WriteHeader(http.StatusBadRequest)
-	} else {
-		w.WriteHeader(http.StatusCreated)
+	o := outcome{
+		result: result,
+		status: http.StatusCreated,
+		errors: result.FieldErrors,
+		limit:  result.Limit,
 	}
-	if err = json.NewEncoder(w).Encode(result); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "failed to encode result: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if result.Session != nil {
+		o.etag = ETag(result.Session.Revision)
 	}
+	writeOutcome(w, r, o)
 }
 
 // onSessionRequest is called for every request to /{version}/sessions/{sessionId}
 func (s *Server) onSessionRequest(w http.ResponseWriter, r *http.Request) {
 	if isGet(r) {
 		s.onGetSessionRequest(w, r)
+	} else if isPatch(r) {
+		s.onPatchSessionRequest(w, r)
 	} else if isDelete(r) {
 		s.onDeleteSessionRequest(w, r)
 	} else {
-		logger.LogWarnF(requestAwareMsg(r, "operation not supported"))
-		w.WriteHeader(http.StatusNotImplemented)
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPatch, http.MethodDelete)
 	}
 }
 
@@ -103,53 +421,67 @@ func (s *Server) onGetSessionRequest(w http.ResponseWriter, r *http.Request) {
 	var vars = mux.Vars(r)
 	sessionId := vars["sessionId"]
 	params := GetSessionParams{Id: sessionId}
-	result, err := (*s.handler).GetSession(params)
+	result, err := s.sessionHandler(r).GetSession(params)
 	if err != nil {
-		logger.LogErrorF(requestAwareMsg(r, "handling error: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
-	if result.Errors != nil {
-		logger.LogWarnF(requestAwareMsg(r, "bad request: %s", result.Errors))
-		w.WriteHeader(http.StatusBadRequest)
-	} else if result.Session == nil {
-		logger.LogDebugF(requestAwareMsg(r, "no such session: %s", sessionId))
-		w.WriteHeader(http.StatusNotFound)
+	o := outcome{
+		result:   result,
+		status:   http.StatusOK,
+		errors:   result.FieldErrors,
+		notFound: result.Session == nil,
+	}
+	if result.Session != nil {
+		o.checkRead(r, result.Session.Revision)
+	}
+	writeOutcome(w, r, o)
+}
+
+// onPatchSessionRequest is called for every PATCH request to /{version}/sessions/{sessionId}
+func (s *Server) onPatchSessionRequest(w http.ResponseWriter, r *http.Request) {
+	patch, ok := readMergePatch(w, r)
+	if !ok {
 		return
-	} else {
-		w.WriteHeader(http.StatusOK)
 	}
-	if err = json.NewEncoder(w).Encode(result); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "failed to encode result: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	params := UpdateSessionParams{Id: mux.Vars(r)["sessionId"], Patch: patch, Conditions: requestConditions(r)}
+	result, err := s.sessionHandler(r).UpdateSession(params)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	o := outcome{
+		result:   result,
+		status:   http.StatusOK,
+		errors:   result.FieldErrors,
+		notFound: result.Session == nil,
+	}
+	if result.Session != nil {
+		o.etag = ETag(result.Session.Revision)
 	}
+	writeOutcome(w, r, o)
 }
 
 // onDeleteSessionRequest is called for every DELETE request to /{version}/sessions/{sessionId}
 func (s *Server) onDeleteSessionRequest(w http.ResponseWriter, r *http.Request) {
 	var vars = mux.Vars(r)
 	sessionId := vars["sessionId"]
-	params := DeleteSessionParams{Id: sessionId}
-	result, err := (*s.handler).DeleteSession(params)
+	params := DeleteSessionParams{Id: sessionId, Conditions: requestConditions(r)}
+	result, err := s.sessionHandler(r).DeleteSession(params)
 	if err != nil {
-		logger.LogErrorF(requestAwareMsg(r, "handling error: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
-	if result.Errors != nil {
-		logger.LogWarnF(requestAwareMsg(r, "bad request: %s", result.Errors))
-		w.WriteHeader(http.StatusBadRequest)
-	} else if result.Session == nil {
-		logger.LogDebugF(requestAwareMsg(r, "no such session: %s", sessionId))
-		w.WriteHeader(http.StatusNotFound)
-		return
-	} else {
-		w.WriteHeader(http.StatusOK)
+	o := outcome{
+		result:   result,
+		status:   http.StatusOK,
+		errors:   result.FieldErrors,
+		notFound: result.Session == nil,
 	}
-	if err = json.NewEncoder(w).Encode(result); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "failed to encode result: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if result.Session != nil {
+		o.etag = ETag(result.Session.Revision)
 	}
+	writeOutcome(w, r, o)
 }
 
 // onSessionParticipantsRequest is called for every request to /{version}/sessions/{sessionId}/participants
@@ -159,8 +491,7 @@ func (s *Server) onSessionParticipantsRequest(w http.ResponseWriter, r *http.Req
 	} else if isPutOrPost(r) == true {
 		s.onPostSessionParticipantsRequest(w, r)
 	} else {
-		logger.LogWarnF(requestAwareMsg(r, "operation not supported"))
-		w.WriteHeader(http.StatusNotImplemented)
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPost, http.MethodPut)
 	}
 }
 
@@ -168,49 +499,44 @@ func (s *Server) onSessionParticipantsRequest(w http.ResponseWriter, r *http.Req
 func (s *Server) onGetSessionParticipantsRequest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	sessionId := vars["sessionId"]
-	result, err := (*s.handler).GetParticipants(GetParticipantsParams{SessionId: sessionId})
+	result, err := s.sessionHandler(r).GetParticipants(GetParticipantsParams{SessionId: sessionId})
 	if err != nil {
-		logger.LogErrorF(requestAwareMsg(r, "handling error: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
-	if result.Errors != nil {
-		logger.LogWarnF(requestAwareMsg(r, "bad request: %s", result.Errors))
-		w.WriteHeader(http.StatusBadRequest)
-	} else {
-		w.WriteHeader(http.StatusOK)
-	}
-	if err = json.NewEncoder(w).Encode(result); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "failed to encode result: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
-	}
+	writeOutcome(w, r, outcome{
+		result: result,
+		status: http.StatusOK,
+		errors: result.FieldErrors,
+	})
 }
 
 // onGetSessionParticipantsRequest is called for every POST request to /{version}/sessions/{sessionId}/participants
 func (s *Server) onPostSessionParticipantsRequest(w http.ResponseWriter, r *http.Request) {
 	params := AddParticipantParams{}
 	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "decoding error: %s", err))
-		w.WriteHeader(http.StatusBadRequest)
+		writeDecodingError(w, r, err)
 		return
 	}
 	params.SessionId = mux.Vars(r)["sessionId"]
-	result, err := (*s.handler).AddParticipant(params)
+	params.IdempotencyKey = r.Header.Get(idempotencyKeyHeader)
+	params.Relay = s.Cluster != nil && s.Cluster.isRelayRequest(r)
+	result, err := s.sessionHandler(r).AddParticipant(params)
 	if err != nil {
-		logger.LogErrorF(requestAwareMsg(r, "handling error: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
-	if result.Errors != nil {
-		logger.LogWarnF(requestAwareMsg(r, "bad request: %s", result.Errors))
-		w.WriteHeader(http.StatusBadRequest)
-	} else {
-		w.WriteHeader(http.StatusCreated)
+	o := outcome{
+		result:   result,
+		status:   http.StatusCreated,
+		errors:   result.FieldErrors,
+		limit:    result.Limit,
+		notFound: result.Participant == nil,
 	}
-	if err = json.NewEncoder(w).Encode(result); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "failed to encode result: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if result.Participant != nil {
+		o.etag = ETag(result.Participant.Revision)
 	}
+	writeOutcome(w, r, o)
 }
 
 // onSessionParticipantRequest is called for every request to
@@ -218,13 +544,12 @@ func (s *Server) onPostSessionParticipantsRequest(w http.ResponseWriter, r *http
 func (s *Server) onSessionParticipantRequest(w http.ResponseWriter, r *http.Request) {
 	if isGet(r) == true {
 		s.onGetSessionParticipantRequest(w, r)
-	} else if isPutOrPost(r) == true {
+	} else if isPutOrPost(r) == true || isPatch(r) {
 		s.onUpdateSessionParticipantRequest(w, r)
 	} else if isDelete(r) == true {
 		s.onDeleteSessionParticipantRequest(w, r)
 	} else {
-		logger.LogWarnF(requestAwareMsg(r, "operation not supported"))
-		w.WriteHeader(http.StatusNotImplemented)
+		writeMethodNotAllowed(w, r, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete)
 	}
 }
 
@@ -234,65 +559,62 @@ func (s *Server) onGetSessionParticipantRequest(w http.ResponseWriter, r *http.R
 	vars := mux.Vars(r)
 	sessionId := vars["sessionId"]
 	participantId := vars["participantId"]
-	result, err := (*s.handler).GetParticipant(GetParticipantParams{
+	result, err := s.sessionHandler(r).GetParticipant(GetParticipantParams{
 		SessionId:     sessionId,
 		ParticipantId: participantId,
 	})
 	if err != nil {
-		logger.LogErrorF(requestAwareMsg(r, "handling error: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
-	if result.Errors != nil {
-		logger.LogWarnF(requestAwareMsg(r, "bad request: %s", result.Errors))
-		w.WriteHeader(http.StatusBadRequest)
-	} else if result.Participant == nil {
-		logger.LogDebugF(requestAwareMsg(r, "no such participant %q in session %q", participantId, sessionId))
-		w.WriteHeader(http.StatusNotFound)
-		return
-	} else {
-		w.WriteHeader(http.StatusOK)
+	o := outcome{
+		result:   result,
+		status:   http.StatusOK,
+		errors:   result.FieldErrors,
+		notFound: result.Participant == nil,
 	}
-	if err = json.NewEncoder(w).Encode(result); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "failed to encode result: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if result.Participant != nil {
+		o.checkRead(r, result.Participant.Revision)
 	}
+	writeOutcome(w, r, o)
 }
 
-// onUpdateSessionParticipantRequest is called for every POST/PUT request to
-// /{version}/sessions/{sessionId}/participants/{participantId}
+// onUpdateSessionParticipantRequest is called for every POST/PUT/PATCH request to
+// /{version}/sessions/{sessionId}/participants/{participantId}. PATCH
+// requests hold a JSON Merge Patch of the participant.
 func (s *Server) onUpdateSessionParticipantRequest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	sessionId := vars["sessionId"]
 	participantId := vars["participantId"]
 	params := UpdateParticipantParams{}
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "decoding error: %s", err))
-		w.WriteHeader(http.StatusBadRequest)
+	if isPatch(r) {
+		patch, ok := readMergePatch(w, r)
+		if !ok {
+			return
+		}
+		params.Patch = patch
+	} else if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeDecodingError(w, r, err)
 		return
 	}
 	params.SessionId = sessionId
 	params.ParticipantId = participantId
-	result, err := (*s.handler).UpdateParticipant(params)
+	params.Conditions = requestConditions(r)
+	result, err := s.sessionHandler(r).UpdateParticipant(params)
 	if err != nil {
-		logger.LogErrorF(requestAwareMsg(r, "handling error: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
-	if result.Errors != nil {
-		logger.LogWarnF(requestAwareMsg(r, "bad request: %s", result.Errors))
-		w.WriteHeader(http.StatusBadRequest)
-	} else if result.Participant == nil {
-		logger.LogDebugF(requestAwareMsg(r, "no such participant %q in session %q", participantId, sessionId))
-		w.WriteHeader(http.StatusNotFound)
-		return
-	} else {
-		w.WriteHeader(http.StatusOK)
+	o := outcome{
+		result:   result,
+		status:   http.StatusOK,
+		errors:   result.FieldErrors,
+		notFound: result.Participant == nil,
 	}
-	if err = json.NewEncoder(w).Encode(result); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "failed to encode result: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	if result.Participant != nil {
+		o.etag = ETag(result.Participant.Revision)
 	}
+	writeOutcome(w, r, o)
 }
 
 // onDeleteSessionParticipantRequest is called for every DELETE request to
@@ -301,29 +623,94 @@ func (s *Server) onDeleteSessionParticipantRequest(w http.ResponseWriter, r *htt
 	vars := mux.Vars(r)
 	sessionId := vars["sessionId"]
 	participantId := vars["participantId"]
-	result, err := (*s.handler).DeleteParticipant(DeleteParticipantParams{
+	result, err := s.sessionHandler(r).DeleteParticipant(DeleteParticipantParams{
 		SessionId:     sessionId,
 		ParticipantId: participantId,
+		Conditions:    requestConditions(r),
 	})
 	if err != nil {
-		logger.LogErrorF(requestAwareMsg(r, "handling error: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
-	if result.Errors != nil {
-		logger.LogWarnF(requestAwareMsg(r, "bad request: %s", result.Errors))
-		w.WriteHeader(http.StatusBadRequest)
-	} else if result.Participant == nil {
-		logger.LogDebugF(requestAwareMsg(r, "no such participant %q in session %q", participantId, sessionId))
-		w.WriteHeader(http.StatusNotFound)
+	o := outcome{
+		result:   result,
+		status:   http.StatusOK,
+		errors:   result.FieldErrors,
+		notFound: result.Participant == nil,
+	}
+	if result.Participant != nil {
+		o.etag = ETag(result.Participant.Revision)
+	}
+	writeOutcome(w, r, o)
+}
+
+// limitStatus returns the http status reported when a request is rejected
+// due to the capacity limit k: 429 for server-wide limits, 409 otherwise.
+func limitStatus(k LimitKind) int {
+	if k.IsServerWide() {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusConflict
+}
+
+// onSessionParticipantHeartbeatRequest is called for every request to
+// /{version}/sessions/{sessionId}/participants/{participantId}/heartbeat
+func (s *Server) onSessionParticipantHeartbeatRequest(w http.ResponseWriter, r *http.Request) {
+	if isPutOrPost(r) == false {
+		writeMethodNotAllowed(w, r, http.MethodPost, http.MethodPut)
 		return
-	} else {
-		w.WriteHeader(http.StatusOK)
 	}
-	if err = json.NewEncoder(w).Encode(result); err != nil {
-		logger.LogWarnF(requestAwareMsg(r, "failed to encode result: %s", err))
-		w.WriteHeader(http.StatusInternalServerError)
+	vars := mux.Vars(r)
+	sessionId := vars["sessionId"]
+	participantId := vars["participantId"]
+	params := HeartbeatParams{}
+	// heartbeats without a body are accepted
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		writeDecodingError(w, r, err)
+		return
+	}
+	params.SessionId = sessionId
+	params.ParticipantId = participantId
+	result, err := s.sessionHandler(r).Heartbeat(params)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeOutcome(w, r, outcome{
+		result:   result,
+		status:   http.StatusOK,
+		errors:   result.FieldErrors,
+		notFound: result.Participant == nil,
+	})
+}
+
+// onSessionParticipantNegotiateRequest is called for every request to
+// /{version}/sessions/{sessionId}/participants/{participantId}/negotiate
+func (s *Server) onSessionParticipantNegotiateRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, r, http.MethodPost)
+		return
 	}
+	vars := mux.Vars(r)
+	params := NegotiateParams{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeDecodingError(w, r, err)
+		return
+	}
+	params.SessionId = vars["sessionId"]
+	params.ParticipantId = vars["participantId"]
+	result, err := s.sessionHandler(r).Negotiate(params)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	writeOutcome(w, r, outcome{
+		result:   result,
+		status:   http.StatusOK,
+		errors:   result.FieldErrors,
+		limit:    result.Limit,
+		notFound: result.Participant == nil,
+	})
 }
 
 // isPutOrPost returns whether a given request object refers to a PUT or POST http method.
@@ -336,13 +723,42 @@ func isGet(r *http.Request) bool {
 	return r.Method == "GET"
 }
 
+// isPatch returns whether a given request object refers to a PATCH http method.
+func isPatch(r *http.Request) bool {
+	return r.Method == "PATCH"
+}
+
 // isDelete returns whether a given request object refers to a DELETE http method.
 func isDelete(r *http.Request) bool {
 	return r.Method == "DELETE"
 }
 
-// requestAwareMsg creates a message in the context of a given request
-func requestAwareMsg(r *http.Request, format string, args ...any) string {
-	prefix := fmt.Sprintf("%s %s:", r.RequestURI, r.Method) + format
-	return fmt.Sprintf(prefix, args...)
+// requestContextMiddleware is called before handling any http request.
+// It attaches the request's id, remote address, method, uri and path
+// variables to the request's context, so they are added to all log records
+// of the request. The request id is taken from the X-Request-Id header, or
+// generated when missing, and echoed in the response.
+func requestContextMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(requestIdHeader)
+		if requestId == "" {
+			requestId = uuid.New().String()
+		}
+		w.Header().Set(requestIdHeader, requestId)
+		fields := []any{
+			logger.ComponentKey, logger.ComponentAPI,
+			logger.RequestIdKey, requestId,
+			logger.RemoteAddrKey, r.RemoteAddr,
+			"method", r.Method,
+			"uri", r.RequestURI,
+		}
+		vars := mux.Vars(r)
+		if sessionId, ok := vars["sessionId"]; ok {
+			fields = append(fields, logger.SessionIdKey, sessionId)
+		}
+		if participantId, ok := vars["participantId"]; ok {
+			fields = append(fields, logger.ParticipantIdKey, participantId)
+		}
+		next.ServeHTTP(w, r.WithContext(logger.NewContext(r.Context(), fields...)))
+	})
 }
@@ -0,0 +1,136 @@
+package sfu
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freeAddress returns a local address with a port no listener uses.
+func freeAddress(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// TestShutdownDeliversInterruptions checks the SessionInterrupted events
+// of a shutdown reach event streams and webhooks before Start returns,
+// even when they outnumber the queue of a stream subscriber.
+func TestShutdownDeliversInterruptions(t *testing.T) {
+	const sessions = 2 * eventSubscriberQueueSize
+	rc := &webhookReceiver{statuses: []int{http.StatusOK}}
+	receiver := httptest.NewServer(rc)
+	defer receiver.Close()
+	config := DefaultWebhookConfig()
+	config.URLs = []string{receiver.URL}
+	s := &Server{Webhooks: NewWebhookDispatcher(config), ShutdownTimeout: 5 * time.Second}
+	h := NewWebRtcSessionHandler()
+	for i := 0; i < sessions; i++ {
+		if _, err := h.CreateSession(CreateSessionParams{Name: "interrupted"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addr := freeAddress(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start(ctx, addr, h)
+	}()
+	var stream *http.Response
+	for deadline := time.Now().Add(5 * time.Second); stream == nil; {
+		resp, err := http.Get("http://" + addr + "/v1/events")
+		if err == nil {
+			stream = resp
+		} else if time.Now().After(deadline) {
+			t.Fatalf("server not listening: %v", err)
+		} else {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	defer stream.Body.Close()
+
+	cancel()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start() did not return")
+	}
+	body, err := io.ReadAll(stream.Body)
+	if err != nil {
+		t.Fatalf("reading event stream: %v", err)
+	}
+	if n := strings.Count(string(body), "event: "+string(SessionInterrupted)+"\n"); n != sessions {
+		t.Errorf("event stream has %d %s events, want %d", n, SessionInterrupted, sessions)
+	}
+	n := 0
+	for _, a := range rc.seen() {
+		if a.header.Get(WebhookEventHeader) == string(SessionInterrupted) {
+			n++
+		}
+	}
+	if n != sessions {
+		t.Errorf("webhook receiver got %d %s events, want %d", n, SessionInterrupted, sessions)
+	}
+}
+
+// TestStartWaitsForShutdown checks Start, when the server is shut down by
+// another goroutine, returns once Shutdown delivered the queued webhooks.
+func TestStartWaitsForShutdown(t *testing.T) {
+	rc := &webhookReceiver{statuses: []int{http.StatusOK}}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		rc.ServeHTTP(w, r)
+	}))
+	defer receiver.Close()
+	config := DefaultWebhookConfig()
+	config.URLs = []string{receiver.URL}
+	s := &Server{Webhooks: NewWebhookDispatcher(config)}
+	h := NewWebRtcSessionHandler()
+	addr := freeAddress(t)
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start(context.Background(), addr, h)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Post("http://"+addr+"/v1/sessions", "application/json", strings.NewReader(`{"name":"queued"}`))
+		if err == nil {
+			resp.Body.Close()
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("server not listening: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go s.Shutdown(ctx)
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start() did not return")
+	}
+	var events []string
+	for _, a := range rc.seen() {
+		events = append(events, a.header.Get(WebhookEventHeader))
+	}
+	if want := []string{string(SessionCreated), string(SessionInterrupted)}; strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("webhook receiver got %v when Start returned, want %v", events, want)
+	}
+}
@@ -0,0 +1,93 @@
+package sfu
+
+import (
+	"github.com/pion/rtp"
+	"sync"
+	"time"
+)
+
+const (
+	// activeSpeakerInterval is the interval at which the active speaker of
+	// a session is evaluated, while its participants publish audio.
+	activeSpeakerInterval = 500 * time.Millisecond
+	// maxSpeakingLevel is the highest audio level, in -dBov, of the voice
+	// of a speaking participant. Higher levels are quieter.
+	maxSpeakingLevel = 60
+)
+
+// speech holds the audio levels of a published audio track, as reported
+// by the audio level header extension (RFC 6464) of its packets.
+type speech struct {
+	locker sync.Mutex
+	// Loudest level of the voice packets heard since the active speaker
+	// was last evaluated, if any.
+	level uint8
+	heard bool
+}
+
+// hear records the audio level of packet, whose extension id carries it.
+func (v *speech) hear(packet *rtp.Packet, id uint8) {
+	data := packet.GetExtension(id)
+	if data == nil {
+		return
+	}
+	var level rtp.AudioLevelExtension
+	if err := level.Unmarshal(data); err != nil || !level.Voice || level.Level > maxSpeakingLevel {
+		return
+	}
+	v.locker.Lock()
+	defer v.locker.Unlock()
+	if !v.heard || level.Level < v.level {
+		v.level = level.Level
+		v.heard = true
+	}
+}
+
+// take returns the loudest level of the voice packets heard since it was
+// last called, if any.
+func (v *speech) take() (uint8, bool) {
+	v.locker.Lock()
+	defer v.locker.Unlock()
+	level, heard := v.level, v.heard
+	v.heard = false
+	return level, heard
+}
+
+// updateActiveSpeaker makes the participant with the loudest voice since
+// the last evaluation the active speaker of a session, unless it was
+// evaluated less than activeSpeakerInterval ago. Silence keeps the active
+// speaker.
+func (h *WebRtcSessionHandler) updateActiveSpeaker(sessionId string) {
+	h.doActionOnSession(sessionId, func(s *webRtcSession) {
+		now := h.clock.Now()
+		if now.Sub(s.speakerEvaluated) < activeSpeakerInterval {
+			return
+		}
+		s.speakerEvaluated = now
+		var speaker *webRtcParticipant
+		var loudest uint8
+		for _, p := range s.participants {
+			if p.media == nil {
+				continue
+			}
+			for _, t := range p.media.published {
+				if level, heard := t.speech.take(); heard && (speaker == nil || level < loudest) {
+					// relayed tracks are spoken by their publisher
+					speaker = p
+					if owner := s.participants[t.owner]; owner != nil {
+						speaker = owner
+					}
+					loudest = level
+				}
+			}
+		}
+		if speaker == nil || speaker.Id == s.activeSpeaker {
+			return
+		}
+		s.activeSpeaker = speaker.Id
+		e := h.newSessionEvent(ActiveSpeakerChanged, s, "")
+		e.ParticipantId = speaker.Id
+		e.Participant = speaker.clone()
+		h.events.emit(e)
+	})
+}
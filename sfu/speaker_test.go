@@ -0,0 +1,93 @@
+package sfu
+
+import (
+	"github.com/pion/rtp"
+	"reflect"
+	"testing"
+)
+
+// audioLevelPacket returns a packet whose extension id reports level.
+func audioLevelPacket(t *testing.T, id uint8, level uint8, voice bool) *rtp.Packet {
+	t.Helper()
+	data, err := rtp.AudioLevelExtension{Level: level, Voice: voice}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := &rtp.Packet{Header: rtp.Header{Version: 2}}
+	if err = packet.SetExtension(id, data); err != nil {
+		t.Fatal(err)
+	}
+	return packet
+}
+
+func TestSpeechHear(t *testing.T) {
+	var v speech
+	v.hear(&rtp.Packet{}, 1)
+	v.hear(audioLevelPacket(t, 1, 10, false), 1)
+	v.hear(audioLevelPacket(t, 1, maxSpeakingLevel+1, true), 1)
+	if _, heard := v.take(); heard {
+		t.Error("heard a voice without voice packets above the speaking level")
+	}
+	v.hear(audioLevelPacket(t, 1, 40, true), 1)
+	v.hear(audioLevelPacket(t, 1, 20, true), 1)
+	v.hear(audioLevelPacket(t, 1, 30, true), 1)
+	if level, heard := v.take(); !heard || level != 20 {
+		t.Errorf("take() = %d, %t, want the loudest level 20", level, heard)
+	}
+	if _, heard := v.take(); heard {
+		t.Error("take() reported the same voice twice")
+	}
+}
+
+func TestUpdateActiveSpeaker(t *testing.T) {
+	clock := newFakeClock()
+	h := NewWebRtcSessionHandler(WithClock(clock))
+	created, _ := h.CreateSession(CreateSessionParams{Name: "standup"})
+	sessionId := created.Session.Id
+	tracks := make(map[string]*forwardedTrack)
+	for _, name := range []string{"alice", "bob"} {
+		added, _ := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: name})
+		tracks[name] = &forwardedTrack{Track: Track{Id: generateTrackId(), Kind: AudioTrack}}
+		h.doActionOnSession(sessionId, func(s *webRtcSession) {
+			p := s.participants[added.Participant.Id]
+			p.media = newParticipantMedia()
+			p.media.published[tracks[name].Id] = tracks[name]
+		})
+	}
+	var speakers []string
+	unsubscribe := h.Subscribe(func(e Event) {
+		if e.Type == ActiveSpeakerChanged {
+			speakers = append(speakers, e.Participant.Name)
+		}
+	})
+	defer unsubscribe()
+
+	for _, step := range []struct {
+		alice uint8
+		bob   uint8
+	}{
+		{alice: 30, bob: 50},
+		// unchanged
+		{alice: 20},
+		{alice: 40, bob: 10},
+		// silence keeps bob
+		{},
+		{alice: 10},
+	} {
+		clock.advance(activeSpeakerInterval)
+		if step.alice != 0 {
+			tracks["alice"].speech.hear(audioLevelPacket(t, 1, step.alice, true), 1)
+		}
+		if step.bob != 0 {
+			tracks["bob"].speech.hear(audioLevelPacket(t, 1, step.bob, true), 1)
+		}
+		h.updateActiveSpeaker(sessionId)
+		// evaluated once per interval
+		tracks["bob"].speech.hear(audioLevelPacket(t, 1, 0, true), 1)
+		h.updateActiveSpeaker(sessionId)
+		tracks["bob"].speech.take()
+	}
+	if want := []string{"alice", "bob", "alice"}; !reflect.DeepEqual(speakers, want) {
+		t.Errorf("active speakers = %q, want %q", speakers, want)
+	}
+}
@@ -0,0 +1,209 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// storeLogContext carries the component of the log records of the store.
+var storeLogContext = logger.NewContext(context.Background(), logger.ComponentKey, logger.ComponentStore)
+
+// SessionRecord holds the persisted metadata of a live view session and
+// its participants.
+type SessionRecord struct {
+	Session      Session       `json:"session"`
+	Participants []Participant `json:"participants"`
+}
+
+// IdempotencyRecord holds the persisted result of an operation requested
+// with an idempotency key, replayed when the operation is requested again
+// with the same key.
+type IdempotencyRecord struct {
+	Key string `json:"key"`
+	// Name of the operation, e.g. CreateSession.
+	Operation string `json:"operation"`
+	// Hash of the parameters of the operation.
+	Fingerprint string `json:"fingerprint"`
+	// Result of the operation, encoded as JSON.
+	Result           json.RawMessage `json:"result"`
+	CreationDateTime string          `json:"creationDateTime"`
+}
+
+// Store defines the interface for implementors of persistent storage of
+// session and participant metadata.
+type Store interface {
+	// CreateSession creates a session along with r, the record of the
+	// idempotency key it was created with if not nil, in one transaction.
+	CreateSession(s Session, r *IdempotencyRecord) error
+	// SaveSession creates or replaces a session.
+	SaveSession(s Session) error
+	// DeleteSession deletes a session along with all its participants.
+	// Deleting a session that does not exist is not an error.
+	DeleteSession(sessionId string) error
+	// CreateParticipant creates a participant of a session along with r,
+	// the record of the idempotency key it was created with if not nil, in
+	// one transaction. It fails if the session does not exist.
+	CreateParticipant(p Participant, r *IdempotencyRecord) error
+	// SaveParticipant creates or replaces a participant of a session. It
+	// fails if the session does not exist.
+	SaveParticipant(p Participant) error
+	// DeleteParticipant deletes a participant of a session. Deleting a
+	// participant that does not exist is not an error.
+	DeleteParticipant(sessionId string, participantId string) error
+	// LoadSessions retrieves all stored sessions and their participants.
+	LoadSessions() ([]SessionRecord, error)
+	// DeleteIdempotencyRecord deletes the record of an idempotency key.
+	// Deleting a record that does not exist is not an error.
+	DeleteIdempotencyRecord(key string) error
+	// LoadIdempotencyRecords retrieves all stored idempotency records.
+	LoadIdempotencyRecords() ([]IdempotencyRecord, error)
+	// Close releases all resources held by the store.
+	Close() error
+}
+
+// sessionNotStoredError returns the error of storing a participant of a
+// session which does not exist.
+func sessionNotStoredError(sessionId string) error {
+	return fmt.Errorf("session %s does not exist", sessionId)
+}
+
+// memoryStore is a Store keeping all data in memory. Its data does not
+// survive restarts.
+type memoryStore struct {
+	sessions    map[string]*SessionRecord
+	idempotency map[string]IdempotencyRecord
+	locker      sync.Mutex
+}
+
+// NewMemoryStore creates and returns a Store which keeps all data in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		sessions:    make(map[string]*SessionRecord),
+		idempotency: make(map[string]IdempotencyRecord),
+	}
+}
+
+func (m *memoryStore) CreateSession(s Session, r *IdempotencyRecord) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.saveSession(s)
+	if r != nil {
+		m.idempotency[r.Key] = *r
+	}
+	return nil
+}
+
+func (m *memoryStore) SaveSession(s Session) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	m.saveSession(s)
+	return nil
+}
+
+// saveSession creates or replaces session s. The store's lock must be held.
+func (m *memoryStore) saveSession(s Session) {
+	if r := m.sessions[s.Id]; r != nil {
+		r.Session = s
+	} else {
+		m.sessions[s.Id] = &SessionRecord{Session: s}
+	}
+}
+
+func (m *memoryStore) DeleteSession(sessionId string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	delete(m.sessions, sessionId)
+	return nil
+}
+
+func (m *memoryStore) CreateParticipant(p Participant, r *IdempotencyRecord) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if !m.saveParticipant(p) {
+		return sessionNotStoredError(p.SessionId)
+	}
+	if r != nil {
+		m.idempotency[r.Key] = *r
+	}
+	return nil
+}
+
+func (m *memoryStore) SaveParticipant(p Participant) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	if !m.saveParticipant(p) {
+		return sessionNotStoredError(p.SessionId)
+	}
+	return nil
+}
+
+// saveParticipant creates or replaces participant p. It returns false if
+// the session of p does not exist. The store's lock must be held.
+func (m *memoryStore) saveParticipant(p Participant) bool {
+	r := m.sessions[p.SessionId]
+	if r == nil {
+		return false
+	}
+	for i := range r.Participants {
+		if r.Participants[i].Id == p.Id {
+			r.Participants[i] = p
+			return true
+		}
+	}
+	r.Participants = append(r.Participants, p)
+	return true
+}
+
+func (m *memoryStore) DeleteParticipant(sessionId string, participantId string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	r := m.sessions[sessionId]
+	if r == nil {
+		return nil
+	}
+	for i := range r.Participants {
+		if r.Participants[i].Id == participantId {
+			r.Participants = append(r.Participants[:i], r.Participants[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) LoadSessions() ([]SessionRecord, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	records := make([]SessionRecord, 0, len(m.sessions))
+	for _, r := range m.sessions {
+		record := SessionRecord{Session: *r.Session.clone(), Participants: make([]Participant, len(r.Participants))}
+		for i, p := range r.Participants {
+			record.Participants[i] = *p.clone()
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (m *memoryStore) DeleteIdempotencyRecord(key string) error {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	delete(m.idempotency, key)
+	return nil
+}
+
+func (m *memoryStore) LoadIdempotencyRecords() ([]IdempotencyRecord, error) {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+	records := make([]IdempotencyRecord, 0, len(m.idempotency))
+	for _, r := range m.idempotency {
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,219 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// tlsLogContext carries the component of the log records of TLS.
+var tlsLogContext = logger.NewContext(context.Background(), logger.ComponentKey, logger.ComponentTLS)
+
+// DefaultTLSReloadInterval is the interval between checks for changes of
+// the certificate and key files, when no other interval is configured.
+const DefaultTLSReloadInterval = 10 * time.Second
+
+// TLSConfig holds the configuration of TLS.
+type TLSConfig struct {
+	// Paths of the PEM encoded certificate chain and private key files.
+	CertFile string
+	KeyFile  string
+	// Whether a self-signed certificate is generated, in memory, when no
+	// certificate and key files are given. Meant for development only.
+	SelfSigned bool
+	// Host names and IP addresses of the self-signed certificate.
+	// localhost and 127.0.0.1 are used when empty.
+	Hosts []string
+	// Interval between checks for changes of the certificate and key
+	// files, which are reloaded without restarting the server.
+	// DefaultTLSReloadInterval is used when zero.
+	ReloadInterval time.Duration
+	// Address of a plain HTTP listener redirecting every request to HTTPS.
+	// No such listener is started when blank.
+	RedirectAddress string
+}
+
+// TLS serves the REST API over HTTPS, reloading its certificate whenever
+// the certificate or key file changes on disk.
+type TLS struct {
+	config   TLSConfig
+	cert     atomic.Pointer[tls.Certificate]
+	modTimes [2]time.Time
+	redirect *http.Server
+}
+
+// NewTLS creates and returns a properly initialized TLS instance, loading
+// its certificate from the configured files or generating a self-signed
+// one.
+func NewTLS(config TLSConfig) (*TLS, error) {
+	t := &TLS{config: config}
+	if len(config.CertFile) == 0 && len(config.KeyFile) == 0 {
+		if !config.SelfSigned {
+			return nil, errors.New("tls requires certificate and key files or a self-signed certificate")
+		}
+		cert, err := selfSignedCertificate(config.Hosts)
+		if err != nil {
+			return nil, err
+		}
+		t.cert.Store(cert)
+		return t, nil
+	}
+	if len(config.CertFile) == 0 || len(config.KeyFile) == 0 {
+		return nil, errors.New("tls requires both certificate and key files")
+	}
+	if _, err := t.reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// tlsConfig returns the configuration of the server's TLS listener.
+func (t *TLS) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return t.cert.Load(), nil
+		},
+	}
+}
+
+// reload loads the certificate and key files if they changed since they
+// were last loaded, returning whether they did.
+func (t *TLS) reload() (bool, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{t.config.CertFile, t.config.KeyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if modTimes == t.modTimes {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(t.config.CertFile, t.config.KeyFile)
+	if err != nil {
+		return false, err
+	}
+	t.cert.Store(&cert)
+	t.modTimes = modTimes
+	return true, nil
+}
+
+// watch reloads the certificate and key files whenever they change, until
+// ctx is done. The current certificate is kept if they can't be loaded,
+// e.g. while only one of them has been replaced.
+func (t *TLS) watch(ctx context.Context) {
+	if len(t.config.CertFile) == 0 {
+		return
+	}
+	interval := t.config.ReloadInterval
+	if interval <= 0 {
+		interval = DefaultTLSReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := t.reload()
+			if err != nil {
+				logger.LogWarnC(tlsLogContext, "failed to reload tls certificate: %s", err)
+			} else if reloaded {
+				logger.LogInfoC(tlsLogContext, "reloaded tls certificate from %s", t.config.CertFile)
+			}
+		}
+	}
+}
+
+// listenAndRedirect serves the redirect listener created by newRedirect.
+func (t *TLS) listenAndRedirect() {
+	logger.LogInfoC(tlsLogContext, "Redirecting HTTP requests on %s to HTTPS...", t.config.RedirectAddress)
+	if err := t.redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.LogErrorC(tlsLogContext, "https redirect listener failed: %s", err)
+	}
+}
+
+// newRedirect creates the plain HTTP listener redirecting every request to
+// the same URL over HTTPS on port httpsPort, if a redirect address is
+// configured.
+func (t *TLS) newRedirect(httpsPort string) {
+	if len(t.config.RedirectAddress) == 0 {
+		return
+	}
+	t.redirect = &http.Server{
+		Addr: t.config.RedirectAddress,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+			if httpsPort != "443" {
+				host = net.JoinHostPort(host, httpsPort)
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+		}),
+	}
+}
+
+// shutdown stops the redirect listener, if any.
+func (t *TLS) shutdown(ctx context.Context) error {
+	if t.redirect == nil {
+		return nil
+	}
+	return t.redirect.Shutdown(ctx)
+}
+
+// selfSignedCertificate generates a leaf certificate for hosts, signed by
+// its own key and valid for a year. It can't sign other certificates, so
+// trusting it only trusts the server.
+func selfSignedCertificate(hosts []string) (*tls.Certificate, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1"}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Blackbird development"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,216 @@
+package sfu
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a new self-signed certificate for hosts and its
+// key as PEM files in dir, dated modTime, and returns the certificate.
+func writeCertificate(t *testing.T, dir string, modTime time.Time, hosts ...string) *x509.Certificate {
+	t.Helper()
+	cert, err := selfSignedCertificate(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]*pem.Block{
+		"cert.pem": {Type: "CERTIFICATE", Bytes: cert.Certificate[0]},
+		"key.pem":  {Type: "EC PRIVATE KEY", Bytes: key},
+	}
+	for name, block := range files {
+		path := filepath.Join(dir, name)
+		if err = os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, err := selfSignedCertificate([]string{"example.org", "10.0.0.1"})
+	if err != nil {
+		t.Fatalf("selfSignedCertificate() error = %v", err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.IsCA || parsed.KeyUsage&x509.KeyUsageCertSign != 0 {
+		t.Errorf("certificate can sign certificates, IsCA = %v, KeyUsage = %b", parsed.IsCA, parsed.KeyUsage)
+	}
+	if len(parsed.ExtKeyUsage) != 1 || parsed.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("certificate ExtKeyUsage = %v, want server authentication", parsed.ExtKeyUsage)
+	}
+	if err = parsed.VerifyHostname("example.org"); err != nil {
+		t.Errorf("VerifyHostname() error = %v", err)
+	}
+	if err = parsed.VerifyHostname("10.0.0.1"); err != nil {
+		t.Errorf("VerifyHostname() error = %v", err)
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+	first := writeCertificate(t, dir, modTime)
+	tlsServer, err := NewTLS(TLSConfig{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")})
+	if err != nil {
+		t.Fatalf("NewTLS() error = %v", err)
+	}
+	served := func() *x509.Certificate {
+		cert, _ := tlsServer.tlsConfig().GetCertificate(nil)
+		parsed, _ := x509.ParseCertificate(cert.Certificate[0])
+		return parsed
+	}
+	if !served().Equal(first) {
+		t.Fatal("TLS does not serve the certificate of the files")
+	}
+	if reloaded, err := tlsServer.reload(); reloaded || err != nil {
+		t.Errorf("reload() of unchanged files = %v, %v, want false", reloaded, err)
+	}
+
+	second := writeCertificate(t, dir, modTime.Add(time.Minute))
+	if reloaded, err := tlsServer.reload(); !reloaded || err != nil {
+		t.Errorf("reload() of changed files = %v, %v, want true", reloaded, err)
+	}
+	if !served().Equal(second) {
+		t.Error("TLS does not serve the reloaded certificate")
+	}
+
+	// a key file not matching the certificate keeps the current certificate
+	if err = os.WriteFile(filepath.Join(dir, "key.pem"), []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlsServer.reload(); err == nil {
+		t.Error("reload() of an invalid key error = nil, want an error")
+	}
+	if !served().Equal(second) {
+		t.Error("TLS does not serve the last valid certificate")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		port   string
+		target string
+		want   string
+	}{
+		{"8443", "http://example.org/v1/sessions?limit=2", "https://example.org:8443/v1/sessions?limit=2"},
+		{"8443", "http://example.org:8080/v1/sessions", "https://example.org:8443/v1/sessions"},
+		{"443", "http://example.org:8080/v1/sessions", "https://example.org/v1/sessions"},
+	}
+	for _, test := range tests {
+		tlsServer := &TLS{config: TLSConfig{RedirectAddress: ":0"}}
+		tlsServer.newRedirect(test.port)
+		w := httptest.NewRecorder()
+		tlsServer.redirect.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != test.want {
+			t.Errorf("redirect of %s to port %s = %d %s, want %d %s", test.target, test.port,
+				w.Code, w.Header().Get("Location"), http.StatusPermanentRedirect, test.want)
+		}
+	}
+}
+
+func TestStartServesHTTPS(t *testing.T) {
+	tlsServer, err := NewTLS(TLSConfig{SelfSigned: true, RedirectAddress: freeAddress(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := tlsServer.tlsConfig().GetCertificate(nil)
+	parsed, _ := x509.ParseCertificate(cert.Certificate[0])
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	s := &Server{TLS: tlsServer}
+	addr := freeAddress(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Start(ctx, addr, NewWebRtcSessionHandler())
+	}()
+	defer func() {
+		cancel()
+		if err := <-started; err != nil {
+			t.Errorf("Start() error = %v", err)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(addr)
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); resp == nil; time.Sleep(10 * time.Millisecond) {
+		if resp, err = client.Get("https://localhost:" + port + "/v1/sessions"); err != nil && time.Now().After(deadline) {
+			t.Fatalf("server not serving https: %v", err)
+		}
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /v1/sessions over https = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	_, redirectPort, _ := net.SplitHostPort(tlsServer.config.RedirectAddress)
+	resp = nil
+	for deadline := time.Now().Add(5 * time.Second); resp == nil; time.Sleep(10 * time.Millisecond) {
+		if resp, err = client.Get("http://localhost:" + redirectPort + "/v1/sessions"); err != nil && time.Now().After(deadline) {
+			t.Fatalf("server not redirecting http: %v", err)
+		}
+	}
+	resp.Body.Close()
+	if want := "https://localhost:" + port + "/v1/sessions"; resp.StatusCode != http.StatusPermanentRedirect || resp.Header.Get("Location") != want {
+		t.Errorf("GET /v1/sessions over http = %d %s, want %d %s", resp.StatusCode, resp.Header.Get("Location"), http.StatusPermanentRedirect, want)
+	}
+}
+
+// TestStartFailureStopsListeners checks no metrics nor redirect listener
+// is left behind when the server can't listen.
+func TestStartFailureStopsListeners(t *testing.T) {
+	addr := freeAddress(t)
+	taken, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	tlsServer, err := NewTLS(TLSConfig{SelfSigned: true, RedirectAddress: freeAddress(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewMetrics(MetricsConfig{Address: freeAddress(t)})
+	s := &Server{TLS: tlsServer, Metrics: metrics}
+	if err = s.Start(context.Background(), addr, NewWebRtcSessionHandler()); err == nil {
+		t.Fatal("Start() on a taken address error = nil, want an error")
+	}
+	time.Sleep(50 * time.Millisecond)
+	for _, other := range []string{tlsServer.config.RedirectAddress, metrics.config.Address} {
+		l, err := net.Listen("tcp", other)
+		if err != nil {
+			t.Errorf("listener on %s left behind: %v", other, err)
+			continue
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,264 @@
+package sfu
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net/http"
+	"os"
+)
+
+const tracerName = "alovenio.com/blackbird/sfu"
+
+// Span exporters supported by Tracing.
+const (
+	// OtlpExporter exports spans to an OTLP collector over http.
+	OtlpExporter = "otlp"
+	// StdoutExporter writes spans as JSON to TracingConfig.Writer.
+	StdoutExporter = "stdout"
+)
+
+// TracingConfig holds the configuration of Tracing.
+type TracingConfig struct {
+	// Span exporter: OtlpExporter or StdoutExporter.
+	Exporter string
+	// Collector endpoint, in host:port form, used by OtlpExporter. The
+	// OTEL_EXPORTER_OTLP_* environment variables are used when blank.
+	Endpoint string
+	// Whether the connection to the collector is not secured with TLS.
+	Insecure bool
+	// Writer of the spans of StdoutExporter. The standard error is used
+	// when nil, keeping spans apart from the logs on the standard output.
+	Writer io.Writer
+	// Name of the service reported in every span.
+	ServiceName string
+}
+
+// Tracing creates OpenTelemetry spans for REST API requests and session
+// handler calls.
+type Tracing struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracing creates and returns a properly initialized Tracing instance,
+// exporting spans with the configured exporter.
+func NewTracing(config TracingConfig) (*Tracing, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case OtlpExporter:
+		var opts []otlptracehttp.Option
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case StdoutExporter:
+		w := config.Writer
+		if w == nil {
+			w = os.Stderr
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	default:
+		err = fmt.Errorf("unknown span exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newTracing(config.ServiceName, sdktrace.WithBatcher(exporter)), nil
+}
+
+// newTracing creates a Tracing instance whose spans are processed by
+// processor.
+func newTracing(serviceName string, processor sdktrace.TracerProviderOption) *Tracing {
+	if serviceName == "" {
+		serviceName = "blackbird"
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))), processor)
+	return &Tracing{
+		provider:   provider,
+		tracer:     provider.Tracer(tracerName),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Shutdown flushes all pending spans and stops exporting.
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+// WithTracing sets the Tracing creating the spans of participants, which
+// record the media events of their PeerConnections. Participants are not
+// traced without it.
+func WithTracing(t *Tracing) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.tracer = t.tracer
+	}
+}
+
+// middleware creates a span for every request, as a child of the trace
+// context propagated by the caller, if any.
+func (t *Tracing) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(r.Method),
+				semconv.HTTPRoute(route),
+				attribute.String("http.target", r.RequestURI),
+			))
+		defer span.End()
+		for k, v := range mux.Vars(r) {
+			span.SetAttributes(attribute.String("blackbird."+k, v))
+		}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPStatusCode(sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.status))
+		}
+	})
+}
+
+// tracedSessionHandler is a SessionHandler creating a span, as a child of
+// a request's span, for every call to the wrapped handler.
+type tracedSessionHandler struct {
+	next   SessionHandler
+	ctx    context.Context
+	tracer trace.Tracer
+}
+
+// trace runs call inside a span named after a SessionHandler method.
+func (h tracedSessionHandler) trace(method string, call func() ([]FieldError, error), attrs ...attribute.KeyValue) {
+	_, span := h.tracer.Start(h.ctx, "SessionHandler."+method, trace.WithAttributes(attrs...))
+	defer span.End()
+	errors, err := call()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if errors != nil {
+		span.SetAttributes(attribute.StringSlice("blackbird.errors", errorDetails(errors)))
+	}
+}
+
+func (h tracedSessionHandler) CreateSession(p CreateSessionParams) (result CreateSessionResult, err error) {
+	h.trace("CreateSession", func() ([]FieldError, error) {
+		result, err = h.next.CreateSession(p)
+		return result.FieldErrors, err
+	})
+	return
+}
+
+func (h tracedSessionHandler) GetSession(p GetSessionParams) (result GetSessionResult, err error) {
+	h.trace("GetSession", func() ([]FieldError, error) {
+		result, err = h.next.GetSession(p)
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.Id))
+	return
+}
+
+func (h tracedSessionHandler) DeleteSession(p DeleteSessionParams) (result DeleteSessionResult, err error) {
+	h.trace("DeleteSession", func() ([]FieldError, error) {
+		result, err = h.next.DeleteSession(p)
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.Id))
+	return
+}
+
+func (h tracedSessionHandler) UpdateSession(p UpdateSessionParams) (result UpdateSessionResult, err error) {
+	h.trace("UpdateSession", func() ([]FieldError, error) {
+		result, err = h.next.UpdateSession(p)
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.Id))
+	return
+}
+
+func (h tracedSessionHandler) ListSessions(p ListSessionsParams) (result ListSessionsResult, err error) {
+	h.trace("ListSessions", func() ([]FieldError, error) {
+		result, err = h.next.ListSessions(p)
+		return result.FieldErrors, err
+	})
+	return
+}
+
+func (h tracedSessionHandler) AddParticipant(p AddParticipantParams) (result AddParticipantResult, err error) {
+	h.trace("AddParticipant", func() ([]FieldError, error) {
+		result, err = h.next.AddParticipant(p)
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.SessionId))
+	return
+}
+
+func (h tracedSessionHandler) GetParticipant(p GetParticipantParams) (result GetParticipantResult, err error) {
+	h.trace("GetParticipant", func() ([]FieldError, error) {
+		result, err = h.next.GetParticipant(p)
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.SessionId), attribute.String("blackbird.participantId", p.ParticipantId))
+	return
+}
+
+func (h tracedSessionHandler) UpdateParticipant(p UpdateParticipantParams) (result UpdateParticipantResult, err error) {
+	h.trace("UpdateParticipant", func() ([]FieldError, error) {
+		result, err = h.next.UpdateParticipant(p)
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.SessionId), attribute.String("blackbird.participantId", p.ParticipantId))
+	return
+}
+
+func (h tracedSessionHandler) DeleteParticipant(p DeleteParticipantParams) (result DeleteParticipantResult, err error) {
+	h.trace("DeleteParticipant", func() ([]FieldError, error) {
+		result, err = h.next.DeleteParticipant(p)
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.SessionId), attribute.String("blackbird.participantId", p.ParticipantId))
+	return
+}
+
+func (h tracedSessionHandler) GetParticipants(p GetParticipantsParams) (result GetParticipantsResult, err error) {
+	h.trace("GetParticipants", func() ([]FieldError, error) {
+		result, err = h.next.GetParticipants(p)
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.SessionId))
+	return
+}
+
+func (h tracedSessionHandler) Negotiate(p NegotiateParams) (result NegotiateResult, err error) {
+	h.trace("Negotiate", func() ([]FieldError, error) {
+		result, err = h.next.Negotiate(p)
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.SessionId), attribute.String("blackbird.participantId", p.ParticipantId))
+	return
+}
+
+func (h tracedSessionHandler) Heartbeat(p HeartbeatParams) (result HeartbeatResult, err error) {
+	h.trace("Heartbeat", func() ([]FieldError, error) {
+		result, err = h.next.Heartbeat(p)
+		if err == nil && p.ConnectionState != "" {
+			trace.SpanFromContext(h.ctx).AddEvent("connection state reported",
+				trace.WithAttributes(attribute.String("blackbird.connectionState", string(p.ConnectionState))))
+		}
+		return result.FieldErrors, err
+	}, attribute.String("blackbird.sessionId", p.SessionId), attribute.String("blackbird.participantId", p.ParticipantId))
+	return
+}
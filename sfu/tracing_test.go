@@ -0,0 +1,146 @@
+package sfu
+
+import (
+	"encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tracedRequest sends a request to ts and decodes its JSON body into v,
+// when not nil.
+func tracedRequest(t *testing.T, ts *httptest.Server, method string, path string, body string, v any) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		t.Fatalf("%s %s: status %d", method, path, resp.StatusCode)
+	}
+	if v != nil {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+// spanAttributes returns the attributes of span by key.
+func spanAttributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value, len(span.Attributes))
+	for _, a := range span.Attributes {
+		attrs[a.Key] = a.Value
+	}
+	return attrs
+}
+
+func TestTracingSessionLifecycle(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	s := &Server{Tracing: newTracing("test", sdktrace.WithSyncer(exporter))}
+	ts := httptest.NewServer(s.newRouter(NewWebRtcSessionHandler()))
+	defer ts.Close()
+
+	var created CreateSessionResult
+	tracedRequest(t, ts, http.MethodPost, "/v1/sessions", `{"name":"traced"}`, &created)
+	id := created.Session.Id
+	var added AddParticipantResult
+	tracedRequest(t, ts, http.MethodPost, "/v1/sessions/"+id+"/participants", `{"name":"alice"}`, &added)
+	tracedRequest(t, ts, http.MethodDelete, "/v1/sessions/"+id, "", nil)
+
+	want := []struct {
+		name  string
+		kind  trace.SpanKind
+		attrs map[attribute.Key]string
+	}{
+		{"SessionHandler.CreateSession", trace.SpanKindInternal, nil},
+		{"POST /{version}/sessions", trace.SpanKindServer, map[attribute.Key]string{
+			"http.method": "POST", "http.route": "/{version}/sessions"}},
+		{"SessionHandler.AddParticipant", trace.SpanKindInternal, map[attribute.Key]string{
+			"blackbird.sessionId": id}},
+		{"POST /{version}/sessions/{sessionId}/participants", trace.SpanKindServer, map[attribute.Key]string{
+			"http.method": "POST", "blackbird.sessionId": id}},
+		{"SessionHandler.DeleteSession", trace.SpanKindInternal, map[attribute.Key]string{
+			"blackbird.sessionId": id}},
+		{"DELETE /{version}/sessions/{sessionId}", trace.SpanKindServer, map[attribute.Key]string{
+			"http.method": "DELETE", "blackbird.sessionId": id}},
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != len(want) {
+		t.Fatalf("got %d spans, want %d: %v", len(spans), len(want), spans)
+	}
+	for i, w := range want {
+		span := spans[i]
+		if span.Name != w.name || span.SpanKind != w.kind {
+			t.Errorf("span %d = %s (%s), want %s (%s)", i, span.Name, span.SpanKind, w.name, w.kind)
+			continue
+		}
+		if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %s has trace id %s, not the propagated one", span.Name, got)
+		}
+		attrs := spanAttributes(span)
+		for k, v := range w.attrs {
+			if got := attrs[k].Emit(); got != v {
+				t.Errorf("span %s has %s = %q, want %q", span.Name, k, got, v)
+			}
+		}
+		if w.kind == trace.SpanKindServer {
+			if status := attrs["http.status_code"].AsInt64(); status < 200 || status > 299 {
+				t.Errorf("span %s has http.status_code %d", span.Name, status)
+			}
+		}
+	}
+	for i := 0; i < len(spans); i += 2 {
+		if spans[i].Parent.SpanID() != spans[i+1].SpanContext.SpanID() {
+			t.Errorf("span %s is not a child of %s", spans[i].Name, spans[i+1].Name)
+		}
+	}
+}
+
+func TestTracingParticipantMediaEvents(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracing := newTracing("test", sdktrace.WithSyncer(exporter))
+	h := NewWebRtcSessionHandler(WithSettingEngine(loopbackSettings()), WithTracing(tracing))
+	created, _ := h.CreateSession(CreateSessionParams{Name: "traced"})
+	sessionId := created.Session.Id
+	alice, _ := h.AddParticipant(AddParticipantParams{SessionId: sessionId, Name: "alice"})
+	defer h.DeleteSession(DeleteSessionParams{Id: sessionId})
+
+	publisher, _ := publishVideo(t, h, sessionId, alice.Participant.Id)
+	waitUntil(t, func() bool { return h.counters.packets.Load() > 0 })
+	negotiate(t, h, sessionId, alice.Participant.Id, publisher)
+	h.DeleteParticipant(DeleteParticipantParams{SessionId: sessionId, ParticipantId: alice.Participant.Id})
+	// the span ends once the PeerConnection is closed
+	waitUntil(t, func() bool { return len(exporter.GetSpans()) == 1 })
+
+	span := exporter.GetSpans()[0]
+	attrs := spanAttributes(span)
+	if span.Name != "Participant" || attrs["blackbird.sessionId"].AsString() != sessionId ||
+		attrs["blackbird.participantId"].AsString() != alice.Participant.Id {
+		t.Errorf("span %s %v, want the span of alice", span.Name, attrs)
+	}
+	var events []string
+	for _, e := range span.Events {
+		events = append(events, e.Name)
+		if e.Name == "first packet" {
+			if len(e.Attributes) != 2 || e.Attributes[1].Value.AsString() != string(VideoTrack) {
+				t.Errorf("first packet attributes = %v, want the video track", e.Attributes)
+			}
+		}
+	}
+	if want := []string{"ice connected", "first packet", "renegotiation"}; !reflect.DeepEqual(events, want) {
+		t.Errorf("span events = %q, want %q", events, want)
+	}
+}
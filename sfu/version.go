@@ -0,0 +1,194 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// versionHandler serves the requests to a route of a version of the REST
+// API.
+type versionHandler func(s *Server, w http.ResponseWriter, r *http.Request)
+
+// apiVersion is a version of the REST API, served under /{version}. A
+// version serves the routes of the version it is based on, except for those
+// it registers its own handlers for, so it can change the behavior or the
+// response shapes of some routes while older versions keep theirs.
+type apiVersion struct {
+	name string
+	// Version whose handlers are served for routes without a handler of
+	// their own. Nil for the first version.
+	base *apiVersion
+	// Handlers by route path, relative to the version.
+	handlers map[string]versionHandler
+	// Whether errors are reported as plain messages in the errors of
+	// results, as before RFC 7807 problem details, by servers configured
+	// with Server.LegacyErrors, to requests which do not accept problem
+	// details.
+	legacyErrors bool
+	// Name of the version superseding the version once it is deprecated.
+	successor string
+}
+
+// Deprecation schedules the deprecation of a version of the REST API.
+type Deprecation struct {
+	// Time the version is deprecated as of. Responses announce it in
+	// advance.
+	Date time.Time
+	// Time the version is expected to be removed. Unknown when zero.
+	Sunset time.Time
+}
+
+// isDeprecated returns whether the version is deprecated at time now.
+func (d Deprecation) isDeprecated(now time.Time) bool {
+	return !d.Date.IsZero() && !now.Before(d.Date)
+}
+
+// v1 is the first version of the REST API. It can report legacy errors, so
+// existing clients keep working until it is removed, and is superseded by
+// v2.
+var v1 = &apiVersion{
+	name:         "v1",
+	legacyErrors: true,
+	successor:    "v2",
+	handlers: map[string]versionHandler{
+		"/events":                            (*Server).onEventsRequest,
+		"/openapi.json":                      (*Server).onOpenAPIRequest,
+		"/sessions":                          (*Server).onSessionsRequest,
+		"/sessions/{sessionId}":              (*Server).onSessionRequest,
+		"/sessions/{sessionId}/events":       (*Server).onSessionEventsRequest,
+		"/sessions/{sessionId}/participants": (*Server).onSessionParticipantsRequest,
+		"/sessions/{sessionId}/participants/{participantId}":           (*Server).onSessionParticipantRequest,
+		"/sessions/{sessionId}/participants/{participantId}/heartbeat": (*Server).onSessionParticipantHeartbeatRequest,
+		"/sessions/{sessionId}/participants/{participantId}/negotiate": (*Server).onSessionParticipantNegotiateRequest,
+	},
+}
+
+// v2 serves the routes of v1, always reporting errors as problem details.
+var v2 = &apiVersion{
+	name:     "v2",
+	base:     v1,
+	handlers: map[string]versionHandler{},
+}
+
+// apiVersions holds the versions of the REST API, by name.
+var apiVersions = registerAPIVersions(v1, v2)
+
+// registerAPIVersions returns versions by name.
+func registerAPIVersions(versions ...*apiVersion) map[string]*apiVersion {
+	registered := make(map[string]*apiVersion, len(versions))
+	for _, v := range versions {
+		registered[v.name] = v
+	}
+	return registered
+}
+
+// handler returns the handler of the route at path, or nil if the version
+// does not serve it.
+func (v *apiVersion) handler(path string) versionHandler {
+	for ; v != nil; v = v.base {
+		if h, ok := v.handlers[path]; ok {
+			return h
+		}
+	}
+	return nil
+}
+
+// setDeprecationHeaders sets the Deprecation, Sunset and Link headers of
+// responses of a version deprecated, or to be deprecated, by d, as per
+// RFC 9745 and RFC 8594.
+func (v *apiVersion) setDeprecationHeaders(h http.Header, d Deprecation) {
+	if d.Date.IsZero() {
+		return
+	}
+	h.Set("Deprecation", "@"+strconv.FormatInt(d.Date.Unix(), 10))
+	if !d.Sunset.IsZero() {
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if len(v.successor) > 0 {
+		h.Add("Link", "</"+v.successor+`>; rel="successor-version"`)
+	}
+}
+
+// APIVersions returns the names of the versions of the REST API.
+func APIVersions() []string {
+	names := make([]string, 0, len(apiVersions))
+	for name := range apiVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkDeprecations checks deprecations are about versions of the REST
+// API, and sunsets come after deprecations.
+func checkDeprecations(deprecations map[string]Deprecation) error {
+	for name, d := range deprecations {
+		if _, ok := apiVersions[name]; !ok {
+			return fmt.Errorf("api version %s does not exist", name)
+		}
+		if !d.Sunset.IsZero() && d.Sunset.Before(d.Date) {
+			return fmt.Errorf("sunset of api version %s is before its deprecation", name)
+		}
+	}
+	return nil
+}
+
+// apiVersionKey is the key of the version of the REST API in the context of
+// requests.
+type apiVersionKey struct{}
+
+// requestAPIVersion returns the version of the REST API request r is
+// served by.
+func requestAPIVersion(r *http.Request) *apiVersion {
+	v, _ := r.Context().Value(apiVersionKey{}).(*apiVersion)
+	return v
+}
+
+// apiPaths returns the paths of the routes served by any version of the
+// REST API, relative to the version.
+func apiPaths() []string {
+	found := make(map[string]bool)
+	for _, v := range apiVersions {
+		for ; v != nil; v = v.base {
+			for path := range v.handlers {
+				found[path] = true
+			}
+		}
+	}
+	paths := make([]string, 0, len(found))
+	for path := range found {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// versioned returns the handler of the route at path for all versions of
+// the REST API. Requests to unknown versions, or to versions not serving
+// the route, are rejected with 404.
+func (s *Server) versioned(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["version"]
+		v, ok := apiVersions[name]
+		if !ok {
+			logger.LogDebugC(r.Context(), "no such api version: %s", name)
+			writeError(w, r, http.StatusNotFound, nil, []FieldError{{Field: "version", Code: ErrorNotFound,
+				Detail: fmt.Sprintf("api version %s does not exist", name)}}, "")
+			return
+		}
+		h := v.handler(path)
+		if h == nil {
+			logger.LogDebugC(r.Context(), "no such route in api version %s", name)
+			writeError(w, r, http.StatusNotFound, nil, nil, "")
+			return
+		}
+		v.setDeprecationHeaders(w.Header(), s.Deprecations[v.name])
+		h(s, w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, v)))
+	}
+}
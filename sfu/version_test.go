@@ -0,0 +1,187 @@
+package sfu
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVersionHandlers(t *testing.T) {
+	overridden := false
+	v := &apiVersion{name: "v3", base: v2, handlers: map[string]versionHandler{
+		"/openapi.json": func(s *Server, w http.ResponseWriter, r *http.Request) { overridden = true },
+	}}
+	if h := v.handler("/openapi.json"); h != nil {
+		h(nil, nil, nil)
+	}
+	if !overridden {
+		t.Error("v3 does not serve its own handler of /openapi.json")
+	}
+	for _, path := range apiPaths() {
+		if v.handler(path) == nil {
+			t.Errorf("v3 does not serve %s of the versions it is based on", path)
+		}
+	}
+	if v.handler("/nothing") != nil {
+		t.Error("v3 serves a route no version has")
+	}
+}
+
+func TestVersionDeprecationHeaders(t *testing.T) {
+	deprecation := Deprecation{
+		Date:   time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC),
+		Sunset: time.Date(2027, time.October, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for _, test := range []struct {
+		deprecations map[string]Deprecation
+		version      string
+		deprecation  string
+		sunset       string
+		link         string
+	}{
+		{version: "v1"},
+		{deprecations: map[string]Deprecation{"v1": deprecation}, version: "v1",
+			deprecation: "@" + strconv.FormatInt(deprecation.Date.Unix(), 10),
+			sunset:      deprecation.Sunset.Format(http.TimeFormat), link: `</v2>; rel="successor-version"`},
+		{deprecations: map[string]Deprecation{"v1": {Date: deprecation.Date}}, version: "v1",
+			deprecation: "@" + strconv.FormatInt(deprecation.Date.Unix(), 10), link: `</v2>; rel="successor-version"`},
+		{deprecations: map[string]Deprecation{"v1": deprecation}, version: "v2"},
+	} {
+		ts := httptest.NewServer((&Server{Deprecations: test.deprecations}).newRouter(NewWebRtcSessionHandler()))
+		resp, err := http.Get(ts.URL + "/" + test.version + "/sessions")
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET /%s/sessions: status %d", test.version, resp.StatusCode)
+		}
+		for name, want := range map[string]string{"Deprecation": test.deprecation, "Sunset": test.sunset, "Link": test.link} {
+			if got := resp.Header.Get(name); got != want {
+				t.Errorf("GET /%s/sessions with %+v: %s = %q, want %q", test.version, test.deprecations, name, got, want)
+			}
+		}
+	}
+	if !deprecation.isDeprecated(deprecation.Date) || deprecation.isDeprecated(deprecation.Date.Add(-time.Second)) {
+		t.Error("v1 is not deprecated as of its deprecation time only")
+	}
+	if (Deprecation{}).isDeprecated(time.Now()) {
+		t.Error("versions without a deprecation are deprecated")
+	}
+}
+
+func TestCheckDeprecations(t *testing.T) {
+	date := time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		deprecations map[string]Deprecation
+		valid        bool
+	}{
+		{valid: true},
+		{deprecations: map[string]Deprecation{"v1": {Date: date}}, valid: true},
+		{deprecations: map[string]Deprecation{"v1": {Date: date, Sunset: date.AddDate(0, 6, 0)}}, valid: true},
+		{deprecations: map[string]Deprecation{"v1": {Date: date, Sunset: date.AddDate(0, -6, 0)}}},
+		{deprecations: map[string]Deprecation{"v0": {Date: date}}},
+	} {
+		if err := checkDeprecations(test.deprecations); (err == nil) != test.valid {
+			t.Errorf("checkDeprecations(%+v) = %v", test.deprecations, err)
+		}
+	}
+}
+
+func TestVersionDeprecatedOperations(t *testing.T) {
+	deprecation := Deprecation{Date: time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)}
+	clock := newFakeClock()
+	clock.now = deprecation.Date.Add(-time.Second)
+	s := &Server{Deprecations: map[string]Deprecation{"v1": deprecation}, Clock: clock}
+	ts := httptest.NewServer(s.newRouter(NewWebRtcSessionHandler()))
+	defer ts.Close()
+	for _, test := range []struct {
+		version    string
+		deprecated bool
+	}{
+		{version: "v1"},
+		{version: "v2"},
+		{version: "v1", deprecated: true},
+		{version: "v2"},
+	} {
+		if test.deprecated {
+			clock.advance(time.Second)
+		}
+		resp, err := http.Get(ts.URL + "/" + test.version + "/openapi.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc struct {
+			Paths map[string]map[string]struct {
+				Deprecated bool `json:"deprecated"`
+			} `json:"paths"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&doc)
+		resp.Body.Close()
+		if err != nil || len(doc.Paths) == 0 {
+			t.Fatalf("GET /%s/openapi.json at %s: %+v, %v", test.version, clock.Now(), doc, err)
+		}
+		for path, operations := range doc.Paths {
+			for method, op := range operations {
+				if op.Deprecated != test.deprecated {
+					t.Errorf("%s /%s%s at %s: deprecated = %t, want %t", method, test.version, path, clock.Now(),
+						op.Deprecated, test.deprecated)
+				}
+			}
+		}
+	}
+}
+
+func TestVersionErrors(t *testing.T) {
+	ts := httptest.NewServer((&Server{}).newRouter(NewWebRtcSessionHandler()))
+	defer ts.Close()
+	legacy := httptest.NewServer((&Server{LegacyErrors: true}).newRouter(NewWebRtcSessionHandler()))
+	defer legacy.Close()
+	for _, test := range []struct {
+		server      *httptest.Server
+		path        string
+		accept      string
+		contentType string
+		problem     bool
+	}{
+		{server: ts, path: "/v1/sessions/0000000000/participants", contentType: problemContentType, problem: true},
+		{server: legacy, path: "/v1/sessions/0000000000/participants", contentType: "application/json"},
+		{server: legacy, path: "/v1/sessions/0000000000/participants", accept: "application/json, application/problem+json",
+			contentType: problemContentType, problem: true},
+		{server: legacy, path: "/v1/sessions/0000000000/participants", accept: "application/problem+json;q=0",
+			contentType: "application/json"},
+		{server: legacy, path: "/v2/sessions/0000000000/participants", contentType: problemContentType, problem: true},
+		{server: legacy, path: "/v3/sessions/0000000000/participants", contentType: problemContentType, problem: true},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, test.server.URL+test.path, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Status int             `json:"status"`
+			Errors json.RawMessage `json:"errors"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s accepting %q: status %d, %v", test.path, test.accept, resp.StatusCode, err)
+			continue
+		}
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != test.contentType {
+			t.Errorf("GET %s accepting %q: content type %q, want %q", test.path, test.accept, mediaType, test.contentType)
+		}
+		var messages []string
+		if legacy := json.Unmarshal(body.Errors, &messages) == nil; legacy == test.problem || (body.Status != 0) != test.problem {
+			t.Errorf("GET %s accepting %q: body %+v, want problem details %t", test.path, test.accept, body, test.problem)
+		}
+	}
+}
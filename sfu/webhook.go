@@ -0,0 +1,303 @@
+package sfu
+
+import (
+	"alovenio.com/blackbird/logger"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webhookLogContext carries the component of the log records of webhooks.
+var webhookLogContext = logger.NewContext(context.Background(), logger.ComponentKey, logger.ComponentWebhook)
+
+const (
+	// WebhookSignatureHeader holds the hex encoded HMAC-SHA256 of a webhook's
+	// timestamp and body, computed with the configured secret by
+	// SignWebhook and prefixed with "sha256=".
+	WebhookSignatureHeader = "X-Blackbird-Signature"
+	// WebhookTimestampHeader holds the time a webhook was sent, in seconds
+	// since the Unix epoch. It is signed along with the body, so receivers
+	// can reject webhooks replayed later on.
+	WebhookTimestampHeader = "X-Blackbird-Timestamp"
+	// WebhookEventHeader holds the type of the event delivered by a webhook.
+	WebhookEventHeader = "X-Blackbird-Event"
+	// WebhookDeliveryHeader holds the unique id of a webhook delivery. It is
+	// kept across retries so receivers can detect duplicates.
+	WebhookDeliveryHeader = "X-Blackbird-Delivery"
+)
+
+// WebhookConfig holds the configuration of a WebhookDispatcher.
+type WebhookConfig struct {
+	// URLs receiving every event.
+	URLs []string
+	// Secret used to sign the body of every webhook. Webhooks are not
+	// signed when blank.
+	Secret string
+	// Maximum number of retries of a failed delivery.
+	MaxRetries int
+	// Delay before the first retry of a failed delivery. It doubles on
+	// every subsequent retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	// Maximum delay between two retries.
+	MaxBackoff time.Duration
+	// Maximum number of events waiting to be delivered to a single URL.
+	// Events are dropped when the queue is full.
+	QueueSize int
+	// Timeout of every single delivery attempt.
+	Timeout time.Duration
+}
+
+// DefaultWebhookConfig returns a WebhookConfig with sensible defaults and
+// no URLs.
+func DefaultWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		MaxRetries:     5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		QueueSize:      1000,
+		Timeout:        10 * time.Second,
+	}
+}
+
+// webhookDelivery holds a single event waiting to be delivered.
+type webhookDelivery struct {
+	id        string
+	eventType EventType
+	body      []byte
+}
+
+// webhookEndpoint holds the queue of deliveries to a single URL.
+type webhookEndpoint struct {
+	url   string
+	queue chan webhookDelivery
+}
+
+// WebhookDispatcher delivers events as signed JSON documents POSTed to
+// a set of configured URLs.
+type WebhookDispatcher struct {
+	config    WebhookConfig
+	endpoints []*webhookEndpoint
+	client    *http.Client
+	// Closed when Shutdown starts, so endpoints deliver the events left in
+	// their queues and stop.
+	draining chan struct{}
+	// Closed when all endpoints stopped.
+	done chan struct{}
+	// Cancels the deliveries in progress. Nil until Start is called.
+	cancel context.CancelFunc
+	closed bool
+	locker sync.Mutex
+}
+
+// NewWebhookDispatcher creates and returns a properly initialized
+// WebhookDispatcher instance. Deliveries start once Start is called.
+func NewWebhookDispatcher(config WebhookConfig) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		config:   config,
+		client:   &http.Client{Timeout: config.Timeout},
+		draining: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, url := range config.URLs {
+		d.endpoints = append(d.endpoints, &webhookEndpoint{
+			url:   url,
+			queue: make(chan webhookDelivery, config.QueueSize),
+		})
+	}
+	return d
+}
+
+// Dispatch queues an event for delivery to all configured URLs. It never
+// blocks, so it can be used as an EventListener. Events dispatched once
+// Shutdown was called are dropped.
+func (d *WebhookDispatcher) Dispatch(e Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		logger.LogErrorC(webhookLogContext, "webhook: failed to encode %s event: %s", e.Type, err)
+		return
+	}
+	delivery := webhookDelivery{id: uuid.New().String(), eventType: e.Type, body: body}
+	d.locker.Lock()
+	defer d.locker.Unlock()
+	if d.closed {
+		logger.LogWarnC(webhookLogContext, "webhook: shutting down, dropping %s event", e.Type)
+		return
+	}
+	for _, ep := range d.endpoints {
+		select {
+		case ep.queue <- delivery:
+		default:
+			logger.LogWarnC(webhookLogContext, "webhook: queue of %s is full, dropping %s event", ep.url, e.Type)
+		}
+	}
+}
+
+// Start delivers queued events in the background until ctx is done or
+// Shutdown delivered the events left in the queues. It must be called
+// only once.
+func (d *WebhookDispatcher) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	d.locker.Lock()
+	d.cancel = cancel
+	d.locker.Unlock()
+	var wg sync.WaitGroup
+	for _, ep := range d.endpoints {
+		wg.Add(1)
+		go func(ep *webhookEndpoint) {
+			defer wg.Done()
+			d.runEndpoint(ctx, ep)
+		}(ep)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(d.done)
+	}()
+}
+
+// Shutdown stops accepting events and waits until the events already
+// queued are delivered, or given up on, or until ctx is done, when the
+// deliveries still pending are cancelled. It returns immediately if
+// Start was never called. Calling Shutdown more than once has no effect.
+func (d *WebhookDispatcher) Shutdown(ctx context.Context) error {
+	d.locker.Lock()
+	cancel := d.cancel
+	if !d.closed {
+		d.closed = true
+		close(d.draining)
+	}
+	d.locker.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		<-d.done
+		pending := 0
+		for _, ep := range d.endpoints {
+			pending += len(ep.queue)
+		}
+		return fmt.Errorf("webhook: %d queued events not delivered: %w", pending, ctx.Err())
+	}
+}
+
+// runEndpoint delivers all events queued for a single URL, in order,
+// until ctx is done or its queue is empty once draining started.
+func (d *WebhookDispatcher) runEndpoint(ctx context.Context, ep *webhookEndpoint) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delivery := <-ep.queue:
+			d.deliver(ctx, ep.url, delivery)
+		case <-d.draining:
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case delivery := <-ep.queue:
+					d.deliver(ctx, ep.url, delivery)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliver POSTs a single event to url, retrying with exponential backoff
+// until it succeeds, retries are exhausted or ctx is done.
+func (d *WebhookDispatcher) deliver(ctx context.Context, url string, delivery webhookDelivery) {
+	backoff := d.config.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := d.post(ctx, url, delivery)
+		if err == nil {
+			logger.LogDebugC(webhookLogContext, "webhook: delivered %s event %s to %s", delivery.eventType, delivery.id, url)
+			return
+		}
+		if attempt >= d.config.MaxRetries {
+			logger.LogErrorC(webhookLogContext, "webhook: giving up on %s event %s to %s after %d attempts: %s",
+				delivery.eventType, delivery.id, url, attempt+1, err)
+			return
+		}
+		logger.LogWarnC(webhookLogContext, "webhook: attempt %d of %s event %s to %s failed, retrying in %s: %s",
+			attempt+1, delivery.eventType, delivery.id, url, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if d.config.MaxBackoff > 0 && backoff > d.config.MaxBackoff {
+			backoff = d.config.MaxBackoff
+		}
+	}
+}
+
+// post sends a single delivery attempt. Any non 2xx response is reported
+// as an error.
+func (d *WebhookDispatcher) post(ctx context.Context, url string, delivery webhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(WebhookEventHeader, string(delivery.eventType))
+	req.Header.Set(WebhookDeliveryHeader, delivery.id)
+	if d.config.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(WebhookTimestampHeader, timestamp)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(d.config.Secret, timestamp, delivery.body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// SignWebhook returns the hex encoded HMAC-SHA256 of timestamp, a dot and
+// body, using secret as key. Receivers use it to verify the
+// WebhookSignatureHeader, or call VerifyWebhook.
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook with header and body,
+// signed with secret, and that it was sent less than maxAge before now.
+func VerifyWebhook(secret string, header http.Header, body []byte, maxAge time.Duration, now time.Time) error {
+	timestamp := header.Get(WebhookTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("webhook: invalid timestamp %q", timestamp)
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > maxAge || age < -maxAge {
+		return fmt.Errorf("webhook: timestamp %s is too old", timestamp)
+	}
+	signature := strings.TrimPrefix(header.Get(WebhookSignatureHeader), "sha256=")
+	if !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, timestamp, body))) {
+		return errors.New("webhook: invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package sfu
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookAttempt is a delivery attempt seen by a webhookReceiver.
+type webhookAttempt struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+// webhookReceiver records delivery attempts, answering them with the
+// statuses of its script, and with its last status once the script ends.
+type webhookReceiver struct {
+	statuses []int
+	attempts []webhookAttempt
+	locker   sync.Mutex
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.locker.Lock()
+	status := rc.statuses[len(rc.statuses)-1]
+	if len(rc.attempts) < len(rc.statuses) {
+		status = rc.statuses[len(rc.attempts)]
+	}
+	rc.attempts = append(rc.attempts, webhookAttempt{header: r.Header.Clone(), body: body, at: time.Now()})
+	rc.locker.Unlock()
+	w.WriteHeader(status)
+}
+
+func (rc *webhookReceiver) seen() []webhookAttempt {
+	rc.locker.Lock()
+	defer rc.locker.Unlock()
+	return append([]webhookAttempt(nil), rc.attempts...)
+}
+
+// dispatch delivers events with a dispatcher POSTing to rc, and returns
+// once the dispatcher is shut down.
+func dispatch(t *testing.T, rc *webhookReceiver, config WebhookConfig, events ...Event) {
+	t.Helper()
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+	config.URLs = []string{ts.URL}
+	d := NewWebhookDispatcher(config)
+	d.Start(context.Background())
+	for _, e := range events {
+		d.Dispatch(e)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+}
+
+func testWebhookConfig() WebhookConfig {
+	config := DefaultWebhookConfig()
+	config.Secret = "s3cr3t"
+	config.InitialBackoff = 20 * time.Millisecond
+	config.MaxBackoff = 30 * time.Millisecond
+	return config
+}
+
+func TestWebhookSignature(t *testing.T) {
+	rc := &webhookReceiver{statuses: []int{http.StatusNoContent}}
+	dispatch(t, rc, testWebhookConfig(), Event{Type: SessionCreated, SessionId: "abc"})
+
+	attempts := rc.seen()
+	if len(attempts) != 1 {
+		t.Fatalf("got %d attempts, want 1", len(attempts))
+	}
+	a := attempts[0]
+	timestamp := a.header.Get(WebhookTimestampHeader)
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(a.body)
+	if got, want := a.header.Get(WebhookSignatureHeader), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("%s = %q, want %q", WebhookSignatureHeader, got, want)
+	}
+	if err := VerifyWebhook("s3cr3t", a.header, a.body, time.Minute, a.at); err != nil {
+		t.Errorf("VerifyWebhook() error = %v", err)
+	}
+	if got := a.header.Get(WebhookEventHeader); got != string(SessionCreated) {
+		t.Errorf("%s = %q, want %q", WebhookEventHeader, got, SessionCreated)
+	}
+	var e Event
+	if err := json.Unmarshal(a.body, &e); err != nil || e.SessionId != "abc" {
+		t.Errorf("body = %s, %v", a.body, err)
+	}
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"type":"session.created"}`)
+	sent := time.Unix(1700000000, 0)
+	header := func(timestamp string, signature string) http.Header {
+		return http.Header{
+			WebhookTimestampHeader: {timestamp},
+			WebhookSignatureHeader: {"sha256=" + signature},
+		}
+	}
+	signed := SignWebhook("s3cr3t", "1700000000", body)
+	for _, test := range []struct {
+		name   string
+		secret string
+		header http.Header
+		body   []byte
+		now    time.Time
+		valid  bool
+	}{
+		{name: "valid", secret: "s3cr3t", header: header("1700000000", signed), body: body, now: sent.Add(time.Minute), valid: true},
+		{name: "other secret", secret: "other", header: header("1700000000", signed), body: body, now: sent},
+		{name: "other body", secret: "s3cr3t", header: header("1700000000", signed), body: []byte(`{}`), now: sent},
+		{name: "replayed", secret: "s3cr3t", header: header("1700000000", signed), body: body, now: sent.Add(10 * time.Minute)},
+		{name: "other timestamp", secret: "s3cr3t", header: header("1700000060", signed), body: body, now: sent},
+		{name: "no timestamp", secret: "s3cr3t", header: header("", signed), body: body, now: sent},
+	} {
+		if err := VerifyWebhook(test.secret, test.header, test.body, 5*time.Minute, test.now); (err == nil) != test.valid {
+			t.Errorf("%s: VerifyWebhook() error = %v", test.name, err)
+		}
+	}
+}
+
+func TestWebhookRetriesWithBackoff(t *testing.T) {
+	rc := &webhookReceiver{statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway,
+		http.StatusInternalServerError, http.StatusOK}}
+	dispatch(t, rc, testWebhookConfig(), Event{Type: SessionCreated, SessionId: "abc"})
+
+	attempts := rc.seen()
+	if len(attempts) != 4 {
+		t.Fatalf("got %d attempts, want 4", len(attempts))
+	}
+	id := attempts[0].header.Get(WebhookDeliveryHeader)
+	backoffs := []time.Duration{20 * time.Millisecond, 30 * time.Millisecond, 30 * time.Millisecond}
+	for i, backoff := range backoffs {
+		a := attempts[i+1]
+		if got := a.header.Get(WebhookDeliveryHeader); got != id {
+			t.Errorf("attempt %d has delivery id %s, want %s", i+2, got, id)
+		}
+		if elapsed := a.at.Sub(attempts[i].at); elapsed < backoff {
+			t.Errorf("attempt %d came %s after the previous one, want at least %s", i+2, elapsed, backoff)
+		}
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	rc := &webhookReceiver{statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError,
+		http.StatusInternalServerError, http.StatusOK}}
+	config := testWebhookConfig()
+	config.MaxRetries = 2
+	dispatch(t, rc, config, Event{Type: SessionCreated, SessionId: "abc"},
+		Event{Type: SessionDeleted, SessionId: "abc"})
+
+	attempts := rc.seen()
+	if len(attempts) != 4 {
+		t.Fatalf("got %d attempts, want 4", len(attempts))
+	}
+	for i, want := range []EventType{SessionCreated, SessionCreated, SessionCreated, SessionDeleted} {
+		if got := attempts[i].header.Get(WebhookEventHeader); got != string(want) {
+			t.Errorf("attempt %d delivers %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestWebhookShutdownDeadline(t *testing.T) {
+	rc := &webhookReceiver{statuses: []int{http.StatusInternalServerError}}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+	config := testWebhookConfig()
+	config.URLs = []string{ts.URL}
+	config.InitialBackoff = time.Minute
+	d := NewWebhookDispatcher(config)
+	d.Start(context.Background())
+	d.Dispatch(Event{Type: SessionCreated, SessionId: "abc"})
+	d.Dispatch(Event{Type: SessionDeleted, SessionId: "abc"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := d.Shutdown(ctx); err == nil {
+		t.Error("Shutdown() succeeded with an undelivered event")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown() took %s, beyond its deadline", elapsed)
+	}
+	d.Dispatch(Event{Type: SessionCreated, SessionId: "def"})
+	if attempts := rc.seen(); len(attempts) != 1 {
+		t.Errorf("got %d attempts, want 1", len(attempts))
+	}
+}
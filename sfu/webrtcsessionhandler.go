@@ -1,36 +1,271 @@
 package sfu
 
 import (
+	"alovenio.com/blackbird/logger"
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/trace"
+	"reflect"
 	"sync"
+	"time"
 )
 
 type webRtcSession struct {
 	Session
 	participants map[string]*webRtcParticipant
+	created      time.Time
+	// Time since the session has no participants. Only meaningful
+	// while the session is empty.
+	emptySince time.Time
+	// Id of the participant last reported as the active speaker, and time
+	// the active speaker was last evaluated.
+	activeSpeaker    string
+	speakerEvaluated time.Time
+	// Media forwarded to the participants of the session.
+	counters mediaCounters
+	// Relay to the node hosting the session, for the mirrors of sessions
+	// hosted by other nodes, nil otherwise.
+	relay *sessionRelay
+}
+
+// removeParticipant removes a participant from the session, keeping
+// track of the time at which the session became empty.
+func (s *webRtcSession) removeParticipant(id string, now time.Time) {
+	delete(s.participants, id)
+	if s.activeSpeaker == id {
+		s.activeSpeaker = ""
+	}
+	if len(s.participants) == 0 {
+		s.emptySince = now
+	}
+}
+
+// countParticipants returns the number of participants of the session,
+// not counting the relays of other nodes.
+func (s *webRtcSession) countParticipants() int {
+	count := 0
+	for _, p := range s.participants {
+		if !p.Relay {
+			count++
+		}
+	}
+	return count
 }
 
 type webRtcParticipant struct {
 	Participant
+	lastSeen time.Time
+	// Media of the participant, nil until its first offer.
+	media *participantMedia
 }
 
 // WebRtcSessionHandler handles live view streaming
 // sessions between multiple live view session
 // participants.
 type WebRtcSessionHandler struct {
-	sessions map[string]*webRtcSession
+	sessions     map[string]*webRtcSession
+	participants int
+	limits       Limits
+	reaperPolicy ReaperPolicy
+	clock        Clock
+	store        Store
+	idPrefix     string
+	events       eventBus
+	// Results of calls with an idempotency key, by key, replayed during
+	// idempotencyWindow.
+	idempotency       map[string]idempotentResult
+	idempotencyWindow time.Duration
+	// Time expired idempotency keys were last swept.
+	idempotencySwept time.Time
+	// API creating the PeerConnections of participants.
+	api        *webrtc.API
+	iceServers []webrtc.ICEServer
+	// Directory where sessions are recorded, if any.
+	recordingPath string
+	// Media forwarded by the handler, including to deleted sessions.
+	counters mediaCounters
+	// Tracer of the spans of participants.
+	tracer trace.Tracer
+	// Cluster whose sessions hosted by other nodes are relayed to the
+	// participants negotiating with the handler, if any.
+	relay *Cluster
+	// Mirrors of the sessions hosted by other nodes, by id, holding the
+	// participants negotiating with the handler and the relay of each.
+	relayed map[string]*webRtcSession
+	// Serializes the opening of relays.
+	relaying sync.Mutex
 	locker   sync.Mutex
 }
 
+// WithStore sets the store where the handler persists session and
+// participant metadata.
+func WithStore(store Store) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.store = store
+	}
+}
+
+// WithSessionIdPrefix sets the prefix of the ids of all sessions created
+// by the handler.
+func WithSessionIdPrefix(prefix string) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.idPrefix = prefix
+	}
+}
+
+// WithSettingEngine sets the settings of the PeerConnections of
+// participants, which should start from NewSettingEngine.
+func WithSettingEngine(settings webrtc.SettingEngine) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.api = newMediaAPI(settings)
+	}
+}
+
+// WithICEServers sets the STUN and TURN servers used by the PeerConnections
+// of participants.
+func WithICEServers(servers []webrtc.ICEServer) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.iceServers = servers
+	}
+}
+
+// WebRtcSessionHandlerOption configures optional behaviour of
+// WebRtcSessionHandler instances.
+type WebRtcSessionHandlerOption func(h *WebRtcSessionHandler)
+
+// WithLimits sets the capacity limits enforced by the handler.
+func WithLimits(limits Limits) WebRtcSessionHandlerOption {
+	return func(h *WebRtcSessionHandler) {
+		h.limits = limits
+	}
+}
+
 // NewWebRtcSessionHandler creates and returns a properly
 // initialized WebRtcSessionHandler instance.
-func NewWebRtcSessionHandler() *WebRtcSessionHandler {
+func NewWebRtcSessionHandler(opts ...WebRtcSessionHandlerOption) *WebRtcSessionHandler {
 	h := &WebRtcSessionHandler{
-		sessions: make(map[string]*webRtcSession),
+		sessions:          make(map[string]*webRtcSession),
+		clock:             systemClock{},
+		store:             NewMemoryStore(),
+		idempotency:       make(map[string]idempotentResult),
+		idempotencyWindow: DefaultIdempotencyWindow,
+		relayed:           make(map[string]*webRtcSession),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.api == nil {
+		h.api = newMediaAPI(NewSettingEngine())
+	}
+	if h.tracer == nil {
+		h.tracer = trace.NewNoopTracerProvider().Tracer(tracerName)
 	}
 	return h
 }
 
+// Stats returns the number of sessions and participants hosted by the handler.
+func (h *WebRtcSessionHandler) Stats() (sessions int, participants int) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	return len(h.sessions), h.participants
+}
+
+// SessionIds returns the ids of all sessions hosted by the handler.
+func (h *WebRtcSessionHandler) SessionIds() []string {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	ids := make([]string, 0, len(h.sessions))
+	for id := range h.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// snapshot returns a copy of all sessions and their participants.
+func (h *WebRtcSessionHandler) snapshot() []SessionRecord {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	records := make([]SessionRecord, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		r := SessionRecord{Session: *s.clone(), Participants: make([]Participant, 0, len(s.participants))}
+		for _, p := range s.participants {
+			r.Participants = append(r.Participants, *p.clone())
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+// mediaCounts returns the counts of the media forwarded by the handler, and
+// by each of its sessions.
+func (h *WebRtcSessionHandler) mediaCounts() (mediaCounts, map[string]mediaCounts) {
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	sessions := make(map[string]mediaCounts, len(h.sessions))
+	for id, s := range h.sessions {
+		sessions[id] = s.counters.load()
+	}
+	return h.counters.load(), sessions
+}
+
+// Subscribe registers a listener for all future events of the handler.
+func (h *WebRtcSessionHandler) Subscribe(l EventListener) func() {
+	return h.events.Subscribe(l)
+}
+
+// newSessionEvent creates an event of type t about session s.
+func (h *WebRtcSessionHandler) newSessionEvent(t EventType, s *webRtcSession, reason string) Event {
+	return Event{
+		Type:      t,
+		DateTime:  formatDateTime(h.clock.Now()),
+		SessionId: s.Id,
+		Reason:    reason,
+		Session:   s.clone(),
+	}
+}
+
+// newParticipantEvent creates an event of type t about participant p.
+func (h *WebRtcSessionHandler) newParticipantEvent(t EventType, p *webRtcParticipant, reason string) Event {
+	return Event{
+		Type:          t,
+		DateTime:      formatDateTime(h.clock.Now()),
+		SessionId:     p.SessionId,
+		ParticipantId: p.Id,
+		Reason:        reason,
+		Participant:   p.clone(),
+	}
+}
+
+// Shutdown notifies the participants of every session, through a
+// SessionInterrupted event, that the server is going away. Sessions are
+// kept in the store, so they are restored on the next start, while the
+// PeerConnections of their participants are closed, along with the relays
+// of the sessions hosted by other nodes.
+func (h *WebRtcSessionHandler) Shutdown(ctx context.Context) error {
+	var pcs []*webrtc.PeerConnection
+	h.locker.Lock()
+	for _, s := range h.sessions {
+		if err := ctx.Err(); err != nil {
+			h.locker.Unlock()
+			return err
+		}
+		h.events.emit(h.newSessionEvent(SessionInterrupted, s, ReasonServerShutdown))
+		pcs = append(pcs, s.peerConnections()...)
+	}
+	relayed := make([]string, 0, len(h.relayed))
+	for id := range h.relayed {
+		relayed = append(relayed, id)
+	}
+	h.locker.Unlock()
+	closePeerConnections(pcs)
+	for _, id := range relayed {
+		h.closeRelay(id)
+	}
+	return nil
+}
+
 /**
 ========================================
      SessionHandler interface
@@ -39,49 +274,201 @@ func NewWebRtcSessionHandler() *WebRtcSessionHandler {
 
 func (h *WebRtcSessionHandler) CreateSession(params CreateSessionParams) (CreateSessionResult, error) {
 	if errors := params.check(); errors != nil {
-		return CreateSessionResult{Errors: errors}, nil
+		return CreateSessionResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	call, err := h.newIdempotentCall(params.IdempotencyKey, "CreateSession", params)
+	if err != nil {
+		return CreateSessionResult{}, err
 	}
-	s := newSession(params)
+	s := newSession(generateId(h.idPrefix), params, h.limits.Session, h.clock.Now())
 	h.locker.Lock()
+	if recorded, reused := replay[CreateSessionResult](h, call); recorded != nil {
+		h.locker.Unlock()
+		return *recorded, nil
+	} else if reused != nil {
+		h.locker.Unlock()
+		errors := []FieldError{*reused}
+		return CreateSessionResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	if reached(h.limits.MaxSessions, len(h.sessions)) {
+		h.locker.Unlock()
+		errors := []FieldError{limitError(ServerSessionsLimit, h.limits.MaxSessions)}
+		return CreateSessionResult{
+			Errors:      errorDetails(errors),
+			FieldErrors: errors,
+			Limit:       ServerSessionsLimit,
+		}, nil
+	}
+	for h.sessions[s.Id] != nil {
+		// ids are random, and restored sessions keep theirs
+		s.Id = generateId(h.idPrefix)
+	}
+	result := CreateSessionResult{Session: s.clone()}
+	record := h.newRecord(call, result)
+	if err := h.store.CreateSession(s.Session, record.stored()); err != nil {
+		h.locker.Unlock()
+		return CreateSessionResult{}, err
+	}
 	h.sessions[s.Id] = s
+	h.remember(record)
+	// emitted under the lock, so events are emitted in the order of changes
+	h.events.emit(h.newSessionEvent(SessionCreated, s, ""))
 	h.locker.Unlock()
-	return CreateSessionResult{Session: &s.Session}, nil
+	return result, nil
 }
 
-func newSession(params CreateSessionParams) *webRtcSession {
+func newSession(id string, params CreateSessionParams, upper SessionLimits, now time.Time) *webRtcSession {
+	limits := upper
+	if params.Limits != nil {
+		limits = params.Limits.capTo(upper)
+	}
 	return &webRtcSession{
 		Session: Session{
-			Id:               generateSessionId(),
+			Id:               id,
 			Name:             params.Name,
-			CreationDateTime: generateCreationDateTime(),
+			CreationDateTime: formatDateTime(now),
+			Limits:           limits,
+			Metadata:         cloneMetadata(params.Metadata),
+			Revision:         1,
 		},
 		participants: make(map[string]*webRtcParticipant),
+		created:      now,
+		emptySince:   now,
 	}
 }
 
 func (h *WebRtcSessionHandler) GetSession(params GetSessionParams) (GetSessionResult, error) {
 	if errors := params.check(); errors != nil {
-		return GetSessionResult{Errors: errors}, nil
+		return GetSessionResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	var session *Session
 	h.doActionOnSession(params.Id, func(s *webRtcSession) {
-		session = &s.Session
+		session = s.clone()
 	})
 	return GetSessionResult{Session: session}, nil
 }
 
 func (h *WebRtcSessionHandler) DeleteSession(params DeleteSessionParams) (DeleteSessionResult, error) {
 	if errors := params.check(); errors != nil {
-		return DeleteSessionResult{Errors: errors}, nil
+		return DeleteSessionResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	var session *Session
+	var pcs []*webrtc.PeerConnection
+	var errors []FieldError
+	var err error
 	h.doActionOnSession(params.Id, func(s *webRtcSession) {
-		session = &s.Session
+		session = s.clone()
+		if failed := params.Conditions.check(s.Revision); failed != nil {
+			errors = []FieldError{*failed}
+			return
+		}
+		if err = h.store.DeleteSession(params.Id); err != nil {
+			return
+		}
+		h.participants -= len(s.participants)
 		delete(h.sessions, params.Id)
+		pcs = s.peerConnections()
+		h.events.emit(h.newSessionEvent(SessionDeleted, s, ""))
 	})
+	closePeerConnections(pcs)
+	if err != nil {
+		return DeleteSessionResult{}, err
+	}
+	if errors != nil {
+		return DeleteSessionResult{Session: session, Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
 	return DeleteSessionResult{Session: session}, nil
 }
 
+func (h *WebRtcSessionHandler) UpdateSession(params UpdateSessionParams) (UpdateSessionResult, error) {
+	if errors := params.check(); errors != nil {
+		return UpdateSessionResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	var session *Session
+	var errors []FieldError
+	var err error
+	h.doActionOnSession(params.Id, func(s *webRtcSession) {
+		if failed := params.Conditions.check(s.Revision); failed != nil {
+			session = s.clone()
+			errors = []FieldError{*failed}
+			return
+		}
+		var updated Session
+		if updated, errors = patchSession(s.Session, params.Patch, h.limits.Session); errors != nil {
+			return
+		}
+		if updated.Recording && h.recordingPath == "" {
+			errors = []FieldError{{Field: "recording", Code: ErrorInvalidValue, Detail: "recording is not enabled on this server"}}
+			return
+		}
+		if reflect.DeepEqual(updated, s.Session) {
+			session = s.clone()
+			return
+		}
+		updated.Revision++
+		if err = h.store.SaveSession(updated); err != nil {
+			return
+		}
+		recording := updated.Recording != s.Recording
+		s.Session = updated
+		session = s.clone()
+		h.events.emit(h.newSessionEvent(SessionUpdated, s, ""))
+		if recording {
+			h.updateRecording(s)
+		}
+	})
+	if err != nil {
+		return UpdateSessionResult{}, err
+	}
+	if errors != nil {
+		return UpdateSessionResult{Session: session, Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	return UpdateSessionResult{Session: session}, nil
+}
+
+// patchSession applies the JSON Merge Patch patch to session s, capping
+// its limits by upper. It returns the patched session, or the errors
+// preventing the patch.
+func patchSession(s Session, patch json.RawMessage, upper SessionLimits) (Session, []FieldError) {
+	patched, err := applyMergePatch(s, patch)
+	if err != nil {
+		return s, []FieldError{patchError(err)}
+	}
+	var errors []FieldError
+	if patched.Id != s.Id {
+		errors = append(errors, readOnlyError("id"))
+	}
+	if patched.CreationDateTime != s.CreationDateTime {
+		errors = append(errors, readOnlyError("creationDateTime"))
+	}
+	if patched.Revision != s.Revision {
+		errors = append(errors, readOnlyError("revision"))
+	}
+	if err := isNotBlank("name", patched.Name); err != nil {
+		errors = append(errors, *err)
+	}
+	errors = append(errors, patched.Limits.check()...)
+	errors = append(errors, checkMetadata("metadata", patched.Metadata)...)
+	patched.Limits = patched.Limits.capTo(upper)
+	return patched, errors
+}
+
+func (h *WebRtcSessionHandler) ListSessions(params ListSessionsParams) (ListSessionsResult, error) {
+	if errors := params.check(); errors != nil {
+		return ListSessionsResult{Sessions: []*Session{}, Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	h.locker.Lock()
+	var sessions []listedSession
+	for _, s := range h.sessions {
+		if params.matches(s) {
+			sessions = append(sessions, listedSession{key: sortKey(s, params.sort()), session: *s.clone()})
+		}
+	}
+	h.locker.Unlock()
+	page, next := params.page(sessions)
+	return ListSessionsResult{Sessions: page, NextCursor: next}, nil
+}
+
 // doActionOnSession locates and executes a given action safely. It returns true
 // if the action was executed, false if no such session exists.
 func (h *WebRtcSessionHandler) doActionOnSession(sessionId string, action func(s *webRtcSession)) bool {
@@ -95,106 +482,334 @@ func (h *WebRtcSessionHandler) doActionOnSession(sessionId string, action func(s
 	return true
 }
 
+// noSuchSession creates the error reported when operating on the
+// participants of a session which does not exist.
+func noSuchSession(sessionId string) FieldError {
+	return FieldError{Field: "sessionId", Code: ErrorNotFound,
+		Detail: fmt.Sprintf("session %s does not exist", sessionId)}
+}
+
 func (h *WebRtcSessionHandler) AddParticipant(params AddParticipantParams) (AddParticipantResult, error) {
 	if errors := params.check(); errors != nil {
-		return AddParticipantResult{Errors: errors}, nil
+		return AddParticipantResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
-	participant := newParticipant(params)
-	action := func(s *webRtcSession) {
-		s.participants[participant.Id] = participant
+	call, err := h.newIdempotentCall(params.IdempotencyKey, "AddParticipant", params)
+	if err != nil {
+		return AddParticipantResult{}, err
 	}
-	if ok := h.doActionOnSession(params.SessionId, action); !ok {
-		errorMsg := fmt.Sprintf("session %s does not exist", params.SessionId)
-		return AddParticipantResult{Errors: []string{errorMsg}}, nil
+	participant := newParticipant(params, h.clock.Now())
+	h.locker.Lock()
+	// replayed even if the session was deleted since
+	if recorded, reused := replay[AddParticipantResult](h, call); recorded != nil {
+		h.locker.Unlock()
+		return *recorded, nil
+	} else if reused != nil {
+		h.locker.Unlock()
+		errors := []FieldError{*reused}
+		return AddParticipantResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	s := h.sessions[params.SessionId]
+	if s == nil {
+		h.locker.Unlock()
+		errors := []FieldError{noSuchSession(params.SessionId)}
+		return AddParticipantResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	if reached(h.limits.MaxParticipants, h.participants) && !params.Relay {
+		h.locker.Unlock()
+		errors := []FieldError{limitError(ServerParticipantsLimit, h.limits.MaxParticipants)}
+		return AddParticipantResult{
+			Errors:      errorDetails(errors),
+			FieldErrors: errors,
+			Limit:       ServerParticipantsLimit,
+		}, nil
+	}
+	if reached(s.Limits.MaxParticipants, s.countParticipants()) && !params.Relay {
+		h.locker.Unlock()
+		errors := []FieldError{limitError(SessionParticipantsLimit, s.Limits.MaxParticipants)}
+		return AddParticipantResult{
+			Errors:      errorDetails(errors),
+			FieldErrors: errors,
+			Limit:       SessionParticipantsLimit,
+		}, nil
+	}
+	for s.participants[participant.Id] != nil {
+		participant.Id = generateParticipantId()
+	}
+	result := AddParticipantResult{Participant: participant.clone()}
+	record := h.newRecord(call, result)
+	if err := h.store.CreateParticipant(participant.Participant, record.stored()); err != nil {
+		h.locker.Unlock()
+		return AddParticipantResult{}, err
 	}
-	return AddParticipantResult{Participant: &participant.Participant}, nil
+	s.participants[participant.Id] = participant
+	h.participants++
+	h.remember(record)
+	h.events.emit(h.newParticipantEvent(ParticipantJoined, participant, ""))
+	h.locker.Unlock()
+	return result, nil
 }
 
-func newParticipant(p AddParticipantParams) *webRtcParticipant {
+func newParticipant(p AddParticipantParams, now time.Time) *webRtcParticipant {
 	return &webRtcParticipant{
 		Participant: Participant{
 			Id:               generateParticipantId(),
 			SessionId:        p.SessionId,
-			CreationDateTime: generateCreationDateTime(),
+			CreationDateTime: formatDateTime(now),
 			Name:             p.Name,
+			ConnectionState:  ConnectionNew,
+			Metadata:         cloneMetadata(p.Metadata),
+			Relay:            p.Relay,
+			Revision:         1,
 		},
+		lastSeen: now,
 	}
 }
 
 func (h *WebRtcSessionHandler) GetParticipant(params GetParticipantParams) (GetParticipantResult, error) {
 	if errors := params.check(); errors != nil {
-		return GetParticipantResult{Errors: errors}, nil
+		return GetParticipantResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	var participant *Participant
 	action := func(s *webRtcSession) {
 		p := s.participants[params.ParticipantId]
 		if p != nil {
-			participant = &p.Participant
+			participant = p.clone()
 		}
 	}
 	if ok := h.doActionOnSession(params.SessionId, action); !ok {
-		errorMsg := fmt.Sprintf("session %s does not exist", params.SessionId)
-		return GetParticipantResult{Errors: []string{errorMsg}}, nil
+		errors := []FieldError{noSuchSession(params.SessionId)}
+		return GetParticipantResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	return GetParticipantResult{Participant: participant}, nil
 }
 
 func (h *WebRtcSessionHandler) UpdateParticipant(params UpdateParticipantParams) (UpdateParticipantResult, error) {
 	if errors := params.check(); errors != nil {
-		return UpdateParticipantResult{Errors: errors}, nil
+		return UpdateParticipantResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	var participant *Participant
+	var errors []FieldError
+	var err error
 	action := func(s *webRtcSession) {
 		p := s.participants[params.ParticipantId]
 		if p == nil {
 			return
 		}
-		p.Name = params.Name
-		participant = &p.Participant
+		if failed := params.Conditions.check(p.Revision); failed != nil {
+			participant = p.clone()
+			errors = []FieldError{*failed}
+			return
+		}
+		updated := p.Participant
+		updated.Name = params.Name
+		if params.Patch != nil {
+			if updated, errors = patchParticipant(p.Participant, params.Patch); errors != nil {
+				return
+			}
+		}
+		if reflect.DeepEqual(updated, p.Participant) {
+			participant = p.clone()
+			return
+		}
+		updated.Revision++
+		if err = h.store.SaveParticipant(updated); err != nil {
+			return
+		}
+		p.Participant = updated
+		participant = p.clone()
+		h.events.emit(h.newParticipantEvent(ParticipantUpdated, p, ""))
 	}
 	if ok := h.doActionOnSession(params.SessionId, action); !ok {
-		errorMsg := fmt.Sprintf("session %s does not exist", params.SessionId)
-		return UpdateParticipantResult{Errors: []string{errorMsg}}, nil
+		errors := []FieldError{noSuchSession(params.SessionId)}
+		return UpdateParticipantResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	if err != nil {
+		return UpdateParticipantResult{}, err
+	}
+	if errors != nil {
+		return UpdateParticipantResult{Participant: participant, Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	return UpdateParticipantResult{Participant: participant}, nil
 }
 
+// patchParticipant applies the JSON Merge Patch patch to participant p.
+// It returns the patched participant, or the errors preventing the patch.
+func patchParticipant(p Participant, patch json.RawMessage) (Participant, []FieldError) {
+	patched, err := applyMergePatch(p, patch)
+	if err != nil {
+		return p, []FieldError{patchError(err)}
+	}
+	var errors []FieldError
+	if patched.Id != p.Id {
+		errors = append(errors, readOnlyError("id"))
+	}
+	if patched.SessionId != p.SessionId {
+		errors = append(errors, readOnlyError("sessionId"))
+	}
+	if patched.CreationDateTime != p.CreationDateTime {
+		errors = append(errors, readOnlyError("creationDateTime"))
+	}
+	if patched.ConnectionState != p.ConnectionState {
+		errors = append(errors, readOnlyError("connectionState"))
+	}
+	if patched.Revision != p.Revision {
+		errors = append(errors, readOnlyError("revision"))
+	}
+	if !reflect.DeepEqual(patched.Tracks, p.Tracks) {
+		errors = append(errors, readOnlyError("tracks"))
+	}
+	if err := isNotBlank("name", patched.Name); err != nil {
+		errors = append(errors, *err)
+	}
+	errors = append(errors, checkMetadata("metadata", patched.Metadata)...)
+	return patched, errors
+}
+
 func (h *WebRtcSessionHandler) DeleteParticipant(params DeleteParticipantParams) (DeleteParticipantResult, error) {
 	if errors := params.check(); errors != nil {
-		return DeleteParticipantResult{Errors: errors}, nil
+		return DeleteParticipantResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	var participant *Participant
+	var pc *webrtc.PeerConnection
+	var errors []FieldError
+	var err error
 	action := func(s *webRtcSession) {
 		p := s.participants[params.ParticipantId]
 		if p == nil {
 			return
 		}
-		delete(s.participants, params.ParticipantId)
-		participant = &p.Participant
+		participant = p.clone()
+		if failed := params.Conditions.check(p.Revision); failed != nil {
+			errors = []FieldError{*failed}
+			return
+		}
+		if err = h.store.DeleteParticipant(params.SessionId, params.ParticipantId); err != nil {
+			return
+		}
+		s.removeParticipant(params.ParticipantId, h.clock.Now())
+		h.participants--
+		pc = p.peerConnection()
+		h.events.emit(h.newParticipantEvent(ParticipantLeft, p, ""))
 	}
 	if ok := h.doActionOnSession(params.SessionId, action); !ok {
-		errorMsg := fmt.Sprintf("session %s does not exist", params.SessionId)
-		return DeleteParticipantResult{Errors: []string{errorMsg}}, nil
+		errors := []FieldError{noSuchSession(params.SessionId)}
+		return DeleteParticipantResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	if pc != nil {
+		closePeerConnections([]*webrtc.PeerConnection{pc})
+	}
+	if err != nil {
+		return DeleteParticipantResult{}, err
+	}
+	if errors != nil {
+		return DeleteParticipantResult{Participant: participant, Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	return DeleteParticipantResult{Participant: participant}, nil
 }
 
 func (h *WebRtcSessionHandler) GetParticipants(params GetParticipantsParams) (GetParticipantsResult, error) {
 	if errors := params.check(); errors != nil {
-		return GetParticipantsResult{Errors: errors}, nil
+		return GetParticipantsResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	var participants []*Participant
 	action := func(s *webRtcSession) {
 		participants = make([]*Participant, len(s.participants))
 		i := 0
 		for _, v := range s.participants {
-			participants[i] = &v.Participant
+			participants[i] = v.clone()
 			i++
 		}
 	}
 	if ok := h.doActionOnSession(params.SessionId, action); !ok {
-		errorMsg := fmt.Sprintf("session %s does not exist", params.SessionId)
-		return GetParticipantsResult{Errors: []string{errorMsg}}, nil
+		errors := []FieldError{noSuchSession(params.SessionId)}
+		return GetParticipantsResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
 	}
 	return GetParticipantsResult{Participants: participants}, nil
 }
+
+func (h *WebRtcSessionHandler) Heartbeat(params HeartbeatParams) (HeartbeatResult, error) {
+	if errors := params.check(); errors != nil {
+		return HeartbeatResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	var participant *Participant
+	var err error
+	action := func(s *webRtcSession) {
+		p := s.participants[params.ParticipantId]
+		if p == nil {
+			return
+		}
+		if params.ConnectionState != "" {
+			if err = h.setConnectionState(p, params.ConnectionState); err != nil {
+				return
+			}
+		}
+		p.lastSeen = h.clock.Now()
+		participant = p.clone()
+	}
+	if ok := h.doActionOnSession(params.SessionId, action); !ok {
+		errors := []FieldError{noSuchSession(params.SessionId)}
+		return HeartbeatResult{Errors: errorDetails(errors), FieldErrors: errors}, nil
+	}
+	if err != nil {
+		return HeartbeatResult{}, err
+	}
+	return HeartbeatResult{Participant: participant}, nil
+}
+
+// setConnectionState sets the connection state of participant p. Only
+// changes are persisted, heartbeats are too frequent. It must be called
+// while holding the lock of the handler.
+func (h *WebRtcSessionHandler) setConnectionState(p *webRtcParticipant, state ConnectionState) error {
+	if state == p.ConnectionState {
+		return nil
+	}
+	updated := p.Participant
+	updated.ConnectionState = state
+	updated.Revision++
+	if err := h.store.SaveParticipant(updated); err != nil {
+		return err
+	}
+	p.Participant = updated
+	return nil
+}
+
+// Restore loads all sessions and participants persisted in the handler's
+// store, along with the results of calls with an idempotency key. Restored
+// participants are marked as disconnected, without tracks, until they send
+// a heartbeat or negotiate again.
+func (h *WebRtcSessionHandler) Restore() error {
+	records, err := h.store.LoadSessions()
+	if err != nil {
+		return err
+	}
+	now := h.clock.Now()
+	h.locker.Lock()
+	defer h.locker.Unlock()
+	for _, r := range records {
+		created, err := time.Parse(timeFormat, r.Session.CreationDateTime)
+		if err != nil {
+			return fmt.Errorf("session %s has an invalid creation date time: %w", r.Session.Id, err)
+		}
+		s := &webRtcSession{
+			Session:      r.Session,
+			participants: make(map[string]*webRtcParticipant),
+			created:      created,
+			emptySince:   now,
+		}
+		for _, p := range r.Participants {
+			if p.ConnectionState != ConnectionDisconnected || p.Tracks != nil {
+				p.ConnectionState = ConnectionDisconnected
+				p.Tracks = nil
+				p.Revision++
+				if err := h.store.SaveParticipant(p); err != nil {
+					return err
+				}
+			}
+			s.participants[p.Id] = &webRtcParticipant{Participant: p, lastSeen: now}
+		}
+		h.participants += len(s.participants)
+		h.sessions[s.Id] = s
+	}
+	logger.LogInfoC(storeLogContext, "restored %d sessions with %d participants", len(records), h.participants)
+	return h.restoreIdempotencyKeys()
+}